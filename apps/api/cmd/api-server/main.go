@@ -5,6 +5,8 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	// Schedules name IANA timezones, which the runtime images do not ship
+	_ "time/tzdata"
 
 	"github.com/dendianugerah/velld/internal"
 	"github.com/dendianugerah/velld/internal/auth"
@@ -12,9 +14,11 @@ import (
 	"github.com/dendianugerah/velld/internal/common"
 	"github.com/dendianugerah/velld/internal/connection"
 	"github.com/dendianugerah/velld/internal/database"
+	"github.com/dendianugerah/velld/internal/events"
 	"github.com/dendianugerah/velld/internal/middleware"
 	"github.com/dendianugerah/velld/internal/notification"
 	"github.com/dendianugerah/velld/internal/settings"
+	"github.com/dendianugerah/velld/internal/webhook"
 	"github.com/gorilla/mux"
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -86,6 +90,8 @@ func main() {
 	protected.HandleFunc("/auth/profile", authHandler.GetProfile).Methods("GET", "OPTIONS")
 
 	protected.HandleFunc("/connections/test", connHandler.TestConnection).Methods("POST", "OPTIONS")
+	protected.HandleFunc("/connections/tools", connHandler.ListTools).Methods("GET", "OPTIONS")
+	protected.HandleFunc("/connections/incidents/test", connHandler.TestIncident).Methods("POST", "OPTIONS")
 	protected.HandleFunc("/connections/{id}", connHandler.GetConnection).Methods("GET", "OPTIONS")
 	protected.HandleFunc("/connections/{id}", connHandler.DeleteConnection).Methods("DELETE", "OPTIONS")
 	protected.HandleFunc("/connections", connHandler.SaveConnection).Methods("POST", "OPTIONS")
@@ -96,6 +102,9 @@ func main() {
 	settingsRepo := settings.NewSettingsRepository(db)
 	notificationRepo := notification.NewNotificationRepository(db)
 	settingsService := settings.NewSettingsService(settingsRepo, cryptoService)
+	webhookRepo := webhook.NewWebhookRepository(db)
+	webhookService := webhook.NewWebhookService(webhookRepo, settingsService)
+	eventBus := events.NewBus()
 
 	backupService := backup.NewBackupService(
 		connRepo,
@@ -103,6 +112,8 @@ func main() {
 		backupRepo,
 		settingsService,
 		notificationRepo,
+		webhookService,
+		eventBus,
 		cryptoService,
 	)
 
@@ -115,20 +126,56 @@ func main() {
 	protected.HandleFunc("/backups/{id}", backupHandler.GetBackup).Methods("GET", "OPTIONS")
 	protected.HandleFunc("/backups/{id}/download", backupHandler.DownloadBackup).Methods("GET", "OPTIONS")
 	protected.HandleFunc("/backups/restore", backupHandler.RestoreBackup).Methods("POST", "OPTIONS")
+	protected.HandleFunc("/restores", backupHandler.ListRestoreJobs).Methods("GET", "OPTIONS")
+	protected.HandleFunc("/restores/{id}", backupHandler.GetRestoreJob).Methods("GET", "OPTIONS")
+	protected.HandleFunc("/restores/{id}/rollback", backupHandler.RollbackRestore).Methods("POST", "OPTIONS")
 	protected.HandleFunc("/backups/compare/{sourceId}/{targetId}", backupHandler.CompareBackups).Methods("GET", "OPTIONS")
 	protected.HandleFunc("/backups/{connection_id}/schedule/disable", backupHandler.DisableBackupSchedule).Methods("POST", "OPTIONS")
 	protected.HandleFunc("/backups/{connection_id}/schedule", backupHandler.UpdateBackupSchedule).Methods("PUT", "OPTIONS")
+	protected.HandleFunc("/schedules", backupHandler.ListSchedules).Methods("GET", "OPTIONS")
+	protected.HandleFunc("/schedules", backupHandler.CreateSchedule).Methods("POST", "OPTIONS")
+	protected.HandleFunc("/schedules/{id}", backupHandler.GetSchedule).Methods("GET", "OPTIONS")
+	protected.HandleFunc("/schedules/{id}", backupHandler.UpdateSchedule).Methods("PUT", "OPTIONS")
+	protected.HandleFunc("/schedules/{id}", backupHandler.DeleteSchedule).Methods("DELETE", "OPTIONS")
+	protected.HandleFunc("/schedules/{id}/enable", backupHandler.EnableSchedule).Methods("POST", "OPTIONS")
+	protected.HandleFunc("/schedules/{id}/disable", backupHandler.DisableSchedule).Methods("POST", "OPTIONS")
+	protected.HandleFunc("/digest", backupHandler.GetDigest).Methods("GET", "OPTIONS")
+	protected.HandleFunc("/digest/send", backupHandler.SendDigest).Methods("POST", "OPTIONS")
 
 	settingsHandler := settings.NewSettingsHandler(settingsService)
 
 	protected.HandleFunc("/settings", settingsHandler.GetSettings).Methods("GET", "OPTIONS")
 	protected.HandleFunc("/settings", settingsHandler.UpdateSettings).Methods("PUT", "OPTIONS")
+	protected.HandleFunc("/settings/notifications/{channel}/test", settingsHandler.TestChannel).Methods("POST", "OPTIONS")
+	protected.HandleFunc("/settings/email/preview", settingsHandler.PreviewEmail).Methods("POST", "OPTIONS")
+	protected.HandleFunc("/settings/smtp/test", settingsHandler.TestSMTP).Methods("POST", "OPTIONS")
 
-	notificationService := notification.NewNotificationService(notificationRepo)
+	webhookHandler := webhook.NewWebhookHandler(webhookService)
+
+	protected.HandleFunc("/webhooks/deliveries", webhookHandler.ListDeliveries).Methods("GET", "OPTIONS")
+	protected.HandleFunc("/webhooks/deliveries/{id}", webhookHandler.GetDelivery).Methods("GET", "OPTIONS")
+	protected.HandleFunc("/webhooks/deliveries/{id}/redeliver", webhookHandler.Redeliver).Methods("POST", "OPTIONS")
+
+	notificationService := notification.NewNotificationService(notificationRepo, eventBus)
 	notificationHandler := notification.NewNotificationHandler(notificationService)
 
 	protected.HandleFunc("/notifications", notificationHandler.GetNotifications).Methods("GET", "OPTIONS")
 	protected.HandleFunc("/notifications/mark-read", notificationHandler.MarkAsRead).Methods("POST", "OPTIONS")
+	protected.HandleFunc("/notifications/mark-all-read", notificationHandler.MarkAllAsRead).Methods("POST", "OPTIONS")
+	protected.HandleFunc("/notifications/delete", notificationHandler.DeleteNotifications).Methods("POST", "OPTIONS")
+	protected.HandleFunc("/notifications/unread-count", notificationHandler.GetUnreadCount).Methods("GET", "OPTIONS")
+	protected.HandleFunc("/notifications/events", notificationHandler.GetEventCatalog).Methods("GET", "OPTIONS")
+	protected.HandleFunc("/notifications/rules", notificationHandler.ListRules).Methods("GET", "OPTIONS")
+	protected.HandleFunc("/notifications/rules", notificationHandler.CreateRule).Methods("POST", "OPTIONS")
+	protected.HandleFunc("/notifications/rules/evaluate", notificationHandler.EvaluateRules).Methods("POST", "OPTIONS")
+	protected.HandleFunc("/notifications/rules/{id}", notificationHandler.GetRule).Methods("GET", "OPTIONS")
+	protected.HandleFunc("/notifications/rules/{id}", notificationHandler.UpdateRule).Methods("PUT", "OPTIONS")
+	protected.HandleFunc("/notifications/rules/{id}", notificationHandler.DeleteRule).Methods("DELETE", "OPTIONS")
+	protected.HandleFunc("/notifications/{id}", notificationHandler.DeleteNotification).Methods("DELETE", "OPTIONS")
+
+	eventsHandler := events.NewEventsHandler(eventBus)
+
+	protected.HandleFunc("/events", eventsHandler.Stream).Methods("GET", "OPTIONS")
 
 	log.Println("Server starting on :8080")
 	if err := http.ListenAndServe(":8080", r); err != nil {
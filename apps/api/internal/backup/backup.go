@@ -81,10 +81,12 @@ func (h *BackupHandler) ListBackups(w http.ResponseWriter, r *http.Request) {
 	offset := (page - 1) * limit
 
 	opts := BackupListOptions{
-		UserID: userID,
-		Limit:  limit,
-		Offset: offset,
-		Search: search,
+		UserID:       userID,
+		ConnectionID: r.URL.Query().Get("connection_id"),
+		ScheduleID:   r.URL.Query().Get("schedule_id"),
+		Limit:        limit,
+		Offset:       offset,
+		Search:       search,
 	}
 
 	backups, total, err := h.backupService.GetAllBackupsWithPagination(opts)
@@ -174,6 +176,144 @@ func (h *BackupHandler) UpdateBackupSchedule(w http.ResponseWriter, r *http.Requ
 	response.SendSuccess(w, "Backup schedule updated successfully", nil)
 }
 
+func (h *BackupHandler) ListSchedules(w http.ResponseWriter, r *http.Request) {
+	userID, err := common.GetUserIDFromContext(r.Context())
+	if err != nil {
+		response.SendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	schedules, err := h.backupService.ListSchedules(ScheduleListOptions{
+		UserID:       userID,
+		ConnectionID: r.URL.Query().Get("connection_id"),
+	})
+	if err != nil {
+		response.SendError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response.SendSuccess(w, "Backup schedules retrieved successfully", schedules)
+}
+
+func (h *BackupHandler) CreateSchedule(w http.ResponseWriter, r *http.Request) {
+	var req ScheduleBackupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.SendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if req.ConnectionID == "" {
+		response.SendError(w, http.StatusBadRequest, "connection_id is required")
+		return
+	}
+	if req.CronSchedule == "" {
+		response.SendError(w, http.StatusBadRequest, "cron_schedule is required")
+		return
+	}
+	if req.RetentionDays <= 0 {
+		response.SendError(w, http.StatusBadRequest, "retention_days must be greater than 0")
+		return
+	}
+
+	schedule, err := h.backupService.CreateSchedule(&req)
+	if err != nil {
+		response.SendError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response.SendSuccess(w, "Backup schedule created successfully", schedule)
+}
+
+func (h *BackupHandler) GetSchedule(w http.ResponseWriter, r *http.Request) {
+	scheduleID := mux.Vars(r)["id"]
+
+	schedule, err := h.backupService.GetSchedule(scheduleID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			response.SendError(w, http.StatusNotFound, "Backup schedule not found")
+			return
+		}
+		response.SendError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response.SendSuccess(w, "Backup schedule retrieved successfully", schedule)
+}
+
+func (h *BackupHandler) UpdateSchedule(w http.ResponseWriter, r *http.Request) {
+	scheduleID := mux.Vars(r)["id"]
+
+	var req UpdateScheduleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.SendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if req.CronSchedule == "" {
+		response.SendError(w, http.StatusBadRequest, "cron_schedule is required")
+		return
+	}
+	if req.RetentionDays <= 0 {
+		response.SendError(w, http.StatusBadRequest, "retention_days must be greater than 0")
+		return
+	}
+
+	schedule, err := h.backupService.UpdateSchedule(scheduleID, &req)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			response.SendError(w, http.StatusNotFound, "Backup schedule not found")
+			return
+		}
+		response.SendError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response.SendSuccess(w, "Backup schedule updated successfully", schedule)
+}
+
+func (h *BackupHandler) DeleteSchedule(w http.ResponseWriter, r *http.Request) {
+	scheduleID := mux.Vars(r)["id"]
+
+	if err := h.backupService.DeleteSchedule(scheduleID); err != nil {
+		if err == sql.ErrNoRows {
+			response.SendError(w, http.StatusNotFound, "Backup schedule not found")
+			return
+		}
+		response.SendError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response.SendSuccess(w, "Backup schedule deleted successfully", nil)
+}
+
+func (h *BackupHandler) EnableSchedule(w http.ResponseWriter, r *http.Request) {
+	h.setScheduleEnabled(w, r, true)
+}
+
+func (h *BackupHandler) DisableSchedule(w http.ResponseWriter, r *http.Request) {
+	h.setScheduleEnabled(w, r, false)
+}
+
+func (h *BackupHandler) setScheduleEnabled(w http.ResponseWriter, r *http.Request, enabled bool) {
+	scheduleID := mux.Vars(r)["id"]
+
+	schedule, err := h.backupService.SetScheduleEnabled(scheduleID, enabled)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			response.SendError(w, http.StatusNotFound, "Backup schedule not found")
+			return
+		}
+		response.SendError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	message := "Backup schedule disabled successfully"
+	if enabled {
+		message = "Backup schedule enabled successfully"
+	}
+	response.SendSuccess(w, message, schedule)
+}
+
 func (h *BackupHandler) GetBackupStats(w http.ResponseWriter, r *http.Request) {
 	userID, err := common.GetUserIDFromContext(r.Context())
 	if err != nil {
@@ -239,11 +379,91 @@ func (h *BackupHandler) RestoreBackup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := h.backupService.RestoreBackup(req.BackupID, req.ConnectionID)
+	if req.DryRun {
+		report, err := h.backupService.PreflightRestore(&req)
+		if err != nil {
+			response.SendError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		response.SendSuccess(w, "Restore pre-flight completed", report)
+		return
+	}
+
+	job, err := h.backupService.StartRestore(&req)
 	if err != nil {
 		response.SendError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	response.SendSuccess(w, "Backup restored successfully", nil)
+	response.SendSuccess(w, "Restore started", job)
+}
+
+func (h *BackupHandler) ListRestoreJobs(w http.ResponseWriter, r *http.Request) {
+	userID, err := common.GetUserIDFromContext(r.Context())
+	if err != nil {
+		response.SendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	page := 1
+	limit := 10
+	if pageStr := r.URL.Query().Get("page"); pageStr != "" {
+		if p, err := strconv.Atoi(pageStr); err == nil && p > 0 {
+			page = p
+		}
+	}
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 {
+			limit = l
+		}
+	}
+
+	opts := RestoreJobListOptions{
+		UserID:       userID,
+		ConnectionID: r.URL.Query().Get("connection_id"),
+		Limit:        limit,
+		Offset:       (page - 1) * limit,
+	}
+
+	jobs, total, err := h.backupService.ListRestoreJobs(opts)
+	if err != nil {
+		response.SendError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response.SendPaginatedSuccess(w, "Restore jobs retrieved successfully", jobs, page, limit, total)
+}
+
+func (h *BackupHandler) GetRestoreJob(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	jobID := vars["id"]
+
+	job, err := h.backupService.GetRestoreJob(jobID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			response.SendError(w, http.StatusNotFound, "Restore job not found")
+			return
+		}
+		response.SendError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response.SendSuccess(w, "Restore job retrieved successfully", job)
+}
+
+func (h *BackupHandler) RollbackRestore(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	jobID := vars["id"]
+
+	job, err := h.backupService.RollbackRestore(jobID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			response.SendError(w, http.StatusNotFound, "Restore job not found")
+			return
+		}
+		response.SendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	response.SendSuccess(w, "Rollback started", job)
 }
@@ -0,0 +1,253 @@
+package backup
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Defaults of AnomalyConfig
+const (
+	defaultAnomalyMinHistory        = 5
+	defaultAnomalyWindow            = 20
+	defaultAnomalySizeDeviation     = 0.5
+	defaultAnomalyDurationDeviation = 2.0
+	defaultAnomalyRebaselineAfter   = 3
+	// Durations closer than this to the baseline are never flagged, since
+	// short backups vary a lot relative to their length
+	anomalyMinDurationDelta = time.Minute
+)
+
+// AnomalyConfig tunes how the backups of a schedule are checked against the
+// history of the schedule. Zero values use the defaults.
+type AnomalyConfig struct {
+	Disabled bool `json:"disabled,omitempty"`
+	// MinHistory is the number of earlier backups needed before checking
+	MinHistory int `json:"min_history,omitempty"`
+	// Window is the number of recent backups the baseline is built from
+	Window int `json:"window,omitempty"`
+	// SizeDeviation and DurationDeviation are the largest accepted
+	// difference from the median as a fraction of it; 0.5 accepts a backup
+	// half or one and a half times the usual size
+	SizeDeviation     float64 `json:"size_deviation,omitempty"`
+	DurationDeviation float64 `json:"duration_deviation,omitempty"`
+	// Percentile, when set, replaces the deviations: a backup is flagged when
+	// its size or duration is below that percentile of the history or above
+	// 100 minus it
+	Percentile float64 `json:"percentile,omitempty"`
+	// RebaselineAfter is the number of suspicious backups in a row after
+	// which they are taken as the new normal, see baseline
+	RebaselineAfter int `json:"rebaseline_after,omitempty"`
+}
+
+// backupSample is the size and duration of an earlier backup
+type backupSample struct {
+	Size       int64
+	Duration   time.Duration
+	Suspicious bool
+}
+
+func (c *AnomalyConfig) withDefaults() AnomalyConfig {
+	config := AnomalyConfig{}
+	if c != nil {
+		config = *c
+	}
+	if config.MinHistory <= 0 {
+		config.MinHistory = defaultAnomalyMinHistory
+	}
+	if config.Window <= 0 {
+		config.Window = defaultAnomalyWindow
+	}
+	if config.Window < config.MinHistory {
+		config.Window = config.MinHistory
+	}
+	if config.SizeDeviation <= 0 {
+		config.SizeDeviation = defaultAnomalySizeDeviation
+	}
+	if config.DurationDeviation <= 0 {
+		config.DurationDeviation = defaultAnomalyDurationDeviation
+	}
+	if config.RebaselineAfter <= 0 {
+		config.RebaselineAfter = defaultAnomalyRebaselineAfter
+	}
+	return config
+}
+
+// validateAnomalyConfig checks the values a user sent
+func validateAnomalyConfig(c *AnomalyConfig) error {
+	if c == nil {
+		return nil
+	}
+	if c.MinHistory < 0 || c.Window < 0 || c.SizeDeviation < 0 || c.DurationDeviation < 0 || c.RebaselineAfter < 0 {
+		return fmt.Errorf("anomaly detection settings cannot be negative")
+	}
+	if c.Window > 1000 {
+		return fmt.Errorf("anomaly detection window cannot exceed 1000 backups")
+	}
+	if c.Percentile < 0 || c.Percentile >= 50 {
+		return fmt.Errorf("anomaly detection percentile must be between 0 and 50")
+	}
+	return nil
+}
+
+func marshalAnomalyConfig(c *AnomalyConfig) (sql.NullString, error) {
+	if c == nil {
+		return sql.NullString{}, nil
+	}
+	data, err := json.Marshal(c)
+	if err != nil {
+		return sql.NullString{}, fmt.Errorf("failed to encode anomaly detection settings: %w", err)
+	}
+	return sql.NullString{String: string(data), Valid: true}, nil
+}
+
+func unmarshalAnomalyConfig(value sql.NullString) (*AnomalyConfig, error) {
+	if !value.Valid || value.String == "" {
+		return nil, nil
+	}
+	var config AnomalyConfig
+	if err := json.Unmarshal([]byte(value.String), &config); err != nil {
+		return nil, fmt.Errorf("failed to decode anomaly detection settings: %w", err)
+	}
+	return &config, nil
+}
+
+// checkBackupAnomaly compares a completed scheduled backup with the history
+// of its schedule and returns why it looks wrong, or an empty string
+func (s *BackupService) checkBackupAnomaly(schedule *BackupSchedule, backup *Backup) (string, error) {
+	config := schedule.AnomalyDetection.withDefaults()
+	if config.Disabled || backup.CompletedTime == nil {
+		return "", nil
+	}
+
+	samples, err := s.backupRepo.GetScheduleHistory(schedule.ID.String(), backup.ID.String(), config.Window)
+	if err != nil {
+		return "", fmt.Errorf("failed to get backup history: %v", err)
+	}
+	history := baseline(samples, config.RebaselineAfter)
+	if len(history) < config.MinHistory {
+		return "", nil
+	}
+
+	reasons := detectAnomalies(config, history, backupSample{
+		Size:     backup.Size,
+		Duration: backup.CompletedTime.Sub(backup.StartedTime),
+	})
+	return strings.Join(reasons, "; "), nil
+}
+
+// baseline picks the backups a new one is compared with from samples,
+// newest first. Suspicious backups are left out, so that one bad run does
+// not shift the baseline. When rebaselineAfter or more of them follow each
+// other the data itself has most likely changed, so that run becomes the
+// start of a new baseline: it is kept together with the completed backups
+// after it, and everything older is dropped. Until the new baseline holds
+// MinHistory backups, backups are not checked.
+func baseline(samples []backupSample, rebaselineAfter int) []backupSample {
+	history := make([]backupSample, 0, len(samples))
+	for i := 0; i < len(samples); i++ {
+		if !samples[i].Suspicious {
+			history = append(history, samples[i])
+			continue
+		}
+
+		run := i
+		for run < len(samples) && samples[run].Suspicious {
+			run++
+		}
+		if run-i >= rebaselineAfter {
+			return append(history, samples[i:run]...)
+		}
+		i = run - 1
+	}
+	return history
+}
+
+// detectAnomalies returns the ways in which current differs from history
+func detectAnomalies(config AnomalyConfig, history []backupSample, current backupSample) []string {
+	sizes := make([]float64, len(history))
+	durations := make([]float64, len(history))
+	for i, sample := range history {
+		sizes[i] = float64(sample.Size)
+		durations[i] = sample.Duration.Seconds()
+	}
+	sort.Float64s(sizes)
+	sort.Float64s(durations)
+
+	size := float64(current.Size)
+	duration := current.Duration.Seconds()
+	var reasons []string
+
+	if current.Size == 0 && sizes[len(sizes)-1] > 0 {
+		return []string{"the backup is empty"}
+	}
+
+	if config.Percentile > 0 {
+		low, high := percentile(sizes, config.Percentile), percentile(sizes, 100-config.Percentile)
+		if size < low || size > high {
+			reasons = append(reasons, fmt.Sprintf("size %s is outside the usual range of %s to %s",
+				formatBytes(current.Size), formatBytes(int64(low)), formatBytes(int64(high))))
+		}
+		low, high = percentile(durations, config.Percentile), percentile(durations, 100-config.Percentile)
+		if (duration < low || duration > high) && math.Min(math.Abs(duration-low), math.Abs(duration-high)) >= anomalyMinDurationDelta.Seconds() {
+			reasons = append(reasons, fmt.Sprintf("duration %s is outside the usual range of %s to %s",
+				formatSeconds(duration), formatSeconds(low), formatSeconds(high)))
+		}
+		return reasons
+	}
+
+	if median := percentile(sizes, 50); median > 0 {
+		if deviation := (size - median) / median; math.Abs(deviation) > config.SizeDeviation {
+			reasons = append(reasons, fmt.Sprintf("size %s is %.0f%% %s the median of %s over the last %d backups",
+				formatBytes(current.Size), math.Abs(deviation)*100, aboveOrBelow(deviation), formatBytes(int64(median)), len(history)))
+		}
+	}
+	if median := percentile(durations, 50); median > 0 && math.Abs(duration-median) >= anomalyMinDurationDelta.Seconds() {
+		if deviation := (duration - median) / median; math.Abs(deviation) > config.DurationDeviation {
+			reasons = append(reasons, fmt.Sprintf("duration %s is %.0f%% %s the median of %s over the last %d backups",
+				formatSeconds(duration), math.Abs(deviation)*100, aboveOrBelow(deviation), formatSeconds(median), len(history)))
+		}
+	}
+	return reasons
+}
+
+// percentile interpolates the p-th percentile of sorted values
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 1 {
+		return sorted[0]
+	}
+	rank := p / 100 * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	if lower >= len(sorted)-1 {
+		return sorted[len(sorted)-1]
+	}
+	return sorted[lower] + (sorted[lower+1]-sorted[lower])*(rank-float64(lower))
+}
+
+func aboveOrBelow(deviation float64) string {
+	if deviation < 0 {
+		return "below"
+	}
+	return "above"
+}
+
+func formatBytes(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+func formatSeconds(seconds float64) string {
+	return time.Duration(seconds * float64(time.Second)).Round(time.Second).String()
+}
@@ -0,0 +1,411 @@
+package backup
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Missed run policies decide what happens to runs that were due while the
+// server was down or that fell in a blackout window
+const (
+	// MissedRunOnce makes up for any number of missed runs with one backup
+	MissedRunOnce = "run_once"
+	// MissedRunImmediately makes up for every missed run, up to maxMissedRuns
+	MissedRunImmediately = "run_immediately"
+	// MissedRunSkip drops missed runs and waits for the next one
+	MissedRunSkip = "skip"
+)
+
+const (
+	// maxMissedRuns bounds the backups MissedRunImmediately catches up with
+	maxMissedRuns = 10
+	// maxJitterSeconds bounds the random delay of a run
+	maxJitterSeconds = 6 * 60 * 60
+)
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// BlackoutWindow is a period in which a schedule does not run. It is either
+// weekly, such as Mon-Fri 08:00-18:00 in the timezone of the schedule, or a
+// one-off period such as declared maintenance.
+type BlackoutWindow struct {
+	Name string `json:"name,omitempty"`
+	// Days are the days the weekly window starts on ("mon", "tue", ...), all
+	// days when empty
+	Days []string `json:"days,omitempty"`
+	// StartTime and EndTime are "HH:MM". A window ending before it starts
+	// runs past midnight; without both the window lasts the whole day.
+	StartTime string `json:"start_time,omitempty"`
+	EndTime   string `json:"end_time,omitempty"`
+	// From and Until make a one-off window instead of a weekly one
+	From  *time.Time `json:"from,omitempty"`
+	Until *time.Time `json:"until,omitempty"`
+}
+
+// pendingRuns are missed runs of a schedule waiting for a blackout window
+// to end
+type pendingRuns struct {
+	runs  int
+	timer *time.Timer
+}
+
+func (w BlackoutWindow) validate() error {
+	if w.From != nil || w.Until != nil {
+		if w.From == nil || w.Until == nil {
+			return fmt.Errorf("blackout window %s needs both from and until", w.label())
+		}
+		if !w.Until.After(*w.From) {
+			return fmt.Errorf("blackout window %s must end after it starts", w.label())
+		}
+		if len(w.Days) > 0 || w.StartTime != "" || w.EndTime != "" {
+			return fmt.Errorf("blackout window %s cannot be both one-off and weekly", w.label())
+		}
+		return nil
+	}
+
+	for _, day := range w.Days {
+		if _, ok := weekdayNames[strings.ToLower(day)]; !ok {
+			return fmt.Errorf("invalid day %q in blackout window %s", day, w.label())
+		}
+	}
+
+	if (w.StartTime == "") != (w.EndTime == "") {
+		return fmt.Errorf("blackout window %s needs both start_time and end_time", w.label())
+	}
+	if w.StartTime == "" {
+		return nil
+	}
+	start, err := parseClock(w.StartTime)
+	if err != nil {
+		return fmt.Errorf("invalid start_time of blackout window %s: %v", w.label(), err)
+	}
+	end, err := parseClock(w.EndTime)
+	if err != nil {
+		return fmt.Errorf("invalid end_time of blackout window %s: %v", w.label(), err)
+	}
+	if start == end {
+		return fmt.Errorf("blackout window %s starts and ends at the same time, leave both out for a whole day", w.label())
+	}
+	return nil
+}
+
+func (w BlackoutWindow) label() string {
+	if w.Name != "" {
+		return fmt.Sprintf("%q", w.Name)
+	}
+	return "without a name"
+}
+
+// onDay reports whether the weekly window starts on day
+func (w BlackoutWindow) onDay(day time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, name := range w.Days {
+		if weekdayNames[strings.ToLower(name)] == day {
+			return true
+		}
+	}
+	return false
+}
+
+// end returns the end of the window when t is inside it. t must be in the
+// timezone of the schedule.
+func (w BlackoutWindow) end(t time.Time) (time.Time, bool) {
+	if w.From != nil && w.Until != nil {
+		if t.Before(*w.From) || !t.Before(*w.Until) {
+			return time.Time{}, false
+		}
+		return *w.Until, true
+	}
+
+	at := func(daysFromToday, minutes int) time.Time {
+		return time.Date(t.Year(), t.Month(), t.Day()+daysFromToday, 0, minutes, 0, 0, t.Location())
+	}
+	yesterday := (t.Weekday() + 6) % 7
+	minute := t.Hour()*60 + t.Minute()
+
+	if w.StartTime == "" {
+		if w.onDay(t.Weekday()) {
+			return at(1, 0), true
+		}
+		return time.Time{}, false
+	}
+
+	start, _ := parseClock(w.StartTime)
+	end, _ := parseClock(w.EndTime)
+	switch {
+	case start < end:
+		if w.onDay(t.Weekday()) && minute >= start && minute < end {
+			return at(0, end), true
+		}
+	case w.onDay(t.Weekday()) && minute >= start:
+		return at(1, end), true
+	case w.onDay(yesterday) && minute < end:
+		return at(0, end), true
+	}
+	return time.Time{}, false
+}
+
+// parseClock returns the minutes since midnight of "HH:MM"
+func parseClock(value string) (int, error) {
+	hours, minutes, ok := strings.Cut(value, ":")
+	if !ok {
+		return 0, fmt.Errorf("%q is not HH:MM", value)
+	}
+	h, err := strconv.Atoi(hours)
+	if err != nil || h < 0 || h > 23 {
+		return 0, fmt.Errorf("%q is not HH:MM", value)
+	}
+	m, err := strconv.Atoi(minutes)
+	if err != nil || m < 0 || m > 59 || len(minutes) != 2 {
+		return 0, fmt.Errorf("%q is not HH:MM", value)
+	}
+	return h*60 + m, nil
+}
+
+// location returns the timezone of a schedule
+func (s *BackupSchedule) location() *time.Location {
+	if s.Timezone == "" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
+// cronSpec returns the cron expression of a schedule in its timezone
+func (s *BackupSchedule) cronSpec() string {
+	if s.Timezone == "" {
+		return s.CronSchedule
+	}
+	return "CRON_TZ=" + s.Timezone + " " + s.CronSchedule
+}
+
+// blackoutEnd returns when the blackout windows of a schedule stop covering
+// t, or false when t is outside of them
+func (s *BackupSchedule) blackoutEnd(t time.Time) (time.Time, bool) {
+	return blackoutEnd(s.BlackoutWindows, t.In(s.location()))
+}
+
+func blackoutEnd(windows []BlackoutWindow, t time.Time) (time.Time, bool) {
+	blocked := false
+	// Windows can follow or overlap each other, so move on until t is
+	// outside all of them
+	for moved, i := true, 0; moved && i < 1000; i++ {
+		moved = false
+		for _, window := range windows {
+			if end, ok := window.end(t); ok {
+				t = end.In(t.Location())
+				blocked, moved = true, true
+			}
+		}
+	}
+	return t, blocked
+}
+
+// validateScheduleTiming checks the timezone, blackout windows, jitter and
+// missed run policy of a schedule and fills in the default policy
+func validateScheduleTiming(schedule *BackupSchedule) error {
+	if schedule.Timezone != "" {
+		if _, err := time.LoadLocation(schedule.Timezone); err != nil {
+			return fmt.Errorf("invalid timezone %q: %v", schedule.Timezone, err)
+		}
+	}
+
+	for _, window := range schedule.BlackoutWindows {
+		if err := window.validate(); err != nil {
+			return err
+		}
+	}
+	// Weekly windows repeat, so if they leave a gap it starts within a week
+	var weekly []BlackoutWindow
+	for _, window := range schedule.BlackoutWindows {
+		if window.From == nil {
+			weekly = append(weekly, window)
+		}
+	}
+	now := time.Now().In(schedule.location())
+	if end, _ := blackoutEnd(weekly, now); end.Sub(now) > 7*24*time.Hour {
+		return fmt.Errorf("blackout windows cannot cover the whole week")
+	}
+
+	if schedule.JitterSeconds < 0 || schedule.JitterSeconds > maxJitterSeconds {
+		return fmt.Errorf("jitter_seconds must be between 0 and %d", maxJitterSeconds)
+	}
+
+	switch schedule.MissedRunPolicy {
+	case "":
+		schedule.MissedRunPolicy = MissedRunOnce
+	case MissedRunOnce, MissedRunImmediately, MissedRunSkip:
+	default:
+		return fmt.Errorf("invalid missed_run_policy %q: must be %s, %s or %s",
+			schedule.MissedRunPolicy, MissedRunOnce, MissedRunImmediately, MissedRunSkip)
+	}
+	return nil
+}
+
+// runScheduledBackup is the cron job of a schedule. The schedule is loaded
+// again once the jitter is over, since it may have been changed, paused or
+// deleted in the meantime.
+func (s *BackupService) runScheduledBackup(registered *BackupSchedule) {
+	if registered.JitterSeconds > 0 {
+		time.Sleep(time.Duration(rand.Int63n(int64(registered.JitterSeconds) * int64(time.Second))))
+	}
+
+	schedule := s.currentSchedule(registered.ID.String())
+	if schedule == nil {
+		return
+	}
+	// A changed cron expression has a job of its own
+	if schedule.cronSpec() != registered.cronSpec() {
+		return
+	}
+
+	if until, blocked := schedule.blackoutEnd(time.Now()); blocked {
+		fmt.Printf("Backup schedule %s is in a blackout window until %s\n", schedule.ID, until.Format(time.RFC3339))
+		s.handleMissedRuns(schedule, 1)
+		return
+	}
+
+	s.executeCronBackup(schedule)
+}
+
+// currentSchedule loads a schedule that is about to run, or returns nil when
+// it was deleted or disabled
+func (s *BackupService) currentSchedule(scheduleID string) *BackupSchedule {
+	schedule, err := s.backupRepo.GetScheduleByID(scheduleID)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		fmt.Printf("Error loading backup schedule %s: %v\n", scheduleID, err)
+		return nil
+	}
+	if !schedule.Enabled {
+		return nil
+	}
+	return schedule
+}
+
+// countMissedRuns counts the runs of a schedule that were due before now
+func countMissedRuns(schedule *BackupSchedule, now time.Time) int {
+	if schedule.NextRunTime == nil || !schedule.NextRunTime.Before(now) {
+		return 0
+	}
+	cronSchedule, err := scheduleParser.Parse(schedule.cronSpec())
+	if err != nil {
+		return 1
+	}
+	missed := 1
+	for t := cronSchedule.Next(*schedule.NextRunTime); !t.After(now) && missed < maxMissedRuns; t = cronSchedule.Next(t) {
+		missed++
+	}
+	return missed
+}
+
+// handleMissedRuns applies the missed run policy of a schedule. The runs
+// that are made up for start as soon as no blackout window covers them.
+func (s *BackupService) handleMissedRuns(schedule *BackupSchedule, missed int) {
+	runs := 1
+	switch schedule.MissedRunPolicy {
+	case MissedRunSkip:
+		nextRun := s.nextRunTime(schedule)
+		schedule.NextRunTime = &nextRun
+		schedule.UpdatedAt = time.Now()
+		if err := s.backupRepo.UpdateScheduleRunTimes(schedule); err != nil {
+			fmt.Printf("Error updating backup schedule: %v\n", err)
+		}
+		return
+	case MissedRunImmediately:
+		runs = min(missed, maxMissedRuns)
+	}
+
+	scheduleID := schedule.ID.String()
+	s.scheduleMu.Lock()
+	defer s.scheduleMu.Unlock()
+
+	if pending, ok := s.pendingRuns[scheduleID]; ok {
+		if schedule.MissedRunPolicy == MissedRunImmediately {
+			pending.runs = min(pending.runs+runs, maxMissedRuns)
+		}
+		return
+	}
+
+	pending := &pendingRuns{runs: runs}
+	until, _ := schedule.blackoutEnd(time.Now())
+	pending.timer = time.AfterFunc(time.Until(until), func() {
+		s.runPendingRuns(schedule)
+	})
+	s.pendingRuns[scheduleID] = pending
+}
+
+// runPendingRuns makes up for the missed runs of a schedule once its
+// blackout window is over, with the schedule as it is by then
+func (s *BackupService) runPendingRuns(registered *BackupSchedule) {
+	scheduleID := registered.ID.String()
+	schedule := s.currentSchedule(scheduleID)
+
+	s.scheduleMu.Lock()
+	pending, ok := s.pendingRuns[scheduleID]
+	if !ok {
+		s.scheduleMu.Unlock()
+		return
+	}
+	if schedule == nil {
+		delete(s.pendingRuns, scheduleID)
+		s.scheduleMu.Unlock()
+		return
+	}
+	// A window may have been declared while waiting
+	if until, blocked := schedule.blackoutEnd(time.Now()); blocked {
+		pending.timer = time.AfterFunc(time.Until(until), func() {
+			s.runPendingRuns(schedule)
+		})
+		s.scheduleMu.Unlock()
+		return
+	}
+	delete(s.pendingRuns, scheduleID)
+	s.scheduleMu.Unlock()
+
+	for i := 0; i < pending.runs; i++ {
+		s.executeCronBackup(schedule)
+	}
+}
+
+func marshalBlackoutWindows(windows []BlackoutWindow) (sql.NullString, error) {
+	if len(windows) == 0 {
+		return sql.NullString{}, nil
+	}
+	data, err := json.Marshal(windows)
+	if err != nil {
+		return sql.NullString{}, fmt.Errorf("failed to encode blackout windows: %w", err)
+	}
+	return sql.NullString{String: string(data), Valid: true}, nil
+}
+
+func unmarshalBlackoutWindows(value sql.NullString) ([]BlackoutWindow, error) {
+	if !value.Valid || value.String == "" {
+		return nil, nil
+	}
+	var windows []BlackoutWindow
+	if err := json.Unmarshal([]byte(value.String), &windows); err != nil {
+		return nil, fmt.Errorf("failed to decode blackout windows: %w", err)
+	}
+	return windows, nil
+}
@@ -10,27 +10,49 @@ import (
 	"github.com/dendianugerah/velld/internal/connection"
 )
 
-var requiredTools = map[string]string{
-	"postgresql": "pg_dump",
-	"mysql":      "mysqldump",
-	"mariadb":    "mysqldump",
-	"mongodb":    "mongodump",
-	"redis":      "redis-cli",
-}
+var requiredTools = common.DumpToolNames
+
+// verifyBackupTools refuses to run when the only dump tools installed are
+// known not to work against the server version recorded for conn.
+func (s *BackupService) verifyBackupTools(conn *connection.StoredConnection) error {
+	toolName, exists := requiredTools[conn.Type]
+	if !exists {
+		return fmt.Errorf("unsupported database type: %s", conn.Type)
+	}
+
+	if conn.ServerVersion == "" {
+		return nil
+	}
 
-func (s *BackupService) verifyBackupTools(dbType string) error {
-	if _, exists := requiredTools[dbType]; !exists {
-		return fmt.Errorf("unsupported database type: %s", dbType)
+	_, compatibility := common.SelectToolInstall(conn.Type, toolName, conn.ServerVersion)
+	if compatibility.Install == nil {
+		return nil
 	}
+
+	switch compatibility.Status {
+	case common.CompatibilityIncompatible:
+		return fmt.Errorf("%s %s at %s is incompatible with %s server %s: %s",
+			toolName, compatibility.Install.Version.String(), compatibility.Install.Dir,
+			conn.Type, conn.ServerVersion, compatibility.Message)
+	case common.CompatibilityWarning:
+		fmt.Printf("WARNING: %s for connection %s: %s\n", toolName, conn.ID, compatibility.Message)
+	}
+
 	return nil
 }
 
-func (s *BackupService) findDatabaseBinaryPath(dbType string) string {
-	if path := common.FindBinaryPath(dbType, requiredTools[dbType]); path != "" {
-		return path
+func (s *BackupService) findDatabaseBinaryPath(conn *connection.StoredConnection) string {
+	return findToolDir(conn, requiredTools[conn.Type])
+}
+
+// findToolDir returns the directory of the install of toolName that best fits
+// the server behind conn, falling back to the first one found on this host.
+func findToolDir(conn *connection.StoredConnection, toolName string) string {
+	if install, _ := common.SelectToolInstall(conn.Type, toolName, conn.ServerVersion); install != nil {
+		return install.Dir
 	}
 
-	return ""
+	return common.FindBinaryPath(conn.Type, toolName)
 }
 
 func (s *BackupService) setupSSHTunnelIfNeeded(conn *connection.StoredConnection) (*connection.SSHTunnel, string, int, error) {
@@ -58,52 +80,67 @@ func (s *BackupService) setupSSHTunnelIfNeeded(conn *connection.StoredConnection
 	return tunnel, "127.0.0.1", tunnel.GetLocalPort(), nil
 }
 
-func (s *BackupService) createPgDumpCmd(conn *connection.StoredConnection, outputPath string) *exec.Cmd {
-	binaryPath := s.findDatabaseBinaryPath("postgresql")
+// The dump command builders return a nil command when the tool cannot be
+// found and an error when the command cannot be prepared. The returned
+// cleanup removes any credential file and must be called once the command
+// has finished.
+
+func (s *BackupService) createPgDumpCmd(conn *connection.StoredConnection, outputPath string) (*exec.Cmd, func(), error) {
+	binaryPath := s.findDatabaseBinaryPath(conn)
 	if binaryPath == "" {
 		fmt.Printf("ERROR: pg_dump binary not found. Please install PostgreSQL client tools.\n")
-		return nil
+		return nil, noCleanup, nil
 	}
 
 	binPath := filepath.Join(binaryPath, common.GetPlatformExecutableName(requiredTools["postgresql"]))
 
 	// Use original host/port (SSH tunnel handled at backup execution level)
-	cmd := exec.Command(binPath,
+	args := []string{
 		"-h", conn.Host,
 		"-p", fmt.Sprintf("%d", conn.Port),
 		"-U", conn.Username,
 		"-d", conn.DatabaseName,
 		"-f", outputPath,
-	)
+	}
+	args = append(args, pgDumpOptionArgs(conn.DumpOptions.PostgresOptions())...)
+
+	cmd := exec.Command(binPath, args...)
 
 	cmd.Env = append(os.Environ(), fmt.Sprintf("PGPASSWORD=%s", conn.Password))
-	return cmd
+	return cmd, noCleanup, nil
 }
 
-func (s *BackupService) createMySQLDumpCmd(conn *connection.StoredConnection, outputPath string) *exec.Cmd {
-	binaryPath := s.findDatabaseBinaryPath(conn.Type)
+func (s *BackupService) createMySQLDumpCmd(conn *connection.StoredConnection, outputPath string) (*exec.Cmd, func(), error) {
+	binaryPath := s.findDatabaseBinaryPath(conn)
 	if binaryPath == "" {
 		fmt.Printf("ERROR: mysqldump binary not found. Please install MySQL/MariaDB client tools.\n")
-		return nil
+		return nil, noCleanup, nil
+	}
+
+	optionFile, cleanup, err := writeMySQLOptionFile(conn)
+	if err != nil {
+		return nil, noCleanup, err
 	}
 
 	binPath := filepath.Join(binaryPath, common.GetPlatformExecutableName(requiredTools[conn.Type]))
-	cmd := exec.Command(binPath,
+	// --defaults-extra-file must be the first argument
+	args := []string{
+		"--defaults-extra-file=" + optionFile,
 		"-h", conn.Host,
 		"-P", fmt.Sprintf("%d", conn.Port),
-		"-u", conn.Username,
-		fmt.Sprintf("-p%s", conn.Password),
-		conn.DatabaseName,
-		"-r", outputPath,
-	)
-	return cmd
+	}
+	args = append(args, mysqlDumpOptionArgs(conn.DumpOptions.MySQLOptions())...)
+	args = append(args, conn.DatabaseName, "-r", outputPath)
+
+	cmd := exec.Command(binPath, args...)
+	return cmd, cleanup, nil
 }
 
-func (s *BackupService) createMongoDumpCmd(conn *connection.StoredConnection, outputPath string) *exec.Cmd {
-	binaryPath := s.findDatabaseBinaryPath("mongodb")
+func (s *BackupService) createMongoDumpCmd(conn *connection.StoredConnection, outputPath string) (*exec.Cmd, func(), error) {
+	binaryPath := s.findDatabaseBinaryPath(conn)
 	if binaryPath == "" {
 		fmt.Printf("ERROR: mongodump binary not found. Please install MongoDB Database Tools.\n")
-		return nil
+		return nil, noCleanup, nil
 	}
 
 	binPath := filepath.Join(binaryPath, common.GetPlatformExecutableName(requiredTools["mongodb"]))
@@ -118,18 +155,26 @@ func (s *BackupService) createMongoDumpCmd(conn *connection.StoredConnection, ou
 		args = append(args, "--username", conn.Username)
 	}
 
+	cleanup := noCleanup
 	if conn.Password != "" {
-		args = append(args, "--password", conn.Password)
+		configFile, configCleanup, err := writeMongoConfigFile(conn)
+		if err != nil {
+			return nil, noCleanup, err
+		}
+		cleanup = configCleanup
+		args = append(args, "--config", configFile)
 	}
 
-	return exec.Command(binPath, args...)
+	args = append(args, mongoDumpOptionArgs(conn.DumpOptions.MongoOptions())...)
+
+	return exec.Command(binPath, args...), cleanup, nil
 }
 
-func (s *BackupService) createRedisDumpCmd(conn *connection.StoredConnection, outputPath string) *exec.Cmd {
-	binaryPath := s.findDatabaseBinaryPath("redis")
+func (s *BackupService) createRedisDumpCmd(conn *connection.StoredConnection, outputPath string) (*exec.Cmd, func(), error) {
+	binaryPath := s.findDatabaseBinaryPath(conn)
 	if binaryPath == "" {
 		fmt.Printf("ERROR: redis-cli binary not found. Please install Redis tools.\n")
-		return nil
+		return nil, noCleanup, nil
 	}
 
 	binPath := filepath.Join(binaryPath, common.GetPlatformExecutableName(requiredTools["redis"]))
@@ -138,15 +183,82 @@ func (s *BackupService) createRedisDumpCmd(conn *connection.StoredConnection, ou
 		"-p", fmt.Sprintf("%d", conn.Port),
 	}
 
-	if conn.Password != "" {
-		args = append(args, "-a", conn.Password)
-	}
-
 	if conn.DatabaseName != "" {
 		args = append(args, "-n", conn.DatabaseName)
 	}
 
 	args = append(args, "--rdb", outputPath)
 
-	return exec.Command(binPath, args...)
+	cmd := exec.Command(binPath, args...)
+	if conn.Password != "" {
+		cmd.Env = append(os.Environ(), fmt.Sprintf("REDISCLI_AUTH=%s", conn.Password))
+	}
+	return cmd, noCleanup, nil
+}
+
+// dumpFileExtension returns the file extension for the artifact produced by
+// the dump tool of the given connection.
+func dumpFileExtension(conn *connection.StoredConnection) string {
+	if conn.Type == "postgresql" && conn.DumpOptions.PostgresOptions().Format == connection.PostgresFormatCustom {
+		return "dump"
+	}
+	return "sql"
+}
+
+func pgDumpOptionArgs(opts connection.PostgresDumpOptions) []string {
+	var args []string
+	if opts.Format == connection.PostgresFormatCustom {
+		args = append(args, "--format=custom")
+	}
+	if opts.NoOwner {
+		args = append(args, "--no-owner")
+	}
+	if opts.NoPrivileges {
+		args = append(args, "--no-privileges")
+	}
+	if opts.LockWaitTimeout > 0 {
+		args = append(args, fmt.Sprintf("--lock-wait-timeout=%d", opts.LockWaitTimeout))
+	}
+	if opts.SchemaOnly {
+		args = append(args, "--schema-only")
+	}
+	return args
+}
+
+func mysqlDumpOptionArgs(opts connection.MySQLDumpOptions) []string {
+	var args []string
+	if opts.SingleTransaction {
+		args = append(args, "--single-transaction", "--quick")
+	}
+	if opts.Routines {
+		args = append(args, "--routines")
+	}
+	// Triggers are dumped by default, so only the opt-out needs a flag
+	if !opts.Triggers {
+		args = append(args, "--skip-triggers")
+	}
+	if opts.Events {
+		args = append(args, "--events")
+	}
+	if opts.SetGTIDPurged != "" {
+		args = append(args, "--set-gtid-purged="+opts.SetGTIDPurged)
+	}
+	if opts.NoData {
+		args = append(args, "--no-data")
+	}
+	if opts.DefaultCharacterSet != "" {
+		args = append(args, "--default-character-set="+opts.DefaultCharacterSet)
+	}
+	return args
+}
+
+func mongoDumpOptionArgs(opts connection.MongoDumpOptions) []string {
+	var args []string
+	if opts.Gzip {
+		args = append(args, "--gzip")
+	}
+	if opts.ReadPreference != "" {
+		args = append(args, "--readPreference="+opts.ReadPreference)
+	}
+	return args
 }
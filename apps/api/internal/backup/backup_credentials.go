@@ -0,0 +1,107 @@
+package backup
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/dendianugerah/velld/internal/connection"
+)
+
+// credentialFilePatterns name the temporary files written for the dump and
+// restore tools
+var credentialFilePatterns = []string{"velld-mysql-*.cnf", "velld-mongo-*.yaml"}
+
+// credentialDir is a directory only this process writes credential files
+// to, so that other processes sharing the temporary directory can neither
+// read nor remove them. Files left behind by a killed process stay in its
+// own directory, which only the same user can read.
+var (
+	credentialDir     string
+	credentialDirErr  error
+	credentialDirOnce sync.Once
+)
+
+// noCleanup is returned by command builders that leave nothing behind
+func noCleanup() {}
+
+// credentialDirectory creates credentialDir on first use
+func credentialDirectory() (string, error) {
+	credentialDirOnce.Do(func() {
+		dir, err := os.MkdirTemp("", "velld-credentials-*")
+		if err != nil {
+			credentialDirErr = fmt.Errorf("failed to create credential directory: %w", err)
+			return
+		}
+		if err := os.Chmod(dir, 0700); err != nil {
+			os.RemoveAll(dir)
+			credentialDirErr = fmt.Errorf("failed to restrict credential directory permissions: %w", err)
+			return
+		}
+		credentialDir = dir
+	})
+	return credentialDir, credentialDirErr
+}
+
+// writeCredentialFile writes content to a temporary file that only the current
+// user can read. The returned cleanup removes the file and must always be called.
+func writeCredentialFile(pattern, content string) (string, func(), error) {
+	dir, err := credentialDirectory()
+	if err != nil {
+		return "", noCleanup, err
+	}
+
+	file, err := os.CreateTemp(dir, pattern)
+	if err != nil {
+		return "", noCleanup, fmt.Errorf("failed to create credential file: %w", err)
+	}
+
+	path := file.Name()
+	cleanup := func() { os.Remove(path) }
+
+	if err := file.Chmod(0600); err != nil {
+		file.Close()
+		cleanup()
+		return "", noCleanup, fmt.Errorf("failed to restrict credential file permissions: %w", err)
+	}
+
+	if _, err := file.WriteString(content); err != nil {
+		file.Close()
+		cleanup()
+		return "", noCleanup, fmt.Errorf("failed to write credential file: %w", err)
+	}
+
+	if err := file.Close(); err != nil {
+		cleanup()
+		return "", noCleanup, fmt.Errorf("failed to write credential file: %w", err)
+	}
+
+	return path, cleanup, nil
+}
+
+// writeMySQLOptionFile creates a [client] option file for --defaults-extra-file
+// so the password never appears in the process arguments.
+func writeMySQLOptionFile(conn *connection.StoredConnection) (string, func(), error) {
+	content := fmt.Sprintf("[client]\nuser=%s\npassword=%s\n",
+		quoteMySQLOption(conn.Username), quoteMySQLOption(conn.Password))
+	return writeCredentialFile(credentialFilePatterns[0], content)
+}
+
+// writeMongoConfigFile creates a YAML file for the --config flag of the
+// MongoDB Database Tools, which is the only supported way to pass the password
+// outside the command line.
+func writeMongoConfigFile(conn *connection.StoredConnection) (string, func(), error) {
+	// A JSON string is a valid double-quoted YAML scalar
+	password, err := json.Marshal(conn.Password)
+	if err != nil {
+		return "", noCleanup, fmt.Errorf("failed to encode mongo password: %w", err)
+	}
+	return writeCredentialFile(credentialFilePatterns[1], fmt.Sprintf("password: %s\n", password))
+}
+
+func quoteMySQLOption(value string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	return `"` + replacer.Replace(value) + `"`
+}
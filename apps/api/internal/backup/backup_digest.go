@@ -0,0 +1,493 @@
+package backup
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/dendianugerah/velld/internal/common"
+	"github.com/dendianugerah/velld/internal/common/response"
+	"github.com/dendianugerah/velld/internal/mail"
+	"github.com/dendianugerah/velld/internal/settings"
+	"github.com/google/uuid"
+)
+
+const (
+	// digestCheckSpec is how often the digests that are due are looked for
+	digestCheckSpec = "@every 1m"
+	// digestTopChanges is how many size changes a digest lists
+	digestTopChanges = 5
+	// digestEventType is the event name of digests sent to the webhook
+	digestEventType = "digest"
+)
+
+// Storage destinations of a digest
+const (
+	DestinationLocal = "local"
+	DestinationS3    = "s3"
+)
+
+// Digest summarises the backups of a user over a period
+type Digest struct {
+	Frequency   string                `json:"frequency"`
+	PeriodStart time.Time             `json:"period_start"`
+	PeriodEnd   time.Time             `json:"period_end"`
+	Runs        int                   `json:"runs"`
+	Succeeded   int                   `json:"succeeded"`
+	Failed      int                   `json:"failed"`
+	SizeAdded   int64                 `json:"size_added"`
+	Connections []DigestConnection    `json:"connections"`
+	Storage     []DigestStorage       `json:"storage"`
+	RPOBreaches []ConnectionRPOStatus `json:"rpo_breaches"`
+	SizeChanges []DigestSizeChange    `json:"size_changes"`
+}
+
+// DigestConnection counts the backups of a connection over the period.
+// Suspicious backups count as succeeded and are also counted on their own.
+type DigestConnection struct {
+	ConnectionID   string `json:"connection_id"`
+	ConnectionName string `json:"connection_name"`
+	DatabaseType   string `json:"database_type"`
+	Runs           int    `json:"runs"`
+	Succeeded      int    `json:"succeeded"`
+	Failed         int    `json:"failed"`
+	Suspicious     int    `json:"suspicious"`
+	SizeAdded      int64  `json:"size_added"`
+}
+
+// DigestStorage is what the backups of a user take up in a destination at
+// the end of the period
+type DigestStorage struct {
+	Destination string `json:"destination"`
+	Backups     int    `json:"backups"`
+	Size        int64  `json:"size"`
+}
+
+// DigestSizeChange compares the latest backup of a connection in the period
+// with the one before it
+type DigestSizeChange struct {
+	ConnectionID   string  `json:"connection_id"`
+	ConnectionName string  `json:"connection_name"`
+	PreviousSize   int64   `json:"previous_size"`
+	CurrentSize    int64   `json:"current_size"`
+	Change         int64   `json:"change"`
+	ChangePercent  float64 `json:"change_percent"`
+}
+
+// digestBackup is a backup as read for a digest
+type digestBackup struct {
+	ID             string
+	ConnectionID   string
+	ConnectionName string
+	DatabaseType   string
+	Status         string
+	Path           string
+	S3ObjectKey    *string
+	Size           int64
+	StartedTime    time.Time
+}
+
+func (b *digestBackup) succeeded() bool {
+	return b.Status == "completed" || b.Status == "suspicious"
+}
+
+// startDigestScheduler sends the digests that are due periodically, so
+// that digests missed while the server was down go out once it is back
+func (s *BackupService) startDigestScheduler() {
+	if _, err := s.cronManager.AddFunc(digestCheckSpec, s.sendDueDigests); err != nil {
+		fmt.Printf("Error scheduling digests: %v\n", err)
+	}
+}
+
+func (s *BackupService) sendDueDigests() {
+	userIDs, err := s.settingsService.ListDigestUserIDs()
+	if err != nil {
+		fmt.Printf("ERROR: failed to list digest users: %v\n", err)
+		return
+	}
+
+	now := time.Now()
+	for _, userID := range userIDs {
+		userSettings, err := s.settingsService.GetUserSettingsInternal(userID)
+		if err != nil {
+			fmt.Printf("ERROR: failed to get settings of user %s: %v\n", userID, err)
+			continue
+		}
+		if !userSettings.DigestDue(now) {
+			continue
+		}
+
+		periodStart, periodEnd := userSettings.LastDigestTime(now)
+		// The digest is marked sent first, a failing channel is not retried
+		// every minute
+		if err := s.settingsService.SetDigestSentAt(userID, now); err != nil {
+			fmt.Printf("ERROR: failed to record digest of user %s: %v\n", userID, err)
+			continue
+		}
+		if _, err := s.sendDigest(userSettings, periodStart, periodEnd); err != nil {
+			fmt.Printf("ERROR: failed to send digest of user %s: %v\n", userID, err)
+		}
+	}
+}
+
+// SendDigest sends the digest of the period up to now through the digest
+// channels of a user
+func (s *BackupService) SendDigest(userID uuid.UUID) (*Digest, error) {
+	userSettings, err := s.settingsService.GetUserSettingsInternal(userID)
+	if err != nil {
+		return nil, err
+	}
+	if !userSettings.DigestEmail && !userSettings.DigestWebhook {
+		return nil, fmt.Errorf("no digest channel is turned on")
+	}
+
+	periodStart, periodEnd := digestPeriod(userSettings, time.Now())
+	return s.sendDigest(userSettings, periodStart, periodEnd)
+}
+
+// GetDigest builds the digest of the period up to now without sending it
+func (s *BackupService) GetDigest(userID uuid.UUID, frequency string) (*Digest, error) {
+	userSettings, err := s.settingsService.GetUserSettingsInternal(userID)
+	if err != nil {
+		return nil, err
+	}
+	if frequency != "" {
+		userSettings.DigestFrequency = &frequency
+	}
+
+	periodStart, periodEnd := digestPeriod(userSettings, time.Now())
+	return s.BuildDigest(userID, digestFrequency(userSettings), periodStart, periodEnd)
+}
+
+func (s *BackupService) sendDigest(userSettings *settings.UserSettings, periodStart, periodEnd time.Time) (*Digest, error) {
+	digest, err := s.BuildDigest(userSettings.UserID, digestFrequency(userSettings), periodStart, periodEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	if userSettings.DigestWebhook && userSettings.WebhookURL != nil && *userSettings.WebhookURL != "" {
+		if _, err := s.webhookService.Deliver(userSettings.UserID, *userSettings.WebhookURL, digestEventType, digest); err != nil {
+			fmt.Printf("Error sending digest webhook: %v\n", err)
+		}
+	}
+
+	if userSettings.DigestEmail && userSettings.Email != nil && *userSettings.Email != "" {
+		rendered, err := renderDigest(digest, userSettings.DigestLocation())
+		if err != nil {
+			return nil, err
+		}
+		go func() {
+			if err := s.sendEmailNotification(userSettings, rendered); err != nil {
+				log.Printf("Failed to send digest email: %v", err)
+			}
+		}()
+	}
+
+	return digest, nil
+}
+
+// BuildDigest summarises the backups of a user started in
+// [periodStart, periodEnd)
+func (s *BackupService) BuildDigest(userID uuid.UUID, frequency string, periodStart, periodEnd time.Time) (*Digest, error) {
+	backups, err := s.backupRepo.GetDigestBackups(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get backups: %v", err)
+	}
+
+	digest := &Digest{
+		Frequency:   frequency,
+		PeriodStart: periodStart,
+		PeriodEnd:   periodEnd,
+		Connections: []DigestConnection{},
+		RPOBreaches: []ConnectionRPOStatus{},
+		SizeChanges: []DigestSizeChange{},
+	}
+
+	connections := map[string]*DigestConnection{}
+	var order []string
+	// latest and previous completed backup of each connection, as of the
+	// end of the period
+	latest := map[string]*digestBackup{}
+	previous := map[string]*digestBackup{}
+	storage := map[string]*DigestStorage{
+		DestinationLocal: {Destination: DestinationLocal},
+		DestinationS3:    {Destination: DestinationS3},
+	}
+
+	for i := range backups {
+		backup := &backups[i]
+		if !backup.StartedTime.Before(periodEnd) {
+			continue
+		}
+
+		if backup.succeeded() {
+			if backup.S3ObjectKey != nil && *backup.S3ObjectKey != "" {
+				storage[DestinationS3].Backups++
+				storage[DestinationS3].Size += backup.Size
+			}
+			if _, err := os.Stat(backup.Path); err == nil {
+				storage[DestinationLocal].Backups++
+				storage[DestinationLocal].Size += backup.Size
+			}
+		}
+
+		if backup.StartedTime.Before(periodStart) {
+			if backup.succeeded() {
+				previous[backup.ConnectionID] = backup
+			}
+			continue
+		}
+
+		conn, ok := connections[backup.ConnectionID]
+		if !ok {
+			conn = &DigestConnection{
+				ConnectionID:   backup.ConnectionID,
+				ConnectionName: backup.ConnectionName,
+				DatabaseType:   backup.DatabaseType,
+			}
+			connections[backup.ConnectionID] = conn
+			order = append(order, backup.ConnectionID)
+		}
+
+		conn.Runs++
+		switch {
+		case backup.succeeded():
+			conn.Succeeded++
+			conn.SizeAdded += backup.Size
+			if backup.Status == "suspicious" {
+				conn.Suspicious++
+			}
+			// The first backup of the period is compared with the last one
+			// before it, later ones with the one before them in the period
+			if last, ok := latest[backup.ConnectionID]; ok {
+				previous[backup.ConnectionID] = last
+			}
+			latest[backup.ConnectionID] = backup
+		case backup.Status == "failed":
+			conn.Failed++
+		}
+	}
+
+	for _, id := range order {
+		conn := connections[id]
+		digest.Connections = append(digest.Connections, *conn)
+		digest.Runs += conn.Runs
+		digest.Succeeded += conn.Succeeded
+		digest.Failed += conn.Failed
+		digest.SizeAdded += conn.SizeAdded
+
+		current, hasCurrent := latest[id]
+		before, hasBefore := previous[id]
+		if !hasCurrent || !hasBefore || current.Size == before.Size {
+			continue
+		}
+		change := DigestSizeChange{
+			ConnectionID:   id,
+			ConnectionName: conn.ConnectionName,
+			PreviousSize:   before.Size,
+			CurrentSize:    current.Size,
+			Change:         current.Size - before.Size,
+		}
+		if before.Size > 0 {
+			change.ChangePercent = float64(change.Change) / float64(before.Size) * 100
+		}
+		digest.SizeChanges = append(digest.SizeChanges, change)
+	}
+
+	sort.Slice(digest.Connections, func(i, j int) bool {
+		return digest.Connections[i].ConnectionName < digest.Connections[j].ConnectionName
+	})
+	sort.Slice(digest.SizeChanges, func(i, j int) bool {
+		return math.Abs(float64(digest.SizeChanges[i].Change)) > math.Abs(float64(digest.SizeChanges[j].Change))
+	})
+	if len(digest.SizeChanges) > digestTopChanges {
+		digest.SizeChanges = digest.SizeChanges[:digestTopChanges]
+	}
+
+	digest.Storage = []DigestStorage{*storage[DestinationLocal], *storage[DestinationS3]}
+
+	compliance, err := s.GetRPOCompliance(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to evaluate RPO: %v", err)
+	}
+	for _, status := range compliance.Connections {
+		if !status.Compliant {
+			digest.RPOBreaches = append(digest.RPOBreaches, status)
+		}
+	}
+
+	return digest, nil
+}
+
+// digestPeriod is the period of a digest sent at now
+func digestPeriod(userSettings *settings.UserSettings, now time.Time) (time.Time, time.Time) {
+	if digestFrequency(userSettings) == settings.DigestWeekly {
+		return now.AddDate(0, 0, -7), now
+	}
+	return now.AddDate(0, 0, -1), now
+}
+
+func digestFrequency(userSettings *settings.UserSettings) string {
+	if userSettings.DigestFrequency != nil && *userSettings.DigestFrequency == settings.DigestWeekly {
+		return settings.DigestWeekly
+	}
+	return settings.DigestDaily
+}
+
+var digestFuncs = map[string]interface{}{
+	"bytes": formatBytes,
+	"signedBytes": func(size int64) string {
+		if size < 0 {
+			return "-" + formatBytes(-size)
+		}
+		return "+" + formatBytes(size)
+	},
+	"percent": func(value float64) string {
+		return fmt.Sprintf("%+.1f%%", value)
+	},
+}
+
+const digestSubjectTemplate = `Velld {{if eq .Frequency "weekly"}}weekly{{else}}daily{{end}} digest: {{.Succeeded}}/{{.Runs}} backups succeeded{{if .Failed}}, {{.Failed}} failed{{end}}`
+
+const digestTextTemplate = `Backups from {{.PeriodStart.Format "2006-01-02 15:04"}} to {{.PeriodEnd.Format "2006-01-02 15:04 MST"}}
+
+Runs: {{.Runs}}, succeeded: {{.Succeeded}}, failed: {{.Failed}}, size added: {{bytes .SizeAdded}}
+{{- if .Connections}}
+
+Connections:
+{{- range .Connections}}
+- {{.ConnectionName}} ({{.DatabaseType}}): {{.Runs}} runs, {{.Succeeded}} succeeded, {{.Failed}} failed{{if .Suspicious}}, {{.Suspicious}} suspicious{{end}}, {{bytes .SizeAdded}} added
+{{- end}}
+{{- end}}
+
+Storage:
+{{- range .Storage}}
+- {{.Destination}}: {{.Backups}} backups, {{bytes .Size}}
+{{- end}}
+{{- if .RPOBreaches}}
+
+Recovery point objectives missed:
+{{- range .RPOBreaches}}
+- {{.ConnectionName}}: no backup within {{.RPOMinutes}} minutes
+{{- end}}
+{{- end}}
+{{- if .SizeChanges}}
+
+Largest size changes:
+{{- range .SizeChanges}}
+- {{.ConnectionName}}: {{bytes .PreviousSize}} -> {{bytes .CurrentSize}} ({{signedBytes .Change}}{{if .PreviousSize}}, {{percent .ChangePercent}}{{end}})
+{{- end}}
+{{- end}}
+`
+
+const digestHTMLTemplate = `<!DOCTYPE html>
+<html>
+<body style="font-family: -apple-system, Segoe UI, Helvetica, Arial, sans-serif; color: #1f2937;">
+  <h2 style="margin-bottom: 4px;">Backup {{if eq .Frequency "weekly"}}weekly{{else}}daily{{end}} digest</h2>
+  <p style="color: #6b7280;">{{.PeriodStart.Format "2006-01-02 15:04"}} to {{.PeriodEnd.Format "2006-01-02 15:04 MST"}}</p>
+  <p><strong>{{.Runs}}</strong> runs, <strong>{{.Succeeded}}</strong> succeeded, <strong style="color: {{if .Failed}}#b91c1c{{else}}inherit{{end}};">{{.Failed}}</strong> failed, {{bytes .SizeAdded}} added</p>
+  {{- if .Connections}}
+  <h3>Connections</h3>
+  <table cellpadding="4" style="border-collapse: collapse;">
+    <tr><th align="left">Connection</th><th>Runs</th><th>Succeeded</th><th>Failed</th><th>Suspicious</th><th align="right">Added</th></tr>
+    {{- range .Connections}}
+    <tr><td>{{.ConnectionName}} ({{.DatabaseType}})</td><td align="center">{{.Runs}}</td><td align="center">{{.Succeeded}}</td><td align="center">{{.Failed}}</td><td align="center">{{.Suspicious}}</td><td align="right">{{bytes .SizeAdded}}</td></tr>
+    {{- end}}
+  </table>
+  {{- end}}
+  <h3>Storage</h3>
+  <table cellpadding="4" style="border-collapse: collapse;">
+    {{- range .Storage}}
+    <tr><td>{{.Destination}}</td><td>{{.Backups}} backups</td><td align="right">{{bytes .Size}}</td></tr>
+    {{- end}}
+  </table>
+  {{- if .RPOBreaches}}
+  <h3 style="color: #b91c1c;">Recovery point objectives missed</h3>
+  <ul>
+    {{- range .RPOBreaches}}
+    <li>{{.ConnectionName}}: no backup within {{.RPOMinutes}} minutes</li>
+    {{- end}}
+  </ul>
+  {{- end}}
+  {{- if .SizeChanges}}
+  <h3>Largest size changes</h3>
+  <ul>
+    {{- range .SizeChanges}}
+    <li>{{.ConnectionName}}: {{bytes .PreviousSize}} &rarr; {{bytes .CurrentSize}} ({{signedBytes .Change}}{{if .PreviousSize}}, {{percent .ChangePercent}}{{end}})</li>
+    {{- end}}
+  </ul>
+  {{- end}}
+</body>
+</html>
+`
+
+var (
+	digestSubject = texttemplate.Must(texttemplate.New("subject").Funcs(digestFuncs).Parse(digestSubjectTemplate))
+	digestText    = texttemplate.Must(texttemplate.New("text").Funcs(digestFuncs).Parse(digestTextTemplate))
+	digestHTML    = htmltemplate.Must(htmltemplate.New("html").Funcs(digestFuncs).Parse(digestHTMLTemplate))
+)
+
+// renderDigest renders the digest email with the times of the period in loc
+func renderDigest(digest *Digest, loc *time.Location) (*mail.Rendered, error) {
+	local := *digest
+	local.PeriodStart = digest.PeriodStart.In(loc)
+	local.PeriodEnd = digest.PeriodEnd.In(loc)
+
+	var subject, text, html bytes.Buffer
+	if err := digestSubject.Execute(&subject, &local); err != nil {
+		return nil, fmt.Errorf("failed to render digest subject: %v", err)
+	}
+	if err := digestText.Execute(&text, &local); err != nil {
+		return nil, fmt.Errorf("failed to render digest: %v", err)
+	}
+	if err := digestHTML.Execute(&html, &local); err != nil {
+		return nil, fmt.Errorf("failed to render digest: %v", err)
+	}
+
+	return &mail.Rendered{Subject: subject.String(), Text: text.String(), HTML: html.String()}, nil
+}
+
+func (h *BackupHandler) GetDigest(w http.ResponseWriter, r *http.Request) {
+	userID, err := common.GetUserIDFromContext(r.Context())
+	if err != nil {
+		response.SendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	frequency := r.URL.Query().Get("frequency")
+	if frequency != "" && frequency != settings.DigestDaily && frequency != settings.DigestWeekly {
+		response.SendError(w, http.StatusBadRequest, "frequency must be daily or weekly")
+		return
+	}
+
+	digest, err := h.backupService.GetDigest(userID, frequency)
+	if err != nil {
+		response.SendError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response.SendSuccess(w, "Digest retrieved successfully", digest)
+}
+
+func (h *BackupHandler) SendDigest(w http.ResponseWriter, r *http.Request) {
+	userID, err := common.GetUserIDFromContext(r.Context())
+	if err != nil {
+		response.SendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	digest, err := h.backupService.SendDigest(userID)
+	if err != nil {
+		response.SendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	response.SendSuccess(w, "Digest sent successfully", digest)
+}
@@ -0,0 +1,114 @@
+package backup
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/dendianugerah/velld/internal/events"
+	"github.com/google/uuid"
+)
+
+// BackupProgressEvent reports how much a running backup has written
+type BackupProgressEvent struct {
+	BackupID     uuid.UUID `json:"backup_id"`
+	ConnectionID string    `json:"connection_id"`
+	BytesWritten int64     `json:"bytes_written"`
+}
+
+// RestoreProgressEvent reports how much of its backup a restore has read
+type RestoreProgressEvent struct {
+	JobID        uuid.UUID `json:"job_id"`
+	ConnectionID string    `json:"connection_id"`
+	BytesDone    int64     `json:"bytes_done"`
+	BytesTotal   int64     `json:"bytes_total"`
+	Progress     *float64  `json:"progress"`
+}
+
+// ScheduleDeletedEvent names a schedule that was removed
+type ScheduleDeletedEvent struct {
+	ID           string `json:"id"`
+	ConnectionID string `json:"connection_id"`
+}
+
+// BackupDeletedEvent names a backup that was removed
+type BackupDeletedEvent struct {
+	ID           uuid.UUID `json:"id"`
+	ConnectionID string    `json:"connection_id"`
+}
+
+// publishConnectionEvent publishes an event to the owner of a connection
+func (s *BackupService) publishConnectionEvent(connectionID, eventType string, data interface{}) {
+	conn, err := s.connStorage.GetConnection(connectionID)
+	if err != nil {
+		fmt.Printf("ERROR: failed to publish %s event: %v\n", eventType, err)
+		return
+	}
+	s.eventBus.Publish(conn.UserID, eventType, data)
+}
+
+// publishBackup publishes the state of a backup. Events carry a copy without
+// the log, as the backup keeps changing while it runs.
+func (s *BackupService) publishBackup(userID uuid.UUID, backup *Backup) {
+	snapshot := *backup
+	snapshot.Log = ""
+	s.eventBus.Publish(userID, events.BackupUpdated, snapshot)
+}
+
+// publishRestoreJob publishes the state of a restore job, without its log
+func (s *BackupService) publishRestoreJob(job *RestoreJob) {
+	snapshot := *job
+	snapshot.Log = ""
+	s.publishConnectionEvent(job.ConnectionID, events.RestoreUpdated, snapshot)
+}
+
+// publishSchedule publishes the state of a schedule
+func (s *BackupService) publishSchedule(schedule *BackupSchedule) {
+	s.publishConnectionEvent(schedule.ConnectionID, events.ScheduleUpdated, *schedule)
+}
+
+// publishRestoreProgress publishes the progress of a restore job that has
+// read bytesDone of its backup
+func (s *BackupService) publishRestoreProgress(userID uuid.UUID, job *RestoreJob, bytesDone int64) {
+	event := RestoreProgressEvent{
+		JobID:        job.ID,
+		ConnectionID: job.ConnectionID,
+		BytesDone:    bytesDone,
+		BytesTotal:   job.BytesTotal,
+	}
+	if job.BytesTotal > 0 {
+		progress := float64(bytesDone) / float64(job.BytesTotal) * 100
+		event.Progress = &progress
+	}
+	s.eventBus.Publish(userID, events.RestoreProgress, event)
+}
+
+// watchBackupProgress publishes the size of the dump file of a running
+// backup until the returned function is called
+func (s *BackupService) watchBackupProgress(userID uuid.UUID, backup *Backup) func() {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+
+		var last int64 = -1
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				info, err := os.Stat(backup.Path)
+				if err != nil || info.Size() == last {
+					continue
+				}
+				last = info.Size()
+				s.eventBus.Publish(userID, events.BackupProgress, BackupProgressEvent{
+					BackupID:     backup.ID,
+					ConnectionID: backup.ConnectionID,
+					BytesWritten: last,
+				})
+			}
+		}
+	}()
+	return func() { close(done) }
+}
@@ -0,0 +1,177 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dendianugerah/velld/internal/common"
+	"github.com/dendianugerah/velld/internal/connection"
+)
+
+// maxHookResponseBytes caps how much of an HTTP hook's response is logged
+const maxHookResponseBytes = 4 * 1024
+
+// hookEnv returns the VELLD_* variables describing the connection a hook runs for.
+func hookEnv(stage connection.HookStage, conn *connection.StoredConnection) map[string]string {
+	return map[string]string{
+		"VELLD_HOOK_STAGE":      string(stage),
+		"VELLD_CONNECTION_ID":   conn.ID,
+		"VELLD_CONNECTION_NAME": conn.Name,
+		"VELLD_DATABASE_TYPE":   conn.Type,
+		"VELLD_DATABASE_NAME":   conn.DatabaseName,
+		"VELLD_DATABASE_HOST":   conn.Host,
+		"VELLD_DATABASE_PORT":   strconv.Itoa(conn.Port),
+	}
+}
+
+// backupHookEnv adds the metadata of backup to the connection variables.
+func backupHookEnv(stage connection.HookStage, conn *connection.StoredConnection, backup *Backup) map[string]string {
+	env := hookEnv(stage, conn)
+	env["VELLD_BACKUP_ID"] = backup.ID.String()
+	env["VELLD_BACKUP_PATH"] = backup.Path
+	env["VELLD_BACKUP_STATUS"] = backup.Status
+	env["VELLD_BACKUP_SIZE"] = strconv.FormatInt(backup.Size, 10)
+	env["VELLD_BACKUP_STARTED_AT"] = backup.StartedTime.Format(time.RFC3339)
+	if backup.ScheduleID != nil {
+		env["VELLD_SCHEDULE_ID"] = *backup.ScheduleID
+	}
+	return env
+}
+
+// runHooks runs every hook of the given stage in order and records their
+// output in log. A failing hook only stops the remaining ones, and is
+// returned as an error, when it is configured to abort.
+func (s *BackupService) runHooks(stage connection.HookStage, hooks []connection.Hook, conn *connection.StoredConnection, env map[string]string, log *operationLog) error {
+	for i, hook := range hooks {
+		if hook.Stage != stage {
+			continue
+		}
+
+		name := hook.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", i+1)
+		}
+
+		log.Printf("Running %s %s hook %s", stage, hook.Type, name)
+		start := time.Now()
+
+		output, err := s.runHook(hook, conn, env)
+		log.Output(common.ScrubSecrets(output, conn.Password, conn.SSHPassword))
+
+		if err != nil {
+			err = fmt.Errorf("%s hook %s failed: %s", stage, name, common.ScrubSecrets(err.Error(), conn.Password, conn.SSHPassword))
+			if hook.AbortOnFailure {
+				log.Printf("%v, aborting", err)
+				return err
+			}
+			log.Printf("%v, continuing", err)
+			continue
+		}
+
+		log.Printf("%s hook %s finished in %s", stage, name, time.Since(start).Round(time.Millisecond))
+	}
+
+	return nil
+}
+
+func (s *BackupService) runHook(hook connection.Hook, conn *connection.StoredConnection, env map[string]string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(hook.Timeout())*time.Second)
+	defer cancel()
+
+	var (
+		output string
+		err    error
+	)
+	switch hook.Type {
+	case connection.HookShell:
+		output, err = runShellHook(ctx, hook, env)
+	case connection.HookHTTP:
+		output, err = runHTTPHook(ctx, hook, env)
+	case connection.HookSQL:
+		output, err = runSQLHook(ctx, hook, conn)
+	default:
+		err = fmt.Errorf("unsupported hook type %q", hook.Type)
+	}
+
+	if ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("timed out after %ds", hook.Timeout())
+	}
+	return output, err
+}
+
+func runShellHook(ctx context.Context, hook connection.Hook, env map[string]string) (string, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", hook.Command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", hook.Command)
+	}
+
+	cmd.Env = os.Environ()
+	for key, value := range env {
+		cmd.Env = append(cmd.Env, key+"="+value)
+	}
+	// Don't wait forever on children that keep the output pipe open after a kill
+	cmd.WaitDelay = 5 * time.Second
+
+	output, err := cmd.CombinedOutput()
+	return string(output), err
+}
+
+func runHTTPHook(ctx context.Context, hook connection.Hook, env map[string]string) (string, error) {
+	// Only the backup metadata is expanded, never the server's own environment
+	expand := func(value string) string {
+		return os.Expand(value, func(key string) string { return env[key] })
+	}
+
+	method := strings.ToUpper(hook.Method)
+	if method == "" {
+		method = http.MethodPost
+	}
+
+	var body io.Reader
+	if hook.Body != "" {
+		body = strings.NewReader(expand(hook.Body))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, expand(hook.URL), body)
+	if err != nil {
+		return "", fmt.Errorf("invalid request: %w", err)
+	}
+	if hook.Body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for key, value := range hook.Headers {
+		req.Header.Set(key, expand(value))
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, maxHookResponseBytes))
+	output := fmt.Sprintf("%s %s\n%s", resp.Proto, resp.Status, respBody)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return output, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return output, nil
+}
+
+func runSQLHook(ctx context.Context, hook connection.Hook, conn *connection.StoredConnection) (string, error) {
+	affected, err := connection.ExecStatement(ctx, conn, hook.SQL)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d row(s) affected", affected), nil
+}
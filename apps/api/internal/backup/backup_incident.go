@@ -0,0 +1,71 @@
+package backup
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dendianugerah/velld/internal/connection"
+	"github.com/dendianugerah/velld/internal/incident"
+)
+
+// updateIncidents opens an alert in the incident integrations of a
+// connection for the events they map to a severity, and resolves it once a
+// backup succeeds again. Failures are logged, the alert of a connection
+// stays open until every integration resolved it.
+func (s *BackupService) updateIncidents(conn *connection.StoredConnection, event backupEvent, metadata map[string]interface{}) {
+	if len(conn.Incidents) == 0 {
+		return
+	}
+	dedupKey := incident.DedupKey(conn.ID)
+
+	if incident.Resolves(event.Type) {
+		if conn.IncidentTriggeredAt == nil {
+			return
+		}
+		resolved := true
+		for _, integration := range conn.Incidents {
+			if err := integration.Resolve(dedupKey); err != nil {
+				fmt.Printf("Error resolving %s alert of connection %s: %v\n", integration.Provider, conn.ID, err)
+				resolved = false
+			}
+		}
+		if resolved {
+			if err := s.connStorage.SetIncidentTriggeredAt(conn.ID, nil); err != nil {
+				fmt.Printf("ERROR: failed to clear alert of connection %s: %v\n", conn.ID, err)
+			}
+		}
+		return
+	}
+
+	alert := incident.Alert{
+		DedupKey:  dedupKey,
+		Summary:   fmt.Sprintf("%s: %s", event.Title, event.Message),
+		Source:    fmt.Sprintf("%s (%s)", conn.Name, conn.DatabaseName),
+		Details:   metadata,
+		Timestamp: time.Now(),
+	}
+	if backupID, ok := metadata["backup_id"].(string); ok {
+		alert.URL = backupLink(backupID)
+	}
+
+	triggered := false
+	for _, integration := range conn.Incidents {
+		severity, ok := integration.SeverityFor(event.Type)
+		if !ok {
+			continue
+		}
+		alert.Severity = severity
+		if err := integration.Trigger(alert); err != nil {
+			fmt.Printf("Error opening %s alert for connection %s: %v\n", integration.Provider, conn.ID, err)
+			continue
+		}
+		triggered = true
+	}
+
+	if triggered {
+		now := time.Now()
+		if err := s.connStorage.SetIncidentTriggeredAt(conn.ID, &now); err != nil {
+			fmt.Printf("ERROR: failed to record alert of connection %s: %v\n", conn.ID, err)
+		}
+	}
+}
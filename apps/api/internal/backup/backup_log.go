@@ -0,0 +1,59 @@
+package backup
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxLogOutputBytes caps how much output of a single command is kept in a log
+const maxLogOutputBytes = 64 * 1024
+
+// operationLog collects timestamped lines for the log stored with a backup.
+type operationLog struct {
+	mu      sync.Mutex
+	builder strings.Builder
+}
+
+func newOperationLog() *operationLog {
+	return &operationLog{}
+}
+
+func (l *operationLog) Printf(format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	line := fmt.Sprintf(format, args...)
+	l.builder.WriteString(time.Now().Format(time.RFC3339))
+	l.builder.WriteString(" ")
+	l.builder.WriteString(strings.TrimRight(line, "\n"))
+	l.builder.WriteString("\n")
+}
+
+// Output appends command output below the previous line, indented so it can
+// be told apart from the log's own messages.
+func (l *operationLog) Output(output string) {
+	output = strings.TrimRight(output, "\n")
+	if output == "" {
+		return
+	}
+	if len(output) > maxLogOutputBytes {
+		output = output[:maxLogOutputBytes] + "\n... output truncated"
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, line := range strings.Split(output, "\n") {
+		l.builder.WriteString("    ")
+		l.builder.WriteString(line)
+		l.builder.WriteString("\n")
+	}
+}
+
+func (l *operationLog) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.builder.String()
+}
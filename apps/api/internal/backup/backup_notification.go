@@ -1,21 +1,200 @@
 package backup
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
 	"log"
-	"net/http"
+	"net/url"
+	"os"
+	"strings"
 	"time"
 
+	"github.com/dendianugerah/velld/internal/chat"
+	"github.com/dendianugerah/velld/internal/connection"
+	"github.com/dendianugerah/velld/internal/events"
 	"github.com/dendianugerah/velld/internal/mail"
 	"github.com/dendianugerah/velld/internal/notification"
 	"github.com/dendianugerah/velld/internal/settings"
 	"github.com/google/uuid"
 )
 
-func (s *BackupService) createFailureNotification(connID string, backupErr error) error {
+// backupEvent is a notification about a backup of a connection
+type backupEvent struct {
+	Type     notification.NotificationType
+	Title    string
+	Message  string
+	Metadata map[string]interface{}
+}
+
+// notifyBackupResult reports how a backup ended. backup is nil when it
+// failed before its record was created.
+func (s *BackupService) notifyBackupResult(connID string, backup *Backup, backupErr error) {
+	var err error
+	switch {
+	case backupErr != nil:
+		err = s.createFailureNotification(connID, backup, backupErr)
+	case backup.Status == "suspicious":
+		err = s.createSuspiciousNotification(connID, backup)
+	default:
+		err = s.createCompletedNotification(connID, backup)
+	}
+	if err != nil {
+		fmt.Printf("Error creating backup notification: %v\n", err)
+	}
+}
+
+func (s *BackupService) createStartedNotification(connID string, backup *Backup) error {
+	return s.notifyBackupEvent(connID, func(conn *connection.StoredConnection) backupEvent {
+		return backupEvent{
+			Type:     notification.BackupStarted,
+			Title:    "Backup Started",
+			Message:  fmt.Sprintf("Backup of database '%s' started", conn.DatabaseName),
+			Metadata: backupMetadata(backup),
+		}
+	})
+}
+
+func (s *BackupService) createCompletedNotification(connID string, backup *Backup) error {
+	return s.notifyBackupEvent(connID, func(conn *connection.StoredConnection) backupEvent {
+		metadata := backupMetadata(backup)
+		metadata["size"] = backup.Size
+		message := fmt.Sprintf("Backup of database '%s' completed (%s)", conn.DatabaseName, formatBytes(backup.Size))
+		if backup.CompletedTime != nil {
+			duration := backup.CompletedTime.Sub(backup.StartedTime)
+			metadata["duration_seconds"] = duration.Seconds()
+			message = fmt.Sprintf("Backup of database '%s' completed in %s (%s)",
+				conn.DatabaseName, duration.Round(time.Second), formatBytes(backup.Size))
+		}
+		return backupEvent{
+			Type:     notification.BackupCompleted,
+			Title:    "Backup Completed",
+			Message:  message,
+			Metadata: metadata,
+		}
+	})
+}
+
+func (s *BackupService) createFailureNotification(connID string, backup *Backup, backupErr error) error {
+	return s.notifyBackupEvent(connID, func(conn *connection.StoredConnection) backupEvent {
+		metadata := backupMetadata(backup)
+		metadata["error"] = backupErr.Error()
+		return backupEvent{
+			Type:     notification.BackupFailed,
+			Title:    "Backup Failed",
+			Message:  fmt.Sprintf("Backup failed for database '%s': %v", conn.DatabaseName, backupErr),
+			Metadata: metadata,
+		}
+	})
+}
+
+// createUploadFailedNotification reports a backup that is only stored locally
+// because its upload failed
+func (s *BackupService) createUploadFailedNotification(connID string, backup *Backup, uploadErr error) error {
+	return s.notifyBackupEvent(connID, func(conn *connection.StoredConnection) backupEvent {
+		metadata := backupMetadata(backup)
+		metadata["error"] = uploadErr.Error()
+		return backupEvent{
+			Type:     notification.UploadFailed,
+			Title:    "Backup Upload Failed",
+			Message:  fmt.Sprintf("Backup of database '%s' is only stored locally, the upload failed: %v", conn.DatabaseName, uploadErr),
+			Metadata: metadata,
+		}
+	})
+}
+
+// createRetentionNotification reports the backups removed by the retention
+// of a schedule
+func (s *BackupService) createRetentionNotification(schedule *BackupSchedule, deleted int) error {
+	return s.notifyBackupEvent(schedule.ConnectionID, func(conn *connection.StoredConnection) backupEvent {
+		return backupEvent{
+			Type:    notification.RetentionDeleted,
+			Title:   "Old Backups Deleted",
+			Message: fmt.Sprintf("Deleted %d backup(s) of database '%s' older than %d days", deleted, conn.DatabaseName, schedule.RetentionDays),
+			Metadata: map[string]interface{}{
+				"schedule_id":    schedule.ID.String(),
+				"deleted":        deleted,
+				"retention_days": schedule.RetentionDays,
+			},
+		}
+	})
+}
 
+// createRestoreNotification reports a restore job that started or ended
+func (s *BackupService) createRestoreNotification(job *RestoreJob) error {
+	return s.notifyBackupEvent(job.ConnectionID, func(conn *connection.StoredConnection) backupEvent {
+		metadata := map[string]interface{}{
+			"restore_job_id":  job.ID.String(),
+			"backup_id":       job.BackupID,
+			"target_database": job.TargetDatabase,
+			"mode":            job.Mode,
+		}
+		switch job.Status {
+		case RestoreStatusFailed:
+			errorMessage := ""
+			if job.Error != nil {
+				errorMessage = *job.Error
+			}
+			metadata["error"] = errorMessage
+			return backupEvent{
+				Type:     notification.RestoreFailed,
+				Title:    "Restore Failed",
+				Message:  fmt.Sprintf("Restore of database '%s' failed: %s", job.TargetDatabase, errorMessage),
+				Metadata: metadata,
+			}
+		case RestoreStatusCompleted:
+			return backupEvent{
+				Type:     notification.RestoreCompleted,
+				Title:    "Restore Completed",
+				Message:  fmt.Sprintf("Restore of database '%s' completed", job.TargetDatabase),
+				Metadata: metadata,
+			}
+		default:
+			return backupEvent{
+				Type:     notification.RestoreStarted,
+				Title:    "Restore Started",
+				Message:  fmt.Sprintf("Restore of database '%s' started", job.TargetDatabase),
+				Metadata: metadata,
+			}
+		}
+	})
+}
+
+// backupMetadata describes a backup in the metadata of its notifications
+func backupMetadata(backup *Backup) map[string]interface{} {
+	metadata := map[string]interface{}{}
+	if backup == nil {
+		return metadata
+	}
+	metadata["backup_id"] = backup.ID.String()
+	if backup.ScheduleID != nil {
+		metadata["schedule_id"] = *backup.ScheduleID
+	}
+	return metadata
+}
+
+// createSuspiciousNotification reports a backup that completed but differs
+// too much from the earlier backups of its schedule
+func (s *BackupService) createSuspiciousNotification(connID string, backup *Backup) error {
+	anomaly := ""
+	if backup.Anomaly != nil {
+		anomaly = *backup.Anomaly
+	}
+	return s.notifyBackupEvent(connID, func(conn *connection.StoredConnection) backupEvent {
+		metadata := backupMetadata(backup)
+		metadata["size"] = backup.Size
+		metadata["anomaly"] = anomaly
+		return backupEvent{
+			Type:     notification.BackupSuspicious,
+			Title:    "Suspicious Backup",
+			Message:  fmt.Sprintf("Backup of database '%s' completed but looks suspicious: %s", conn.DatabaseName, anomaly),
+			Metadata: metadata,
+		}
+	})
+}
+
+// notifyBackupEvent sends an event about a connection through the channels
+// picked by eventChannels
+func (s *BackupService) notifyBackupEvent(connID string, build func(conn *connection.StoredConnection) backupEvent) error {
 	conn, err := s.connStorage.GetConnection(connID)
 	if err != nil {
 		log.Printf("Failed to get connection details: %v", err)
@@ -43,100 +222,215 @@ func (s *BackupService) createFailureNotification(connID string, backupErr error
 		return fmt.Errorf("no settings found for user: %s", conn.UserID)
 	}
 
+	event := build(conn)
+
 	metadata := map[string]interface{}{
+		"type":          event.Type,
 		"connection_id": connID,
 		"database_name": conn.DatabaseName,
 		"database_type": conn.Type,
-		"error":         backupErr.Error(),
 		"timestamp":     time.Now().Format(time.RFC3339),
 	}
+	for key, value := range event.Metadata {
+		metadata[key] = value
+	}
 
 	metadataJSON, _ := json.Marshal(metadata)
 
-	// Create dashboard notification if enabled
-	if userSettings.NotifyDashboard {
-		notification := &notification.Notification{
-			ID:        uuid.New(),
-			UserID:    conn.UserID,
-			Title:     "Backup Failed",
-			Message:   fmt.Sprintf("Backup failed for database '%s': %v", conn.DatabaseName, backupErr),
-			Type:      notification.BackupFailed,
-			Status:    notification.StatusUnread,
-			Metadata:  metadataJSON,
-			CreatedAt: time.Now(),
-			UpdatedAt: time.Now(),
-		}
+	// Incident integrations belong to the connection, they are not subject
+	// to the rules, channels and subscriptions of its owner
+	s.updateIncidents(conn, event, metadata)
 
-		if err := s.notificationRepo.CreateNotification(notification); err != nil {
-			fmt.Printf("Error creating dashboard notification: %v\n", err)
-		}
+	channels, err := s.eventChannels(conn, userSettings, event)
+	if err != nil {
+		log.Printf("Failed to route notification: %v", err)
+		return fmt.Errorf("failed to route notification: %v", err)
 	}
 
-	// Send webhook notification if enabled
-	if userSettings.NotifyWebhook && userSettings.WebhookURL != nil {
-		go s.sendWebhookNotification(*userSettings.WebhookURL, metadata)
-	}
+	for _, channel := range channels {
+		switch channel {
+		case notification.ChannelDashboard:
+			notification := &notification.Notification{
+				ID:           uuid.New(),
+				UserID:       conn.UserID,
+				Title:        event.Title,
+				Message:      event.Message,
+				Type:         event.Type,
+				Status:       notification.StatusUnread,
+				Metadata:     metadataJSON,
+				ConnectionID: &connID,
+				CreatedAt:    time.Now(),
+				UpdatedAt:    time.Now(),
+			}
 
-	// Send email notification if enabled
-	if userSettings.NotifyEmail && userSettings.Email != nil {
-		log.Printf("Attempting to send email notification to: %s", *userSettings.Email)
-		// Use separate goroutine for email to prevent blocking
-		go func(emailAddr string, userSettings *settings.UserSettings, meta map[string]interface{}) {
-			if err := s.sendEmailNotification(emailAddr, userSettings, meta); err != nil {
-				log.Printf("Failed to send email notification: %v", err)
+			if err := s.notificationRepo.CreateNotification(notification); err != nil {
+				fmt.Printf("Error creating dashboard notification: %v\n", err)
+			} else {
+				s.eventBus.Publish(conn.UserID, events.NotificationCreated, notification)
 			}
-		}(*userSettings.Email, userSettings, metadata)
-	} else {
-		log.Printf("Email notification skipped - enabled: %v, email configured: %v",
-			userSettings.NotifyEmail, userSettings.Email != nil)
+
+		case notification.ChannelWebhook:
+			if _, err := s.webhookService.Deliver(conn.UserID, *userSettings.WebhookURL, string(event.Type), metadata); err != nil {
+				fmt.Printf("Error sending webhook notification: %v\n", err)
+			}
+
+		case notification.ChannelEmail:
+			log.Printf("Attempting to send email notification to: %s", *userSettings.Email)
+			rendered := renderEmail(userSettings, emailTemplateData(conn, event, metadata))
+			// Use separate goroutine for email to prevent blocking
+			go func(userSettings *settings.UserSettings) {
+				if err := s.sendEmailNotification(userSettings, rendered); err != nil {
+					log.Printf("Failed to send email notification: %v", err)
+				}
+			}(userSettings)
+
+		default:
+			go s.sendChatNotification(userSettings, channel, chatMessage(conn, event, metadata))
+		}
 	}
 
 	return nil
 }
 
-func (s *BackupService) sendWebhookNotification(webhookURL string, data map[string]interface{}) {
-	body, _ := json.Marshal(data)
-	_, err := http.Post(webhookURL, "application/json", bytes.NewBuffer(body))
+func (s *BackupService) sendChatNotification(userSettings *settings.UserSettings, channel string, msg *chat.Message) {
+	if err := s.settingsService.SendChatMessage(userSettings, channel, msg); err != nil {
+		fmt.Printf("Error sending %s notification: %v\n", channel, err)
+	}
+}
+
+// chatMessage renders an event for the chat channels, with the details
+// found in its metadata
+func chatMessage(conn *connection.StoredConnection, event backupEvent, metadata map[string]interface{}) *chat.Message {
+	msg := &chat.Message{
+		Title:     event.Title,
+		Text:      chatSummary(event, metadata),
+		Severity:  chat.Severity(notification.EventSeverity(event.Type)),
+		Timestamp: time.Now(),
+		Fields: []chat.Field{
+			{Name: "Database", Value: fmt.Sprintf("%s (%s)", conn.DatabaseName, conn.Type)},
+			{Name: "Connection", Value: conn.Name},
+		},
+	}
+
+	if size, ok := metadata["size"].(int64); ok && size > 0 {
+		msg.Fields = append(msg.Fields, chat.Field{Name: "Size", Value: formatBytes(size)})
+	}
+	if seconds, ok := metadata["duration_seconds"].(float64); ok {
+		msg.Fields = append(msg.Fields, chat.Field{Name: "Duration", Value: formatSeconds(seconds)})
+	}
+	if errorMessage, ok := metadata["error"].(string); ok {
+		msg.Error = errorMessage
+	}
+	if backupID, ok := metadata["backup_id"].(string); ok {
+		msg.URL = backupLink(backupID)
+	}
+
+	return msg
+}
+
+// chatSummary is the message of an event without the error it ends with,
+// which chat messages show as a shortened excerpt of its own
+func chatSummary(event backupEvent, metadata map[string]interface{}) string {
+	if errorMessage, ok := metadata["error"].(string); ok && errorMessage != "" {
+		return strings.TrimSuffix(event.Message, ": "+errorMessage)
+	}
+	return event.Message
+}
+
+// backupLink points to a backup in the web app, APP_URL must be set to the
+// address the app is reached at for notifications to link back
+func backupLink(backupID string) string {
+	appURL := strings.TrimRight(os.Getenv("APP_URL"), "/")
+	if appURL == "" {
+		return ""
+	}
+	return appURL + "/history?backup=" + url.QueryEscape(backupID)
+}
+
+// emailTemplateData exposes an event to the email templates
+func emailTemplateData(conn *connection.StoredConnection, event backupEvent, metadata map[string]interface{}) *mail.TemplateData {
+	data := &mail.TemplateData{
+		Event: mail.TemplateEvent{
+			Type:      string(event.Type),
+			Title:     event.Title,
+			Message:   event.Message,
+			Timestamp: time.Now(),
+		},
+		Connection: mail.TemplateConnection{
+			ID:           conn.ID,
+			Name:         conn.Name,
+			Type:         conn.Type,
+			Host:         conn.Host,
+			DatabaseName: conn.DatabaseName,
+		},
+		Metadata: metadata,
+	}
+
+	if errorMessage, ok := metadata["error"].(string); ok {
+		data.Error = errorMessage
+	}
+	if backupID, ok := metadata["backup_id"].(string); ok {
+		data.Backup = &mail.TemplateBackup{ID: backupID, URL: backupLink(backupID)}
+		if size, ok := metadata["size"].(int64); ok {
+			data.Backup.Size = size
+			data.Backup.SizeHuman = formatBytes(size)
+		}
+		if seconds, ok := metadata["duration_seconds"].(float64); ok {
+			data.Backup.Duration = formatSeconds(seconds)
+		}
+	}
+
+	return data
+}
+
+// renderEmail renders the templates of the user, falling back to the
+// defaults when they fail so that the notification is still sent
+func renderEmail(userSettings *settings.UserSettings, data *mail.TemplateData) *mail.Rendered {
+	rendered, err := mail.Render(userSettings.EmailTemplates(), data)
+	if err == nil {
+		return rendered
+	}
+
+	log.Printf("Failed to render email templates, using the defaults: %v", err)
+	rendered, err = mail.Render(mail.Templates{}, data)
 	if err != nil {
-		fmt.Printf("Error sending webhook notification: %v\n", err)
+		// The defaults render every event, this only guards against a
+		// broken default template
+		return &mail.Rendered{Subject: "Velld - " + data.Event.Title, Text: data.Event.Message}
 	}
+	return rendered
 }
 
-func (s *BackupService) sendEmailNotification(email string, userSettings *settings.UserSettings, data map[string]interface{}) error {
+func (s *BackupService) sendEmailNotification(userSettings *settings.UserSettings, rendered *mail.Rendered) error {
 	if userSettings == nil {
 		return fmt.Errorf("settings cannot be nil")
 	}
 
-	if userSettings.SMTPHost == nil || userSettings.SMTPUsername == nil ||
-		userSettings.SMTPPassword == nil || userSettings.SMTPPort == nil {
-		return fmt.Errorf("incomplete SMTP configuration")
+	to, err := mail.ParseAddresses(*userSettings.Email)
+	if err != nil {
+		return err
 	}
-
-	// Check if password is from environment variable (plain text) or database (encrypted)
-	password := *userSettings.SMTPPassword
-
-	// If password is configured via env var, it's already plain text
-	// Otherwise, it's encrypted in the database and needs to be decrypted
-	if userSettings.EnvConfigured == nil || !userSettings.EnvConfigured["smtp_password"] {
-		decryptedPassword, err := s.cryptoService.Decrypt(password)
-		if err != nil {
-			return fmt.Errorf("failed to decrypt SMTP password: %v", err)
+	var cc []string
+	if userSettings.EmailCC != nil {
+		if cc, err = mail.ParseAddresses(*userSettings.EmailCC); err != nil {
+			return err
 		}
-		password = decryptedPassword
 	}
 
-	smtpConfig := &mail.SMTPConfig{
-		Host:     *userSettings.SMTPHost,
-		Port:     *userSettings.SMTPPort,
-		Username: *userSettings.SMTPUsername,
-		Password: password,
+	smtpConfig, err := s.settingsService.SMTPConfig(userSettings)
+	if err != nil {
+		return err
 	}
 
+	from, fromName := userSettings.SMTPFrom()
 	msg := &mail.Message{
-		From:    *userSettings.SMTPUsername,
-		To:      email,
-		Subject: "Velld - Backup Failed",
-		Body:    fmt.Sprintf("Backup failed for database '%s'. Error: %v", data["database_name"], data["error"]),
+		From:     from,
+		FromName: fromName,
+		To:       to,
+		CC:       cc,
+		Subject:  rendered.Subject,
+		Body:     rendered.Text,
+		HTMLBody: rendered.HTML,
 	}
 
 	if err := mail.SendEmail(smtpConfig, msg); err != nil {
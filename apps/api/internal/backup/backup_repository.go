@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/dendianugerah/velld/internal/common"
+	"github.com/dendianugerah/velld/internal/connection"
 	"github.com/google/uuid"
 )
 
@@ -33,15 +34,44 @@ func (r *BackupRepository) CreateBackupSchedule(schedule *BackupSchedule) error
 		lastBackupStr = &str
 	}
 
+	hooks, err := connection.MarshalHooks(schedule.Hooks)
+	if err != nil {
+		return err
+	}
+
+	anomaly, err := marshalAnomalyConfig(schedule.AnomalyDetection)
+	if err != nil {
+		return err
+	}
+
+	dumpOptions, err := connection.MarshalDumpOptions(schedule.DumpOptions)
+	if err != nil {
+		return err
+	}
+
+	destination, err := marshalScheduleDestination(schedule.Destination)
+	if err != nil {
+		return err
+	}
+
+	blackoutWindows, err := marshalBlackoutWindows(schedule.BlackoutWindows)
+	if err != nil {
+		return err
+	}
+
 	now := time.Now().Format(time.RFC3339)
-	_, err := r.db.Exec(`
+	_, err = r.db.Exec(`
 		INSERT INTO backup_schedules (
 			id, connection_id, enabled, cron_schedule, retention_days,
-			next_run_time, last_backup_time, created_at, updated_at
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+			next_run_time, last_backup_time, created_at, updated_at, hooks,
+			anomaly_detection, name, dump_options, destination, timezone,
+			blackout_windows, jitter_seconds, missed_run_policy
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18)`,
 		schedule.ID, schedule.ConnectionID, schedule.Enabled,
 		schedule.CronSchedule, schedule.RetentionDays,
-		nextRunStr, lastBackupStr, now, now)
+		nextRunStr, lastBackupStr, now, now, hooks, anomaly,
+		schedule.Name, dumpOptions, destination, schedule.Timezone,
+		blackoutWindows, schedule.JitterSeconds, schedule.MissedRunPolicy)
 	return err
 }
 
@@ -58,6 +88,31 @@ func (r *BackupRepository) UpdateBackupSchedule(schedule *BackupSchedule) error
 		lastBackupStr = &str
 	}
 
+	hooks, err := connection.MarshalHooks(schedule.Hooks)
+	if err != nil {
+		return err
+	}
+
+	anomaly, err := marshalAnomalyConfig(schedule.AnomalyDetection)
+	if err != nil {
+		return err
+	}
+
+	dumpOptions, err := connection.MarshalDumpOptions(schedule.DumpOptions)
+	if err != nil {
+		return err
+	}
+
+	destination, err := marshalScheduleDestination(schedule.Destination)
+	if err != nil {
+		return err
+	}
+
+	blackoutWindows, err := marshalBlackoutWindows(schedule.BlackoutWindows)
+	if err != nil {
+		return err
+	}
+
 	query := `
 		UPDATE backup_schedules 
 		SET enabled = $1, 
@@ -65,16 +120,34 @@ func (r *BackupRepository) UpdateBackupSchedule(schedule *BackupSchedule) error
 		    retention_days = $3, 
 		    next_run_time = $4,
 		    last_backup_time = $5,
-		    updated_at = $6
-		WHERE id = $7
+		    hooks = $6,
+		    anomaly_detection = $7,
+		    name = $8,
+		    dump_options = $9,
+		    destination = $10,
+		    timezone = $11,
+		    blackout_windows = $12,
+		    jitter_seconds = $13,
+		    missed_run_policy = $14,
+		    updated_at = $15
+		WHERE id = $16
 	`
 
-	_, err := r.db.Exec(query,
+	_, err = r.db.Exec(query,
 		schedule.Enabled,
 		schedule.CronSchedule,
 		schedule.RetentionDays,
 		nextRunStr,
 		lastBackupStr,
+		hooks,
+		anomaly,
+		schedule.Name,
+		dumpOptions,
+		destination,
+		schedule.Timezone,
+		blackoutWindows,
+		schedule.JitterSeconds,
+		schedule.MissedRunPolicy,
 		time.Now(),
 		schedule.ID)
 	if err != nil {
@@ -84,24 +157,68 @@ func (r *BackupRepository) UpdateBackupSchedule(schedule *BackupSchedule) error
 	return nil
 }
 
-func (r *BackupRepository) GetBackupSchedule(connectionID string) (*BackupSchedule, error) {
+// scheduleColumns are the columns read by scanSchedule
+const scheduleColumns = `id, connection_id, enabled, cron_schedule, retention_days,
+		       next_run_time, last_backup_time, created_at, updated_at, hooks,
+		       anomaly_detection, name, dump_options, destination, timezone,
+		       blackout_windows, jitter_seconds, missed_run_policy`
+
+// scanSchedule reads a row of scheduleColumns
+func scanSchedule(row interface{ Scan(...any) error }) (*BackupSchedule, error) {
 	var (
 		nextRunStr    sql.NullString
 		lastBackupStr sql.NullString
 		createdAtStr  string
 		updatedAtStr  string
+		hooks         sql.NullString
+		anomaly       sql.NullString
+		name          sql.NullString
+		dumpOptions   sql.NullString
+		destination   sql.NullString
+		timezone      sql.NullString
+		blackout      sql.NullString
+		jitter        sql.NullInt64
+		missedRun     sql.NullString
 	)
 	schedule := &BackupSchedule{}
-	err := r.db.QueryRow(`
-		SELECT id, connection_id, enabled, cron_schedule, retention_days,
-		       next_run_time, last_backup_time, created_at, updated_at 
-		FROM backup_schedules 
-		WHERE connection_id = $1
-		ORDER BY created_at DESC LIMIT 1`,
-		connectionID).Scan(
+	err := row.Scan(
 		&schedule.ID, &schedule.ConnectionID, &schedule.Enabled,
 		&schedule.CronSchedule, &schedule.RetentionDays,
-		&nextRunStr, &lastBackupStr, &createdAtStr, &updatedAtStr)
+		&nextRunStr, &lastBackupStr, &createdAtStr, &updatedAtStr, &hooks,
+		&anomaly, &name, &dumpOptions, &destination, &timezone,
+		&blackout, &jitter, &missedRun)
+	if err != nil {
+		return nil, err
+	}
+	schedule.Name = name.String
+	schedule.Timezone = timezone.String
+	schedule.JitterSeconds = int(jitter.Int64)
+	schedule.MissedRunPolicy = missedRun.String
+	if schedule.MissedRunPolicy == "" {
+		schedule.MissedRunPolicy = MissedRunOnce
+	}
+
+	schedule.BlackoutWindows, err = unmarshalBlackoutWindows(blackout)
+	if err != nil {
+		return nil, err
+	}
+
+	schedule.DumpOptions, err = connection.UnmarshalDumpOptions(dumpOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	schedule.Destination, err = unmarshalScheduleDestination(destination)
+	if err != nil {
+		return nil, err
+	}
+
+	schedule.Hooks, err = connection.UnmarshalHooks(hooks)
+	if err != nil {
+		return nil, err
+	}
+
+	schedule.AnomalyDetection, err = unmarshalAnomalyConfig(anomaly)
 	if err != nil {
 		return nil, err
 	}
@@ -140,66 +257,133 @@ func (r *BackupRepository) GetBackupSchedule(connectionID string) (*BackupSchedu
 	return schedule, nil
 }
 
-func (r *BackupRepository) GetAllActiveSchedules() ([]*BackupSchedule, error) {
-	rows, err := r.db.Query(`
-		SELECT id, connection_id, enabled, cron_schedule, retention_days,
-		       next_run_time, last_backup_time, created_at, updated_at 
+// UpdateScheduleRunTimes stores when a schedule runs next and when it last
+// made a backup, leaving the settings of the schedule alone so that edits
+// made while it was running are kept
+func (r *BackupRepository) UpdateScheduleRunTimes(schedule *BackupSchedule) error {
+	var nextRunStr *string
+	if schedule.NextRunTime != nil {
+		str := schedule.NextRunTime.Format(time.RFC3339)
+		nextRunStr = &str
+	}
+
+	var lastBackupStr *string
+	if schedule.LastBackupTime != nil {
+		str := schedule.LastBackupTime.Format(time.RFC3339)
+		lastBackupStr = &str
+	}
+
+	_, err := r.db.Exec(`
+		UPDATE backup_schedules
+		SET next_run_time = $1, last_backup_time = $2, updated_at = $3
+		WHERE id = $4`,
+		nextRunStr, lastBackupStr, schedule.UpdatedAt.Format(time.RFC3339), schedule.ID)
+	return err
+}
+
+// GetBackupSchedule returns the most recent schedule of a connection
+func (r *BackupRepository) GetBackupSchedule(connectionID string) (*BackupSchedule, error) {
+	return scanSchedule(r.db.QueryRow(`
+		SELECT `+scheduleColumns+`
 		FROM backup_schedules 
-		WHERE enabled = true
-		ORDER BY created_at DESC`)
+		WHERE connection_id = $1
+		ORDER BY created_at DESC LIMIT 1`,
+		connectionID))
+}
+
+func (r *BackupRepository) GetScheduleByID(id string) (*BackupSchedule, error) {
+	return scanSchedule(r.db.QueryRow(`
+		SELECT `+scheduleColumns+`
+		FROM backup_schedules
+		WHERE id = $1`,
+		id))
+}
+
+// ListSchedules returns the schedules of the connections of a user, oldest
+// first
+func (r *BackupRepository) ListSchedules(opts ScheduleListOptions) ([]*BackupSchedule, error) {
+	query := `
+		SELECT ` + scheduleColumns + `
+		FROM backup_schedules
+		WHERE connection_id IN (SELECT id FROM connections WHERE user_id = $1)`
+	args := []interface{}{opts.UserID}
+	if opts.ConnectionID != "" {
+		query += " AND connection_id = $2"
+		args = append(args, opts.ConnectionID)
+	}
+	query += " ORDER BY created_at, id"
+
+	rows, err := r.db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var schedules []*BackupSchedule
+	schedules := make([]*BackupSchedule, 0)
 	for rows.Next() {
-		var (
-			nextRunStr    sql.NullString
-			lastBackupStr sql.NullString
-			createdAtStr  string
-			updatedAtStr  string
-		)
-		schedule := &BackupSchedule{}
-		err := rows.Scan(
-			&schedule.ID, &schedule.ConnectionID, &schedule.Enabled,
-			&schedule.CronSchedule, &schedule.RetentionDays,
-			&nextRunStr, &lastBackupStr, &createdAtStr, &updatedAtStr)
+		schedule, err := scanSchedule(rows)
 		if err != nil {
 			return nil, err
 		}
+		schedules = append(schedules, schedule)
+	}
 
-		// Parse next_run_time if not null
-		if nextRunStr.Valid {
-			nextRun, err := common.ParseTime(nextRunStr.String)
-			if err != nil {
-				return nil, fmt.Errorf("error parsing next_run_time: %v", err)
-			}
-			schedule.NextRunTime = &nextRun
-		}
+	return schedules, rows.Err()
+}
 
-		// Parse last_backup_time if not null
-		if lastBackupStr.Valid {
-			lastBackup, err := common.ParseTime(lastBackupStr.String)
-			if err != nil {
-				return nil, fmt.Errorf("error parsing last_backup_time: %v", err)
-			}
-			schedule.LastBackupTime = &lastBackup
-		}
+// DeleteSchedule removes a schedule. Its backups are kept and no longer
+// refer to it.
+func (r *BackupRepository) DeleteSchedule(id string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
-		// Parse created_at and updated_at
-		createdAt, err := common.ParseTime(createdAtStr)
-		if err != nil {
-			return nil, fmt.Errorf("error parsing created_at: %v", err)
-		}
-		schedule.CreatedAt = createdAt
+	if _, err := tx.Exec("UPDATE backups SET schedule_id = NULL WHERE schedule_id = $1", id); err != nil {
+		return fmt.Errorf("failed to detach backups: %v", err)
+	}
+
+	result, err := tx.Exec("DELETE FROM backup_schedules WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return tx.Commit()
+}
+
+// GetMaxRetentionDays returns the longest retention of the enabled schedules
+// of a connection, or 0 when it has none
+func (r *BackupRepository) GetMaxRetentionDays(connectionID string) (int, error) {
+	var days sql.NullInt64
+	err := r.db.QueryRow(`
+		SELECT MAX(retention_days)
+		FROM backup_schedules
+		WHERE connection_id = $1 AND enabled = true`,
+		connectionID).Scan(&days)
+	return int(days.Int64), err
+}
+
+func (r *BackupRepository) GetAllActiveSchedules() ([]*BackupSchedule, error) {
+	rows, err := r.db.Query(`
+		SELECT ` + scheduleColumns + `
+		FROM backup_schedules 
+		WHERE enabled = true
+		ORDER BY created_at DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-		updatedAt, err := common.ParseTime(updatedAtStr)
+	var schedules []*BackupSchedule
+	for rows.Next() {
+		schedule, err := scanSchedule(rows)
 		if err != nil {
-			return nil, fmt.Errorf("error parsing updated_at: %v", err)
+			return nil, err
 		}
-		schedule.UpdatedAt = updatedAt
-
 		schedules = append(schedules, schedule)
 	}
 
@@ -212,12 +396,40 @@ func (r *BackupRepository) CreateBackup(backup *Backup) error {
 	_, err := r.db.Exec(`
 		INSERT INTO backups (
 			id, connection_id, schedule_id, status, path, s3_object_key, size,
-			started_time, completed_time, created_at, updated_at
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
+			started_time, completed_time, created_at, updated_at, log,
+			checksum, server_version
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)`,
 		backup.ID, backup.ConnectionID, backup.ScheduleID,
 		backup.Status, backup.Path, backup.S3ObjectKey, backup.Size,
 		backup.StartedTime, backup.CompletedTime,
-		backup.CreatedAt, backup.UpdatedAt)
+		backup.CreatedAt, backup.UpdatedAt, backup.Log,
+		backup.Checksum, backup.ServerVersion)
+	return err
+}
+
+// UpdateBackup stores the outcome of a backup that was created in progress
+func (r *BackupRepository) UpdateBackup(backup *Backup) error {
+	_, err := r.db.Exec(`
+		UPDATE backups
+		SET status = $1, s3_object_key = $2, size = $3, completed_time = $4,
+		    log = $5, checksum = $6, anomaly = $7, updated_at = $8
+		WHERE id = $9`,
+		backup.Status, backup.S3ObjectKey, backup.Size, backup.CompletedTime,
+		backup.Log, backup.Checksum, backup.Anomaly, backup.UpdatedAt, backup.ID)
+	return err
+}
+
+// FailInterruptedBackups marks backups that were still running when the
+// server stopped as failed
+func (r *BackupRepository) FailInterruptedBackups() error {
+	_, err := r.db.Exec(`
+		UPDATE backups
+		SET status = 'failed',
+		    log = COALESCE(log, '') || $1,
+		    updated_at = $2
+		WHERE status = 'in_progress'`,
+		time.Now().Format(time.RFC3339)+" Backup was interrupted by a server restart\n",
+		time.Now().Format(time.RFC3339))
 	return err
 }
 
@@ -227,14 +439,18 @@ func (r *BackupRepository) UpdateBackupStatus(id string, status string) error {
 	return err
 }
 
-func (r *BackupRepository) GetBackupsOlderThan(connectionID string, cutoffTime time.Time) ([]*Backup, error) {
+// GetBackupsOlderThan returns the finished backups of a schedule created
+// before cutoffTime, or those of the connection without a schedule when
+// scheduleID is nil
+func (r *BackupRepository) GetBackupsOlderThan(connectionID string, scheduleID *string, cutoffTime time.Time) ([]*Backup, error) {
 	rows, err := r.db.Query(`
 		SELECT id, path, created_at 
 		FROM backups 
 		WHERE connection_id = $1 
-		AND created_at < $2 
-		AND status = 'completed'`,
-		connectionID, cutoffTime)
+		AND (schedule_id = $2 OR ($2 IS NULL AND schedule_id IS NULL))
+		AND created_at < $3 
+		AND status IN ('completed', 'suspicious')`,
+		connectionID, scheduleID, cutoffTime)
 	if err != nil {
 		return nil, err
 	}
@@ -269,19 +485,25 @@ func (r *BackupRepository) GetBackup(id string) (*Backup, error) {
 		completedTimeStr sql.NullString
 		createdAtStr     string
 		updatedAtStr     string
+		logStr           sql.NullString
+		serverVersion    sql.NullString
 	)
 	backup := &Backup{}
 	err := r.db.QueryRow(`
 		SELECT id, connection_id, schedule_id, status, path, s3_object_key, size,
-			   started_time, completed_time, created_at, updated_at 
+			   started_time, completed_time, created_at, updated_at, log,
+			   checksum, server_version, anomaly
 		FROM backups WHERE id = $1`, id).
 		Scan(&backup.ID, &backup.ConnectionID, &backup.ScheduleID,
 			&backup.Status, &backup.Path, &backup.S3ObjectKey, &backup.Size,
 			&startedTimeStr, &completedTimeStr,
-			&createdAtStr, &updatedAtStr)
+			&createdAtStr, &updatedAtStr, &logStr,
+			&backup.Checksum, &serverVersion, &backup.Anomaly)
 	if err != nil {
 		return nil, err
 	}
+	backup.Log = logStr.String
+	backup.ServerVersion = serverVersion.String
 
 	// Parse started_time
 	startedTime, err := common.ParseTime(startedTimeStr)
@@ -320,6 +542,18 @@ func (r *BackupRepository) GetAllBackupsWithPagination(opts BackupListOptions) (
 	args := []interface{}{opts.UserID}
 	argCount := 2
 
+	if opts.ConnectionID != "" {
+		whereClause += fmt.Sprintf(" AND b.connection_id = $%d", argCount)
+		args = append(args, opts.ConnectionID)
+		argCount++
+	}
+
+	if opts.ScheduleID != "" {
+		whereClause += fmt.Sprintf(" AND b.schedule_id = $%d", argCount)
+		args = append(args, opts.ScheduleID)
+		argCount++
+	}
+
 	if opts.Search != "" {
 		whereClause += fmt.Sprintf(" AND (LOWER(b.path) LIKE $%d OR LOWER(b.status) LIKE $%d)", argCount, argCount)
 		args = append(args, "%"+strings.ToLower(opts.Search)+"%")
@@ -387,15 +621,6 @@ func (r *BackupRepository) GetAllBackupsWithPagination(opts BackupListOptions) (
 	return backups, total, rows.Err()
 }
 
-func (r *BackupRepository) UpdateBackupStatusAndSchedule(id string, status string, scheduleID string) error {
-	_, err := r.db.Exec(`
-		UPDATE backups 
-		SET status = $1, schedule_id = $2, updated_at = $3 
-		WHERE id = $4`,
-		status, scheduleID, time.Now().Format(time.RFC3339), id)
-	return err
-}
-
 func (r *BackupRepository) GetBackupStats(userID uuid.UUID) (*BackupStats, error) {
 	stats := &BackupStats{
 		TotalBackups:    0,
@@ -408,7 +633,7 @@ func (r *BackupRepository) GetBackupStats(userID uuid.UUID) (*BackupStats, error
 	err := r.db.QueryRow(`
 		SELECT 
 				COALESCE(COUNT(*), 0) as total_backups,
-				COALESCE(SUM(CASE WHEN b.status != 'completed' THEN 1 ELSE 0 END), 0) as failed_backups,
+				COALESCE(SUM(CASE WHEN b.status NOT IN ('completed', 'suspicious') THEN 1 ELSE 0 END), 0) as failed_backups,
 				COALESCE(SUM(b.size), 0) as total_size
 		FROM backups b
 		INNER JOIN connections c ON b.connection_id = c.id
@@ -472,3 +697,111 @@ func (r *BackupRepository) GetBackupStats(userID uuid.UUID) (*BackupStats, error
 
 	return stats, nil
 }
+
+// GetScheduleHistory returns the size and duration of the latest completed
+// and suspicious backups of a schedule, newest first, leaving out excludeID.
+// checkBackupAnomaly decides which of them make up the baseline.
+func (r *BackupRepository) GetScheduleHistory(scheduleID, excludeID string, limit int) ([]backupSample, error) {
+	rows, err := r.db.Query(`
+		SELECT size, status, started_time, completed_time
+		FROM backups
+		WHERE schedule_id = $1
+		AND id != $2
+		AND status IN ('completed', 'suspicious')
+		AND completed_time IS NOT NULL
+		ORDER BY started_time DESC
+		LIMIT $3`,
+		scheduleID, excludeID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var samples []backupSample
+	for rows.Next() {
+		var (
+			sample           backupSample
+			status           string
+			startedTimeStr   string
+			completedTimeStr string
+		)
+		if err := rows.Scan(&sample.Size, &status, &startedTimeStr, &completedTimeStr); err != nil {
+			return nil, err
+		}
+		sample.Suspicious = status == "suspicious"
+
+		startedTime, err := common.ParseTime(startedTimeStr)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing started_time: %v", err)
+		}
+		completedTime, err := common.ParseTime(completedTimeStr)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing completed_time: %v", err)
+		}
+		sample.Duration = completedTime.Sub(startedTime)
+		samples = append(samples, sample)
+	}
+	return samples, rows.Err()
+}
+
+// GetLastCompletedBackupTime returns when the latest completed backup of a
+// connection finished, nil when it has none
+func (r *BackupRepository) GetLastCompletedBackupTime(connectionID string) (*time.Time, error) {
+	var completedTimeStr string
+	err := r.db.QueryRow(`
+		SELECT completed_time
+		FROM backups
+		WHERE connection_id = $1
+		AND status IN ('completed', 'suspicious')
+		AND completed_time IS NOT NULL
+		ORDER BY completed_time DESC
+		LIMIT 1`,
+		connectionID).Scan(&completedTimeStr)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	completedTime, err := common.ParseTime(completedTimeStr)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing completed_time: %v", err)
+	}
+	return &completedTime, nil
+}
+
+// GetDigestBackups returns every backup of a user's connections, oldest
+// first, with what a digest reports on
+func (r *BackupRepository) GetDigestBackups(userID uuid.UUID) ([]digestBackup, error) {
+	rows, err := r.db.Query(`
+		SELECT b.id, b.connection_id, c.name, c.type, b.status, b.path, b.s3_object_key,
+		       COALESCE(b.size, 0), b.started_time
+		FROM backups b
+		INNER JOIN connections c ON b.connection_id = c.id
+		WHERE c.user_id = $1
+		ORDER BY b.started_time ASC`,
+		userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var backups []digestBackup
+	for rows.Next() {
+		var (
+			backup         digestBackup
+			startedTimeStr string
+		)
+		if err := rows.Scan(&backup.ID, &backup.ConnectionID, &backup.ConnectionName, &backup.DatabaseType,
+			&backup.Status, &backup.Path, &backup.S3ObjectKey, &backup.Size, &startedTimeStr); err != nil {
+			return nil, err
+		}
+		backup.StartedTime, err = common.ParseTime(startedTimeStr)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing started_time: %v", err)
+		}
+		backups = append(backups, backup)
+	}
+	return backups, rows.Err()
+}
@@ -1,19 +1,153 @@
 package backup
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"strings"
+	"time"
 
 	"github.com/dendianugerah/velld/internal/common"
 	"github.com/dendianugerah/velld/internal/connection"
+	"github.com/google/uuid"
 )
 
 type RestoreRequest struct {
-	BackupID     string `json:"backup_id"`
-	ConnectionID string `json:"connection_id"`
+	BackupID       string `json:"backup_id"`
+	ConnectionID   string `json:"connection_id"`
+	TargetDatabase string `json:"target_database"` // defaults to the connection's database
+	Mode           string `json:"mode"`            // "existing", "create" or "drop_recreate"
+	Owner          string `json:"owner"`           // PostgreSQL, for created databases
+	Encoding       string `json:"encoding"`        // PostgreSQL, for created databases
+	Charset        string `json:"charset"`         // MySQL and MariaDB, for created databases
+	// Snapshot backs up an existing target database before it is overwritten
+	Snapshot bool `json:"snapshot"`
+
+	// Tables limits the restore to these tables ("orders" or "sales.orders"),
+	// or collections for MongoDB. Schemas selects whole PostgreSQL schemas.
+	Tables  []string `json:"tables"`
+	Schemas []string `json:"schemas"`
+	// Rename restores a selected table or collection under a new name
+	Rename map[string]string `json:"rename"`
+	// TargetSchema restores the selected PostgreSQL tables into another schema
+	TargetSchema string `json:"target_schema"`
+
+	// DryRun only runs the pre-flight checks and leaves the target untouched
+	DryRun bool `json:"dry_run"`
+}
+
+const (
+	// RestoreModeExisting restores into a database that must already exist
+	RestoreModeExisting = "existing"
+	// RestoreModeCreate creates the target database and fails if it exists
+	RestoreModeCreate = "create"
+	// RestoreModeDropRecreate drops the target database if present and creates it again
+	RestoreModeDropRecreate = "drop_recreate"
+)
+
+// restoreTargetTimeout bounds creating or dropping the target database
+const restoreTargetTimeout = 2 * time.Minute
+
+func (r *RestoreRequest) createOptions() connection.CreateDatabaseOptions {
+	return connection.CreateDatabaseOptions{
+		Owner:    r.Owner,
+		Encoding: r.Encoding,
+		Charset:  r.Charset,
+	}
+}
+
+// Validate fills in defaults and checks the request against the target type.
+func (r *RestoreRequest) Validate(conn *connection.StoredConnection) error {
+	if r.Mode == "" {
+		r.Mode = RestoreModeExisting
+	}
+	if r.TargetDatabase == "" {
+		r.TargetDatabase = conn.DatabaseName
+	}
+
+	switch r.Mode {
+	case RestoreModeExisting:
+		if r.Owner != "" || r.Encoding != "" || r.Charset != "" {
+			return fmt.Errorf("owner, encoding and charset only apply when the target database is created")
+		}
+	case RestoreModeCreate, RestoreModeDropRecreate:
+		if err := r.createOptions().Validate(conn.Type); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("invalid restore mode %q: must be %s, %s or %s",
+			r.Mode, RestoreModeExisting, RestoreModeCreate, RestoreModeDropRecreate)
+	}
+
+	if r.TargetDatabase != conn.DatabaseName {
+		if err := connection.ValidateDatabaseName(r.TargetDatabase); err != nil {
+			return err
+		}
+	}
+	return r.validatePartial(conn.Type)
+}
+
+// IsPartial reports whether only some tables, schemas or collections are restored
+func (r *RestoreRequest) IsPartial() bool {
+	return len(r.Tables) > 0 || len(r.Schemas) > 0
+}
+
+var (
+	tableNamePattern      = regexp.MustCompile(`^[A-Za-z0-9_$-]{1,63}(\.[A-Za-z0-9_$-]{1,63})?$`)
+	collectionNamePattern = regexp.MustCompile(`^[^$\x00]{1,255}$`)
+)
+
+func (r *RestoreRequest) validatePartial(dbType string) error {
+	if !r.IsPartial() {
+		if len(r.Rename) > 0 || r.TargetSchema != "" {
+			return fmt.Errorf("rename and target_schema require tables or schemas to be selected")
+		}
+		return nil
+	}
+
+	if r.Mode == RestoreModeDropRecreate {
+		return fmt.Errorf("drop_recreate mode cannot be combined with a partial restore")
+	}
+	if dbType != "postgresql" && (len(r.Schemas) > 0 || r.TargetSchema != "") {
+		return fmt.Errorf("schemas and target_schema are only supported for postgresql")
+	}
+
+	pattern := tableNamePattern
+	if dbType == "mongodb" {
+		pattern = collectionNamePattern
+	}
+
+	selected := make(map[string]bool)
+	for _, table := range r.Tables {
+		if !pattern.MatchString(table) {
+			return fmt.Errorf("invalid table name %q", table)
+		}
+		selected[table] = true
+	}
+	for _, schema := range r.Schemas {
+		if err := connection.ValidateDatabaseName(schema); err != nil {
+			return fmt.Errorf("invalid schema name %q", schema)
+		}
+	}
+	if r.TargetSchema != "" {
+		if err := connection.ValidateDatabaseName(r.TargetSchema); err != nil {
+			return fmt.Errorf("invalid target_schema %q", r.TargetSchema)
+		}
+	}
+
+	for from, to := range r.Rename {
+		if !selected[from] {
+			return fmt.Errorf("cannot rename %q: it is not one of the selected tables", from)
+		}
+		if !pattern.MatchString(to) || (dbType != "mongodb" && strings.Contains(to, ".")) {
+			return fmt.Errorf("invalid new name %q for %q", to, from)
+		}
+	}
+	return nil
 }
 
 var restoreTools = map[string]string{
@@ -23,26 +157,163 @@ var restoreTools = map[string]string{
 	"mongodb":    "mongorestore",
 }
 
-// RestoreBackup restores a backup to a target database connection
-func (s *BackupService) RestoreBackup(backupID string, connectionID string) error {
-	backup, err := s.backupRepo.GetBackup(backupID)
+// pgRestoreTool restores PostgreSQL dumps taken with --format=custom
+const pgRestoreTool = "pg_restore"
+
+// StartRestore validates the request and restores the backup in the
+// background. The returned job can be polled for status and progress.
+func (s *BackupService) StartRestore(req *RestoreRequest) (*RestoreJob, error) {
+	return s.startRestore(req, nil)
+}
+
+func (s *BackupService) startRestore(req *RestoreRequest, rollbackOf *string) (*RestoreJob, error) {
+	backup, err := s.backupRepo.GetBackup(req.BackupID)
 	if err != nil {
-		return fmt.Errorf("failed to get backup: %v", err)
+		return nil, fmt.Errorf("failed to get backup: %v", err)
 	}
 
 	if _, err := os.Stat(backup.Path); os.IsNotExist(err) {
-		return fmt.Errorf("backup file not found: %s", backup.Path)
+		return nil, fmt.Errorf("backup file not found: %s", backup.Path)
 	}
 
-	conn, err := s.connStorage.GetConnection(connectionID)
+	conn, err := s.connStorage.GetConnection(req.ConnectionID)
 	if err != nil {
-		return fmt.Errorf("failed to get connection: %v", err)
+		return nil, fmt.Errorf("failed to get connection: %v", err)
 	}
 
 	if err := s.verifyRestoreTools(conn.Type); err != nil {
-		return err
+		return nil, err
+	}
+
+	if err := req.Validate(conn); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	job := &RestoreJob{
+		ID:             uuid.New(),
+		BackupID:       req.BackupID,
+		ConnectionID:   req.ConnectionID,
+		TargetDatabase: req.TargetDatabase,
+		Mode:           req.Mode,
+		Status:         RestoreStatusPending,
+		RollbackOf:     rollbackOf,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+
+	if err := s.backupRepo.CreateRestoreJob(job); err != nil {
+		return nil, fmt.Errorf("failed to save restore job: %v", err)
+	}
+
+	go s.runRestoreJob(job, req, backup)
+	return job, nil
+}
+
+// RollbackRestore restores the pre-restore snapshot taken by a job into the
+// same target database, as a new job.
+func (s *BackupService) RollbackRestore(jobID string) (*RestoreJob, error) {
+	job, err := s.backupRepo.GetRestoreJob(jobID)
+	if err != nil {
+		return nil, err
+	}
+
+	switch job.Status {
+	case RestoreStatusPending, RestoreStatusRunning:
+		return nil, fmt.Errorf("restore job is still %s", job.Status)
+	case RestoreStatusRolledBack:
+		return nil, fmt.Errorf("restore job has already been rolled back")
+	}
+
+	if job.SnapshotBackupID == nil {
+		return nil, fmt.Errorf("restore job has no pre-restore snapshot to roll back to")
+	}
+
+	req := &RestoreRequest{
+		BackupID:       *job.SnapshotBackupID,
+		ConnectionID:   job.ConnectionID,
+		TargetDatabase: job.TargetDatabase,
+		Mode:           RestoreModeDropRecreate,
+	}
+	rollbackOf := job.ID.String()
+	return s.startRestore(req, &rollbackOf)
+}
+
+func (s *BackupService) GetRestoreJob(id string) (*RestoreJob, error) {
+	return s.backupRepo.GetRestoreJob(id)
+}
+
+func (s *BackupService) ListRestoreJobs(opts RestoreJobListOptions) ([]*RestoreJob, int, error) {
+	if opts.Limit <= 0 {
+		opts.Limit = 10
+	}
+	if opts.Limit > 100 {
+		opts.Limit = 100
+	}
+	if opts.Offset < 0 {
+		opts.Offset = 0
+	}
+
+	return s.backupRepo.ListRestoreJobs(opts)
+}
+
+func (s *BackupService) runRestoreJob(job *RestoreJob, req *RestoreRequest, backup *Backup) {
+	log := newOperationLog()
+	started := time.Now()
+	job.Status = RestoreStatusRunning
+	job.StartedTime = &started
+	if err := s.backupRepo.UpdateRestoreJob(job); err != nil {
+		fmt.Printf("ERROR: failed to update restore job %s: %v\n", job.ID, err)
+	}
+	s.publishRestoreJob(job)
+	if err := s.createRestoreNotification(job); err != nil {
+		fmt.Printf("Error creating restore notification: %v\n", err)
+	}
+
+	restoreErr := s.executeRestore(job, req, backup, log)
+
+	completed := time.Now()
+	job.CompletedTime = &completed
+	if restoreErr != nil {
+		message := restoreErr.Error()
+		job.Error = &message
+		job.Status = RestoreStatusFailed
+		log.Printf("Restore failed: %v", restoreErr)
+		if job.SnapshotBackupID != nil {
+			log.Printf("A pre-restore snapshot is available, the restore can be rolled back")
+		}
+	} else {
+		job.Status = RestoreStatusCompleted
+		log.Printf("Restore completed in %s", completed.Sub(started).Round(time.Second))
+	}
+	job.Log = log.String()
+
+	if err := s.backupRepo.UpdateRestoreJob(job); err != nil {
+		fmt.Printf("ERROR: failed to update restore job %s: %v\n", job.ID, err)
+	}
+	s.publishRestoreJob(job)
+
+	if job.RollbackOf != nil && restoreErr == nil {
+		if err := s.backupRepo.UpdateRestoreJobStatus(*job.RollbackOf, RestoreStatusRolledBack); err != nil {
+			fmt.Printf("ERROR: failed to mark restore job %s as rolled back: %v\n", *job.RollbackOf, err)
+		}
+	}
+
+	if err := s.createRestoreNotification(job); err != nil {
+		fmt.Printf("Error creating restore notification: %v\n", err)
+	}
+}
+
+// executeRestore takes the optional snapshot, prepares the target database,
+// runs the restore tool and the post-restore hooks of the target connection
+func (s *BackupService) executeRestore(job *RestoreJob, req *RestoreRequest, backup *Backup, log *operationLog) error {
+	conn, err := s.connStorage.GetConnection(req.ConnectionID)
+	if err != nil {
+		return fmt.Errorf("failed to get connection: %v", err)
 	}
 
+	log.Printf("Restoring backup %s into %s database '%s' (mode %s)", backup.ID, conn.Type, req.TargetDatabase, req.Mode)
+
 	tunnel, effectiveHost, effectivePort, err := s.setupSSHTunnelIfNeeded(conn)
 	if err != nil {
 		return fmt.Errorf("failed to setup SSH tunnel: %v", err)
@@ -53,26 +324,171 @@ func (s *BackupService) RestoreBackup(backupID string, connectionID string) erro
 		conn.Port = effectivePort
 	}
 
-	var cmd *exec.Cmd
+	ctx, cancel := context.WithTimeout(context.Background(), restoreTargetTimeout)
+	exists, err := connection.DatabaseExists(ctx, conn, req.TargetDatabase)
+	cancel()
+	if err != nil {
+		return fmt.Errorf("failed to check target database: %v", err)
+	}
+
+	// Only a restore into a database that already exists can destroy data
+	if req.Snapshot && exists && req.Mode != RestoreModeCreate {
+		log.Printf("Taking pre-restore snapshot of '%s'", req.TargetDatabase)
+		snapshot, err := s.createBackup(conn.ID, backupRun{
+			database: req.TargetDatabase,
+			reason:   fmt.Sprintf("Pre-restore snapshot for restore job %s", job.ID),
+		})
+		if err != nil {
+			return fmt.Errorf("pre-restore snapshot failed, target left untouched: %v", err)
+		}
+		snapshotID := snapshot.ID.String()
+		job.SnapshotBackupID = &snapshotID
+		log.Printf("Pre-restore snapshot saved as backup %s", snapshotID)
+		if err := s.backupRepo.UpdateRestoreJob(job); err != nil {
+			fmt.Printf("ERROR: failed to update restore job %s: %v\n", job.ID, err)
+		}
+	}
+
+	if err := s.prepareRestoreTarget(conn, req, exists, log); err != nil {
+		return err
+	}
+	conn.DatabaseName = req.TargetDatabase
+
+	var restoreErr error
+	if req.IsPartial() && conn.Type != "mongodb" {
+		restoreErr = s.runPartialRestore(job, req, conn, backup, log)
+	} else {
+		restoreErr = s.runRestoreTool(job, req, conn, backup, log)
+	}
+
+	if hookErr := s.runPostRestoreHooks(conn, backup, restoreErr, log); hookErr != nil && restoreErr == nil {
+		return fmt.Errorf("restore completed but %v", hookErr)
+	}
+	return restoreErr
+}
+
+func (s *BackupService) runRestoreTool(job *RestoreJob, req *RestoreRequest, conn *connection.StoredConnection, backup *Backup, log *operationLog) error {
+	var (
+		cmd     *exec.Cmd
+		cleanup func()
+		err     error
+	)
 	switch conn.Type {
 	case "postgresql":
-		cmd = s.createPsqlRestoreCmd(conn, backup.Path)
+		if isPgCustomDump(backup.Path) {
+			cmd, cleanup, err = s.createPgRestoreCmd(conn)
+		} else {
+			cmd, cleanup, err = s.createPsqlRestoreCmd(conn)
+		}
 	case "mysql", "mariadb":
-		cmd = s.createMySQLRestoreCmd(conn, backup.Path)
+		cmd, cleanup, err = s.createMySQLRestoreCmd(conn)
 	case "mongodb":
-		cmd = s.createMongoRestoreCmd(conn, backup.Path)
+		var nsArgs []string
+		if req.IsPartial() {
+			nsArgs = s.mongoNamespaceArgs(req, backup, log)
+		}
+		cmd, cleanup, err = s.createMongoRestoreCmd(conn, backup.Path, nsArgs)
 	default:
 		return fmt.Errorf("unsupported database type for restore: %s", conn.Type)
 	}
+	defer cleanup()
+
+	if err != nil {
+		return fmt.Errorf("failed to prepare %s: %v", restoreTools[conn.Type], err)
+	}
 
 	if cmd == nil {
 		return fmt.Errorf("restore tool not found for %s. Please ensure %s is installed", conn.Type, restoreTools[conn.Type])
 	}
 
+	// mongorestore reads a directory, the other tools read the dump on stdin
+	// so that progress can be measured
+	var progress *progressReader
+	if conn.Type != "mongodb" {
+		file, err := os.Open(backup.Path)
+		if err != nil {
+			return fmt.Errorf("failed to open backup file: %v", err)
+		}
+		defer file.Close()
+
+		if info, err := file.Stat(); err == nil {
+			job.BytesTotal = info.Size()
+		}
+		progress = newProgressReader(file, func(read int64) {
+			if err := s.backupRepo.UpdateRestoreProgress(job.ID.String(), read); err != nil {
+				fmt.Printf("ERROR: failed to update restore progress: %v\n", err)
+			}
+			s.publishRestoreProgress(conn.UserID, job, read)
+		})
+		cmd.Stdin = progress
+		if err := s.backupRepo.UpdateRestoreJob(job); err != nil {
+			fmt.Printf("ERROR: failed to update restore job %s: %v\n", job.ID, err)
+		}
+	}
+
+	log.Printf("Running %s", filepath.Base(cmd.Path))
 	output, err := cmd.CombinedOutput()
+	output = []byte(common.ScrubSecrets(string(output), conn.Password, conn.SSHPassword))
+	log.Output(string(output))
+
+	if progress != nil {
+		job.BytesDone = progress.BytesRead()
+	}
+
 	return s.validateRestoreOutput(conn.Type, conn.DatabaseName, output, err)
 }
 
+// runPostRestoreHooks runs the post_restore hooks of the target connection,
+// whether or not the restore succeeded
+func (s *BackupService) runPostRestoreHooks(conn *connection.StoredConnection, backup *Backup, restoreErr error, log *operationLog) error {
+	if len(conn.Hooks) == 0 {
+		return nil
+	}
+
+	status := "completed"
+	if restoreErr != nil {
+		status = "failed"
+	}
+
+	env := hookEnv(connection.HookPostRestore, conn)
+	env["VELLD_BACKUP_ID"] = backup.ID.String()
+	env["VELLD_BACKUP_PATH"] = backup.Path
+	env["VELLD_RESTORE_STATUS"] = status
+	env["VELLD_RESTORE_DATABASE"] = conn.DatabaseName
+
+	return s.runHooks(connection.HookPostRestore, conn.Hooks, conn, env, log)
+}
+
+// prepareRestoreTarget makes sure the target database is in the state the
+// restore mode expects: present for "existing", freshly created otherwise
+func (s *BackupService) prepareRestoreTarget(conn *connection.StoredConnection, req *RestoreRequest, exists bool, log *operationLog) error {
+	ctx, cancel := context.WithTimeout(context.Background(), restoreTargetTimeout)
+	defer cancel()
+
+	switch req.Mode {
+	case RestoreModeExisting:
+		// MongoDB creates the database while restoring
+		if !exists && conn.Type != "mongodb" {
+			return fmt.Errorf("target database '%s' does not exist; use mode %q to create it", req.TargetDatabase, RestoreModeCreate)
+		}
+		return nil
+	case RestoreModeCreate:
+		if exists {
+			return fmt.Errorf("target database '%s' already exists; use mode %q to replace it", req.TargetDatabase, RestoreModeDropRecreate)
+		}
+	case RestoreModeDropRecreate:
+		if exists {
+			log.Printf("Dropping database '%s'", req.TargetDatabase)
+			if err := connection.DropDatabase(ctx, conn, req.TargetDatabase); err != nil {
+				return err
+			}
+		}
+	}
+
+	log.Printf("Creating database '%s'", req.TargetDatabase)
+	return connection.CreateDatabase(ctx, conn, req.TargetDatabase, req.createOptions())
+}
+
 func (s *BackupService) validateRestoreOutput(dbType, dbName string, output []byte, cmdErr error) error {
 	switch dbType {
 	case "postgresql":
@@ -93,7 +509,8 @@ func (s *BackupService) validatePostgreSQLRestore(output []byte, cmdErr error) e
 	var criticalErrors []string
 
 	for _, line := range lines {
-		if !strings.Contains(line, "ERROR:") {
+		// "ERROR:" comes from the server, "error:" from psql/pg_restore themselves
+		if !strings.Contains(line, "ERROR:") && !strings.Contains(line, "error:") {
 			continue
 		}
 
@@ -160,18 +577,15 @@ func (s *BackupService) verifyRestoreTools(dbType string) error {
 	return nil
 }
 
-func (s *BackupService) findDatabaseRestorePath(dbType string) string {
-	if path := common.FindBinaryPath(dbType, restoreTools[dbType]); path != "" {
-		return path
-	}
-	return ""
+func (s *BackupService) findDatabaseRestorePath(conn *connection.StoredConnection) string {
+	return findToolDir(conn, restoreTools[conn.Type])
 }
 
-func (s *BackupService) createPsqlRestoreCmd(conn *connection.StoredConnection, backupPath string) *exec.Cmd {
-	binaryPath := s.findDatabaseRestorePath("postgresql")
+func (s *BackupService) createPsqlRestoreCmd(conn *connection.StoredConnection) (*exec.Cmd, func(), error) {
+	binaryPath := s.findDatabaseRestorePath(conn)
 	if binaryPath == "" {
 		fmt.Printf("ERROR: psql binary not found. Please install PostgreSQL client tools.\n")
-		return nil
+		return nil, noCleanup, nil
 	}
 
 	binPath := filepath.Join(binaryPath, common.GetPlatformExecutableName(restoreTools["postgresql"]))
@@ -183,46 +597,49 @@ func (s *BackupService) createPsqlRestoreCmd(conn *connection.StoredConnection,
 		"-p", fmt.Sprintf("%d", conn.Port),
 		"-U", conn.Username,
 		"-d", conn.DatabaseName,
-		"-f", backupPath,
 		"-v", "ON_ERROR_STOP=1", // Exit on first error
 	)
 
 	cmd.Env = append(os.Environ(), fmt.Sprintf("PGPASSWORD=%s", conn.Password))
-	return cmd
+	return cmd, noCleanup, nil
 }
 
-func (s *BackupService) createMySQLRestoreCmd(conn *connection.StoredConnection, backupPath string) *exec.Cmd {
-	binaryPath := s.findDatabaseRestorePath(conn.Type)
+func (s *BackupService) createMySQLRestoreCmd(conn *connection.StoredConnection) (*exec.Cmd, func(), error) {
+	binaryPath := s.findDatabaseRestorePath(conn)
 	if binaryPath == "" {
 		fmt.Printf("ERROR: mysql binary not found. Please install MySQL/MariaDB client tools.\n")
-		return nil
+		return nil, noCleanup, nil
 	}
 
 	binPath := filepath.Join(binaryPath, common.GetPlatformExecutableName(restoreTools[conn.Type]))
 
-	cmd := exec.Command(binPath,
+	optionFile, removeOptionFile, err := writeMySQLOptionFile(conn)
+	if err != nil {
+		return nil, noCleanup, err
+	}
+
+	// --defaults-extra-file must be the first argument
+	args := []string{
+		"--defaults-extra-file=" + optionFile,
 		"-h", conn.Host,
 		"-P", fmt.Sprintf("%d", conn.Port),
-		"-u", conn.Username,
-		fmt.Sprintf("-p%s", conn.Password),
-		conn.DatabaseName,
-	)
-
-	file, err := os.Open(backupPath)
-	if err != nil {
-		fmt.Printf("ERROR: failed to open backup file: %v\n", err)
-		return nil
 	}
+	if charset := conn.DumpOptions.MySQLOptions().DefaultCharacterSet; charset != "" {
+		args = append(args, "--default-character-set="+charset)
+	}
+	args = append(args, conn.DatabaseName)
 
-	cmd.Stdin = file
-	return cmd
+	return exec.Command(binPath, args...), removeOptionFile, nil
 }
 
-func (s *BackupService) createMongoRestoreCmd(conn *connection.StoredConnection, backupPath string) *exec.Cmd {
-	binaryPath := s.findDatabaseRestorePath("mongodb")
+// createMongoRestoreCmd builds mongorestore for the dump next to backupPath.
+// nsArgs selects and renames collections; without them the whole dump is
+// restored into the connection's database.
+func (s *BackupService) createMongoRestoreCmd(conn *connection.StoredConnection, backupPath string, nsArgs []string) (*exec.Cmd, func(), error) {
+	binaryPath := s.findDatabaseRestorePath(conn)
 	if binaryPath == "" {
 		fmt.Printf("ERROR: mongorestore binary not found. Please install MongoDB Database Tools.\n")
-		return nil
+		return nil, noCleanup, nil
 	}
 
 	binPath := filepath.Join(binaryPath, common.GetPlatformExecutableName(restoreTools["mongodb"]))
@@ -232,17 +649,84 @@ func (s *BackupService) createMongoRestoreCmd(conn *connection.StoredConnection,
 	args := []string{
 		"--host", conn.Host,
 		"--port", fmt.Sprintf("%d", conn.Port),
-		"--db", conn.DatabaseName,
-		backupDir,
 	}
+	if nsArgs != nil {
+		args = append(args, nsArgs...)
+	} else {
+		args = append(args, "--db", conn.DatabaseName)
+	}
+	args = append(args, backupDir)
 
 	if conn.Username != "" {
 		args = append(args, "--username", conn.Username)
 	}
 
+	cleanup := noCleanup
 	if conn.Password != "" {
-		args = append(args, "--password", conn.Password)
+		configFile, configCleanup, err := writeMongoConfigFile(conn)
+		if err != nil {
+			return nil, noCleanup, err
+		}
+		cleanup = configCleanup
+		args = append(args, "--config", configFile)
+	}
+
+	// The archive layout decides whether --gzip is needed, not the current
+	// options of the target connection
+	if mongoDumpIsGzipped(backupDir) {
+		args = append(args, "--gzip")
+	}
+
+	return exec.Command(binPath, args...), cleanup, nil
+}
+
+func (s *BackupService) createPgRestoreCmd(conn *connection.StoredConnection) (*exec.Cmd, func(), error) {
+	binaryPath := findToolDir(conn, pgRestoreTool)
+	if binaryPath == "" {
+		fmt.Printf("ERROR: pg_restore binary not found. Please install PostgreSQL client tools.\n")
+		return nil, noCleanup, nil
+	}
+
+	binPath := filepath.Join(binaryPath, common.GetPlatformExecutableName(pgRestoreTool))
+
+	args := []string{
+		"-h", conn.Host,
+		"-p", fmt.Sprintf("%d", conn.Port),
+		"-U", conn.Username,
+		"-d", conn.DatabaseName,
+		"--exit-on-error",
 	}
 
-	return exec.Command(binPath, args...)
+	opts := conn.DumpOptions.PostgresOptions()
+	if opts.NoOwner {
+		args = append(args, "--no-owner")
+	}
+	if opts.NoPrivileges {
+		args = append(args, "--no-privileges")
+	}
+
+	cmd := exec.Command(binPath, args...)
+	cmd.Env = append(os.Environ(), fmt.Sprintf("PGPASSWORD=%s", conn.Password))
+	return cmd, noCleanup, nil
+}
+
+// isPgCustomDump reports whether the file was written by pg_dump --format=custom
+func isPgCustomDump(path string) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(file, header); err != nil {
+		return false
+	}
+	return string(header) == "PGDMP"
+}
+
+// mongoDumpIsGzipped reports whether mongodump wrote compressed collections into dir
+func mongoDumpIsGzipped(dir string) bool {
+	matches, _ := filepath.Glob(filepath.Join(dir, "*", "*.bson.gz"))
+	return len(matches) > 0
 }
@@ -0,0 +1,72 @@
+package backup
+
+import (
+	"time"
+
+	"github.com/dendianugerah/velld/internal/connection"
+	"github.com/dendianugerah/velld/internal/notification"
+	"github.com/dendianugerah/velld/internal/settings"
+)
+
+// eventChannels decides which channels an event about a connection is
+// delivered through. Once its owner has notification rules they decide,
+// otherwise the channels the owner turned on and subscribed to the event
+// do, with the subscriptions of the connection taking precedence. Channels
+// without a destination are left out either way.
+func (s *BackupService) eventChannels(conn *connection.StoredConnection, userSettings *settings.UserSettings, event backupEvent) ([]string, error) {
+	route, err := s.router.Route(conn.UserID, notification.RouteTarget{
+		ConnectionID: conn.ID,
+		Tags:         conn.Tags,
+		Environment:  conn.Environment,
+		EventType:    event.Type,
+	}, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	if route.HasRules {
+		channels := make([]string, 0, len(route.Channels))
+		for _, channel := range route.Channels {
+			if channelConfigured(userSettings, channel) {
+				channels = append(channels, channel)
+			}
+		}
+		return channels, nil
+	}
+
+	subscriptions := userSettings.NotificationEvents.Merge(conn.NotificationEvents)
+	channels := make([]string, 0, len(notification.Channels))
+	for _, channel := range notification.Channels {
+		if channelEnabled(userSettings, channel) && channelConfigured(userSettings, channel) &&
+			subscriptions.Wants(channel, event.Type) {
+			channels = append(channels, channel)
+		}
+	}
+	return channels, nil
+}
+
+// channelEnabled reports whether a channel is turned on in the settings
+func channelEnabled(userSettings *settings.UserSettings, channel string) bool {
+	switch channel {
+	case notification.ChannelDashboard:
+		return userSettings.NotifyDashboard
+	case notification.ChannelEmail:
+		return userSettings.NotifyEmail
+	case notification.ChannelWebhook:
+		return userSettings.NotifyWebhook
+	}
+	return userSettings.ChatEnabled(channel)
+}
+
+// channelConfigured reports whether a channel has somewhere to deliver to
+func channelConfigured(userSettings *settings.UserSettings, channel string) bool {
+	switch channel {
+	case notification.ChannelDashboard:
+		return true
+	case notification.ChannelEmail:
+		return userSettings.Email != nil && *userSettings.Email != ""
+	case notification.ChannelWebhook:
+		return userSettings.WebhookURL != nil && *userSettings.WebhookURL != ""
+	}
+	return userSettings.ChatConfigured(channel)
+}
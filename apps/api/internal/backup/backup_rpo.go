@@ -0,0 +1,181 @@
+package backup
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dendianugerah/velld/internal/connection"
+	"github.com/dendianugerah/velld/internal/notification"
+	"github.com/google/uuid"
+)
+
+// rpoCheckSpec is how often the watchdog evaluates recovery point objectives
+const rpoCheckSpec = "@every 1m"
+
+// RPOCompliance summarises the recovery point objectives of a user's
+// connections
+type RPOCompliance struct {
+	Monitored   int                   `json:"monitored"`
+	Compliant   int                   `json:"compliant"`
+	Breached    int                   `json:"breached"`
+	Connections []ConnectionRPOStatus `json:"connections"`
+}
+
+// ConnectionRPOStatus tells whether a connection has a completed backup
+// within its recovery point objective
+type ConnectionRPOStatus struct {
+	ConnectionID   string     `json:"connection_id"`
+	ConnectionName string     `json:"connection_name"`
+	RPOMinutes     int        `json:"rpo_minutes"`
+	LastBackupTime *time.Time `json:"last_backup_time"`
+	// DueTime is when the objective is missed without a new backup
+	DueTime       *time.Time `json:"due_time"`
+	Compliant     bool       `json:"compliant"`
+	BreachedSince *time.Time `json:"breached_since,omitempty"`
+}
+
+// startRPOWatchdog evaluates the recovery point objectives periodically, so
+// that a schedule that stopped firing or a server that was down is noticed
+// even though no backup failed
+func (s *BackupService) startRPOWatchdog() {
+	if _, err := s.cronManager.AddFunc(rpoCheckSpec, s.checkRPOTargets); err != nil {
+		fmt.Printf("Error scheduling RPO watchdog: %v\n", err)
+	}
+}
+
+// checkRPOTargets notifies about connections that started or stopped
+// missing their recovery point objective since the last check
+func (s *BackupService) checkRPOTargets() {
+	targets, err := s.connStorage.ListRPOTargets(uuid.Nil)
+	if err != nil {
+		fmt.Printf("ERROR: failed to list RPO targets: %v\n", err)
+		return
+	}
+
+	now := time.Now()
+	for _, target := range targets {
+		status, err := s.rpoStatus(target, now)
+		if err != nil {
+			fmt.Printf("ERROR: failed to evaluate RPO of connection %s: %v\n", target.ConnectionID, err)
+			continue
+		}
+
+		switch {
+		case !status.Compliant && target.BreachedAt == nil:
+			breachedAt := now
+			if status.DueTime != nil {
+				breachedAt = *status.DueTime
+			}
+			if err := s.connStorage.SetRPOBreachedAt(target.ConnectionID, &breachedAt); err != nil {
+				fmt.Printf("ERROR: failed to record RPO breach of connection %s: %v\n", target.ConnectionID, err)
+				continue
+			}
+			if err := s.createRPONotification(target, status, true); err != nil {
+				fmt.Printf("Error creating RPO breach notification: %v\n", err)
+			}
+
+		case status.Compliant && target.BreachedAt != nil:
+			if err := s.connStorage.SetRPOBreachedAt(target.ConnectionID, nil); err != nil {
+				fmt.Printf("ERROR: failed to clear RPO breach of connection %s: %v\n", target.ConnectionID, err)
+				continue
+			}
+			status.BreachedSince = target.BreachedAt
+			if err := s.createRPONotification(target, status, false); err != nil {
+				fmt.Printf("Error creating RPO recovery notification: %v\n", err)
+			}
+		}
+	}
+}
+
+// rpoStatus evaluates the objective of a connection at now. Connections
+// without any backup are measured from their creation.
+func (s *BackupService) rpoStatus(target connection.RPOTarget, now time.Time) (ConnectionRPOStatus, error) {
+	status := ConnectionRPOStatus{
+		ConnectionID:   target.ConnectionID,
+		ConnectionName: target.ConnectionName,
+		RPOMinutes:     target.RPOMinutes,
+	}
+
+	lastBackup, err := s.backupRepo.GetLastCompletedBackupTime(target.ConnectionID)
+	if err != nil {
+		return status, err
+	}
+	status.LastBackupTime = lastBackup
+
+	since := target.CreatedAt
+	if lastBackup != nil {
+		since = *lastBackup
+	}
+	if !since.IsZero() {
+		due := since.Add(time.Duration(target.RPOMinutes) * time.Minute)
+		status.DueTime = &due
+		status.Compliant = now.Before(due)
+	}
+
+	if !status.Compliant {
+		status.BreachedSince = target.BreachedAt
+		if status.BreachedSince == nil {
+			status.BreachedSince = status.DueTime
+		}
+	}
+	return status, nil
+}
+
+// GetRPOCompliance evaluates the recovery point objectives of a user's
+// connections
+func (s *BackupService) GetRPOCompliance(userID uuid.UUID) (*RPOCompliance, error) {
+	targets, err := s.connStorage.ListRPOTargets(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list RPO targets: %v", err)
+	}
+
+	compliance := &RPOCompliance{Connections: []ConnectionRPOStatus{}}
+	now := time.Now()
+	for _, target := range targets {
+		status, err := s.rpoStatus(target, now)
+		if err != nil {
+			return nil, fmt.Errorf("failed to evaluate RPO of connection %s: %v", target.ConnectionID, err)
+		}
+		compliance.Monitored++
+		if status.Compliant {
+			compliance.Compliant++
+		} else {
+			compliance.Breached++
+		}
+		compliance.Connections = append(compliance.Connections, status)
+	}
+	return compliance, nil
+}
+
+func (s *BackupService) createRPONotification(target connection.RPOTarget, status ConnectionRPOStatus, breached bool) error {
+	objective := (time.Duration(target.RPOMinutes) * time.Minute).String()
+	lastBackup := "never"
+	if status.LastBackupTime != nil {
+		lastBackup = status.LastBackupTime.Format(time.RFC3339)
+	}
+
+	return s.notifyBackupEvent(target.ConnectionID, func(conn *connection.StoredConnection) backupEvent {
+		metadata := map[string]interface{}{
+			"rpo_minutes":      target.RPOMinutes,
+			"last_backup_time": lastBackup,
+		}
+		if breached {
+			return backupEvent{
+				Type:     notification.RPOBreached,
+				Title:    "Backup RPO Breached",
+				Message:  fmt.Sprintf("No completed backup of database '%s' within its RPO of %s (last completed backup: %s)", conn.DatabaseName, objective, lastBackup),
+				Metadata: metadata,
+			}
+		}
+
+		if status.BreachedSince != nil {
+			metadata["breached_since"] = status.BreachedSince.Format(time.RFC3339)
+		}
+		return backupEvent{
+			Type:     notification.RPORecovered,
+			Title:    "Backup RPO Recovered",
+			Message:  fmt.Sprintf("Database '%s' is within its RPO of %s again (last completed backup: %s)", conn.DatabaseName, objective, lastBackup),
+			Metadata: metadata,
+		}
+	})
+}
@@ -2,79 +2,237 @@ package backup
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"os"
+	"strings"
 	"time"
 
+	"github.com/dendianugerah/velld/internal/connection"
+	"github.com/dendianugerah/velld/internal/events"
 	"github.com/google/uuid"
 	"github.com/robfig/cron/v3"
 )
 
+// scheduleParser parses the six-field cron expressions of schedules
+var scheduleParser = cron.NewParser(cron.Second | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow)
+
+// ScheduleBackup replaces the most recent schedule of a connection, or
+// creates one when the connection has none. New clients add schedules with
+// CreateSchedule instead.
 func (s *BackupService) ScheduleBackup(req *ScheduleBackupRequest) error {
-	// Check if a schedule already exists for this connection
 	existingSchedule, err := s.backupRepo.GetBackupSchedule(req.ConnectionID)
-	if err != nil && err != sql.ErrNoRows {
+	if err == sql.ErrNoRows {
+		_, err = s.CreateSchedule(req)
+		return err
+	}
+	if err != nil {
 		return fmt.Errorf("failed to check existing schedule: %v", err)
 	}
 
-	parser := cron.NewParser(cron.Second | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow)
-	schedule, err := parser.Parse(req.CronSchedule)
-	if err != nil {
-		return fmt.Errorf("invalid cron schedule: %v", err)
+	existingSchedule.Enabled = true
+	existingSchedule.CronSchedule = req.CronSchedule
+	existingSchedule.RetentionDays = req.RetentionDays
+	existingSchedule.Hooks = req.Hooks
+	existingSchedule.AnomalyDetection = req.AnomalyDetection
+	if req.Name != "" {
+		existingSchedule.Name = req.Name
+	}
+	if req.DumpOptions != nil {
+		existingSchedule.DumpOptions = req.DumpOptions
+	}
+	if req.Destination != nil {
+		existingSchedule.Destination = req.Destination
+	}
+	if req.Timezone != "" {
+		existingSchedule.Timezone = req.Timezone
+	}
+	if req.BlackoutWindows != nil {
+		existingSchedule.BlackoutWindows = req.BlackoutWindows
+	}
+	if req.JitterSeconds != 0 {
+		existingSchedule.JitterSeconds = req.JitterSeconds
+	}
+	if req.MissedRunPolicy != "" {
+		existingSchedule.MissedRunPolicy = req.MissedRunPolicy
 	}
 
-	nextRun := schedule.Next(time.Now())
+	return s.saveSchedule(existingSchedule)
+}
 
-	if existingSchedule != nil {
-		// Update existing schedule
-		existingSchedule.Enabled = true
-		existingSchedule.CronSchedule = req.CronSchedule
-		existingSchedule.RetentionDays = req.RetentionDays
-		existingSchedule.NextRunTime = &nextRun
-		existingSchedule.UpdatedAt = time.Now()
+// CreateSchedule adds a schedule to a connection, next to its other schedules
+func (s *BackupService) CreateSchedule(req *ScheduleBackupRequest) (*BackupSchedule, error) {
+	schedule := &BackupSchedule{
+		ID:               uuid.New(),
+		ConnectionID:     req.ConnectionID,
+		Name:             req.Name,
+		Enabled:          true,
+		CronSchedule:     req.CronSchedule,
+		RetentionDays:    req.RetentionDays,
+		Hooks:            req.Hooks,
+		DumpOptions:      req.DumpOptions,
+		Destination:      req.Destination,
+		AnomalyDetection: req.AnomalyDetection,
+		Timezone:         req.Timezone,
+		BlackoutWindows:  req.BlackoutWindows,
+		JitterSeconds:    req.JitterSeconds,
+		MissedRunPolicy:  req.MissedRunPolicy,
+		CreatedAt:        time.Now(),
+		UpdatedAt:        time.Now(),
+	}
 
-		if err := s.backupRepo.UpdateBackupSchedule(existingSchedule); err != nil {
-			return fmt.Errorf("failed to update backup schedule: %v", err)
-		}
+	if err := s.validateSchedule(schedule); err != nil {
+		return nil, err
+	}
+	nextRun := s.nextRunTime(schedule)
+	schedule.NextRunTime = &nextRun
 
-		// Update cron job
-		scheduleID := existingSchedule.ID.String()
-		if oldEntryID, exists := s.cronEntries[scheduleID]; exists {
-			s.cronManager.Remove(oldEntryID)
-		}
+	if err := s.backupRepo.CreateBackupSchedule(schedule); err != nil {
+		return nil, fmt.Errorf("failed to save backup schedule: %v", err)
+	}
 
-		entryID, err := s.cronManager.AddFunc(req.CronSchedule, func() {
-			s.executeCronBackup(existingSchedule)
-		})
-		if err != nil {
-			return fmt.Errorf("failed to schedule backup: %v", err)
+	if err := s.registerSchedule(schedule); err != nil {
+		return nil, err
+	}
+	s.publishSchedule(schedule)
+	return schedule, nil
+}
+
+func (s *BackupService) GetSchedule(id string) (*BackupSchedule, error) {
+	return s.backupRepo.GetScheduleByID(id)
+}
+
+func (s *BackupService) ListSchedules(opts ScheduleListOptions) ([]*BackupSchedule, error) {
+	return s.backupRepo.ListSchedules(opts)
+}
+
+// UpdateSchedule changes a schedule and reschedules it when it is enabled
+func (s *BackupService) UpdateSchedule(id string, req *UpdateScheduleRequest) (*BackupSchedule, error) {
+	schedule, err := s.backupRepo.GetScheduleByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	schedule.CronSchedule = req.CronSchedule
+	schedule.RetentionDays = req.RetentionDays
+	if req.Name != nil {
+		schedule.Name = *req.Name
+	}
+	if req.Hooks != nil {
+		schedule.Hooks = req.Hooks
+	}
+	if req.AnomalyDetection != nil {
+		schedule.AnomalyDetection = req.AnomalyDetection
+	}
+	if req.DumpOptions != nil {
+		schedule.DumpOptions = req.DumpOptions
+		if isEmptyDumpOptions(req.DumpOptions) {
+			schedule.DumpOptions = nil
 		}
+	}
+	if req.Destination != nil {
+		schedule.Destination = req.Destination
+		if *req.Destination == (ScheduleDestination{}) {
+			schedule.Destination = nil
+		}
+	}
+	if req.Timezone != nil {
+		schedule.Timezone = *req.Timezone
+	}
+	if req.BlackoutWindows != nil {
+		schedule.BlackoutWindows = req.BlackoutWindows
+	}
+	if req.JitterSeconds != nil {
+		schedule.JitterSeconds = *req.JitterSeconds
+	}
+	if req.MissedRunPolicy != nil {
+		schedule.MissedRunPolicy = *req.MissedRunPolicy
+	}
 
-		s.cronEntries[scheduleID] = entryID
-		return nil
+	if err := s.saveSchedule(schedule); err != nil {
+		return nil, err
+	}
+	return schedule, nil
+}
+
+// SetScheduleEnabled pauses or resumes a schedule
+func (s *BackupService) SetScheduleEnabled(id string, enabled bool) (*BackupSchedule, error) {
+	schedule, err := s.backupRepo.GetScheduleByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	schedule.Enabled = enabled
+	if err := s.saveSchedule(schedule); err != nil {
+		return nil, err
+	}
+	return schedule, nil
+}
+
+// DeleteSchedule stops and removes a schedule. The backups it made are kept.
+func (s *BackupService) DeleteSchedule(id string) error {
+	schedule, err := s.backupRepo.GetScheduleByID(id)
+	if err != nil {
+		return err
+	}
+
+	s.unregisterSchedule(id)
+	if err := s.backupRepo.DeleteSchedule(id); err != nil {
+		return err
+	}
+	s.publishConnectionEvent(schedule.ConnectionID, events.ScheduleDeleted, ScheduleDeletedEvent{
+		ID:           id,
+		ConnectionID: schedule.ConnectionID,
+	})
+	return nil
+}
+
+// saveSchedule validates and stores a changed schedule and updates its cron
+// job
+func (s *BackupService) saveSchedule(schedule *BackupSchedule) error {
+	if err := s.validateSchedule(schedule); err != nil {
+		return err
 	}
 
-	// Create new schedule if none exists
-	backupSchedule := &BackupSchedule{
-		ID:            uuid.New(),
-		ConnectionID:  req.ConnectionID,
-		Enabled:       true,
-		CronSchedule:  req.CronSchedule,
-		RetentionDays: req.RetentionDays,
-		NextRunTime:   &nextRun,
-		CreatedAt:     time.Now(),
-		UpdatedAt:     time.Now(),
+	if schedule.Enabled {
+		nextRun := s.nextRunTime(schedule)
+		schedule.NextRunTime = &nextRun
 	}
+	schedule.UpdatedAt = time.Now()
 
-	if err := s.backupRepo.CreateBackupSchedule(backupSchedule); err != nil {
-		return fmt.Errorf("failed to save backup schedule: %v", err)
+	if err := s.backupRepo.UpdateBackupSchedule(schedule); err != nil {
+		return fmt.Errorf("failed to update backup schedule: %v", err)
 	}
+	s.publishSchedule(schedule)
 
-	scheduleID := backupSchedule.ID.String()
-	entryID, err := s.cronManager.AddFunc(req.CronSchedule, func() {
-		s.executeCronBackup(backupSchedule)
+	if !schedule.Enabled {
+		s.unregisterSchedule(schedule.ID.String())
+		return nil
+	}
+	return s.registerSchedule(schedule)
+}
+
+// registerSchedule adds the cron job of a schedule, replacing its previous one
+// and dropping the runs the previous one was holding back for a blackout
+// window
+func (s *BackupService) registerSchedule(schedule *BackupSchedule) error {
+	scheduleID := schedule.ID.String()
+	s.scheduleMu.Lock()
+	defer s.scheduleMu.Unlock()
+
+	if entryID, exists := s.cronEntries[scheduleID]; exists {
+		s.cronManager.Remove(entryID)
+	}
+	if pending, exists := s.pendingRuns[scheduleID]; exists {
+		pending.timer.Stop()
+		delete(s.pendingRuns, scheduleID)
+	}
+
+	entryID, err := s.cronManager.AddFunc(schedule.cronSpec(), func() {
+		s.runScheduledBackup(schedule)
 	})
 	if err != nil {
+		delete(s.cronEntries, scheduleID)
 		return fmt.Errorf("failed to schedule backup: %v", err)
 	}
 
@@ -82,114 +240,207 @@ func (s *BackupService) ScheduleBackup(req *ScheduleBackupRequest) error {
 	return nil
 }
 
+// unregisterSchedule removes the cron job of a schedule and drops the runs
+// it was holding back for a blackout window
+func (s *BackupService) unregisterSchedule(scheduleID string) {
+	s.scheduleMu.Lock()
+	defer s.scheduleMu.Unlock()
+
+	if entryID, exists := s.cronEntries[scheduleID]; exists {
+		s.cronManager.Remove(entryID)
+		delete(s.cronEntries, scheduleID)
+	}
+	if pending, exists := s.pendingRuns[scheduleID]; exists {
+		pending.timer.Stop()
+		delete(s.pendingRuns, scheduleID)
+	}
+}
+
+func (s *BackupService) nextRunTime(schedule *BackupSchedule) time.Time {
+	cronSchedule, err := scheduleParser.Parse(schedule.cronSpec())
+	if err != nil {
+		return time.Time{}
+	}
+	return cronSchedule.Next(time.Now())
+}
+
 func (s *BackupService) executeCronBackup(schedule *BackupSchedule) {
 	// if schedule.CronSchedule == "0 */1 * * * *" {
 	// 	err := fmt.Errorf("test failure: this is a simulated backup failure for SMTP testing")
-	// 	if notifyErr := s.createFailureNotification(schedule.ConnectionID, err); notifyErr != nil {
+	// 	if notifyErr := s.createFailureNotification(schedule.ConnectionID, nil, err); notifyErr != nil {
 	// 		fmt.Printf("Error creating failure notification: %v\n", notifyErr)
 	// 	}
 	// 	return
 	// }
 
-	backup, err := s.CreateBackup(schedule.ConnectionID)
-	if err != nil {
-		if notifyErr := s.createFailureNotification(schedule.ConnectionID, err); notifyErr != nil {
-			fmt.Printf("Error creating failure notification: %v\n", notifyErr)
-		}
-	} else {
-		scheduleIDStr := schedule.ID.String()
-		if err := s.backupRepo.UpdateBackupStatusAndSchedule(backup.ID.String(), backup.Status, scheduleIDStr); err != nil {
-			fmt.Printf("Error updating backup status and schedule: %v\n", err)
-		}
-	}
+	// createBackup reports the outcome of the backup itself
+	s.createBackup(schedule.ConnectionID, backupRun{schedule: schedule})
 
 	// Update schedule's next run time and last backup time
-	parser := cron.NewParser(cron.Second | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow)
-	cronSchedule, _ := parser.Parse(schedule.CronSchedule)
-	nextRun := cronSchedule.Next(time.Now())
+	nextRun := s.nextRunTime(schedule)
 	schedule.NextRunTime = &nextRun
 	now := time.Now()
 	schedule.LastBackupTime = &now
 	schedule.UpdatedAt = now
 
-	if err := s.backupRepo.UpdateBackupSchedule(schedule); err != nil {
+	if err := s.backupRepo.UpdateScheduleRunTimes(schedule); err != nil {
 		fmt.Printf("Error updating backup schedule: %v\n", err)
+	} else {
+		s.publishSchedule(schedule)
 	}
 
+	deleted := 0
 	if schedule.RetentionDays > 0 {
-		s.cleanupOldBackups(schedule.ConnectionID, schedule.RetentionDays)
+		scheduleID := schedule.ID.String()
+		deleted += s.cleanupOldBackups(schedule.ConnectionID, &scheduleID, schedule.RetentionDays)
+	}
+
+	// Backups made outside of a schedule are kept as long as those of the
+	// schedule of the connection with the longest retention
+	if retentionDays, err := s.backupRepo.GetMaxRetentionDays(schedule.ConnectionID); err != nil {
+		fmt.Printf("Error getting retention of connection %s: %v\n", schedule.ConnectionID, err)
+	} else if retentionDays > 0 {
+		deleted += s.cleanupOldBackups(schedule.ConnectionID, nil, retentionDays)
+	}
+
+	if deleted > 0 {
+		if err := s.createRetentionNotification(schedule, deleted); err != nil {
+			fmt.Printf("Error creating retention notification: %v\n", err)
+		}
 	}
 }
 
-func (s *BackupService) cleanupOldBackups(connectionID string, retentionDays int) {
+// cleanupOldBackups removes the backups of a schedule, or the unscheduled
+// backups of the connection when scheduleID is nil, older than retentionDays.
+// It returns how many backups were deleted.
+func (s *BackupService) cleanupOldBackups(connectionID string, scheduleID *string, retentionDays int) int {
 	cutoffTime := time.Now().AddDate(0, 0, -retentionDays)
-	oldBackups, err := s.backupRepo.GetBackupsOlderThan(connectionID, cutoffTime)
+	oldBackups, err := s.backupRepo.GetBackupsOlderThan(connectionID, scheduleID, cutoffTime)
 	if err != nil {
-		return
+		return 0
 	}
 
+	deleted := 0
 	for _, backup := range oldBackups {
 		os.Remove(backup.Path)
-		s.backupRepo.DeleteBackup(backup.ID.String())
+		if err := s.backupRepo.DeleteBackup(backup.ID.String()); err == nil {
+			deleted++
+			s.publishConnectionEvent(connectionID, events.BackupDeleted, BackupDeletedEvent{
+				ID:           backup.ID,
+				ConnectionID: connectionID,
+			})
+		}
 	}
+	return deleted
 }
 
+// DisableBackupSchedule disables the most recent schedule of a connection
 func (s *BackupService) DisableBackupSchedule(connectionID string) error {
 	schedule, err := s.backupRepo.GetBackupSchedule(connectionID)
 	if err != nil {
 		return err
 	}
 
-	scheduleID := schedule.ID.String()
-	if entryID, exists := s.cronEntries[scheduleID]; exists {
-		s.cronManager.Remove(entryID)
-		delete(s.cronEntries, scheduleID)
-	}
-
-	schedule.Enabled = false
-	schedule.UpdatedAt = time.Now()
-	if err := s.backupRepo.UpdateBackupSchedule(schedule); err != nil {
-		return err
-	}
-
-	return nil
+	_, err = s.SetScheduleEnabled(schedule.ID.String(), false)
+	return err
 }
 
+// UpdateBackupSchedule updates the most recent schedule of a connection
 func (s *BackupService) UpdateBackupSchedule(connectionID string, req *UpdateScheduleRequest) error {
 	schedule, err := s.backupRepo.GetBackupSchedule(connectionID)
 	if err != nil {
 		return err
 	}
 
-	parser := cron.NewParser(cron.Second | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow)
-	_, err = parser.Parse(req.CronSchedule)
-	if err != nil {
+	_, err = s.UpdateSchedule(schedule.ID.String(), req)
+	return err
+}
+
+// validateSchedule checks a schedule against its connection
+func (s *BackupService) validateSchedule(schedule *BackupSchedule) error {
+	if err := validateScheduleTiming(schedule); err != nil {
+		return err
+	}
+	if _, err := scheduleParser.Parse(schedule.cronSpec()); err != nil {
 		return fmt.Errorf("invalid cron schedule: %v", err)
 	}
+	if schedule.RetentionDays < 0 {
+		return fmt.Errorf("retention_days cannot be negative")
+	}
 
-	schedule.CronSchedule = req.CronSchedule
-	schedule.RetentionDays = req.RetentionDays
-	err = s.backupRepo.UpdateBackupSchedule(schedule)
-	if err != nil {
+	if err := s.validateScheduleHooks(schedule.ConnectionID, schedule.Hooks); err != nil {
 		return err
 	}
 
-	// Remove old cron job
-	if entryID, ok := s.cronEntries[schedule.ID.String()]; ok {
-		s.cronManager.Remove(entryID)
-		delete(s.cronEntries, schedule.ID.String())
+	if err := validateAnomalyConfig(schedule.AnomalyDetection); err != nil {
+		return err
 	}
 
-	// Add new cron job
-	entryID, err := s.cronManager.AddFunc(schedule.CronSchedule, func() {
-		s.executeCronBackup(schedule)
-	})
-	if err != nil {
-		return fmt.Errorf("failed to register cron job: %v", err)
+	if schedule.DumpOptions != nil {
+		conn, err := s.connStorage.GetConnection(schedule.ConnectionID)
+		if err != nil {
+			return fmt.Errorf("failed to get connection: %v", err)
+		}
+		if err := schedule.DumpOptions.Validate(conn.Type); err != nil {
+			return err
+		}
 	}
 
-	// Store the new entry ID
-	s.cronEntries[schedule.ID.String()] = entryID
+	if destination := schedule.Destination; destination != nil {
+		destination.S3PathPrefix = strings.Trim(destination.S3PathPrefix, "/")
+		for _, part := range strings.Split(destination.S3PathPrefix, "/") {
+			if part == "." || part == ".." {
+				return fmt.Errorf("invalid s3_path_prefix %q", destination.S3PathPrefix)
+			}
+		}
+	}
 
 	return nil
 }
+
+func isEmptyDumpOptions(opts *connection.DumpOptions) bool {
+	return opts.PostgreSQL == nil && opts.MySQL == nil && opts.MongoDB == nil
+}
+
+func marshalScheduleDestination(destination *ScheduleDestination) (sql.NullString, error) {
+	if destination == nil {
+		return sql.NullString{}, nil
+	}
+	data, err := json.Marshal(destination)
+	if err != nil {
+		return sql.NullString{}, fmt.Errorf("failed to encode schedule destination: %w", err)
+	}
+	return sql.NullString{String: string(data), Valid: true}, nil
+}
+
+func unmarshalScheduleDestination(value sql.NullString) (*ScheduleDestination, error) {
+	if !value.Valid || value.String == "" {
+		return nil, nil
+	}
+	var destination ScheduleDestination
+	if err := json.Unmarshal([]byte(value.String), &destination); err != nil {
+		return nil, fmt.Errorf("failed to decode schedule destination: %w", err)
+	}
+	return &destination, nil
+}
+
+// validateScheduleHooks checks hooks attached to a schedule. Restores are not
+// tied to a schedule, so only the backup stages are accepted.
+func (s *BackupService) validateScheduleHooks(connectionID string, hooks []connection.Hook) error {
+	if len(hooks) == 0 {
+		return nil
+	}
+
+	conn, err := s.connStorage.GetConnection(connectionID)
+	if err != nil {
+		return fmt.Errorf("failed to get connection: %v", err)
+	}
+
+	for _, hook := range hooks {
+		if hook.Stage == connection.HookPostRestore {
+			return fmt.Errorf("schedule hooks only support the %s and %s stages", connection.HookPreBackup, connection.HookPostBackup)
+		}
+	}
+
+	return connection.ValidateHooks(hooks, conn.Type)
+}
@@ -6,12 +6,15 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/dendianugerah/velld/internal/common"
 	"github.com/dendianugerah/velld/internal/connection"
+	"github.com/dendianugerah/velld/internal/events"
 	"github.com/dendianugerah/velld/internal/notification"
 	"github.com/dendianugerah/velld/internal/settings"
+	"github.com/dendianugerah/velld/internal/webhook"
 	"github.com/google/uuid"
 	"github.com/robfig/cron/v3"
 )
@@ -22,8 +25,13 @@ type BackupService struct {
 	backupRepo       *BackupRepository
 	cronManager      *cron.Cron
 	cronEntries      map[string]cron.EntryID // map[scheduleID]entryID
+	pendingRuns      map[string]*pendingRuns // map[scheduleID]runs held by a blackout window
+	scheduleMu       sync.Mutex              // guards cronEntries and pendingRuns
 	settingsService  *settings.SettingsService
 	notificationRepo *notification.NotificationRepository
+	router           *notification.Router
+	webhookService   *webhook.WebhookService
+	eventBus         *events.Bus
 	cryptoService    *common.EncryptionService
 }
 
@@ -33,12 +41,25 @@ func NewBackupService(
 	backupRepo *BackupRepository,
 	settingsService *settings.SettingsService,
 	notificationRepo *notification.NotificationRepository,
+	webhookService *webhook.WebhookService,
+	eventBus *events.Bus,
 	cryptoService *common.EncryptionService,
 ) *BackupService {
 	if err := os.MkdirAll(backupDir, 0755); err != nil {
 		panic(err)
 	}
 
+	if _, err := credentialDirectory(); err != nil {
+		fmt.Printf("ERROR: %v\n", err)
+	}
+
+	if err := backupRepo.FailInterruptedBackups(); err != nil {
+		fmt.Printf("Error marking interrupted backups as failed: %v\n", err)
+	}
+	if err := backupRepo.FailInterruptedRestoreJobs(); err != nil {
+		fmt.Printf("Error marking interrupted restores as failed: %v\n", err)
+	}
+
 	cronManager := cron.New(cron.WithSeconds())
 	service := &BackupService{
 		connStorage:      connStorage,
@@ -46,9 +67,13 @@ func NewBackupService(
 		backupRepo:       backupRepo,
 		settingsService:  settingsService,
 		notificationRepo: notificationRepo,
+		router:           notification.NewRouter(notificationRepo),
+		webhookService:   webhookService,
+		eventBus:         eventBus,
 		cryptoService:    cryptoService,
 		cronManager:      cronManager,
 		cronEntries:      make(map[string]cron.EntryID),
+		pendingRuns:      make(map[string]*pendingRuns),
 	}
 
 	// Recover existing schedules before starting the cron manager
@@ -56,6 +81,9 @@ func NewBackupService(
 		fmt.Printf("Error recovering schedules: %v\n", err)
 	}
 
+	service.startRPOWatchdog()
+	service.startDigestScheduler()
+
 	cronManager.Start()
 	return service
 }
@@ -68,36 +96,61 @@ func (s *BackupService) recoverSchedules() error {
 
 	now := time.Now()
 	for _, schedule := range schedules {
-		scheduleID := schedule.ID.String()
-
-		// Check if we missed any backups
-		if schedule.NextRunTime != nil && schedule.NextRunTime.Before(now) {
-			// Execute a backup immediately for missed schedule
-			go s.executeCronBackup(schedule)
-		}
-
 		// Re-register the cron job
-		entryID, err := s.cronManager.AddFunc(schedule.CronSchedule, func() {
-			s.executeCronBackup(schedule)
-		})
-		if err != nil {
-			fmt.Printf("Error re-registering schedule %s: %v\n", scheduleID, err)
-			continue
+		if err := s.registerSchedule(schedule); err != nil {
+			fmt.Printf("Error re-registering schedule %s: %v\n", schedule.ID, err)
 		}
 
-		s.cronEntries[scheduleID] = entryID
+		// Make up for the runs missed while the server was down, after
+		// registering since that drops pending runs
+		if missed := countMissedRuns(schedule, now); missed > 0 {
+			s.handleMissedRuns(schedule, missed)
+		}
 	}
 
 	return nil
 }
 
+// backupRun describes why createBackup is called
+type backupRun struct {
+	schedule *BackupSchedule
+	// database replaces the connection's database, used for pre-restore snapshots
+	database string
+	reason   string
+}
+
 func (s *BackupService) CreateBackup(connectionID string) (*Backup, error) {
+	return s.createBackup(connectionID, backupRun{})
+}
+
+// createBackup dumps the database of a connection, running the hooks of the
+// connection and, for scheduled backups, of the schedule around the dump.
+// Once the backup record exists, failures are stored on it together with the log.
+func (s *BackupService) createBackup(connectionID string, run backupRun) (result *Backup, err error) {
+	// backup is set once its record exists, so that failures before then
+	// are reported without one
+	var backup *Backup
+	defer func() {
+		s.notifyBackupResult(connectionID, backup, err)
+	}()
+
 	conn, err := s.connStorage.GetConnection(connectionID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get connection: %v", err)
 	}
+	if run.database != "" {
+		conn.DatabaseName = run.database
+	}
+	schedule := run.schedule
+	var destination *ScheduleDestination
+	if schedule != nil {
+		if schedule.DumpOptions != nil {
+			conn.DumpOptions = schedule.DumpOptions
+		}
+		destination = schedule.Destination
+	}
 
-	if err := s.verifyBackupTools(conn.Type); err != nil {
+	if err := s.verifyBackupTools(conn); err != nil {
 		return nil, err
 	}
 
@@ -115,7 +168,7 @@ func (s *BackupService) CreateBackup(connectionID string) (*Backup, error) {
 
 	backupID := uuid.New()
 	timestamp := time.Now().Format("20060102_150405")
-	filename := fmt.Sprintf("%s_%s.sql", conn.DatabaseName, timestamp)
+	filename := fmt.Sprintf("%s_%s.%s", conn.DatabaseName, timestamp, dumpFileExtension(conn))
 
 	connectionFolder := filepath.Join(s.backupDir, common.SanitizeConnectionName(conn.Name))
 	if err := os.MkdirAll(connectionFolder, 0755); err != nil {
@@ -124,64 +177,164 @@ func (s *BackupService) CreateBackup(connectionID string) (*Backup, error) {
 
 	backupPath := filepath.Join(connectionFolder, filename)
 
-	backup := &Backup{
-		ID:           backupID,
-		ConnectionID: connectionID,
-		StartedTime:  time.Now(),
-		Status:       "in_progress",
-		Path:         backupPath,
-		CreatedAt:    time.Now(),
-		UpdatedAt:    time.Now(),
+	backup = &Backup{
+		ID:            backupID,
+		ConnectionID:  connectionID,
+		StartedTime:   time.Now(),
+		Status:        "in_progress",
+		Path:          backupPath,
+		ServerVersion: conn.ServerVersion,
+		CreatedAt:     time.Now(),
+		UpdatedAt:     time.Now(),
+	}
+
+	hooks := conn.Hooks
+	if schedule != nil {
+		scheduleID := schedule.ID.String()
+		backup.ScheduleID = &scheduleID
+		hooks = append(append([]connection.Hook{}, conn.Hooks...), schedule.Hooks...)
+	}
+
+	if err := s.backupRepo.CreateBackup(backup); err != nil {
+		backup = nil
+		return nil, fmt.Errorf("failed to save backup: %v", err)
+	}
+	s.publishBackup(conn.UserID, backup)
+
+	if err := s.createStartedNotification(connectionID, backup); err != nil {
+		fmt.Printf("Error creating backup started notification: %v\n", err)
 	}
 
-	var cmd *exec.Cmd
+	log := newOperationLog()
+	if run.reason != "" {
+		log.Printf("%s", run.reason)
+	}
+	log.Printf("Starting %s backup of database '%s'", conn.Type, conn.DatabaseName)
+
+	backupErr := s.runHooks(connection.HookPreBackup, hooks, conn, backupHookEnv(connection.HookPreBackup, conn, backup), log)
+	if backupErr == nil {
+		backupErr = s.dumpDatabase(conn, backup, destination, log)
+	}
+
+	if backupErr != nil {
+		backup.Status = "failed"
+	} else {
+		backup.Status = "completed"
+	}
+
+	// Post-backup hooks also run after a failure, so that whatever the
+	// pre-backup hooks paused can be resumed
+	if err := s.runHooks(connection.HookPostBackup, hooks, conn, backupHookEnv(connection.HookPostBackup, conn, backup), log); err != nil && backupErr == nil {
+		backupErr = err
+		backup.Status = "failed"
+	}
+
+	now := time.Now()
+	backup.UpdatedAt = now
+	if backupErr != nil {
+		log.Printf("Backup failed: %v", backupErr)
+	} else {
+		backup.CompletedTime = &now
+		log.Printf("Backup completed (%d bytes)", backup.Size)
+	}
+
+	// A dump tool can succeed on a database that lost its data, so scheduled
+	// backups are compared with the earlier backups of their schedule
+	if backupErr == nil && schedule != nil {
+		if anomaly, err := s.checkBackupAnomaly(schedule, backup); err != nil {
+			log.Printf("Warning: anomaly detection skipped: %v", err)
+		} else if anomaly != "" {
+			backup.Status = "suspicious"
+			backup.Anomaly = &anomaly
+			log.Printf("Backup flagged as suspicious: %s", anomaly)
+		}
+	}
+	backup.Log = log.String()
+
+	if err := s.backupRepo.UpdateBackup(backup); err != nil {
+		fmt.Printf("ERROR: failed to update backup %s: %v\n", backup.ID, err)
+	}
+	s.publishBackup(conn.UserID, backup)
+
+	if backupErr != nil {
+		return nil, backupErr
+	}
+
+	return backup, nil
+}
+
+// dumpDatabase runs the dump tool for conn into backup.Path and uploads the
+// result to S3 when enabled
+func (s *BackupService) dumpDatabase(conn *connection.StoredConnection, backup *Backup, destination *ScheduleDestination, log *operationLog) error {
+	var (
+		cmd     *exec.Cmd
+		cleanup func()
+		err     error
+	)
 	switch conn.Type {
 	case "postgresql":
-		cmd = s.createPgDumpCmd(conn, backupPath)
+		cmd, cleanup, err = s.createPgDumpCmd(conn, backup.Path)
 	case "mysql", "mariadb":
-		cmd = s.createMySQLDumpCmd(conn, backupPath)
+		cmd, cleanup, err = s.createMySQLDumpCmd(conn, backup.Path)
 	case "mongodb":
-		cmd = s.createMongoDumpCmd(conn, backupPath)
+		cmd, cleanup, err = s.createMongoDumpCmd(conn, backup.Path)
 	case "redis":
-		cmd = s.createRedisDumpCmd(conn, backupPath)
+		cmd, cleanup, err = s.createRedisDumpCmd(conn, backup.Path)
 	default:
-		return nil, fmt.Errorf("unsupported database type for backup: %s", conn.Type)
+		return fmt.Errorf("unsupported database type for backup: %s", conn.Type)
+	}
+	defer cleanup()
+
+	if err != nil {
+		return fmt.Errorf("failed to prepare %s: %v", requiredTools[conn.Type], err)
 	}
 
 	if cmd == nil {
-		return nil, fmt.Errorf("backup tool not found for %s. Please ensure %s is installed and available in PATH", conn.Type, requiredTools[conn.Type])
+		return fmt.Errorf("backup tool not found for %s. Please ensure %s is installed and available in PATH", conn.Type, requiredTools[conn.Type])
 	}
 
+	log.Printf("Running %s", filepath.Base(cmd.Path))
+	stopProgress := s.watchBackupProgress(conn.UserID, backup)
 	output, err := cmd.CombinedOutput()
+	stopProgress()
+	scrubbed := common.ScrubSecrets(string(output), conn.Password, conn.SSHPassword)
+	log.Output(scrubbed)
 	if err != nil {
-		errorMsg := string(output)
+		errorMsg := scrubbed
 		if errorMsg == "" {
 			errorMsg = err.Error()
 		}
-		return nil, fmt.Errorf("backup failed for %s database '%s' on %s:%d - %s",
+		return fmt.Errorf("backup failed for %s database '%s' on %s:%d - %s",
 			conn.Type, conn.DatabaseName, conn.Host, conn.Port, errorMsg)
 	}
 
 	// Get file size
-	fileInfo, err := os.Stat(backupPath)
+	fileInfo, err := os.Stat(backup.Path)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get backup file info: %v", err)
+		return fmt.Errorf("failed to get backup file info: %v", err)
 	}
-
 	backup.Size = fileInfo.Size()
-	backup.Status = "completed"
-	now := time.Now()
-	backup.CompletedTime = &now
 
-	if err := s.uploadToS3IfEnabled(backup, conn.UserID); err != nil {
-		fmt.Printf("Warning: Failed to upload backup to S3: %v\n", err)
+	if fileInfo.Mode().IsRegular() {
+		checksum, err := fileChecksum(backup.Path)
+		if err != nil {
+			return fmt.Errorf("failed to checksum backup file: %v", err)
+		}
+		backup.Checksum = &checksum
+		log.Printf("Backup checksum sha256:%s", checksum)
 	}
 
-	if err := s.backupRepo.CreateBackup(backup); err != nil {
-		return nil, fmt.Errorf("failed to save backup: %v", err)
+	if err := s.uploadToS3IfEnabled(backup, conn.UserID, destination); err != nil {
+		fmt.Printf("Warning: Failed to upload backup to S3: %v\n", err)
+		log.Printf("Warning: failed to upload backup to S3: %v", err)
+		if notifyErr := s.createUploadFailedNotification(conn.ID, backup, err); notifyErr != nil {
+			fmt.Printf("Error creating upload failure notification: %v\n", notifyErr)
+		}
+	} else if backup.S3ObjectKey != nil {
+		log.Printf("Uploaded backup to S3 as %s", *backup.S3ObjectKey)
 	}
 
-	return backup, nil
+	return nil
 }
 
 func (s *BackupService) GetBackup(id string) (*Backup, error) {
@@ -203,16 +356,31 @@ func (s *BackupService) GetAllBackupsWithPagination(opts BackupListOptions) ([]*
 }
 
 func (s *BackupService) GetBackupStats(userID uuid.UUID) (*BackupStats, error) {
-	return s.backupRepo.GetBackupStats(userID)
+	stats, err := s.backupRepo.GetBackupStats(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	stats.RPO, err = s.GetRPOCompliance(userID)
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
 }
 
-func (s *BackupService) uploadToS3IfEnabled(backup *Backup, userID uuid.UUID) error {
+// uploadToS3IfEnabled uploads a backup with the S3 settings of the user,
+// unless the destination of its schedule says otherwise
+func (s *BackupService) uploadToS3IfEnabled(backup *Backup, userID uuid.UUID, destination *ScheduleDestination) error {
 	userSettings, err := s.settingsService.GetUserSettings(userID)
 	if err != nil {
 		return fmt.Errorf("failed to get user settings: %w", err)
 	}
 
-	if !userSettings.S3Enabled {
+	upload := userSettings.S3Enabled
+	if destination != nil && destination.S3 != nil {
+		upload = *destination.S3
+	}
+	if !upload {
 		return nil
 	}
 
@@ -244,6 +412,9 @@ func (s *BackupService) uploadToS3IfEnabled(backup *Backup, userID uuid.UUID) er
 	if userSettings.S3PathPrefix != nil {
 		pathPrefix = *userSettings.S3PathPrefix
 	}
+	if destination != nil && destination.S3PathPrefix != "" {
+		pathPrefix = destination.S3PathPrefix
+	}
 
 	s3Config := S3Config{
 		Endpoint:   *userSettings.S3Endpoint,
@@ -0,0 +1,459 @@
+package backup
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Row change kinds of a data comparison
+const (
+	RowInserted = "inserted"
+	RowDeleted  = "deleted"
+	RowUpdated  = "updated"
+)
+
+const (
+	defaultDataDiffSamples = 5
+	maxDataDiffSamples     = 50
+	// dataDiffMaxRows bounds the source rows whose hashes are held in memory
+	dataDiffMaxRows = 20000000
+)
+
+// DataDiffOptions select the tables of a data comparison
+type DataDiffOptions struct {
+	Tables  []string // all tables with data when empty
+	Samples int      // changed rows returned per table
+}
+
+// DataDiff is the row level difference between two SQL backups
+type DataDiff struct {
+	SourceBackupID string          `json:"source_backup_id"`
+	TargetBackupID string          `json:"target_backup_id"`
+	Summary        DataDiffSummary `json:"summary"`
+	Tables         []TableDataDiff `json:"tables"`
+}
+
+type DataDiffSummary struct {
+	Tables        int   `json:"tables"`
+	ChangedTables int   `json:"changed_tables"`
+	SkippedTables int   `json:"skipped_tables"`
+	Inserted      int64 `json:"inserted"`
+	Deleted       int64 `json:"deleted"`
+	Updated       int64 `json:"updated"`
+}
+
+// TableDataDiff counts the changed rows of a table. Rows are matched by
+// primary key; tables without one are compared as multisets of rows, so a
+// changed row counts as deleted and inserted.
+type TableDataDiff struct {
+	Name       string      `json:"name"`
+	PrimaryKey []string    `json:"primary_key,omitempty"`
+	SourceRows int64       `json:"source_rows"`
+	TargetRows int64       `json:"target_rows"`
+	Inserted   int64       `json:"inserted"`
+	Deleted    int64       `json:"deleted"`
+	Updated    int64       `json:"updated"`
+	Unchanged  int64       `json:"unchanged"`
+	Skipped    string      `json:"skipped,omitempty"`
+	Samples    []RowChange `json:"samples,omitempty"`
+}
+
+// RowChange is a sample of a changed row. Null values are nil.
+type RowChange struct {
+	Change  string             `json:"change"`
+	Key     map[string]*string `json:"key,omitempty"`
+	Before  map[string]*string `json:"before,omitempty"`
+	After   map[string]*string `json:"after,omitempty"`
+	Columns []string           `json:"columns,omitempty"` // changed columns of an updated row
+}
+
+// tableRows holds the hashes of the rows of a source table. For tables with
+// a primary key it maps key hashes to row hashes, otherwise row hashes to
+// the number of equal rows.
+type tableRows struct {
+	diff    *TableDataDiff
+	columns []string // declared columns, for INSERTs without a column list
+	key     []string
+	rows    map[uint64]uint64
+	samples []*RowChange
+	// wanted are the samples waiting for their source row, by hash
+	wanted map[uint64]*RowChange
+}
+
+// CompareData compares the rows of the tables of two backups. The schema of
+// both dumps is read first for the primary keys, then the source rows are
+// hashed and the target rows are looked up. The rows of changed samples are
+// read from the source again, so only hashes are held in memory.
+func (s *BackupService) CompareData(sourceID, targetID string, opts DataDiffOptions) (*DataDiff, error) {
+	if opts.Samples <= 0 {
+		opts.Samples = defaultDataDiffSamples
+	}
+	if opts.Samples > maxDataDiffSamples {
+		opts.Samples = maxDataDiffSamples
+	}
+
+	var schemas [2]*dumpSchema
+	var errs [2]error
+	var wg sync.WaitGroup
+	for i, id := range []string{sourceID, targetID} {
+		wg.Add(1)
+		go func(i int, id string) {
+			defer wg.Done()
+			schemas[i], errs[i] = s.loadDumpSchema(id)
+		}(i, id)
+	}
+	wg.Wait()
+	if errs[0] != nil {
+		return nil, fmt.Errorf("source backup: %w", errs[0])
+	}
+	if errs[1] != nil {
+		return nil, fmt.Errorf("target backup: %w", errs[1])
+	}
+	source, target := schemas[0], schemas[1]
+	if source.dialect != target.dialect {
+		return nil, fmt.Errorf("backups of different database engines cannot be compared")
+	}
+
+	diff := &DataDiff{SourceBackupID: sourceID, TargetBackupID: targetID, Tables: []TableDataDiff{}}
+	tables := selectDataTables(source, target, opts.Tables, diff)
+	if len(tables) == 0 {
+		return diff, nil
+	}
+
+	// Hash the source rows
+	if err := s.scanBackupRows(sourceID, tables, func(table *tableRows, values map[string]*string) {
+		if len(table.key) == 0 {
+			table.rows[hashRow(values)]++
+			return
+		}
+		table.rows[hashKey(table.key, values)] = hashRow(values)
+	}); err != nil {
+		return nil, fmt.Errorf("source backup: %w", err)
+	}
+
+	// Match the target rows against them
+	if err := s.scanBackupRows(targetID, tables, func(table *tableRows, values map[string]*string) {
+		rowHash := hashRow(values)
+		if len(table.key) == 0 {
+			if count := table.rows[rowHash]; count > 0 {
+				if count == 1 {
+					delete(table.rows, rowHash)
+				} else {
+					table.rows[rowHash] = count - 1
+				}
+				table.diff.Unchanged++
+				return
+			}
+			table.diff.Inserted++
+			table.sample(opts.Samples, rowHash, &RowChange{Change: RowInserted, After: values}, false)
+			return
+		}
+
+		keyHash := hashKey(table.key, values)
+		sourceHash, found := table.rows[keyHash]
+		switch {
+		case !found:
+			table.diff.Inserted++
+			table.sample(opts.Samples, keyHash, &RowChange{Change: RowInserted, Key: keyValues(table.key, values), After: values}, false)
+		case sourceHash != rowHash:
+			table.diff.Updated++
+			table.sample(opts.Samples, keyHash, &RowChange{Change: RowUpdated, Key: keyValues(table.key, values), After: values}, true)
+		default:
+			table.diff.Unchanged++
+		}
+		if found {
+			delete(table.rows, keyHash)
+		}
+	}); err != nil {
+		return nil, fmt.Errorf("target backup: %w", err)
+	}
+
+	// What is left of the source rows was deleted
+	needSource := false
+	for _, table := range tables {
+		for hash, count := range table.rows {
+			if len(table.key) == 0 {
+				table.diff.Deleted += int64(count)
+			} else {
+				table.diff.Deleted++
+			}
+			table.sample(opts.Samples, hash, &RowChange{Change: RowDeleted}, true)
+		}
+		table.rows = nil
+		if len(table.wanted) > 0 {
+			needSource = true
+		}
+	}
+
+	// Read the source rows of the samples
+	if needSource {
+		if err := s.scanBackupRows(sourceID, tables, func(table *tableRows, values map[string]*string) {
+			if len(table.wanted) == 0 {
+				return
+			}
+			hash := hashRow(values)
+			if len(table.key) > 0 {
+				hash = hashKey(table.key, values)
+			}
+			change, ok := table.wanted[hash]
+			if !ok {
+				return
+			}
+			delete(table.wanted, hash)
+			change.Before = values
+			if len(table.key) > 0 {
+				change.Key = keyValues(table.key, values)
+			}
+			if change.Change == RowUpdated {
+				change.Columns = changedColumns(change.Before, change.After)
+			}
+		}); err != nil {
+			return nil, fmt.Errorf("source backup: %w", err)
+		}
+	}
+
+	for _, table := range tables {
+		for _, change := range table.samples {
+			table.diff.Samples = append(table.diff.Samples, *change)
+		}
+		sortRowChanges(table.diff.Samples)
+	}
+	for i := range diff.Tables {
+		table := &diff.Tables[i]
+		diff.Summary.Inserted += table.Inserted
+		diff.Summary.Deleted += table.Deleted
+		diff.Summary.Updated += table.Updated
+		if table.Inserted+table.Deleted+table.Updated > 0 {
+			diff.Summary.ChangedTables++
+		}
+		if table.Skipped != "" {
+			diff.Summary.SkippedTables++
+		}
+	}
+	return diff, nil
+}
+
+// selectDataTables adds the tables with data to diff and returns the ones
+// that will be compared, by name. The primary key of the target is used
+// when the table exists there.
+func selectDataTables(source, target *dumpSchema, only []string, diff *DataDiff) map[string]*tableRows {
+	wanted := func(table *schemaTable) bool {
+		if len(only) == 0 {
+			return true
+		}
+		for _, name := range only {
+			if name == table.Name.String() || name == table.Name.Name {
+				return true
+			}
+		}
+		return false
+	}
+
+	var budget int64 = dataDiffMaxRows
+	for _, name := range mergedNames(source.tableNames(), target.tableNames()) {
+		before, after := source.tables[name], target.tables[name]
+		table := after
+		if table == nil {
+			table = before
+		}
+		if !wanted(table) || (before == nil || !before.HasData) && (after == nil || !after.HasData) {
+			continue
+		}
+
+		entry := TableDataDiff{Name: name, PrimaryKey: table.PrimaryKey}
+		if before != nil {
+			entry.SourceRows = before.Rows
+		}
+		if after != nil {
+			entry.TargetRows = after.Rows
+		}
+		if before != nil && after != nil && len(after.PrimaryKey) > 0 && !equalStrings(before.PrimaryKey, after.PrimaryKey) {
+			entry.Skipped = "the primary key changed"
+		} else if entry.SourceRows > budget {
+			entry.Skipped = "too many rows to compare"
+		} else {
+			budget -= entry.SourceRows
+		}
+		diff.Tables = append(diff.Tables, entry)
+	}
+	diff.Summary.Tables = len(diff.Tables)
+
+	tables := make(map[string]*tableRows)
+	for i := range diff.Tables {
+		entry := &diff.Tables[i]
+		if entry.Skipped != "" {
+			continue
+		}
+		rows := &tableRows{diff: entry, key: entry.PrimaryKey, rows: make(map[uint64]uint64), wanted: make(map[uint64]*RowChange)}
+		if table := target.tables[entry.Name]; table != nil && len(table.Columns) > 0 {
+			rows.columns = columnNames(table)
+		} else if table := source.tables[entry.Name]; table != nil {
+			rows.columns = columnNames(table)
+		}
+		tables[entry.Name] = rows
+	}
+	return tables
+}
+
+// scanBackupRows reads the rows of the given tables of a backup and hands
+// them to visit as column values by name
+func (s *BackupService) scanBackupRows(backupID string, tables map[string]*tableRows, visit func(table *tableRows, values map[string]*string)) error {
+	backup, err := s.backupRepo.GetBackup(backupID)
+	if err != nil {
+		return fmt.Errorf("backup not found: %v", err)
+	}
+	reader, dialect, err := s.openDumpSQL(backup)
+	if err != nil {
+		return err
+	}
+
+	err = scanDumpRows(reader, dialect, func(name qualifiedName, columns []string, values []dataValue) error {
+		table, ok := tables[name.String()]
+		if !ok {
+			return nil
+		}
+		if columns == nil {
+			columns = table.columns
+		}
+		if len(columns) != len(values) {
+			return fmt.Errorf("table %s has %d columns but a row with %d values", name, len(columns), len(values))
+		}
+
+		row := make(map[string]*string, len(columns))
+		for i, column := range columns {
+			if !values[i].Null {
+				text := values[i].Text
+				row[column] = &text
+			} else {
+				row[column] = nil
+			}
+		}
+		for _, column := range table.key {
+			if _, ok := row[column]; !ok {
+				return fmt.Errorf("rows of table %s do not include primary key column %s", name, column)
+			}
+		}
+		visit(table, row)
+		return nil
+	})
+	if closeErr := reader.Close(); err == nil && closeErr != nil {
+		err = closeErr
+	}
+	return err
+}
+
+// sample keeps a changed row while the table has fewer than max samples.
+// When needSource is set the source row is read later, by hash.
+func (t *tableRows) sample(max int, hash uint64, change *RowChange, needSource bool) {
+	if len(t.samples) >= max {
+		return
+	}
+	t.samples = append(t.samples, change)
+	if needSource {
+		t.wanted[hash] = change
+	}
+}
+
+// hashRow hashes the values of a row independently of the column order, so
+// that rows of dumps with reordered columns compare equal
+func hashRow(values map[string]*string) uint64 {
+	var sum uint64
+	for column, value := range values {
+		h := hashString(fnvOffset64, column)
+		if value == nil {
+			h = (h ^ 0xff) * fnvPrime64
+		} else {
+			h = hashString((h^0xfe)*fnvPrime64, *value)
+		}
+		sum += h
+	}
+	return sum
+}
+
+func hashKey(key []string, values map[string]*string) uint64 {
+	h := uint64(fnvOffset64)
+	for _, column := range key {
+		if value := values[column]; value == nil {
+			h = (h ^ 0xff) * fnvPrime64
+		} else {
+			h = hashString((h^0xfe)*fnvPrime64, *value)
+		}
+	}
+	return h
+}
+
+func hashString(h uint64, s string) uint64 {
+	for i := 0; i < len(s); i++ {
+		h ^= uint64(s[i])
+		h *= fnvPrime64
+	}
+	return h
+}
+
+func keyValues(key []string, values map[string]*string) map[string]*string {
+	result := make(map[string]*string, len(key))
+	for _, column := range key {
+		result[column] = values[column]
+	}
+	return result
+}
+
+// changedColumns returns the columns whose value differs, sorted
+func changedColumns(before, after map[string]*string) []string {
+	var columns []string
+	for column, value := range after {
+		old, ok := before[column]
+		if !ok || (old == nil) != (value == nil) || old != nil && *old != *value {
+			columns = append(columns, column)
+		}
+	}
+	for column := range before {
+		if _, ok := after[column]; !ok {
+			columns = append(columns, column)
+		}
+	}
+	sort.Strings(columns)
+	return columns
+}
+
+func columnNames(table *schemaTable) []string {
+	names := make([]string, len(table.Columns))
+	for i, column := range table.Columns {
+		names[i] = column.Name
+	}
+	return names
+}
+
+// sortRowChanges orders samples by change kind and key
+func sortRowChanges(changes []RowChange) {
+	render := func(change RowChange) string {
+		values := change.Key
+		if values == nil {
+			values = change.After
+		}
+		if values == nil {
+			values = change.Before
+		}
+		keys := make([]string, 0, len(values))
+		for column := range values {
+			keys = append(keys, column)
+		}
+		sort.Strings(keys)
+		var b strings.Builder
+		for _, column := range keys {
+			if value := values[column]; value != nil {
+				b.WriteString(*value)
+			}
+			b.WriteByte(0)
+		}
+		return b.String()
+	}
+	sort.SliceStable(changes, func(i, j int) bool {
+		if changes[i].Change != changes[j].Change {
+			return changes[i].Change < changes[j].Change
+		}
+		return render(changes[i]) < render(changes[j])
+	})
+}
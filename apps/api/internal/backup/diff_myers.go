@@ -0,0 +1,174 @@
+package backup
+
+// diffOpKind is the kind of a diffOp
+type diffOpKind int
+
+const (
+	opEqual diffOpKind = iota
+	opDelete
+	opInsert
+	opReplace // only produced by groupOps
+)
+
+// diffOp covers a[A1:A2] and b[B1:B2]. Equal ops have ranges of the same
+// length, deletes an empty b range and inserts an empty a range.
+type diffOp struct {
+	Kind   diffOpKind
+	A1, A2 int
+	B1, B2 int
+}
+
+// myersMaxCost bounds the edit distance searched for between two ranges.
+// Ranges that differ more are reported as replaced as a whole, which keeps
+// the time linear in the input for unrelated files.
+const myersMaxCost = 4096
+
+// myersDiff returns the shortest edit script between a and b. It uses the
+// linear space variant of Myers' algorithm, splitting the problem at the
+// middle snake, so memory stays proportional to len(a)+len(b).
+func myersDiff(a, b []uint64) []diffOp {
+	d := &myers{a: a, b: b}
+	d.compare(0, len(a), 0, len(b))
+	return d.merged()
+}
+
+type myers struct {
+	a, b []uint64
+	ops  []diffOp
+}
+
+func (d *myers) emit(kind diffOpKind, a1, a2, b1, b2 int) {
+	if a1 == a2 && b1 == b2 {
+		return
+	}
+	d.ops = append(d.ops, diffOp{Kind: kind, A1: a1, A2: a2, B1: b1, B2: b2})
+}
+
+func (d *myers) compare(a1, a2, b1, b2 int) {
+	// Common prefix and suffix
+	start := 0
+	for a1+start < a2 && b1+start < b2 && d.a[a1+start] == d.b[b1+start] {
+		start++
+	}
+	d.emit(opEqual, a1, a1+start, b1, b1+start)
+	a1, b1 = a1+start, b1+start
+
+	end := 0
+	for a2-end > a1 && b2-end > b1 && d.a[a2-end-1] == d.b[b2-end-1] {
+		end++
+	}
+	suffixA, suffixB := a2-end, b2-end
+
+	switch {
+	case a1 == suffixA:
+		d.emit(opInsert, a1, a1, b1, suffixB)
+	case b1 == suffixB:
+		d.emit(opDelete, a1, suffixA, b1, b1)
+	default:
+		x, y, ok := d.bisect(a1, suffixA, b1, suffixB)
+		// A split at either end would not make progress
+		if ok && (x != a1 || y != b1) && (x != suffixA || y != suffixB) {
+			d.compare(a1, x, b1, y)
+			d.compare(x, suffixA, y, suffixB)
+		} else {
+			d.emit(opDelete, a1, suffixA, b1, b1)
+			d.emit(opInsert, suffixA, suffixA, b1, suffixB)
+		}
+	}
+
+	d.emit(opEqual, suffixA, a2, suffixB, b2)
+}
+
+// bisect finds the middle snake of a[a1:a2] and b[b1:b2] and returns the
+// point to split at, searching forwards and backwards at the same time
+func (d *myers) bisect(a1, a2, b1, b2 int) (int, int, bool) {
+	n, m := a2-a1, b2-b1
+	maxD := (n + m + 1) / 2
+	offset := maxD + 1
+	length := 2*maxD + 2
+	v1 := make([]int, length)
+	v2 := make([]int, length)
+	for i := range v1 {
+		v1[i], v2[i] = -1, -1
+	}
+	v1[offset+1], v2[offset+1] = 0, 0
+
+	delta := n - m
+	front := delta%2 != 0
+	k1start, k1end, k2start, k2end := 0, 0, 0, 0
+
+	for step := 0; step < maxD && step <= myersMaxCost; step++ {
+		for k1 := -step + k1start; k1 <= step-k1end; k1 += 2 {
+			k1Offset := offset + k1
+			var x1 int
+			if k1 == -step || (k1 != step && v1[k1Offset-1] < v1[k1Offset+1]) {
+				x1 = v1[k1Offset+1]
+			} else {
+				x1 = v1[k1Offset-1] + 1
+			}
+			y1 := x1 - k1
+			for x1 < n && y1 < m && d.a[a1+x1] == d.b[b1+y1] {
+				x1++
+				y1++
+			}
+			v1[k1Offset] = x1
+			switch {
+			case x1 > n:
+				k1end += 2
+			case y1 > m:
+				k1start += 2
+			case front:
+				k2Offset := offset + delta - k1
+				if k2Offset >= 0 && k2Offset < length && v2[k2Offset] != -1 && x1 >= n-v2[k2Offset] {
+					return a1 + x1, b1 + y1, true
+				}
+			}
+		}
+
+		for k2 := -step + k2start; k2 <= step-k2end; k2 += 2 {
+			k2Offset := offset + k2
+			var x2 int
+			if k2 == -step || (k2 != step && v2[k2Offset-1] < v2[k2Offset+1]) {
+				x2 = v2[k2Offset+1]
+			} else {
+				x2 = v2[k2Offset-1] + 1
+			}
+			y2 := x2 - k2
+			for x2 < n && y2 < m && d.a[a2-x2-1] == d.b[b2-y2-1] {
+				x2++
+				y2++
+			}
+			v2[k2Offset] = x2
+			switch {
+			case x2 > n:
+				k2end += 2
+			case y2 > m:
+				k2start += 2
+			case !front:
+				k1Offset := offset + delta - k2
+				if k1Offset >= 0 && k1Offset < length && v1[k1Offset] != -1 {
+					x1 := v1[k1Offset]
+					y1 := offset + x1 - k1Offset
+					if x1 >= n-x2 {
+						return a1 + x1, b1 + y1, true
+					}
+				}
+			}
+		}
+	}
+
+	return 0, 0, false
+}
+
+// merged joins adjacent ops of the same kind
+func (d *myers) merged() []diffOp {
+	var ops []diffOp
+	for _, op := range d.ops {
+		if last := len(ops) - 1; last >= 0 && ops[last].Kind == op.Kind && ops[last].A2 == op.A1 && ops[last].B2 == op.B1 {
+			ops[last].A2, ops[last].B2 = op.A2, op.B2
+			continue
+		}
+		ops = append(ops, op)
+	}
+	return ops
+}
@@ -0,0 +1,580 @@
+package backup
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+const (
+	diffContextLines = 3
+	// A chunk ends after a line whose hash has these bits clear, so chunk
+	// boundaries follow the content and realign after an insertion
+	diffChunkMask     = 127
+	diffChunkMaxLines = 4096
+	diffChunkMaxBytes = 8 << 20
+	// Changed regions larger than this are reported as replaced without
+	// comparing their lines
+	diffRegionMaxLines = 200000
+	// diffMaxHashedLines bounds the line hashes held for all changed regions
+	diffMaxHashedLines = 4000000
+	// diffMaxLineBytes is how much of a line is returned in a change
+	diffMaxLineBytes = 2000
+	// diffMaxHunkLines is how many lines of a single hunk are returned
+	diffMaxHunkLines = 2000
+
+	fnvOffset64 = 14695981039346656037
+	fnvPrime64  = 1099511628211
+)
+
+// DiffOptions select the part of a text comparison that is returned
+type DiffOptions struct {
+	SummaryOnly bool
+	Page        int // 1-based page of hunks
+	Limit       int // hunks per page
+}
+
+// diffChunk is a run of lines of a dump, identified by the hash of its lines
+type diffChunk struct {
+	hash   uint64
+	offset int64
+	line   int
+	lines  int
+}
+
+// diffInput is one side of a comparison. Only the chunk index is kept in
+// memory; lines are read again from the backup when they are needed.
+type diffInput struct {
+	backup   *Backup
+	chunks   []diffChunk
+	lines    int
+	seekable bool
+}
+
+// lineEdit replaces old lines [OldStart, OldEnd) with new lines
+// [NewStart, NewEnd). Line numbers are 0-based.
+type lineEdit struct {
+	OldStart, OldEnd int
+	NewStart, NewEnd int
+}
+
+// lineRange is a range of lines to read from a diffInput
+type lineRange struct {
+	start, end int
+}
+
+type diffLine struct {
+	hash      uint64
+	content   []byte
+	truncated bool
+}
+
+// lineScanner reads lines of any length, hashing them as they are read
+type lineScanner struct {
+	r      *bufio.Reader
+	offset int64 // byte offset of the next line
+	line   int   // number of the next line
+}
+
+func newLineScanner(r io.Reader, offset int64, line int) *lineScanner {
+	return &lineScanner{r: bufio.NewReaderSize(r, 64*1024), offset: offset, line: line}
+}
+
+// next reads a line and returns its FNV-1a hash. Up to keep bytes of the
+// line are returned as well.
+func (s *lineScanner) next(keep int) (diffLine, error) {
+	result := diffLine{hash: fnvOffset64}
+	read, size := 0, 0
+	for {
+		fragment, err := s.r.ReadSlice('\n')
+		read += len(fragment)
+		if err == nil {
+			fragment = fragment[:len(fragment)-1]
+		}
+
+		for _, c := range fragment {
+			result.hash ^= uint64(c)
+			result.hash *= fnvPrime64
+		}
+		size += len(fragment)
+		if room := keep - len(result.content); room > 0 {
+			if room > len(fragment) {
+				room = len(fragment)
+			}
+			result.content = append(result.content, fragment[:room]...)
+		}
+
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err == io.EOF {
+			if read == 0 {
+				return result, io.EOF
+			}
+			break
+		}
+		if err != nil {
+			return result, err
+		}
+		break
+	}
+
+	result.truncated = size > len(result.content) && keep > 0
+	s.offset += int64(read)
+	s.line++
+	return result, nil
+}
+
+// DiffBackups compares the text of two backups line by line with memory
+// bounded by the number of changes rather than the size of the dumps.
+//
+// Both dumps are first split into chunks of lines at content defined
+// boundaries and the chunk hashes are compared with Myers' algorithm. Only
+// the lines of chunks that differ are then hashed and compared line by
+// line, and only the lines of the requested page of hunks are read again.
+func (s *BackupService) DiffBackups(sourceID, targetID string, opts DiffOptions) (*DiffResponse, error) {
+	if opts.Page < 1 {
+		opts.Page = 1
+	}
+	if opts.Limit <= 0 {
+		opts.Limit = 20
+	}
+	if opts.Limit > 200 {
+		opts.Limit = 200
+	}
+
+	var inputs [2]*diffInput
+	var errs [2]error
+	var wg sync.WaitGroup
+	for i, id := range []string{sourceID, targetID} {
+		wg.Add(1)
+		go func(i int, id string) {
+			defer wg.Done()
+			inputs[i], errs[i] = s.scanDiffInput(id)
+		}(i, id)
+	}
+	wg.Wait()
+	if errs[0] != nil {
+		return nil, fmt.Errorf("source backup: %w", errs[0])
+	}
+	if errs[1] != nil {
+		return nil, fmt.Errorf("target backup: %w", errs[1])
+	}
+	source, target := inputs[0], inputs[1]
+
+	edits, unchanged, err := s.diffInputs(source, target)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &DiffResponse{
+		Unchanged:   unchanged,
+		Changes:     []DiffChange{},
+		Hunks:       []DiffHunk{},
+		SourceLines: source.lines,
+		TargetLines: target.lines,
+		Page:        opts.Page,
+		Limit:       opts.Limit,
+		SummaryOnly: opts.SummaryOnly,
+	}
+	for _, edit := range edits {
+		removed, added := edit.OldEnd-edit.OldStart, edit.NewEnd-edit.NewStart
+		paired := removed
+		if added < paired {
+			paired = added
+		}
+		result.Modified += paired
+		result.Removed += removed - paired
+		result.Added += added - paired
+	}
+
+	hunks := groupHunks(edits, source.lines, target.lines)
+	result.TotalHunks = len(hunks)
+	if opts.SummaryOnly {
+		return result, nil
+	}
+
+	first := (opts.Page - 1) * opts.Limit
+	if first >= len(hunks) {
+		return result, nil
+	}
+	last := first + opts.Limit
+	if last > len(hunks) {
+		last = len(hunks)
+	}
+
+	if err := s.renderHunks(result, hunks[first:last], source, target); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// scanDiffInput reads a backup once and builds its chunk index
+func (s *BackupService) scanDiffInput(backupID string) (*diffInput, error) {
+	backup, err := s.backupRepo.GetBackup(backupID)
+	if err != nil {
+		return nil, fmt.Errorf("backup not found: %v", err)
+	}
+
+	reader, err := s.openDumpText(backup)
+	if err != nil {
+		return nil, err
+	}
+
+	input := &diffInput{backup: backup, seekable: reader.seekable}
+	scanner := newLineScanner(reader, 0, 0)
+	chunk := diffChunk{hash: fnvOffset64}
+	var chunkBytes int64
+
+	for {
+		start := scanner.offset
+		line, err := scanner.next(0)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			reader.Close()
+			return nil, fmt.Errorf("failed to read backup: %v", err)
+		}
+
+		if chunk.lines == 0 {
+			chunk.offset, chunk.line = start, input.lines
+		}
+		chunk.hash = (chunk.hash ^ line.hash) * fnvPrime64
+		chunk.lines++
+		chunkBytes += scanner.offset - start
+		input.lines++
+
+		if line.hash&diffChunkMask == 0 || chunk.lines >= diffChunkMaxLines || chunkBytes >= diffChunkMaxBytes {
+			input.chunks = append(input.chunks, chunk)
+			chunk = diffChunk{hash: fnvOffset64}
+			chunkBytes = 0
+		}
+	}
+	if chunk.lines > 0 {
+		input.chunks = append(input.chunks, chunk)
+	}
+
+	if err := reader.Close(); err != nil {
+		return nil, err
+	}
+	return input, nil
+}
+
+// diffInputs compares the chunks of both inputs and then the lines of the
+// chunks that differ. It returns the line edits in order and the number of
+// unchanged lines.
+func (s *BackupService) diffInputs(source, target *diffInput) ([]lineEdit, int, error) {
+	chunkHashes := func(input *diffInput) []uint64 {
+		hashes := make([]uint64, len(input.chunks))
+		for i, chunk := range input.chunks {
+			hashes[i] = chunk.hash
+		}
+		return hashes
+	}
+
+	// Changed regions as line ranges of both inputs
+	var regions []lineEdit
+	unchanged := 0
+	lineOf := func(input *diffInput, chunk int) int {
+		if chunk < len(input.chunks) {
+			return input.chunks[chunk].line
+		}
+		return input.lines
+	}
+	for _, op := range groupOps(myersDiff(chunkHashes(source), chunkHashes(target))) {
+		if op.Kind == opEqual {
+			unchanged += lineOf(source, op.A2) - lineOf(source, op.A1)
+			continue
+		}
+		regions = append(regions, lineEdit{
+			OldStart: lineOf(source, op.A1), OldEnd: lineOf(source, op.A2),
+			NewStart: lineOf(target, op.B1), NewEnd: lineOf(target, op.B2),
+		})
+	}
+
+	// Regions that are too large to compare line by line stay replaced
+	var oldRanges, newRanges []lineRange
+	detailed := make([]bool, len(regions))
+	hashed := 0
+	for i, region := range regions {
+		size := (region.OldEnd - region.OldStart) + (region.NewEnd - region.NewStart)
+		if region.OldEnd-region.OldStart > diffRegionMaxLines || region.NewEnd-region.NewStart > diffRegionMaxLines ||
+			hashed+size > diffMaxHashedLines {
+			continue
+		}
+		detailed[i] = true
+		hashed += size
+		oldRanges = append(oldRanges, lineRange{region.OldStart, region.OldEnd})
+		newRanges = append(newRanges, lineRange{region.NewStart, region.NewEnd})
+	}
+
+	var oldLines, newLines [][]diffLine
+	var oldErr, newErr error
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		oldLines, oldErr = s.readLineRanges(source, oldRanges, 0)
+	}()
+	go func() {
+		defer wg.Done()
+		newLines, newErr = s.readLineRanges(target, newRanges, 0)
+	}()
+	wg.Wait()
+	if oldErr != nil {
+		return nil, 0, oldErr
+	}
+	if newErr != nil {
+		return nil, 0, newErr
+	}
+
+	var edits []lineEdit
+	next := 0
+	for i, region := range regions {
+		if !detailed[i] {
+			edits = append(edits, region)
+			continue
+		}
+
+		a, b := lineHashes(oldLines[next]), lineHashes(newLines[next])
+		next++
+		for _, op := range groupOps(myersDiff(a, b)) {
+			if op.Kind == opEqual {
+				unchanged += op.A2 - op.A1
+				continue
+			}
+			edits = append(edits, lineEdit{
+				OldStart: region.OldStart + op.A1, OldEnd: region.OldStart + op.A2,
+				NewStart: region.NewStart + op.B1, NewEnd: region.NewStart + op.B2,
+			})
+		}
+	}
+
+	return edits, unchanged, nil
+}
+
+// groupOps joins adjacent deletes and inserts into a single replace op
+func groupOps(ops []diffOp) []diffOp {
+	var grouped []diffOp
+	for _, op := range ops {
+		if op.Kind != opEqual {
+			if last := len(grouped) - 1; last >= 0 && grouped[last].Kind == opReplace {
+				grouped[last].A2, grouped[last].B2 = op.A2, op.B2
+				continue
+			}
+			op.Kind = opReplace
+		}
+		grouped = append(grouped, op)
+	}
+	return grouped
+}
+
+func lineHashes(lines []diffLine) []uint64 {
+	hashes := make([]uint64, len(lines))
+	for i, line := range lines {
+		hashes[i] = line.hash
+	}
+	return hashes
+}
+
+// readLineRanges reads the lines of sorted, non-overlapping ranges in one
+// pass. Seekable inputs jump to the chunk holding each range; compressed or
+// converted dumps are read from the start.
+func (s *BackupService) readLineRanges(input *diffInput, ranges []lineRange, keep int) ([][]diffLine, error) {
+	result := make([][]diffLine, len(ranges))
+	if len(ranges) == 0 {
+		return result, nil
+	}
+
+	reader, err := s.openDumpText(input.backup)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	scanner := newLineScanner(reader, 0, 0)
+	for i, r := range ranges {
+		if input.seekable && r.start > scanner.line {
+			chunk := input.chunkAt(r.start)
+			if chunk.line > scanner.line {
+				if err := reader.seekTo(chunk.offset); err != nil {
+					return nil, fmt.Errorf("failed to seek in backup: %v", err)
+				}
+				scanner = newLineScanner(reader, chunk.offset, chunk.line)
+			}
+		}
+
+		for scanner.line < r.end {
+			line, err := scanner.next(keep)
+			if err == io.EOF {
+				return nil, fmt.Errorf("backup %s changed while it was compared", input.backup.ID)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to read backup: %v", err)
+			}
+			if scanner.line > r.start {
+				result[i] = append(result[i], line)
+			}
+		}
+	}
+	return result, nil
+}
+
+// chunkAt returns the chunk holding line
+func (input *diffInput) chunkAt(line int) diffChunk {
+	i := sort.Search(len(input.chunks), func(i int) bool { return input.chunks[i].line > line })
+	if i == 0 {
+		return diffChunk{}
+	}
+	return input.chunks[i-1]
+}
+
+// diffHunk is a group of edits shown with their surrounding context
+type diffHunk struct {
+	edits            []lineEdit
+	oldStart, oldEnd int
+	newStart, newEnd int
+}
+
+// groupHunks groups edits that are close together, adding context lines
+func groupHunks(edits []lineEdit, oldLines, newLines int) []diffHunk {
+	var hunks []diffHunk
+	for _, edit := range edits {
+		if last := len(hunks) - 1; last >= 0 && edit.OldStart-hunks[last].edits[len(hunks[last].edits)-1].OldEnd <= 2*diffContextLines {
+			hunks[last].edits = append(hunks[last].edits, edit)
+			continue
+		}
+
+		before := diffContextLines
+		if edit.OldStart < before {
+			before = edit.OldStart
+		}
+		if edit.NewStart < before {
+			before = edit.NewStart
+		}
+		hunks = append(hunks, diffHunk{
+			edits:    []lineEdit{edit},
+			oldStart: edit.OldStart - before,
+			newStart: edit.NewStart - before,
+		})
+	}
+
+	for i := range hunks {
+		last := hunks[i].edits[len(hunks[i].edits)-1]
+		after := diffContextLines
+		if oldLines-last.OldEnd < after {
+			after = oldLines - last.OldEnd
+		}
+		if newLines-last.NewEnd < after {
+			after = newLines - last.NewEnd
+		}
+		hunks[i].oldEnd = last.OldEnd + after
+		hunks[i].newEnd = last.NewEnd + after
+	}
+	return hunks
+}
+
+// renderHunks reads the lines of the hunks of a page and adds them to result
+func (s *BackupService) renderHunks(result *DiffResponse, hunks []diffHunk, source, target *diffInput) error {
+	// Huge replaced regions are cut to diffMaxHunkLines lines per side
+	clip := func(start, end int) lineRange {
+		if end-start > diffMaxHunkLines {
+			end = start + diffMaxHunkLines
+		}
+		return lineRange{start, end}
+	}
+
+	var oldRanges, newRanges []lineRange
+	for _, hunk := range hunks {
+		oldRanges = append(oldRanges, clip(hunk.oldStart, hunk.oldEnd))
+		newRanges = append(newRanges, clip(hunk.newStart, hunk.newEnd))
+	}
+
+	oldLines, err := s.readLineRanges(source, oldRanges, diffMaxLineBytes)
+	if err != nil {
+		return err
+	}
+	newLines, err := s.readLineRanges(target, newRanges, diffMaxLineBytes)
+	if err != nil {
+		return err
+	}
+
+	for i, hunk := range hunks {
+		index := (result.Page-1)*result.Limit + i
+		header := DiffHunk{
+			Index:    index,
+			OldStart: hunk.oldStart + 1,
+			OldLines: hunk.oldEnd - hunk.oldStart,
+			NewStart: hunk.newStart + 1,
+			NewLines: hunk.newEnd - hunk.newStart,
+		}
+
+		emitted := 0
+		add := func(kind string, lines []diffLine, fileLine int, old bool) bool {
+			lineIndex := fileLine - hunk.newStart
+			if old {
+				lineIndex = fileLine - hunk.oldStart
+			}
+			if emitted >= diffMaxHunkLines || lineIndex >= len(lines) {
+				header.Truncated = true
+				return false
+			}
+			emitted++
+
+			prefix := "  "
+			switch kind {
+			case "added":
+				prefix = "+ "
+			case "removed":
+				prefix = "- "
+			}
+			change := DiffChange{
+				Type:       kind,
+				Content:    prefix + string(lines[lineIndex].content),
+				LineNumber: len(result.Changes) + 1,
+				Hunk:       index,
+				Truncated:  lines[lineIndex].truncated,
+			}
+			if kind != "added" {
+				change.OldLine = fileLine + 1
+			}
+			if kind == "added" {
+				change.NewLine = fileLine + 1
+			}
+			result.Changes = append(result.Changes, change)
+			return true
+		}
+
+		oldLine, newLine := hunk.oldStart, hunk.newStart
+		context := func(until int) {
+			for ; oldLine < until; oldLine, newLine = oldLine+1, newLine+1 {
+				if !add("unchanged", oldLines[i], oldLine, true) {
+					return
+				}
+				result.Changes[len(result.Changes)-1].NewLine = newLine + 1
+			}
+		}
+
+		for _, edit := range hunk.edits {
+			context(edit.OldStart)
+			for line := edit.OldStart; line < edit.OldEnd; line++ {
+				if !add("removed", oldLines[i], line, true) {
+					break
+				}
+			}
+			for line := edit.NewStart; line < edit.NewEnd; line++ {
+				if !add("added", newLines[i], line, false) {
+					break
+				}
+			}
+			oldLine, newLine = edit.OldEnd, edit.NewEnd
+		}
+		context(hunk.oldEnd)
+
+		result.Hunks = append(result.Hunks, header)
+	}
+	return nil
+}
@@ -0,0 +1,880 @@
+package backup
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// sqlDialect selects the quoting and comment rules of a plain SQL dump
+type sqlDialect int
+
+const (
+	dialectPostgres sqlDialect = iota
+	dialectMySQL
+)
+
+func dialectFor(dbType string) (sqlDialect, bool) {
+	switch dbType {
+	case "postgresql":
+		return dialectPostgres, true
+	case "mysql", "mariadb":
+		return dialectMySQL, true
+	default:
+		return 0, false
+	}
+}
+
+// dumpStatement is one statement of a plain SQL dump, including the comments
+// in front of it and its delimiter
+type dumpStatement struct {
+	SQL string
+	// IsMeta marks client commands such as psql's \connect or mysql's DELIMITER
+	IsMeta bool
+	// IsCopy marks COPY ... FROM stdin; its rows are read with CopyRow
+	IsCopy bool
+}
+
+// copyTerminator ends the rows of a COPY statement
+const copyTerminator = "\\."
+
+type scanMode int
+
+const (
+	scanNormal scanMode = iota
+	scanSingleQuote
+	scanDoubleQuote
+	scanBacktick
+	scanDollarQuote
+	scanLineComment
+	scanBlockComment
+)
+
+// dumpScanner splits a plain SQL dump written by pg_dump or mysqldump into
+// statements. Only the current statement is held in memory, COPY rows are
+// handed out one line at a time.
+type dumpScanner struct {
+	reader    *bufio.Reader
+	dialect   sqlDialect
+	delimiter string
+
+	line    string // rest of the current line not yet consumed
+	eof     bool
+	inCopy  bool
+	pending strings.Builder
+
+	mode         scanMode
+	hasCode      bool
+	backslash    bool // the previous character escapes the next one
+	escapeString bool // the current single-quoted string uses backslash escapes
+	dollarTag    string
+	commentDepth int
+}
+
+func newDumpScanner(r io.Reader, dialect sqlDialect) *dumpScanner {
+	return &dumpScanner{
+		reader:    bufio.NewReaderSize(r, 1<<20),
+		dialect:   dialect,
+		delimiter: ";",
+	}
+}
+
+func (s *dumpScanner) readLine() bool {
+	if s.eof {
+		return false
+	}
+	line, err := s.reader.ReadString('\n')
+	if err != nil {
+		s.eof = true
+		if line == "" {
+			return false
+		}
+	}
+	s.line = line
+	return true
+}
+
+// CopyRow returns the next row of the current COPY statement, without its
+// line ending. ok is false once the terminating "\." line has been read.
+func (s *dumpScanner) CopyRow() (row string, ok bool, err error) {
+	if !s.inCopy {
+		return "", false, nil
+	}
+	if s.line == "" && !s.readLine() {
+		s.inCopy = false
+		return "", false, io.ErrUnexpectedEOF
+	}
+
+	row = strings.TrimRight(s.line, "\r\n")
+	s.line = ""
+	if row == copyTerminator {
+		s.inCopy = false
+		return "", false, nil
+	}
+	return row, true, nil
+}
+
+// Next returns the next statement, or io.EOF at the end of the dump. Unread
+// rows of a previous COPY statement are skipped.
+func (s *dumpScanner) Next() (*dumpStatement, error) {
+	for s.inCopy {
+		if _, ok, err := s.CopyRow(); err != nil {
+			return nil, err
+		} else if !ok {
+			break
+		}
+	}
+
+	for {
+		if s.line == "" && !s.readLine() {
+			return s.finish()
+		}
+
+		if !s.hasCode && s.mode == scanNormal {
+			if stmt := s.metaCommand(); stmt != nil {
+				return stmt, nil
+			}
+		}
+
+		if end := s.scanLine(); end >= 0 {
+			s.pending.WriteString(s.line[:end])
+			s.line = s.line[end:]
+			return s.emit(), nil
+		}
+
+		s.pending.WriteString(s.line)
+		s.line = ""
+	}
+}
+
+// metaCommand recognises client commands, which take a whole line and have
+// no delimiter
+func (s *dumpScanner) metaCommand() *dumpStatement {
+	trimmed := strings.TrimLeft(s.line, " \t")
+	isMeta := false
+	switch s.dialect {
+	case dialectPostgres:
+		isMeta = strings.HasPrefix(trimmed, "\\")
+	case dialectMySQL:
+		if len(trimmed) > 10 && strings.EqualFold(trimmed[:10], "DELIMITER ") {
+			isMeta = true
+			if delimiter := strings.TrimSpace(trimmed[10:]); delimiter != "" {
+				s.delimiter = delimiter
+			}
+		}
+	}
+	if !isMeta {
+		return nil
+	}
+
+	s.pending.WriteString(s.line)
+	s.line = ""
+	stmt := &dumpStatement{SQL: s.pending.String(), IsMeta: true}
+	s.pending.Reset()
+	return stmt
+}
+
+// scanLine advances the lexical state over the current line and returns the
+// offset just past the delimiter ending the statement, or -1
+func (s *dumpScanner) scanLine() int {
+	line := s.line
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+
+		switch s.mode {
+		case scanLineComment:
+			if c == '\n' {
+				s.mode = scanNormal
+			}
+			continue
+
+		case scanBlockComment:
+			if c == '*' && i+1 < len(line) && line[i+1] == '/' {
+				i++
+				s.commentDepth--
+				if s.commentDepth == 0 {
+					s.mode = scanNormal
+				}
+			} else if s.dialect == dialectPostgres && c == '/' && i+1 < len(line) && line[i+1] == '*' {
+				i++
+				s.commentDepth++
+			}
+			continue
+
+		case scanSingleQuote, scanDoubleQuote, scanBacktick:
+			quote := byte('\'')
+			if s.mode == scanDoubleQuote {
+				quote = '"'
+			} else if s.mode == scanBacktick {
+				quote = '`'
+			}
+			if s.backslash {
+				s.backslash = false
+				continue
+			}
+			if c == '\\' && s.mode != scanBacktick && (s.escapeString || s.dialect == dialectMySQL) {
+				s.backslash = true
+				continue
+			}
+			if c == quote {
+				// A doubled quote is an escaped quote, not the end
+				if i+1 < len(line) && line[i+1] == quote {
+					i++
+					continue
+				}
+				s.mode = scanNormal
+			}
+			continue
+
+		case scanDollarQuote:
+			if c == '$' && strings.HasPrefix(line[i:], s.dollarTag) {
+				i += len(s.dollarTag) - 1
+				s.mode = scanNormal
+			}
+			continue
+		}
+
+		// scanNormal
+		if strings.HasPrefix(line[i:], s.delimiter) {
+			return i + len(s.delimiter)
+		}
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+		case c == '-' && i+1 < len(line) && line[i+1] == '-':
+			s.mode = scanLineComment
+			i++
+		case c == '#' && s.dialect == dialectMySQL:
+			s.mode = scanLineComment
+		case c == '/' && i+1 < len(line) && line[i+1] == '*':
+			// MySQL's /*!40101 ... */ version comments hold code, but never
+			// the delimiter, so they can be skipped like other comments here
+			if i+2 < len(line) && line[i+2] == '!' {
+				s.hasCode = true
+			}
+			s.mode = scanBlockComment
+			s.commentDepth = 1
+			i++
+		case c == '\'':
+			s.hasCode = true
+			s.mode = scanSingleQuote
+			s.escapeString = i > 0 && (line[i-1] == 'E' || line[i-1] == 'e') && (i == 1 || !isWordByte(line[i-2]))
+		case c == '"':
+			s.hasCode = true
+			s.mode = scanDoubleQuote
+			s.escapeString = false
+		case c == '`' && s.dialect == dialectMySQL:
+			s.hasCode = true
+			s.mode = scanBacktick
+		case c == '$' && s.dialect == dialectPostgres:
+			s.hasCode = true
+			if tag, ok := dollarQuoteTag(line[i:]); ok && (i == 0 || !isWordByte(line[i-1])) {
+				s.mode = scanDollarQuote
+				s.dollarTag = tag
+				i += len(tag) - 1
+			}
+		default:
+			s.hasCode = true
+		}
+	}
+	return -1
+}
+
+func (s *dumpScanner) emit() *dumpStatement {
+	stmt := &dumpStatement{SQL: s.pending.String()}
+	s.pending.Reset()
+	s.hasCode = false
+	s.mode = scanNormal
+
+	if s.dialect == dialectPostgres && isCopyFromStdin(stmt.SQL) {
+		stmt.IsCopy = true
+		s.inCopy = true
+		// The rows start on the line after the statement, which keeps its
+		// line ending so that the statement can be written out as is
+		if strings.TrimSpace(s.line) == "" {
+			stmt.SQL += "\n"
+			s.line = ""
+		}
+	}
+	return stmt
+}
+
+func (s *dumpScanner) finish() (*dumpStatement, error) {
+	if !s.hasCode {
+		s.pending.Reset()
+		return nil, io.EOF
+	}
+	return s.emit(), nil
+}
+
+func isCopyFromStdin(sql string) bool {
+	lexer := newSQLLexer(sql, dialectPostgres)
+	first, ok := lexer.next()
+	if !ok || !first.isKeyword("COPY") {
+		return false
+	}
+	for {
+		tok, ok := lexer.next()
+		if !ok {
+			return false
+		}
+		if tok.isKeyword("FROM") {
+			next, ok := lexer.next()
+			return ok && next.isKeyword("stdin")
+		}
+	}
+}
+
+// dollarQuoteTag returns the opening $tag$ at the start of s
+func dollarQuoteTag(s string) (string, bool) {
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		if c == '$' {
+			return s[:i+1], true
+		}
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80 || i > 1 && c >= '0' && c <= '9') {
+			return "", false
+		}
+	}
+	return "", false
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c == '$' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c >= 0x80
+}
+
+type tokenKind int
+
+const (
+	tokenWord tokenKind = iota // keyword or unquoted identifier
+	tokenQuotedIdent
+	tokenString
+	tokenNumber
+	tokenPunct
+)
+
+// sqlToken is a token of a single statement
+type sqlToken struct {
+	Kind  tokenKind
+	Text  string // as written
+	Value string // identifier or string contents without quotes and escapes
+	Start int    // byte offsets in the statement
+	End   int
+}
+
+func (t sqlToken) isKeyword(keyword string) bool {
+	return t.Kind == tokenWord && strings.EqualFold(t.Text, keyword)
+}
+
+func (t sqlToken) isIdent() bool {
+	return t.Kind == tokenWord || t.Kind == tokenQuotedIdent
+}
+
+func (t sqlToken) isPunct(p string) bool {
+	return t.Kind == tokenPunct && t.Text == p
+}
+
+// sqlLexer tokenizes one statement, skipping comments. The contents of
+// MySQL's /*!NNNNN ... */ version comments are returned as regular tokens.
+type sqlLexer struct {
+	src          string
+	pos          int
+	dialect      sqlDialect
+	versionDepth int
+}
+
+func newSQLLexer(src string, dialect sqlDialect) *sqlLexer {
+	return &sqlLexer{src: src, dialect: dialect}
+}
+
+// tokenize returns all tokens of a statement
+func tokenize(src string, dialect sqlDialect) []sqlToken {
+	return tokenizeN(src, dialect, -1)
+}
+
+// tokenizeN returns at most max tokens of a statement, all of them if max < 0
+func tokenizeN(src string, dialect sqlDialect, max int) []sqlToken {
+	lexer := newSQLLexer(src, dialect)
+	var tokens []sqlToken
+	for max < 0 || len(tokens) < max {
+		tok, ok := lexer.next()
+		if !ok {
+			break
+		}
+		tokens = append(tokens, tok)
+	}
+	return tokens
+}
+
+func (l *sqlLexer) next() (sqlToken, bool) {
+	src := l.src
+	for l.pos < len(src) {
+		c := src[l.pos]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			l.pos++
+		case c == '-' && strings.HasPrefix(src[l.pos:], "--"), c == '#' && l.dialect == dialectMySQL:
+			if end := strings.IndexByte(src[l.pos:], '\n'); end >= 0 {
+				l.pos += end + 1
+			} else {
+				l.pos = len(src)
+			}
+		case c == '/' && strings.HasPrefix(src[l.pos:], "/*!") && l.dialect == dialectMySQL:
+			l.pos += 3
+			for l.pos < len(src) && src[l.pos] >= '0' && src[l.pos] <= '9' {
+				l.pos++
+			}
+			l.versionDepth++
+		case c == '*' && l.versionDepth > 0 && strings.HasPrefix(src[l.pos:], "*/"):
+			l.pos += 2
+			l.versionDepth--
+		case c == '/' && strings.HasPrefix(src[l.pos:], "/*"):
+			l.skipBlockComment()
+		default:
+			return l.token(), true
+		}
+	}
+	return sqlToken{}, false
+}
+
+func (l *sqlLexer) skipBlockComment() {
+	depth := 0
+	for l.pos < len(l.src) {
+		if strings.HasPrefix(l.src[l.pos:], "/*") {
+			depth++
+			l.pos += 2
+			continue
+		}
+		if strings.HasPrefix(l.src[l.pos:], "*/") {
+			depth--
+			l.pos += 2
+			if depth == 0 || l.dialect == dialectMySQL {
+				return
+			}
+			continue
+		}
+		l.pos++
+	}
+}
+
+func (l *sqlLexer) token() sqlToken {
+	src := l.src
+	start := l.pos
+	c := src[start]
+
+	switch {
+	case c == '\'':
+		value := l.quoted('\'', l.dialect == dialectMySQL)
+		return sqlToken{Kind: tokenString, Text: src[start:l.pos], Value: value, Start: start, End: l.pos}
+
+	case (c == 'E' || c == 'e') && l.dialect == dialectPostgres && start+1 < len(src) && src[start+1] == '\'':
+		l.pos++
+		value := l.quoted('\'', true)
+		return sqlToken{Kind: tokenString, Text: src[start:l.pos], Value: value, Start: start, End: l.pos}
+
+	case c == '"':
+		if l.dialect == dialectMySQL {
+			value := l.quoted('"', true)
+			return sqlToken{Kind: tokenString, Text: src[start:l.pos], Value: value, Start: start, End: l.pos}
+		}
+		value := l.quoted('"', false)
+		return sqlToken{Kind: tokenQuotedIdent, Text: src[start:l.pos], Value: value, Start: start, End: l.pos}
+
+	case c == '`' && l.dialect == dialectMySQL:
+		value := l.quoted('`', false)
+		return sqlToken{Kind: tokenQuotedIdent, Text: src[start:l.pos], Value: value, Start: start, End: l.pos}
+
+	case c == '$' && l.dialect == dialectPostgres:
+		if tag, ok := dollarQuoteTag(src[start:]); ok {
+			body := start + len(tag)
+			end := strings.Index(src[body:], tag)
+			if end < 0 {
+				l.pos = len(src)
+				return sqlToken{Kind: tokenString, Text: src[start:], Value: src[body:], Start: start, End: l.pos}
+			}
+			l.pos = body + end + len(tag)
+			return sqlToken{Kind: tokenString, Text: src[start:l.pos], Value: src[body : body+end], Start: start, End: l.pos}
+		}
+		l.pos++
+		for l.pos < len(src) && src[l.pos] >= '0' && src[l.pos] <= '9' {
+			l.pos++
+		}
+		return sqlToken{Kind: tokenPunct, Text: src[start:l.pos], Start: start, End: l.pos}
+
+	case c >= '0' && c <= '9':
+		for l.pos < len(src) && (isWordByte(src[l.pos]) || src[l.pos] == '.') {
+			l.pos++
+		}
+		return sqlToken{Kind: tokenNumber, Text: src[start:l.pos], Value: src[start:l.pos], Start: start, End: l.pos}
+
+	case isWordByte(c):
+		for l.pos < len(src) && isWordByte(src[l.pos]) {
+			l.pos++
+		}
+		return sqlToken{Kind: tokenWord, Text: src[start:l.pos], Value: src[start:l.pos], Start: start, End: l.pos}
+
+	case c == ':' && strings.HasPrefix(src[start:], "::"):
+		l.pos += 2
+		return sqlToken{Kind: tokenPunct, Text: "::", Start: start, End: l.pos}
+	}
+
+	l.pos++
+	return sqlToken{Kind: tokenPunct, Text: src[start:l.pos], Start: start, End: l.pos}
+}
+
+// quoted reads a quoted string or identifier starting at the opening quote
+// and returns its unescaped contents
+func (l *sqlLexer) quoted(quote byte, backslashEscapes bool) string {
+	src := l.src
+	var value strings.Builder
+	l.pos++
+	for l.pos < len(src) {
+		c := src[l.pos]
+		if c == '\\' && backslashEscapes && l.pos+1 < len(src) {
+			value.WriteByte(unescapeByte(src[l.pos+1]))
+			l.pos += 2
+			continue
+		}
+		if c == quote {
+			if l.pos+1 < len(src) && src[l.pos+1] == quote {
+				value.WriteByte(quote)
+				l.pos += 2
+				continue
+			}
+			l.pos++
+			return value.String()
+		}
+		value.WriteByte(c)
+		l.pos++
+	}
+	return value.String()
+}
+
+func unescapeByte(c byte) byte {
+	switch c {
+	case 'n':
+		return '\n'
+	case 'r':
+		return '\r'
+	case 't':
+		return '\t'
+	case '0':
+		return 0
+	case 'Z':
+		return 26
+	default:
+		return c
+	}
+}
+
+// qualifiedName is a possibly schema-qualified object name
+type qualifiedName struct {
+	Schema string
+	Name   string
+}
+
+func (n qualifiedName) String() string {
+	if n.Schema == "" {
+		return n.Name
+	}
+	return n.Schema + "." + n.Name
+}
+
+// parseQualifiedName splits "schema.name", honouring quoted parts
+func parseQualifiedName(text string, dialect sqlDialect) qualifiedName {
+	tokens := tokenize(text, dialect)
+	var parts []string
+	for _, tok := range tokens {
+		if tok.isIdent() {
+			parts = append(parts, tok.Value)
+		}
+	}
+	switch len(parts) {
+	case 0:
+		return qualifiedName{}
+	case 1:
+		return qualifiedName{Name: parts[0]}
+	default:
+		return qualifiedName{Schema: parts[len(parts)-2], Name: parts[len(parts)-1]}
+	}
+}
+
+// readName reads a dotted name starting at tokens[i] and returns its parts,
+// the token index range it spans and the index of the following token
+func readName(tokens []sqlToken, i int) (parts []string, first, last, next int) {
+	first = i
+	for i < len(tokens) && tokens[i].isIdent() {
+		parts = append(parts, tokens[i].Value)
+		last = i
+		if i+2 < len(tokens) && tokens[i+1].isPunct(".") && tokens[i+2].isIdent() {
+			i += 2
+			continue
+		}
+		i++
+		break
+	}
+	return parts, first, last, i
+}
+
+// Statement kinds recognised by classifyStatement
+const (
+	stmtOther           = "other"
+	stmtSet             = "set"
+	stmtTransaction     = "transaction"
+	stmtCreateTable     = "create_table"
+	stmtAlterTable      = "alter_table"
+	stmtDropTable       = "drop_table"
+	stmtCreateIndex     = "create_index"
+	stmtCreateView      = "create_view"
+	stmtCreateMatView   = "create_materialized_view"
+	stmtCreateSequence  = "create_sequence"
+	stmtAlterSequence   = "alter_sequence"
+	stmtSetval          = "setval"
+	stmtCreateFunction  = "create_function"
+	stmtCreateProcedure = "create_procedure"
+	stmtCreateTrigger   = "create_trigger"
+	stmtCreateOther     = "create_other"
+	stmtCopy            = "copy"
+	stmtInsert          = "insert"
+	stmtLockTables      = "lock_tables"
+	stmtUnlockTables    = "unlock_tables"
+	stmtComment         = "comment"
+	stmtGrant           = "grant"
+	stmtDropOther       = "drop_other"
+)
+
+// statementInfo describes what a dump statement does and to which object
+type statementInfo struct {
+	Kind   string
+	Object qualifiedName
+	// ObjectStart and ObjectEnd are the byte offsets of Object in the
+	// statement, -1 when it does not name one
+	ObjectStart int
+	ObjectEnd   int
+	// Name is the index, trigger or policy created by the statement
+	Name string
+	// OwnedBy is the table owning a sequence, from ALTER SEQUENCE ... OWNED BY
+	OwnedBy qualifiedName
+}
+
+// classifyTokenLimit bounds the tokens looked at, so that large INSERT
+// statements are not tokenized in full
+const classifyTokenLimit = 64
+
+func nameFromParts(parts []string) qualifiedName {
+	switch len(parts) {
+	case 0:
+		return qualifiedName{}
+	case 1:
+		return qualifiedName{Name: parts[0]}
+	default:
+		return qualifiedName{Schema: parts[len(parts)-2], Name: parts[len(parts)-1]}
+	}
+}
+
+// classifyStatement recognises the statements pg_dump and mysqldump write
+// for tables and their dependent objects
+func classifyStatement(sql string, dialect sqlDialect) statementInfo {
+	tokens := tokenizeN(sql, dialect, classifyTokenLimit)
+	info := statementInfo{Kind: stmtOther, ObjectStart: -1, ObjectEnd: -1}
+	if len(tokens) == 0 {
+		return info
+	}
+
+	// setObject reads the object name at tokens[i]
+	setObject := func(kind string, i int) int {
+		info.Kind = kind
+		parts, first, last, next := readName(tokens, i)
+		if len(parts) > 0 {
+			info.Object = nameFromParts(parts)
+			info.ObjectStart, info.ObjectEnd = tokens[first].Start, tokens[last].End
+		}
+		return next
+	}
+	skip := func(i int, keywords ...string) int {
+		for i < len(tokens) {
+			matched := false
+			for _, kw := range keywords {
+				if tokens[i].isKeyword(kw) {
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return i
+			}
+			i++
+		}
+		return i
+	}
+	findKeyword := func(from int, keyword string) int {
+		for i := from; i < len(tokens); i++ {
+			if tokens[i].isKeyword(keyword) {
+				return i
+			}
+		}
+		return -1
+	}
+
+	first := tokens[0]
+	switch {
+	case first.isKeyword("SET"):
+		info.Kind = stmtSet
+
+	case first.isKeyword("BEGIN"), first.isKeyword("COMMIT"), first.isKeyword("START"):
+		info.Kind = stmtTransaction
+
+	case first.isKeyword("SELECT"):
+		i := 1
+		if i+1 < len(tokens) && tokens[i].isKeyword("pg_catalog") && tokens[i+1].isPunct(".") {
+			i += 2
+		}
+		if i < len(tokens) && tokens[i].isKeyword("set_config") {
+			info.Kind = stmtSet
+		} else if i+2 < len(tokens) && tokens[i].isKeyword("setval") && tokens[i+1].isPunct("(") && tokens[i+2].Kind == tokenString {
+			info.Kind = stmtSetval
+			info.Object = parseQualifiedName(tokens[i+2].Value, dialect)
+		}
+
+	case first.isKeyword("COPY"):
+		setObject(stmtCopy, 1)
+
+	case first.isKeyword("INSERT"), first.isKeyword("REPLACE"):
+		i := skip(1, "IGNORE", "LOW_PRIORITY", "DELAYED", "HIGH_PRIORITY", "INTO")
+		setObject(stmtInsert, i)
+
+	case first.isKeyword("LOCK"):
+		setObject(stmtLockTables, skip(1, "TABLES", "TABLE", "ONLY"))
+
+	case first.isKeyword("UNLOCK"):
+		info.Kind = stmtUnlockTables
+
+	case first.isKeyword("ALTER") && len(tokens) > 1:
+		switch {
+		case tokens[1].isKeyword("TABLE"):
+			setObject(stmtAlterTable, skip(2, "IF", "EXISTS", "ONLY"))
+		case tokens[1].isKeyword("SEQUENCE"):
+			next := setObject(stmtAlterSequence, skip(2, "IF", "EXISTS"))
+			if owned := findKeyword(next, "OWNED"); owned >= 0 && owned+2 < len(tokens) && tokens[owned+1].isKeyword("BY") {
+				parts, _, _, _ := readName(tokens, owned+2)
+				if len(parts) >= 2 {
+					info.OwnedBy = nameFromParts(parts[:len(parts)-1])
+				}
+			}
+		}
+
+	case first.isKeyword("DROP") && len(tokens) > 1:
+		if tokens[1].isKeyword("TABLE") {
+			setObject(stmtDropTable, skip(2, "IF", "EXISTS"))
+		} else {
+			setObject(stmtDropOther, skip(2, "IF", "EXISTS"))
+		}
+
+	case first.isKeyword("CREATE"):
+		classifyCreate(&info, tokens, setObject, skip, findKeyword)
+
+	case first.isKeyword("COMMENT") && len(tokens) > 2 && tokens[1].isKeyword("ON"):
+		info.Kind = stmtComment
+		i := 3
+		if tokens[2].isKeyword("MATERIALIZED") || tokens[2].isKeyword("FOREIGN") {
+			i = 4
+		}
+		switch {
+		case tokens[2].isKeyword("COLUMN"):
+			parts, first, last, _ := readName(tokens, i)
+			if len(parts) >= 2 {
+				info.Object = nameFromParts(parts[:len(parts)-1])
+				info.ObjectStart, info.ObjectEnd = tokens[first].Start, tokens[last-2].End
+			}
+		case tokens[2].isKeyword("CONSTRAINT"), tokens[2].isKeyword("TRIGGER"), tokens[2].isKeyword("POLICY"), tokens[2].isKeyword("RULE"):
+			if on := findKeyword(i, "ON"); on >= 0 {
+				setObject(stmtComment, on+1)
+			}
+		default:
+			setObject(stmtComment, i)
+		}
+
+	case first.isKeyword("GRANT"), first.isKeyword("REVOKE"):
+		info.Kind = stmtGrant
+		if on := findKeyword(1, "ON"); on >= 0 {
+			i := skip(on+1, "TABLE", "SEQUENCE")
+			if i < len(tokens) && !tokens[i].isKeyword("ALL") && !tokens[i].isKeyword("SCHEMA") &&
+				!tokens[i].isKeyword("FUNCTION") && !tokens[i].isKeyword("DATABASE") {
+				setObject(stmtGrant, i)
+			}
+		}
+	}
+
+	return info
+}
+
+func classifyCreate(info *statementInfo, tokens []sqlToken,
+	setObject func(kind string, i int) int,
+	skip func(i int, keywords ...string) int,
+	findKeyword func(from int, keyword string) int) {
+
+	// Modifiers such as OR REPLACE, UNIQUE, UNLOGGED or MySQL's DEFINER=...
+	// come before the object type
+	materialized := false
+	for i := 1; i < len(tokens) && i < 40; i++ {
+		tok := tokens[i]
+		if tok.Kind != tokenWord {
+			continue
+		}
+		switch strings.ToUpper(tok.Text) {
+		case "MATERIALIZED":
+			materialized = true
+		case "TABLE":
+			setObject(stmtCreateTable, skip(i+1, "IF", "NOT", "EXISTS"))
+			return
+		case "INDEX":
+			j := skip(i+1, "CONCURRENTLY", "IF", "NOT", "EXISTS")
+			if j < len(tokens) && !tokens[j].isKeyword("ON") {
+				info.Name = tokens[j].Value
+			}
+			if on := findKeyword(j, "ON"); on >= 0 {
+				setObject(stmtCreateIndex, skip(on+1, "ONLY"))
+			}
+			info.Kind = stmtCreateIndex
+			return
+		case "VIEW":
+			if materialized {
+				setObject(stmtCreateMatView, skip(i+1, "IF", "NOT", "EXISTS"))
+			} else {
+				setObject(stmtCreateView, skip(i+1, "IF", "NOT", "EXISTS"))
+			}
+			return
+		case "SEQUENCE":
+			setObject(stmtCreateSequence, skip(i+1, "IF", "NOT", "EXISTS"))
+			return
+		case "FUNCTION":
+			setObject(stmtCreateFunction, skip(i+1, "IF", "NOT", "EXISTS"))
+			return
+		case "PROCEDURE":
+			setObject(stmtCreateProcedure, skip(i+1, "IF", "NOT", "EXISTS"))
+			return
+		case "TRIGGER", "POLICY":
+			if i+1 < len(tokens) {
+				info.Name = tokens[i+1].Value
+			}
+			info.Kind = stmtCreateTrigger
+			if strings.EqualFold(tok.Text, "POLICY") {
+				info.Kind = stmtCreateOther
+			}
+			if on := findKeyword(i+2, "ON"); on >= 0 {
+				setObject(info.Kind, on+1)
+			}
+			return
+		case "RULE":
+			if to := findKeyword(i+1, "TO"); to >= 0 {
+				setObject(stmtCreateOther, to+1)
+			}
+			info.Kind = stmtCreateOther
+			return
+		case "SCHEMA", "TYPE", "DOMAIN", "EXTENSION", "DATABASE", "EVENT", "AGGREGATE", "COLLATION", "SERVER":
+			setObject(stmtCreateOther, skip(i+1, "IF", "NOT", "EXISTS"))
+			return
+		}
+	}
+	info.Kind = stmtCreateOther
+}
@@ -0,0 +1,154 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+
+	"github.com/dendianugerah/velld/internal/connection"
+)
+
+// dumpSQLReader streams the text of a backup file. Compressed files are
+// decompressed and custom format PostgreSQL dumps are converted to SQL by
+// pg_restore on the fly.
+type dumpSQLReader struct {
+	src    io.Reader
+	file   *os.File
+	cmd    *exec.Cmd
+	stderr bytes.Buffer
+	eof    bool
+
+	// dbType is the type of the connection the backup was taken from, empty
+	// when that connection no longer exists
+	dbType string
+	// seekable is true when the text is the file itself, so that byte
+	// offsets can be used to seek in it
+	seekable bool
+}
+
+// openDumpText opens a backup for reading its text
+func (s *BackupService) openDumpText(backup *Backup) (*dumpSQLReader, error) {
+	var conn *connection.StoredConnection
+	if stored, err := s.connStorage.GetConnection(backup.ConnectionID); err == nil {
+		conn = stored
+	}
+
+	file, err := os.Open(backup.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open backup %s: %v", backup.ID, err)
+	}
+
+	r := &dumpSQLReader{src: file, file: file, seekable: true}
+	if conn != nil {
+		r.dbType = conn.Type
+	}
+
+	if isPgCustomDump(backup.Path) {
+		if conn == nil {
+			file.Close()
+			return nil, fmt.Errorf("the connection of backup %s no longer exists, its custom format dump cannot be read", backup.ID)
+		}
+		cmd := s.createPgExtractCmd(conn)
+		if cmd == nil {
+			file.Close()
+			return nil, fmt.Errorf("%s is required to read custom format dumps", pgRestoreTool)
+		}
+		cmd.Stdin = file
+		cmd.Stderr = &r.stderr
+		stdout, err := cmd.StdoutPipe()
+		if err == nil {
+			err = cmd.Start()
+		}
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to start %s: %v", pgRestoreTool, err)
+		}
+		r.src = stdout
+		r.cmd = cmd
+		r.seekable = false
+		return r, nil
+	}
+
+	buffered := bufio.NewReader(file)
+	header, _ := buffered.Peek(3)
+	switch {
+	case len(header) >= 2 && header[0] == 0x1f && header[1] == 0x8b:
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to read gzip backup %s: %v", backup.ID, err)
+		}
+		r.src = gz
+		r.seekable = false
+	case bytes.Equal(header, []byte("BZh")):
+		r.src = bzip2.NewReader(buffered)
+		r.seekable = false
+	default:
+		r.src = buffered
+	}
+
+	return r, nil
+}
+
+// openDumpSQL opens a backup for parsing as SQL. The dialect follows the
+// type of the connection the backup was taken from.
+func (s *BackupService) openDumpSQL(backup *Backup) (*dumpSQLReader, sqlDialect, error) {
+	r, err := s.openDumpText(backup)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if r.dbType == "" {
+		r.Close()
+		return nil, 0, fmt.Errorf("the connection of backup %s no longer exists", backup.ID)
+	}
+	dialect, ok := dialectFor(r.dbType)
+	if !ok {
+		r.Close()
+		return nil, 0, fmt.Errorf("%s backups are not SQL dumps", r.dbType)
+	}
+	return r, dialect, nil
+}
+
+func (r *dumpSQLReader) Read(p []byte) (int, error) {
+	n, err := r.src.Read(p)
+	if err == io.EOF {
+		r.eof = true
+	}
+	return n, err
+}
+
+// seekTo moves to a byte offset of a seekable dump
+func (r *dumpSQLReader) seekTo(offset int64) error {
+	if !r.seekable {
+		return fmt.Errorf("backup is not seekable")
+	}
+	if _, err := r.file.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	r.src = bufio.NewReader(r.file)
+	r.eof = false
+	return nil
+}
+
+// Close stops the conversion if the dump was not read to the end. A failed
+// conversion is only reported when the whole output was read, since it may
+// otherwise have been cut short on purpose.
+func (r *dumpSQLReader) Close() error {
+	var err error
+	if r.cmd != nil {
+		if !r.eof {
+			r.cmd.Process.Kill()
+		}
+		if waitErr := r.cmd.Wait(); waitErr != nil && r.eof {
+			err = fmt.Errorf("%s failed: %v: %s", pgRestoreTool, waitErr, bytes.TrimSpace(r.stderr.Bytes()))
+		}
+	}
+	r.file.Close()
+	return err
+}
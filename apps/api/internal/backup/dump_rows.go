@@ -0,0 +1,224 @@
+package backup
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// dataValue is a column value of a dump row
+type dataValue struct {
+	Text string
+	Null bool
+}
+
+// dumpRowVisitor receives the rows of a dump. columns is nil when an INSERT
+// statement does not list its columns.
+type dumpRowVisitor func(table qualifiedName, columns []string, values []dataValue) error
+
+// scanDumpRows reads the rows of the COPY blocks and INSERT statements of a
+// plain SQL dump, one row at a time
+func scanDumpRows(r io.Reader, dialect sqlDialect, visit dumpRowVisitor) error {
+	scanner := newDumpScanner(r, dialect)
+	for {
+		stmt, err := scanner.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read dump: %w", err)
+		}
+		if stmt.IsMeta {
+			continue
+		}
+
+		info := classifyStatement(stmt.SQL, dialect)
+		switch info.Kind {
+		case stmtCopy:
+			columns := copyColumns(stmt.SQL, dialect)
+			for {
+				row, ok, err := scanner.CopyRow()
+				if err != nil {
+					return fmt.Errorf("failed to read COPY data: %w", err)
+				}
+				if !ok {
+					break
+				}
+				if err := visit(info.Object, columns, parseCopyRow(row)); err != nil {
+					return err
+				}
+			}
+		case stmtInsert:
+			if err := parseInsertRows(stmt.SQL, dialect, func(columns []string, values []dataValue) error {
+				return visit(info.Object, columns, values)
+			}); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// copyColumns returns the column list of a COPY ... FROM stdin statement
+func copyColumns(sql string, dialect sqlDialect) []string {
+	tokens := tokenizeN(sql, dialect, classifyTokenLimit)
+	for i, tok := range tokens {
+		if tok.isKeyword("FROM") {
+			tokens = tokens[:i]
+			break
+		}
+	}
+	return columnList(tokens)
+}
+
+// parseCopyRow splits a row of COPY's text format into its values
+func parseCopyRow(row string) []dataValue {
+	fields := strings.Split(row, "\t")
+	values := make([]dataValue, len(fields))
+	for i, field := range fields {
+		if field == `\N` {
+			values[i] = dataValue{Null: true}
+			continue
+		}
+		values[i] = dataValue{Text: unescapeCopyField(field)}
+	}
+	return values
+}
+
+// unescapeCopyField decodes the backslash escapes of COPY's text format
+func unescapeCopyField(field string) string {
+	if !strings.Contains(field, `\`) {
+		return field
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(field); i++ {
+		c := field[i]
+		if c != '\\' || i+1 == len(field) {
+			b.WriteByte(c)
+			continue
+		}
+		i++
+		switch c = field[i]; c {
+		case 'b':
+			b.WriteByte('\b')
+		case 'f':
+			b.WriteByte('\f')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case 'v':
+			b.WriteByte('\v')
+		case 'x':
+			end := i + 1
+			for end < len(field) && end < i+3 && isHexDigit(field[end]) {
+				end++
+			}
+			if n, err := strconv.ParseUint(field[i+1:end], 16, 8); err == nil {
+				b.WriteByte(byte(n))
+				i = end - 1
+			} else {
+				b.WriteByte('x')
+			}
+		default:
+			if c >= '0' && c <= '7' {
+				end := i
+				for end < len(field) && end < i+3 && field[end] >= '0' && field[end] <= '7' {
+					end++
+				}
+				n, _ := strconv.ParseUint(field[i:end], 8, 8)
+				b.WriteByte(byte(n))
+				i = end - 1
+				continue
+			}
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+func isHexDigit(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
+
+// parseInsertRows reads the rows of an INSERT ... VALUES statement. Values
+// that are not plain literals are kept as written.
+func parseInsertRows(sql string, dialect sqlDialect, visit func(columns []string, values []dataValue) error) error {
+	lexer := newSQLLexer(sql, dialect)
+	var head []sqlToken
+	for {
+		tok, ok := lexer.next()
+		if !ok {
+			return nil
+		}
+		if tok.isKeyword("SELECT") {
+			return nil
+		}
+		if tok.isKeyword("VALUES") {
+			break
+		}
+		head = append(head, tok)
+	}
+	columns := columnList(head)
+
+	var row []sqlToken
+	depth := 0
+	for {
+		tok, ok := lexer.next()
+		if !ok {
+			return nil
+		}
+		switch {
+		case tok.isPunct("("):
+			depth++
+			if depth == 1 {
+				row = row[:0]
+				continue
+			}
+		case tok.isPunct(")"):
+			depth--
+			if depth == 0 {
+				if err := visit(columns, rowValues(row)); err != nil {
+					return err
+				}
+				continue
+			}
+		case depth == 0:
+			// Rows are separated by commas; anything else, such as ON
+			// DUPLICATE KEY UPDATE or the delimiter, ends them
+			if tok.isPunct(",") {
+				continue
+			}
+			return nil
+		}
+		row = append(row, tok)
+	}
+}
+
+// rowValues converts the tokens of a VALUES row into its values
+func rowValues(tokens []sqlToken) []dataValue {
+	parts := splitTopLevel(tokens)
+	values := make([]dataValue, len(parts))
+	for i, part := range parts {
+		// Drop MySQL character set introducers such as _binary or _utf8mb4
+		if len(part) == 2 && part[0].Kind == tokenWord && strings.HasPrefix(part[0].Text, "_") && part[1].Kind == tokenString {
+			part = part[1:]
+		}
+		switch {
+		case len(part) == 1 && part[0].isKeyword("NULL"):
+			values[i] = dataValue{Null: true}
+		case len(part) == 1 && part[0].Kind == tokenString:
+			values[i] = dataValue{Text: part[0].Value}
+		default:
+			var b strings.Builder
+			for _, tok := range part {
+				b.WriteString(tok.Text)
+			}
+			values[i] = dataValue{Text: b.String()}
+		}
+	}
+	return values
+}
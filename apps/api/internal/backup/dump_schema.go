@@ -0,0 +1,565 @@
+package backup
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// SchemaColumn is a table column as declared in a dump
+type SchemaColumn struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Nullable bool   `json:"nullable"`
+	Default  string `json:"default,omitempty"`
+	// Extra holds the remaining options, such as AUTO_INCREMENT, COLLATE or
+	// an identity clause
+	Extra string `json:"extra,omitempty"`
+}
+
+func (c SchemaColumn) equal(other SchemaColumn) bool {
+	return c.Type == other.Type && c.Nullable == other.Nullable && c.Default == other.Default && c.Extra == other.Extra
+}
+
+// schemaTable is a table of a dump with the rows found for it
+type schemaTable struct {
+	Name       qualifiedName
+	Columns    []SchemaColumn
+	PrimaryKey []string
+	Rows       int64
+	HasData    bool
+}
+
+func (t *schemaTable) column(name string) *SchemaColumn {
+	for i := range t.Columns {
+		if t.Columns[i].Name == name {
+			return &t.Columns[i]
+		}
+	}
+	return nil
+}
+
+// schemaObject is an index, constraint, function or view
+type schemaObject struct {
+	Name       string
+	Table      string
+	Definition string
+}
+
+// dumpSchema is the structure of a database as described by its dump
+type dumpSchema struct {
+	dialect     sqlDialect
+	tables      map[string]*schemaTable
+	indexes     map[string]schemaObject
+	constraints map[string]schemaObject
+	functions   map[string]schemaObject
+	views       map[string]schemaObject
+}
+
+func newDumpSchema(dialect sqlDialect) *dumpSchema {
+	return &dumpSchema{
+		dialect:     dialect,
+		tables:      make(map[string]*schemaTable),
+		indexes:     make(map[string]schemaObject),
+		constraints: make(map[string]schemaObject),
+		functions:   make(map[string]schemaObject),
+		views:       make(map[string]schemaObject),
+	}
+}
+
+// parseDumpSchema reads a plain SQL dump and collects its schema objects and
+// the number of rows of every table. Only one statement is held in memory at
+// a time and COPY rows are counted without being kept.
+func parseDumpSchema(r io.Reader, dialect sqlDialect) (*dumpSchema, error) {
+	schema := newDumpSchema(dialect)
+	scanner := newDumpScanner(r, dialect)
+
+	for {
+		stmt, err := scanner.Next()
+		if err == io.EOF {
+			return schema, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read dump: %w", err)
+		}
+		if stmt.IsMeta {
+			continue
+		}
+
+		info := classifyStatement(stmt.SQL, dialect)
+		switch info.Kind {
+		case stmtCreateTable:
+			schema.createTable(stmt.SQL, info)
+		case stmtDropTable:
+			// mysqldump creates placeholder tables for views and drops them
+			// again before creating the view
+			delete(schema.tables, info.Object.String())
+		case stmtAlterTable:
+			schema.alterTable(stmt.SQL, info)
+		case stmtCreateIndex:
+			schema.createIndex(stmt.SQL, info)
+		case stmtCreateView, stmtCreateMatView:
+			name := info.Object.String()
+			schema.views[name] = schemaObject{Name: name, Definition: normalizeSQL(stmt.SQL, dialect)}
+		case stmtCreateFunction, stmtCreateProcedure:
+			schema.createFunction(stmt.SQL, info)
+		case stmtCopy:
+			table := schema.table(info.Object)
+			table.HasData = true
+			for {
+				_, ok, err := scanner.CopyRow()
+				if err != nil {
+					return nil, fmt.Errorf("failed to read COPY data: %w", err)
+				}
+				if !ok {
+					break
+				}
+				table.Rows++
+			}
+		case stmtInsert:
+			table := schema.table(info.Object)
+			table.HasData = true
+			table.Rows += countInsertRows(stmt.SQL, dialect)
+		}
+	}
+}
+
+// table returns the table called name, adding it when a dump has data for a
+// table it did not create
+func (d *dumpSchema) table(name qualifiedName) *schemaTable {
+	key := name.String()
+	table, ok := d.tables[key]
+	if !ok {
+		table = &schemaTable{Name: name}
+		d.tables[key] = table
+	}
+	return table
+}
+
+// tableNames returns the table names in sorted order
+func (d *dumpSchema) tableNames() []string {
+	names := make([]string, 0, len(d.tables))
+	for name := range d.tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// columnOptionKeywords end the type of a column definition
+var columnOptionKeywords = map[string]bool{
+	"NOT": true, "NULL": true, "DEFAULT": true, "PRIMARY": true, "UNIQUE": true,
+	"REFERENCES": true, "CHECK": true, "CONSTRAINT": true, "COLLATE": true,
+	"GENERATED": true, "AUTO_INCREMENT": true, "COMMENT": true, "ON": true,
+	"AS": true, "CHARACTER": true, "STORAGE": true, "COLUMN_FORMAT": true,
+	"INVISIBLE": true, "VISIBLE": true, "SRID": true, "KEY": true,
+}
+
+func (d *dumpSchema) createTable(sql string, info statementInfo) {
+	name := info.Object
+	table := &schemaTable{Name: name}
+	if existing, ok := d.tables[name.String()]; ok {
+		table.Rows, table.HasData = existing.Rows, existing.HasData
+	}
+	d.tables[name.String()] = table
+
+	tokens := tokenize(sql, d.dialect)
+	open := -1
+	for i, tok := range tokens {
+		if tok.End > info.ObjectEnd && tok.isPunct("(") {
+			open = i
+			break
+		}
+	}
+	if open < 0 {
+		// CREATE TABLE ... AS or PARTITION OF
+		return
+	}
+
+	for _, element := range splitList(tokens, open) {
+		if len(element) == 0 {
+			continue
+		}
+		first := strings.ToUpper(element[0].Text)
+		switch {
+		case element[0].Kind == tokenWord && (first == "CONSTRAINT" || first == "PRIMARY" || first == "FOREIGN" ||
+			first == "CHECK" || first == "EXCLUDE" || first == "UNIQUE"):
+			if first == "UNIQUE" && d.dialect == dialectMySQL {
+				d.addTableIndex(table, element)
+			} else {
+				d.addConstraint(table, element)
+			}
+		case element[0].Kind == tokenWord && (first == "KEY" || first == "INDEX" || first == "FULLTEXT" || first == "SPATIAL"):
+			d.addTableIndex(table, element)
+		case element[0].Kind == tokenWord && first == "LIKE":
+			continue
+		default:
+			column := d.parseColumn(element)
+			table.Columns = append(table.Columns, column)
+			if containsKeywords(element, "PRIMARY", "KEY") {
+				table.PrimaryKey = []string{column.Name}
+			}
+		}
+	}
+}
+
+// parseColumn reads a column definition such as "id integer NOT NULL"
+func (d *dumpSchema) parseColumn(element []sqlToken) SchemaColumn {
+	column := SchemaColumn{Name: element[0].Value, Nullable: true}
+
+	i := 1
+	typeStart := i
+	for depth := 0; i < len(element); i++ {
+		tok := element[i]
+		if depth == 0 && tok.Kind == tokenWord && columnOptionKeywords[strings.ToUpper(tok.Text)] {
+			break
+		}
+		if tok.isPunct("(") {
+			depth++
+		} else if tok.isPunct(")") {
+			depth--
+		}
+	}
+	column.Type = joinTokens(element[typeStart:i])
+
+	var extra []sqlToken
+	for i < len(element) {
+		tok := element[i]
+		switch {
+		case tok.isKeyword("NOT") && i+1 < len(element) && element[i+1].isKeyword("NULL"):
+			column.Nullable = false
+			i += 2
+		case tok.isKeyword("NULL"):
+			i++
+		case tok.isKeyword("PRIMARY") && i+1 < len(element) && element[i+1].isKeyword("KEY"):
+			column.Nullable = false
+			i += 2
+		case tok.isKeyword("DEFAULT"):
+			end := optionEnd(element, i+1)
+			column.Default = joinTokens(element[i+1 : end])
+			i = end
+		default:
+			extra = append(extra, tok)
+			i++
+		}
+	}
+	column.Extra = joinTokens(extra)
+	return column
+}
+
+// optionEnd returns the index of the next column option keyword at the top
+// level, starting from i
+func optionEnd(tokens []sqlToken, i int) int {
+	for depth := 0; i < len(tokens); i++ {
+		tok := tokens[i]
+		if depth == 0 && tok.Kind == tokenWord && columnOptionKeywords[strings.ToUpper(tok.Text)] && !tok.isKeyword("NULL") {
+			return i
+		}
+		if tok.isPunct("(") {
+			depth++
+		} else if tok.isPunct(")") {
+			depth--
+		}
+	}
+	return i
+}
+
+// addConstraint records a table constraint, e.g. "CONSTRAINT orders_pkey
+// PRIMARY KEY (id)" or an unnamed "PRIMARY KEY (`id`)"
+func (d *dumpSchema) addConstraint(table *schemaTable, element []sqlToken) {
+	name := ""
+	body := element
+	if element[0].isKeyword("CONSTRAINT") && len(element) > 2 {
+		name = element[1].Value
+		body = element[2:]
+	}
+
+	if body[0].isKeyword("PRIMARY") {
+		table.PrimaryKey = columnList(body)
+		if name == "" {
+			name = "PRIMARY"
+		}
+	}
+	if name == "" {
+		name = joinTokens(body)
+	}
+
+	tableName := table.Name.String()
+	d.constraints[tableName+"/"+name] = schemaObject{Name: name, Table: tableName, Definition: joinTokens(body)}
+}
+
+// addTableIndex records an index declared inside CREATE TABLE, as MySQL does
+func (d *dumpSchema) addTableIndex(table *schemaTable, element []sqlToken) {
+	name := ""
+	for i, tok := range element {
+		if (tok.isKeyword("KEY") || tok.isKeyword("INDEX")) && i+1 < len(element) && element[i+1].isIdent() {
+			name = element[i+1].Value
+			break
+		}
+	}
+	if name == "" {
+		name = joinTokens(element)
+	}
+
+	tableName := table.Name.String()
+	d.indexes[tableName+"/"+name] = schemaObject{Name: name, Table: tableName, Definition: joinTokens(element)}
+}
+
+// alterTable applies the ALTER TABLE statements pg_dump writes for
+// constraints, defaults and identity columns
+func (d *dumpSchema) alterTable(sql string, info statementInfo) {
+	table, ok := d.tables[info.Object.String()]
+	if !ok {
+		return
+	}
+
+	tokens := tokenize(sql, d.dialect)
+	start := len(tokens)
+	for i, tok := range tokens {
+		if tok.Start >= info.ObjectEnd {
+			start = i
+			break
+		}
+	}
+
+	for _, action := range splitTopLevel(tokens[start:]) {
+		if len(action) < 2 {
+			continue
+		}
+		switch {
+		case action[0].isKeyword("ADD") && (action[1].isKeyword("CONSTRAINT") || action[1].isKeyword("PRIMARY") ||
+			action[1].isKeyword("UNIQUE") || action[1].isKeyword("FOREIGN") || action[1].isKeyword("CHECK")):
+			d.addConstraint(table, action[1:])
+		case action[0].isKeyword("ADD") && (action[1].isKeyword("KEY") || action[1].isKeyword("INDEX")):
+			d.addTableIndex(table, action[1:])
+		case action[0].isKeyword("ADD"):
+			element := action[1:]
+			if element[0].isKeyword("COLUMN") {
+				element = element[1:]
+			}
+			if len(element) > 0 {
+				table.Columns = append(table.Columns, d.parseColumn(element))
+			}
+		case action[0].isKeyword("ALTER"):
+			d.alterColumn(table, action[1:])
+		}
+	}
+}
+
+func (d *dumpSchema) alterColumn(table *schemaTable, action []sqlToken) {
+	if len(action) > 0 && action[0].isKeyword("COLUMN") {
+		action = action[1:]
+	}
+	if len(action) < 2 {
+		return
+	}
+	column := table.column(action[0].Value)
+	if column == nil {
+		return
+	}
+
+	rest := action[1:]
+	switch {
+	case rest[0].isKeyword("SET") && len(rest) > 1 && rest[1].isKeyword("DEFAULT"):
+		column.Default = joinTokens(rest[2:])
+	case rest[0].isKeyword("SET") && len(rest) > 2 && rest[1].isKeyword("NOT") && rest[2].isKeyword("NULL"):
+		column.Nullable = false
+	case rest[0].isKeyword("ADD") && len(rest) > 1 && rest[1].isKeyword("GENERATED"):
+		// The options of the identity sequence follow in parentheses
+		end := len(rest)
+		for i, tok := range rest {
+			if tok.isPunct("(") {
+				end = i
+				break
+			}
+		}
+		column.Extra = strings.TrimSpace(column.Extra + " " + joinTokens(rest[1:end]))
+	}
+}
+
+func (d *dumpSchema) createIndex(sql string, info statementInfo) {
+	name := info.Name
+	if info.Object.Schema != "" {
+		name = info.Object.Schema + "." + name
+	}
+	table := info.Object.String()
+	d.indexes[table+"/"+name] = schemaObject{Name: name, Table: table, Definition: normalizeSQL(sql, d.dialect)}
+}
+
+// createFunction records a function or procedure under its name and argument
+// types, since PostgreSQL allows overloading
+func (d *dumpSchema) createFunction(sql string, info statementInfo) {
+	tokens := tokenize(sql, d.dialect)
+	signature := info.Object.String()
+	for i, tok := range tokens {
+		if tok.Start >= info.ObjectEnd && tok.isPunct("(") {
+			signature += "(" + joinTokens(closingParen(tokens, i)) + ")"
+			break
+		}
+	}
+	d.functions[signature] = schemaObject{Name: signature, Definition: normalizeSQL(sql, d.dialect)}
+}
+
+// splitList returns the comma separated elements inside the parentheses
+// opened at tokens[open]
+func splitList(tokens []sqlToken, open int) [][]sqlToken {
+	return splitTopLevel(closingParen(tokens, open))
+}
+
+// closingParen returns the tokens between tokens[open] and its closing
+// parenthesis
+func closingParen(tokens []sqlToken, open int) []sqlToken {
+	depth := 0
+	for i := open; i < len(tokens); i++ {
+		if tokens[i].isPunct("(") {
+			depth++
+		} else if tokens[i].isPunct(")") {
+			depth--
+			if depth == 0 {
+				return tokens[open+1 : i]
+			}
+		}
+	}
+	return tokens[open+1:]
+}
+
+// splitTopLevel splits tokens at commas outside parentheses
+func splitTopLevel(tokens []sqlToken) [][]sqlToken {
+	var parts [][]sqlToken
+	depth, start := 0, 0
+	for i, tok := range tokens {
+		switch {
+		case tok.isPunct("("):
+			depth++
+		case tok.isPunct(")"):
+			depth--
+		case tok.isPunct(",") && depth == 0:
+			parts = append(parts, tokens[start:i])
+			start = i + 1
+		case tok.isPunct(";") && depth == 0:
+			parts = append(parts, tokens[start:i])
+			return parts
+		}
+	}
+	return append(parts, tokens[start:])
+}
+
+// columnList returns the names in the first parenthesized list of tokens
+func columnList(tokens []sqlToken) []string {
+	for i, tok := range tokens {
+		if tok.isPunct("(") {
+			var columns []string
+			for _, part := range splitTopLevel(closingParen(tokens, i)) {
+				if len(part) > 0 && part[0].isIdent() {
+					columns = append(columns, part[0].Value)
+				}
+			}
+			return columns
+		}
+	}
+	return nil
+}
+
+func containsKeywords(tokens []sqlToken, keywords ...string) bool {
+	for i := 0; i+len(keywords) <= len(tokens); i++ {
+		matched := true
+		for j, kw := range keywords {
+			if !tokens[i+j].isKeyword(kw) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// joinTokens renders tokens with single spaces, dropping the spaces a dump
+// tool puts around punctuation so that formatting does not matter
+func joinTokens(tokens []sqlToken) string {
+	var b strings.Builder
+	for i, tok := range tokens {
+		if i > 0 && !tok.isPunct(",") && !tok.isPunct(")") && !tok.isPunct(".") && !tok.isPunct("::") &&
+			!tokens[i-1].isPunct("(") && !tokens[i-1].isPunct(".") && !tokens[i-1].isPunct("::") &&
+			!(tok.isPunct("(") && tokens[i-1].isIdent()) {
+			b.WriteByte(' ')
+		}
+		b.WriteString(tok.Text)
+	}
+	return b.String()
+}
+
+// normalizeSQL renders a statement without comments, formatting, OR REPLACE
+// and MySQL DEFINER clauses, so that equal objects compare equal
+func normalizeSQL(sql string, dialect sqlDialect) string {
+	tokens := tokenize(sql, dialect)
+	kept := make([]sqlToken, 0, len(tokens))
+	for i := 0; i < len(tokens); i++ {
+		tok := tokens[i]
+		switch {
+		case tok.isKeyword("OR") && i+1 < len(tokens) && tokens[i+1].isKeyword("REPLACE"):
+			i++
+			continue
+		case tok.isKeyword("DEFINER") && i+2 < len(tokens) && tokens[i+1].isPunct("="):
+			// DEFINER=`user`@`host`
+			i += 2
+			if i+2 < len(tokens) && tokens[i+1].isPunct("@") {
+				i += 2
+			}
+			continue
+		case tok.isPunct(";"):
+			continue
+		}
+		kept = append(kept, tok)
+	}
+	return joinTokens(kept)
+}
+
+// countInsertRows counts the rows of an INSERT ... VALUES statement without
+// tokenizing its data
+func countInsertRows(sql string, dialect sqlDialect) int64 {
+	lexer := newSQLLexer(sql, dialect)
+	start := -1
+	for {
+		tok, ok := lexer.next()
+		if !ok {
+			return 0
+		}
+		if tok.isKeyword("VALUES") {
+			start = tok.End
+			break
+		}
+		if tok.isKeyword("SELECT") {
+			return 0
+		}
+	}
+
+	var rows int64
+	depth := 0
+	backslash := dialect == dialectMySQL
+	for i := start; i < len(sql); i++ {
+		switch c := sql[i]; c {
+		case '\'', '"', '`':
+			for i++; i < len(sql) && sql[i] != c; i++ {
+				if backslash && sql[i] == '\\' {
+					i++
+				}
+			}
+		case '(':
+			if depth == 0 {
+				rows++
+			}
+			depth++
+		case ')':
+			depth--
+		case ';':
+			if depth == 0 {
+				return rows
+			}
+		}
+	}
+	return rows
+}
@@ -3,20 +3,45 @@ package backup
 import (
 	"time"
 
+	"github.com/dendianugerah/velld/internal/connection"
 	"github.com/google/uuid"
 )
 
 // BackupSchedule represents a backup schedule configuration
 type BackupSchedule struct {
-	ID             uuid.UUID  `json:"id"`
-	ConnectionID   string     `json:"connection_id"`
-	Enabled        bool       `json:"enabled"`
-	CronSchedule   string     `json:"cron_schedule"`
-	RetentionDays  int        `json:"retention_days"`
-	NextRunTime    *time.Time `json:"next_run_time"`
-	LastBackupTime *time.Time `json:"last_backup_time"`
-	CreatedAt      time.Time  `json:"created_at"`
-	UpdatedAt      time.Time  `json:"updated_at"`
+	ID            uuid.UUID         `json:"id"`
+	ConnectionID  string            `json:"connection_id"`
+	Name          string            `json:"name"`
+	Enabled       bool              `json:"enabled"`
+	CronSchedule  string            `json:"cron_schedule"`
+	RetentionDays int               `json:"retention_days"`
+	Hooks         []connection.Hook `json:"hooks,omitempty"`
+	// DumpOptions replaces the dump options of the connection when set
+	DumpOptions *connection.DumpOptions `json:"dump_options,omitempty"`
+	// Destination is nil to store backups like manual ones
+	Destination *ScheduleDestination `json:"destination,omitempty"`
+	// AnomalyDetection is nil for the default settings
+	AnomalyDetection *AnomalyConfig `json:"anomaly_detection,omitempty"`
+	// Timezone is the IANA timezone of the cron expression and the blackout
+	// windows, empty for the timezone of the server
+	Timezone        string           `json:"timezone,omitempty"`
+	BlackoutWindows []BlackoutWindow `json:"blackout_windows,omitempty"`
+	// JitterSeconds delays each run by a random duration up to this long
+	JitterSeconds   int        `json:"jitter_seconds"`
+	MissedRunPolicy string     `json:"missed_run_policy"`
+	NextRunTime     *time.Time `json:"next_run_time"`
+	LastBackupTime  *time.Time `json:"last_backup_time"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+}
+
+// ScheduleDestination overrides where the backups of a schedule are stored
+type ScheduleDestination struct {
+	// S3 nil follows the S3 settings of the user, false keeps the backups
+	// local and true uploads them even when automatic uploads are disabled
+	S3 *bool `json:"s3,omitempty"`
+	// S3PathPrefix replaces the path prefix of the S3 settings
+	S3PathPrefix string `json:"s3_path_prefix,omitempty"`
 }
 
 // Backup represents a single backup record
@@ -30,6 +55,10 @@ type Backup struct {
 	Size          int64      `json:"size"`
 	StartedTime   time.Time  `json:"started_time"`
 	CompletedTime *time.Time `json:"completed_time"`
+	Checksum      *string    `json:"checksum,omitempty"`       // sha256 of the backup file
+	ServerVersion string     `json:"server_version,omitempty"` // version of the server that was dumped
+	Anomaly       *string    `json:"anomaly,omitempty"`        // why a suspicious backup was flagged
+	Log           string     `json:"log,omitempty"`
 	CreatedAt     time.Time  `json:"created_at"`
 	UpdatedAt     time.Time  `json:"updated_at"`
 }
@@ -58,9 +87,19 @@ type BackupRequest struct {
 
 // ScheduleBackupRequest represents a request to create a backup schedule
 type ScheduleBackupRequest struct {
-	ConnectionID  string `json:"connection_id"`
-	CronSchedule  string `json:"cron_schedule"`
-	RetentionDays int    `json:"retention_days"`
+	ConnectionID  string                  `json:"connection_id"`
+	Name          string                  `json:"name"`
+	CronSchedule  string                  `json:"cron_schedule"`
+	RetentionDays int                     `json:"retention_days"`
+	Hooks         []connection.Hook       `json:"hooks"`
+	DumpOptions   *connection.DumpOptions `json:"dump_options"`
+	Destination   *ScheduleDestination    `json:"destination"`
+	// AnomalyDetection is optional, the defaults apply without it
+	AnomalyDetection *AnomalyConfig   `json:"anomaly_detection"`
+	Timezone         string           `json:"timezone"`
+	BlackoutWindows  []BlackoutWindow `json:"blackout_windows"`
+	JitterSeconds    int              `json:"jitter_seconds"`
+	MissedRunPolicy  string           `json:"missed_run_policy"` // empty for MissedRunOnce
 }
 
 // BackupStats represents backup statistics
@@ -70,6 +109,8 @@ type BackupStats struct {
 	TotalSize       int64   `json:"total_size"`
 	AverageDuration float64 `json:"average_duration"`
 	SuccessRate     float64 `json:"success_rate"`
+	// RPO is the compliance of the connections with a recovery point objective
+	RPO *RPOCompliance `json:"rpo"`
 }
 
 // PaginatedBackupResponse represents a paginated response of backups
@@ -90,13 +131,73 @@ type BackupListRequest struct {
 
 // BackupListOptions represents options for listing backups
 type BackupListOptions struct {
-	UserID uuid.UUID
-	Limit  int
-	Offset int
-	Search string
+	UserID       uuid.UUID
+	ConnectionID string
+	ScheduleID   string
+	Limit        int
+	Offset       int
+	Search       string
+}
+
+// ScheduleListOptions represents options for listing schedules
+type ScheduleListOptions struct {
+	UserID       uuid.UUID
+	ConnectionID string
 }
 
 type UpdateScheduleRequest struct {
-	CronSchedule  string `json:"cron_schedule"`
-	RetentionDays int    `json:"retention_days"`
+	Name          *string           `json:"name"` // nil keeps the current name
+	CronSchedule  string            `json:"cron_schedule"`
+	RetentionDays int               `json:"retention_days"`
+	Hooks         []connection.Hook `json:"hooks"` // nil keeps the current hooks
+	// DumpOptions and Destination nil keep the current settings, an empty
+	// object removes them
+	DumpOptions *connection.DumpOptions `json:"dump_options"`
+	Destination *ScheduleDestination    `json:"destination"`
+	// AnomalyDetection nil keeps the current settings
+	AnomalyDetection *AnomalyConfig `json:"anomaly_detection"`
+	// Timezone, JitterSeconds and MissedRunPolicy nil keep the current
+	// values, BlackoutWindows nil keeps the current windows
+	Timezone        *string          `json:"timezone"`
+	BlackoutWindows []BlackoutWindow `json:"blackout_windows"`
+	JitterSeconds   *int             `json:"jitter_seconds"`
+	MissedRunPolicy *string          `json:"missed_run_policy"`
+}
+
+// Restore job statuses
+const (
+	RestoreStatusPending    = "pending"
+	RestoreStatusRunning    = "running"
+	RestoreStatusCompleted  = "completed"
+	RestoreStatusFailed     = "failed"
+	RestoreStatusRolledBack = "rolled_back"
+)
+
+// RestoreJob tracks a restore running in the background
+type RestoreJob struct {
+	ID               uuid.UUID  `json:"id"`
+	BackupID         string     `json:"backup_id"`
+	ConnectionID     string     `json:"connection_id"`
+	TargetDatabase   string     `json:"target_database"`
+	Mode             string     `json:"mode"`
+	Status           string     `json:"status"`
+	Progress         *float64   `json:"progress"` // percent, nil when it cannot be measured
+	BytesTotal       int64      `json:"bytes_total"`
+	BytesDone        int64      `json:"bytes_done"`
+	SnapshotBackupID *string    `json:"snapshot_backup_id"`
+	RollbackOf       *string    `json:"rollback_of"`
+	Error            *string    `json:"error"`
+	Log              string     `json:"log,omitempty"`
+	StartedTime      *time.Time `json:"started_time"`
+	CompletedTime    *time.Time `json:"completed_time"`
+	CreatedAt        time.Time  `json:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at"`
+}
+
+// RestoreJobListOptions represents options for listing restore jobs
+type RestoreJobListOptions struct {
+	UserID       uuid.UUID
+	ConnectionID string
+	Limit        int
+	Offset       int
 }
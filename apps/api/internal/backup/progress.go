@@ -0,0 +1,43 @@
+package backup
+
+import (
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// progressInterval is how often a progressReader reports the bytes read
+const progressInterval = time.Second
+
+// progressReader counts the bytes read through it and reports the total to
+// onProgress at most once per progressInterval.
+type progressReader struct {
+	reader     io.Reader
+	read       atomic.Int64
+	lastReport time.Time
+	onProgress func(read int64)
+}
+
+func newProgressReader(reader io.Reader, onProgress func(read int64)) *progressReader {
+	return &progressReader{
+		reader:     reader,
+		lastReport: time.Now(),
+		onProgress: onProgress,
+	}
+}
+
+func (p *progressReader) Read(buf []byte) (int, error) {
+	n, err := p.reader.Read(buf)
+	read := p.read.Add(int64(n))
+
+	if time.Since(p.lastReport) >= progressInterval {
+		p.lastReport = time.Now()
+		p.onProgress(read)
+	}
+	return n, err
+}
+
+// BytesRead returns the number of bytes read so far
+func (p *progressReader) BytesRead() int64 {
+	return p.read.Load()
+}
@@ -0,0 +1,416 @@
+package backup
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// tableSelection is the part of a dump chosen for a partial restore, and
+// where it is restored to
+type tableSelection struct {
+	dialect      sqlDialect
+	tables       []qualifiedName
+	schemas      map[string]bool
+	rename       map[string]string // requested table name -> new name
+	targetSchema string
+}
+
+func newTableSelection(req *RestoreRequest, dialect sqlDialect) *tableSelection {
+	sel := &tableSelection{
+		dialect:      dialect,
+		schemas:      make(map[string]bool),
+		rename:       req.Rename,
+		targetSchema: req.TargetSchema,
+	}
+	for _, table := range req.Tables {
+		sel.tables = append(sel.tables, parseQualifiedName(table, dialect))
+	}
+	for _, schema := range req.Schemas {
+		sel.schemas[schema] = true
+	}
+	return sel
+}
+
+func (sel *tableSelection) sameName(a, b string) bool {
+	// MySQL table names are case-insensitive on most platforms
+	if sel.dialect == dialectMySQL {
+		return strings.EqualFold(a, b)
+	}
+	return a == b
+}
+
+// match reports whether name is selected and the name it is restored under
+func (sel *tableSelection) match(name qualifiedName) (qualifiedName, bool) {
+	if name.Name == "" {
+		return qualifiedName{}, false
+	}
+
+	target := name
+	if sel.targetSchema != "" {
+		target.Schema = sel.targetSchema
+	}
+
+	for _, table := range sel.tables {
+		if table.Schema != "" && !sel.sameName(table.Schema, name.Schema) {
+			continue
+		}
+		if !sel.sameName(table.Name, name.Name) {
+			continue
+		}
+		if newName, ok := sel.rename[table.String()]; ok {
+			target.Name = newName
+		}
+		return target, true
+	}
+
+	if sel.schemas[name.Schema] {
+		return target, true
+	}
+	return qualifiedName{}, false
+}
+
+// dumpFilter copies the statements of a plain SQL dump that belong to the
+// selected tables, renaming them on the way. Sequences are only known to
+// belong to a table once ALTER SEQUENCE ... OWNED BY is seen, so their
+// earlier statements are held back until then.
+type dumpFilter struct {
+	sel *tableSelection
+	log *operationLog
+
+	// sequences maps the owned sequences to the name they are restored under
+	sequences map[qualifiedName]qualifiedName
+	// names maps index, constraint and trigger names that would clash with
+	// the originals when a table is renamed within the same schema
+	names            map[string]string
+	pendingSequences map[qualifiedName][]string
+	restored         map[qualifiedName]bool
+	skippedKeys      int
+}
+
+func newDumpFilter(sel *tableSelection, log *operationLog) *dumpFilter {
+	return &dumpFilter{
+		sel:              sel,
+		log:              log,
+		sequences:        make(map[qualifiedName]qualifiedName),
+		names:            make(map[string]string),
+		pendingSequences: make(map[qualifiedName][]string),
+		restored:         make(map[qualifiedName]bool),
+	}
+}
+
+// Run reads the dump from scanner and writes the selected part to w
+func (f *dumpFilter) Run(scanner *dumpScanner, w io.Writer) error {
+	if f.sel.targetSchema != "" {
+		if _, err := io.WriteString(w, "CREATE SCHEMA IF NOT EXISTS "+f.quote(f.sel.targetSchema)+";\n"); err != nil {
+			return err
+		}
+	}
+
+	for {
+		stmt, err := scanner.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read dump: %w", err)
+		}
+
+		if err := f.statement(scanner, stmt, w); err != nil {
+			return err
+		}
+	}
+
+	return f.finish()
+}
+
+func (f *dumpFilter) statement(scanner *dumpScanner, stmt *dumpStatement, w io.Writer) error {
+	if stmt.IsMeta {
+		_, err := io.WriteString(w, stmt.SQL)
+		return err
+	}
+
+	info := classifyStatement(stmt.SQL, f.sel.dialect)
+	switch info.Kind {
+	case stmtSet, stmtTransaction, stmtUnlockTables:
+		_, err := io.WriteString(w, stmt.SQL)
+		return err
+
+	case stmtCreateSequence, stmtAlterSequence, stmtSetval, stmtAlterTable, stmtGrant, stmtComment:
+		if info.Kind == stmtAlterSequence && info.OwnedBy.Name != "" {
+			return f.claimSequence(info, stmt.SQL, w)
+		}
+		if _, owned := f.sequences[info.Object]; owned {
+			_, err := io.WriteString(w, f.rewrite(stmt.SQL, info))
+			return err
+		}
+		if _, pending := f.pendingSequences[info.Object]; pending || info.Kind == stmtCreateSequence {
+			f.pendingSequences[info.Object] = append(f.pendingSequences[info.Object], stmt.SQL)
+			return nil
+		}
+	}
+
+	target, selected := f.sel.match(info.Object)
+	if !selected {
+		return nil
+	}
+	if info.Kind == stmtCreateTable || info.Kind == stmtCopy || info.Kind == stmtInsert {
+		f.restored[info.Object] = true
+	}
+
+	if info.Kind == stmtAlterTable && f.referencesUnselected(stmt.SQL) {
+		f.skippedKeys++
+		return nil
+	}
+
+	f.registerNames(stmt.SQL, info, target)
+
+	if _, err := io.WriteString(w, f.rewrite(stmt.SQL, info)); err != nil {
+		return err
+	}
+
+	if stmt.IsCopy {
+		for {
+			row, ok, err := scanner.CopyRow()
+			if err != nil {
+				return fmt.Errorf("failed to read COPY data: %w", err)
+			}
+			if !ok {
+				break
+			}
+			if _, err := io.WriteString(w, row+"\n"); err != nil {
+				return err
+			}
+		}
+		if _, err := io.WriteString(w, copyTerminator+"\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// claimSequence handles ALTER SEQUENCE ... OWNED BY, which decides whether
+// the statements held back for the sequence are restored
+func (f *dumpFilter) claimSequence(info statementInfo, sql string, w io.Writer) error {
+	pending := f.pendingSequences[info.Object]
+	delete(f.pendingSequences, info.Object)
+
+	table, selected := f.sel.match(info.OwnedBy)
+	if !selected {
+		return nil
+	}
+
+	target := info.Object
+	if f.sel.targetSchema != "" {
+		target.Schema = f.sel.targetSchema
+	}
+	if table.Name != info.OwnedBy.Name && !f.movesSchema() {
+		target.Name = renameDependent(info.Object.Name, info.OwnedBy.Name, table.Name)
+	}
+	f.sequences[info.Object] = target
+
+	for _, stmt := range append(pending, sql) {
+		if _, err := io.WriteString(w, f.rewrite(stmt, classifyStatement(stmt, f.sel.dialect))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *dumpFilter) movesSchema() bool {
+	return f.sel.targetSchema != "" && f.sel.dialect == dialectPostgres
+}
+
+// referencesUnselected reports whether an ALTER TABLE adds a foreign key to
+// a table that is not restored, which would fail or point at live data
+func (f *dumpFilter) referencesUnselected(sql string) bool {
+	tokens := tokenize(sql, f.sel.dialect)
+	for i, tok := range tokens {
+		if !tok.isKeyword("REFERENCES") {
+			continue
+		}
+		parts, _, _, _ := readName(tokens, i+1)
+		if _, selected := f.sel.match(nameFromParts(parts)); !selected {
+			return true
+		}
+	}
+	return false
+}
+
+// registerNames records index, constraint and trigger names of a renamed
+// table so they are renamed as well and do not clash with the live table's
+func (f *dumpFilter) registerNames(sql string, info statementInfo, target qualifiedName) {
+	if target.Name == info.Object.Name || f.movesSchema() {
+		return
+	}
+
+	add := func(name string) {
+		if name != "" {
+			f.names[name] = renameDependent(name, info.Object.Name, target.Name)
+		}
+	}
+
+	switch info.Kind {
+	case stmtCreateIndex, stmtCreateTrigger:
+		add(info.Name)
+	case stmtCreateTable, stmtAlterTable:
+		tokens := tokenize(sql, f.sel.dialect)
+		for i := 0; i+1 < len(tokens); i++ {
+			if tokens[i].isKeyword("CONSTRAINT") && tokens[i+1].isIdent() {
+				add(tokens[i+1].Value)
+			}
+			// Identity columns name their sequence inline
+			if tokens[i].isKeyword("SEQUENCE") && i+2 < len(tokens) && tokens[i+1].isKeyword("NAME") {
+				parts, _, _, _ := readName(tokens, i+2)
+				seq := nameFromParts(parts)
+				seqTarget := seq
+				seqTarget.Name = renameDependent(seq.Name, info.Object.Name, target.Name)
+				f.sequences[seq] = seqTarget
+			}
+		}
+	}
+}
+
+// rewrite renames the selected tables and their dependent objects in sql.
+// Row data is never rewritten: for INSERT and COPY only the table name is.
+func (f *dumpFilter) rewrite(sql string, info statementInfo) string {
+	if len(f.sel.rename) == 0 && f.sel.targetSchema == "" {
+		return sql
+	}
+
+	if info.Kind == stmtInsert || info.Kind == stmtCopy {
+		if target, ok := f.sel.match(info.Object); ok && info.ObjectStart >= 0 {
+			return sql[:info.ObjectStart] + f.render(target, info.Object) + sql[info.ObjectEnd:]
+		}
+		return sql
+	}
+
+	tokens := tokenize(sql, f.sel.dialect)
+	var out strings.Builder
+	last := 0
+	replace := func(start, end int, text string) {
+		out.WriteString(sql[last:start])
+		out.WriteString(text)
+		last = end
+	}
+
+	for i := 0; i < len(tokens); {
+		tok := tokens[i]
+
+		if tok.Kind == tokenString && f.sel.dialect == dialectPostgres {
+			// Sequences are referenced by name in nextval('...') and setval('...')
+			if target, ok := f.sequences[parseQualifiedName(tok.Value, dialectPostgres)]; ok {
+				replace(tok.Start, tok.End, "'"+strings.ReplaceAll(f.render(target, target), "'", "''")+"'")
+			}
+			i++
+			continue
+		}
+
+		if !tok.isIdent() {
+			i++
+			continue
+		}
+
+		parts, first, lastToken, next := readName(tokens, i)
+		start, end := tokens[first].Start, tokens[lastToken].End
+		isObject := start == info.ObjectStart
+		afterReferences := i > 0 && tokens[i-1].isKeyword("REFERENCES")
+		i = next
+
+		switch {
+		case len(parts) == 2 || len(parts) == 3:
+			name := nameFromParts(parts[:2])
+			if target, ok := f.sequences[name]; ok && len(parts) == 2 {
+				replace(start, end, f.render(target, name))
+				continue
+			}
+			if target, ok := f.sel.match(name); ok {
+				// schema.table, or schema.table.column in COMMENT ON COLUMN
+				tableEnd := end
+				if len(parts) == 3 {
+					tableEnd = tokens[lastToken-2].End
+				}
+				replace(start, tableEnd, f.render(target, name))
+				continue
+			}
+			if len(parts) == 2 {
+				if newName, ok := f.names[parts[1]]; ok {
+					replace(tokens[lastToken].Start, end, f.quote(newName))
+				}
+			}
+
+		case len(parts) == 1:
+			// Unqualified names are only table names where the grammar says so,
+			// anything else could be a column
+			if isObject || afterReferences {
+				if target, ok := f.sel.match(nameFromParts(parts)); ok {
+					replace(start, end, f.render(target, nameFromParts(parts)))
+					continue
+				}
+			}
+			if newName, ok := f.names[parts[0]]; ok {
+				replace(start, end, f.quote(newName))
+			}
+		}
+	}
+
+	out.WriteString(sql[last:])
+	return out.String()
+}
+
+// render writes target as an identifier, qualified when the original was or
+// when the table moves to another schema
+func (f *dumpFilter) render(target, original qualifiedName) string {
+	if original.Schema == "" && (f.sel.targetSchema == "" || f.sel.dialect != dialectPostgres) {
+		return f.quote(target.Name)
+	}
+	return f.quote(target.Schema) + "." + f.quote(target.Name)
+}
+
+func (f *dumpFilter) quote(name string) string {
+	if f.sel.dialect == dialectMySQL {
+		return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+	}
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+func (f *dumpFilter) finish() error {
+	var restored []string
+	for name := range f.restored {
+		restored = append(restored, name.String())
+	}
+	sort.Strings(restored)
+	f.log.Printf("Restoring %d table(s): %s", len(restored), strings.Join(restored, ", "))
+
+	if f.skippedKeys > 0 {
+		f.log.Printf("Skipped %d foreign key(s) referencing tables outside the selection", f.skippedKeys)
+	}
+
+	var missing []string
+	for _, table := range f.sel.tables {
+		found := false
+		for name := range f.restored {
+			if (table.Schema == "" || f.sel.sameName(table.Schema, name.Schema)) && f.sel.sameName(table.Name, name.Name) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, table.String())
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("tables not found in backup: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// renameDependent derives the name of an object belonging to a renamed
+// table, e.g. orders_pkey becomes orders_restored_pkey
+func renameDependent(name, oldTable, newTable string) string {
+	if strings.HasPrefix(name, oldTable) {
+		return newTable + name[len(oldTable):]
+	}
+	return newTable + "_" + name
+}
@@ -0,0 +1,174 @@
+package backup
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/dendianugerah/velld/internal/common"
+	"github.com/dendianugerah/velld/internal/connection"
+)
+
+// runPartialRestore restores the selected tables of a PostgreSQL or MySQL
+// backup. The dump is streamed through dumpFilter into psql or mysql.
+// Custom format dumps are first converted to SQL with pg_restore -f -,
+// because pg_restore -t only restores the table definition and data and
+// leaves out its indexes, constraints, triggers and sequences.
+func (s *BackupService) runPartialRestore(job *RestoreJob, req *RestoreRequest, conn *connection.StoredConnection, backup *Backup, log *operationLog) error {
+	dialect, ok := dialectFor(conn.Type)
+	if !ok {
+		return fmt.Errorf("partial restore is not supported for %s", conn.Type)
+	}
+
+	var (
+		cmd     *exec.Cmd
+		cleanup func()
+		err     error
+	)
+	if conn.Type == "postgresql" {
+		cmd, cleanup, err = s.createPsqlRestoreCmd(conn)
+	} else {
+		cmd, cleanup, err = s.createMySQLRestoreCmd(conn)
+	}
+	defer cleanup()
+
+	if err != nil {
+		return fmt.Errorf("failed to prepare %s: %v", restoreTools[conn.Type], err)
+	}
+
+	if cmd == nil {
+		return fmt.Errorf("restore tool not found for %s. Please ensure %s is installed", conn.Type, restoreTools[conn.Type])
+	}
+
+	file, err := os.Open(backup.Path)
+	if err != nil {
+		return fmt.Errorf("failed to open backup file: %v", err)
+	}
+	defer file.Close()
+
+	if info, err := file.Stat(); err == nil {
+		job.BytesTotal = info.Size()
+	}
+	progress := newProgressReader(file, func(read int64) {
+		if err := s.backupRepo.UpdateRestoreProgress(job.ID.String(), read); err != nil {
+			fmt.Printf("ERROR: failed to update restore progress: %v\n", err)
+		}
+		s.publishRestoreProgress(conn.UserID, job, read)
+	})
+	if err := s.backupRepo.UpdateRestoreJob(job); err != nil {
+		fmt.Printf("ERROR: failed to update restore job %s: %v\n", job.ID, err)
+	}
+
+	var source io.Reader = progress
+	var extract *exec.Cmd
+	var extractStderr bytes.Buffer
+	if conn.Type == "postgresql" && isPgCustomDump(backup.Path) {
+		extract = s.createPgExtractCmd(conn)
+		if extract == nil {
+			return fmt.Errorf("restore tool not found for %s. Please ensure %s is installed", conn.Type, pgRestoreTool)
+		}
+		extract.Stdin = progress
+		extract.Stderr = &extractStderr
+		stdout, err := extract.StdoutPipe()
+		if err != nil {
+			return fmt.Errorf("failed to start %s: %v", pgRestoreTool, err)
+		}
+		if err := extract.Start(); err != nil {
+			return fmt.Errorf("failed to start %s: %v", pgRestoreTool, err)
+		}
+		source = stdout
+		log.Printf("Converting custom format dump with %s", filepath.Base(extract.Path))
+	}
+
+	pr, pw := io.Pipe()
+	filterDone := make(chan error, 1)
+	go func() {
+		err := newDumpFilter(newTableSelection(req, dialect), log).Run(newDumpScanner(source, dialect), pw)
+		pw.CloseWithError(err)
+		filterDone <- err
+	}()
+
+	cmd.Stdin = pr
+	log.Printf("Running %s", filepath.Base(cmd.Path))
+	output, cmdErr := cmd.CombinedOutput()
+	output = []byte(common.ScrubSecrets(string(output), conn.Password, conn.SSHPassword))
+	log.Output(string(output))
+
+	// Unblock the filter and the extractor if the restore tool stopped early
+	pr.Close()
+	filterErr := <-filterDone
+	if errors.Is(filterErr, io.ErrClosedPipe) {
+		filterErr = nil
+	}
+
+	var extractErr error
+	if extract != nil {
+		if cmdErr != nil && extract.Process != nil {
+			extract.Process.Kill()
+		}
+		if err := extract.Wait(); err != nil && cmdErr == nil {
+			extractErr = fmt.Errorf("%s failed: %v: %s", pgRestoreTool, err, bytes.TrimSpace(extractStderr.Bytes()))
+		}
+	}
+
+	job.BytesDone = progress.BytesRead()
+
+	if err := s.validateRestoreOutput(conn.Type, conn.DatabaseName, output, cmdErr); err != nil {
+		return err
+	}
+	if extractErr != nil {
+		return extractErr
+	}
+	return filterErr
+}
+
+// createPgExtractCmd builds pg_restore converting a custom format dump read
+// from stdin to plain SQL on stdout
+func (s *BackupService) createPgExtractCmd(conn *connection.StoredConnection) *exec.Cmd {
+	binaryPath := findToolDir(conn, pgRestoreTool)
+	if binaryPath == "" {
+		fmt.Printf("ERROR: pg_restore binary not found. Please install PostgreSQL client tools.\n")
+		return nil
+	}
+
+	args := []string{"-f", "-"}
+	opts := conn.DumpOptions.PostgresOptions()
+	if opts.NoOwner {
+		args = append(args, "--no-owner")
+	}
+	if opts.NoPrivileges {
+		args = append(args, "--no-privileges")
+	}
+
+	return exec.Command(filepath.Join(binaryPath, common.GetPlatformExecutableName(pgRestoreTool)), args...)
+}
+
+// mongoNamespaceArgs selects the requested collections of a mongodump and
+// maps them to the target database, applying renames
+func (s *BackupService) mongoNamespaceArgs(req *RestoreRequest, backup *Backup, log *operationLog) []string {
+	// mongodump writes the collections under the name of the source database
+	sourceDB := req.TargetDatabase
+	if source, err := s.connStorage.GetConnection(backup.ConnectionID); err == nil {
+		sourceDB = source.DatabaseName
+	}
+
+	args := []string{}
+	for _, collection := range req.Tables {
+		from := sourceDB + "." + collection
+		args = append(args, "--nsInclude", from)
+
+		to := collection
+		if newName, ok := req.Rename[collection]; ok {
+			to = newName
+		}
+		if sourceDB != req.TargetDatabase || to != collection {
+			args = append(args, "--nsFrom", from, "--nsTo", req.TargetDatabase+"."+to)
+		}
+		log.Printf("Restoring collection %s as %s.%s", from, req.TargetDatabase, to)
+	}
+	return args
+}
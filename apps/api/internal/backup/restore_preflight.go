@@ -0,0 +1,379 @@
+package backup
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/dendianugerah/velld/internal/common"
+	"github.com/dendianugerah/velld/internal/connection"
+)
+
+type PreflightStatus string
+
+const (
+	PreflightPass    PreflightStatus = "pass"
+	PreflightWarn    PreflightStatus = "warn"
+	PreflightFail    PreflightStatus = "fail"
+	PreflightSkipped PreflightStatus = "skipped"
+)
+
+// PreflightCheck is the outcome of one restore pre-flight check
+type PreflightCheck struct {
+	Name    string          `json:"name"`
+	Status  PreflightStatus `json:"status"`
+	Message string          `json:"message"`
+}
+
+// RestorePreflight describes what a restore would do and whether it is
+// expected to succeed. Ready is false when any check failed.
+type RestorePreflight struct {
+	BackupID       string           `json:"backup_id"`
+	ConnectionID   string           `json:"connection_id"`
+	TargetDatabase string           `json:"target_database"`
+	Mode           string           `json:"mode"`
+	Ready          bool             `json:"ready"`
+	BackupSize     int64            `json:"backup_size"`
+	SourceVersion  string           `json:"source_version,omitempty"`
+	TargetVersion  string           `json:"target_version,omitempty"`
+	TargetExists   bool             `json:"target_exists"`
+	TargetObjects  *int             `json:"target_objects,omitempty"`
+	FreeSpace      *int64           `json:"free_space,omitempty"`
+	Actions        []string         `json:"actions"`
+	Checks         []PreflightCheck `json:"checks"`
+}
+
+func (p *RestorePreflight) add(name string, status PreflightStatus, format string, args ...interface{}) {
+	p.Checks = append(p.Checks, PreflightCheck{Name: name, Status: status, Message: fmt.Sprintf(format, args...)})
+	if status == PreflightFail {
+		p.Ready = false
+	}
+}
+
+// PreflightRestore runs the checks of a restore without changing the target.
+// Errors are only returned when the backup or connection cannot be loaded;
+// everything else is reported as a check.
+func (s *BackupService) PreflightRestore(req *RestoreRequest) (*RestorePreflight, error) {
+	backup, err := s.backupRepo.GetBackup(req.BackupID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get backup: %v", err)
+	}
+
+	conn, err := s.connStorage.GetConnection(req.ConnectionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection: %v", err)
+	}
+
+	report := &RestorePreflight{
+		BackupID:      req.BackupID,
+		ConnectionID:  req.ConnectionID,
+		Ready:         true,
+		BackupSize:    backup.Size,
+		SourceVersion: backup.ServerVersion,
+		Actions:       []string{},
+	}
+
+	if err := req.Validate(conn); err != nil {
+		report.add("request", PreflightFail, "%v", err)
+	} else {
+		report.add("request", PreflightPass, "restore options are valid")
+	}
+	report.TargetDatabase = req.TargetDatabase
+	report.Mode = req.Mode
+
+	if err := s.verifyRestoreTools(conn.Type); err != nil {
+		report.add("restore_tools", PreflightFail, "%v", err)
+	} else {
+		report.add("restore_tools", PreflightPass, "%s is installed", restoreTools[conn.Type])
+	}
+
+	s.checkArtifact(report, backup, conn.Type)
+
+	// The engine and version of the dumped server
+	source, err := s.connStorage.GetConnection(backup.ConnectionID)
+	if err != nil {
+		report.add("engine", PreflightSkipped, "the connection the backup was taken from no longer exists")
+	} else {
+		if report.SourceVersion == "" {
+			report.SourceVersion = source.ServerVersion
+		}
+		checkEngine(report, source.Type, conn.Type)
+	}
+
+	s.checkTarget(report, req, conn)
+
+	if report.Ready {
+		report.Actions = append(report.Actions, restoreActions(req, conn, report)...)
+	}
+	return report, nil
+}
+
+func (s *BackupService) checkArtifact(report *RestorePreflight, backup *Backup, dbType string) {
+	if backup.Status != "completed" {
+		report.add("backup_status", PreflightWarn, "backup status is %q", backup.Status)
+	}
+
+	// mongorestore reads the dump directory next to the recorded path
+	if dbType == "mongodb" {
+		if _, err := os.ReadDir(filepath.Dir(backup.Path)); err != nil {
+			report.add("artifact", PreflightFail, "backup directory is not readable: %v", err)
+		} else {
+			report.add("artifact", PreflightPass, "backup directory is readable")
+		}
+		report.add("checksum", PreflightSkipped, "checksums are only recorded for single file backups")
+		return
+	}
+
+	file, err := os.Open(backup.Path)
+	if err != nil {
+		report.add("artifact", PreflightFail, "backup file is not readable: %v", err)
+		report.add("checksum", PreflightSkipped, "backup file is not readable")
+		return
+	}
+	info, err := file.Stat()
+	file.Close()
+	if err != nil || info.Size() == 0 {
+		report.add("artifact", PreflightFail, "backup file is empty")
+		report.add("checksum", PreflightSkipped, "backup file is empty")
+		return
+	}
+	if backup.Size > 0 && info.Size() != backup.Size {
+		report.add("artifact", PreflightFail, "backup file is %d bytes but %d bytes were recorded", info.Size(), backup.Size)
+	} else {
+		report.add("artifact", PreflightPass, "backup file is readable (%d bytes)", info.Size())
+	}
+
+	if backup.Checksum == nil {
+		report.add("checksum", PreflightSkipped, "no checksum was recorded for this backup")
+		return
+	}
+	checksum, err := fileChecksum(backup.Path)
+	switch {
+	case err != nil:
+		report.add("checksum", PreflightFail, "failed to read backup file: %v", err)
+	case checksum != *backup.Checksum:
+		report.add("checksum", PreflightFail, "checksum mismatch: expected sha256:%s, got sha256:%s", *backup.Checksum, checksum)
+	default:
+		report.add("checksum", PreflightPass, "sha256:%s matches", checksum)
+	}
+}
+
+func checkEngine(report *RestorePreflight, sourceType, targetType string) {
+	isMySQL := func(t string) bool { return t == "mysql" || t == "mariadb" }
+
+	switch {
+	case sourceType == targetType:
+		report.add("engine", PreflightPass, "backup and target are both %s", targetType)
+	case isMySQL(sourceType) && isMySQL(targetType):
+		report.add("engine", PreflightWarn, "%s backup restored into %s; engine specific features may not be supported", sourceType, targetType)
+	default:
+		report.add("engine", PreflightFail, "a %s backup cannot be restored into %s", sourceType, targetType)
+	}
+}
+
+// checkTarget connects to the target server and inspects the target database
+func (s *BackupService) checkTarget(report *RestorePreflight, req *RestoreRequest, conn *connection.StoredConnection) {
+	local := !conn.SSHEnabled && isLocalHost(conn.Host)
+
+	tunnel, effectiveHost, effectivePort, err := s.setupSSHTunnelIfNeeded(conn)
+	if err != nil {
+		report.add("reachability", PreflightFail, "failed to setup SSH tunnel: %v", err)
+		return
+	}
+	if tunnel != nil {
+		defer tunnel.Stop()
+		conn.Host = effectiveHost
+		conn.Port = effectivePort
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), restoreTargetTimeout)
+	defer cancel()
+
+	version, err := connection.QueryServerVersion(ctx, conn)
+	if err != nil {
+		report.add("reachability", PreflightFail, "cannot connect to %s:%d: %v", conn.Host, conn.Port, err)
+		return
+	}
+	report.TargetVersion = version
+	report.add("reachability", PreflightPass, "connected to %s:%d", conn.Host, conn.Port)
+
+	checkVersion(report, conn.Type)
+
+	if req.TargetDatabase == "" {
+		return
+	}
+
+	exists, err := connection.DatabaseExists(ctx, conn, req.TargetDatabase)
+	if err != nil {
+		report.add("target_database", PreflightFail, "failed to check target database: %v", err)
+		return
+	}
+	report.TargetExists = exists
+
+	switch {
+	case req.Mode == RestoreModeExisting && !exists && conn.Type != "mongodb":
+		report.add("target_database", PreflightFail, "database '%s' does not exist; use mode %q to create it", req.TargetDatabase, RestoreModeCreate)
+	case req.Mode == RestoreModeCreate && exists:
+		report.add("target_database", PreflightFail, "database '%s' already exists; use mode %q to replace it", req.TargetDatabase, RestoreModeDropRecreate)
+	case exists:
+		report.add("target_database", PreflightPass, "database '%s' exists", req.TargetDatabase)
+	default:
+		report.add("target_database", PreflightPass, "database '%s' will be created", req.TargetDatabase)
+	}
+
+	if exists {
+		count, err := connection.CountObjects(ctx, conn, req.TargetDatabase)
+		switch {
+		case err != nil:
+			report.add("target_empty", PreflightWarn, "failed to inspect target database: %v", err)
+		case count == 0:
+			report.TargetObjects = &count
+			report.add("target_empty", PreflightPass, "database '%s' is empty", req.TargetDatabase)
+		case req.Mode == RestoreModeDropRecreate:
+			report.TargetObjects = &count
+			report.add("target_empty", PreflightWarn, "database '%s' contains %d table(s) that will be dropped", req.TargetDatabase, count)
+		default:
+			report.TargetObjects = &count
+			report.add("target_empty", PreflightWarn, "database '%s' contains %d table(s); objects in the backup may conflict with them", req.TargetDatabase, count)
+		}
+	}
+
+	s.checkFreeSpace(ctx, report, conn, local)
+}
+
+func checkVersion(report *RestorePreflight, dbType string) {
+	if report.SourceVersion == "" {
+		report.add("version", PreflightSkipped, "the server version of the backup is unknown")
+		return
+	}
+
+	source, sourceOK := common.ParseVersion(report.SourceVersion)
+	target, targetOK := common.ParseVersion(report.TargetVersion)
+	if !sourceOK || !targetOK {
+		report.add("version", PreflightSkipped, "cannot compare versions %q and %q", report.SourceVersion, report.TargetVersion)
+		return
+	}
+
+	switch {
+	case source.Major == target.Major:
+		report.add("version", PreflightPass, "backup and target are both version %d", target.Major)
+	case target.Major > source.Major:
+		report.add("version", PreflightPass, "backup from version %s restored into newer version %s", source.String(), target.String())
+	case dbType == "postgresql":
+		report.add("version", PreflightFail, "backup from PostgreSQL %d cannot be restored reliably into older PostgreSQL %d", source.Major, target.Major)
+	default:
+		report.add("version", PreflightWarn, "backup from version %s restored into older version %s", source.String(), target.String())
+	}
+}
+
+// checkFreeSpace compares the free space of the target server with the
+// backup size. Tables and indexes usually take more room than their dump, so
+// less than twice the backup size is reported as a warning.
+func (s *BackupService) checkFreeSpace(ctx context.Context, report *RestorePreflight, conn *connection.StoredConnection, local bool) {
+	free := int64(-1)
+
+	info, err := connection.QueryStorageInfo(ctx, conn)
+	switch {
+	case err != nil:
+		report.add("free_space", PreflightSkipped, "cannot determine free space: %v", err)
+		return
+	case info.FreeBytes >= 0:
+		free = info.FreeBytes
+	case info.DataDirectory != "" && local:
+		free, err = common.FreeDiskSpace(info.DataDirectory)
+		if err != nil {
+			report.add("free_space", PreflightSkipped, "cannot determine free space of %s: %v", info.DataDirectory, err)
+			return
+		}
+	default:
+		report.add("free_space", PreflightSkipped, "free space on %s cannot be determined remotely", conn.Host)
+		return
+	}
+
+	report.FreeSpace = &free
+	needed := report.BackupSize
+	switch {
+	case free < needed:
+		report.add("free_space", PreflightFail, "%d bytes free, the backup alone is %d bytes", free, needed)
+	case free < 2*needed:
+		report.add("free_space", PreflightWarn, "%d bytes free for a %d byte backup; restored data may need more", free, needed)
+	default:
+		report.add("free_space", PreflightPass, "%d bytes free", free)
+	}
+}
+
+// restoreActions lists what the restore will do, in order
+func restoreActions(req *RestoreRequest, conn *connection.StoredConnection, report *RestorePreflight) []string {
+	var actions []string
+
+	if req.Snapshot && report.TargetExists && req.Mode != RestoreModeCreate {
+		actions = append(actions, fmt.Sprintf("Back up database '%s' as a pre-restore snapshot", req.TargetDatabase))
+	}
+	if req.Mode == RestoreModeDropRecreate && report.TargetExists {
+		actions = append(actions, fmt.Sprintf("Drop database '%s'", req.TargetDatabase))
+	}
+	if req.Mode != RestoreModeExisting {
+		actions = append(actions, fmt.Sprintf("Create database '%s'", req.TargetDatabase))
+	}
+
+	switch {
+	case len(req.Tables) > 0:
+		actions = append(actions, fmt.Sprintf("Restore %s into '%s'", strings.Join(req.Tables, ", "), req.TargetDatabase))
+	case len(req.Schemas) > 0:
+		actions = append(actions, fmt.Sprintf("Restore schema(s) %s into '%s'", strings.Join(req.Schemas, ", "), req.TargetDatabase))
+	default:
+		actions = append(actions, fmt.Sprintf("Restore the full backup into '%s'", req.TargetDatabase))
+	}
+	renamed := make([]string, 0, len(req.Rename))
+	for from := range req.Rename {
+		renamed = append(renamed, from)
+	}
+	sort.Strings(renamed)
+	for _, from := range renamed {
+		actions = append(actions, fmt.Sprintf("Rename %s to %s", from, req.Rename[from]))
+	}
+	if req.TargetSchema != "" {
+		actions = append(actions, fmt.Sprintf("Move restored tables to schema '%s'", req.TargetSchema))
+	}
+
+	hooks := 0
+	for _, hook := range conn.Hooks {
+		if hook.Stage == connection.HookPostRestore {
+			hooks++
+		}
+	}
+	if hooks > 0 {
+		actions = append(actions, fmt.Sprintf("Run %d post-restore hook(s)", hooks))
+	}
+	return actions
+}
+
+func isLocalHost(host string) bool {
+	if host == "" || strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// fileChecksum returns the hex encoded sha256 of the file at path
+func fileChecksum(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
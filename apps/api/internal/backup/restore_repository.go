@@ -0,0 +1,191 @@
+package backup
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/dendianugerah/velld/internal/common"
+)
+
+// Restore Job Methods
+
+const restoreJobColumns = `
+	j.id, j.backup_id, j.connection_id, j.target_database, j.mode, j.status,
+	j.bytes_total, j.bytes_done, j.snapshot_backup_id, j.rollback_of, j.error,
+	j.log, j.started_time, j.completed_time, j.created_at, j.updated_at`
+
+func (r *BackupRepository) CreateRestoreJob(job *RestoreJob) error {
+	_, err := r.db.Exec(`
+		INSERT INTO restore_jobs (
+			id, backup_id, connection_id, target_database, mode, status,
+			bytes_total, bytes_done, snapshot_backup_id, rollback_of, error,
+			log, created_at, updated_at
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)`,
+		job.ID, job.BackupID, job.ConnectionID, job.TargetDatabase, job.Mode, job.Status,
+		job.BytesTotal, job.BytesDone, job.SnapshotBackupID, job.RollbackOf, job.Error,
+		job.Log, job.CreatedAt.Format(time.RFC3339), job.UpdatedAt.Format(time.RFC3339))
+	return err
+}
+
+func (r *BackupRepository) UpdateRestoreJob(job *RestoreJob) error {
+	_, err := r.db.Exec(`
+		UPDATE restore_jobs
+		SET status = $1, bytes_total = $2, bytes_done = $3, snapshot_backup_id = $4,
+		    error = $5, log = $6, started_time = $7, completed_time = $8, updated_at = $9
+		WHERE id = $10`,
+		job.Status, job.BytesTotal, job.BytesDone, job.SnapshotBackupID,
+		job.Error, job.Log, formatOptionalTime(job.StartedTime), formatOptionalTime(job.CompletedTime),
+		time.Now().Format(time.RFC3339), job.ID)
+	return err
+}
+
+func (r *BackupRepository) UpdateRestoreProgress(id string, bytesDone int64) error {
+	_, err := r.db.Exec(`
+		UPDATE restore_jobs SET bytes_done = $1, updated_at = $2 WHERE id = $3`,
+		bytesDone, time.Now().Format(time.RFC3339), id)
+	return err
+}
+
+func (r *BackupRepository) UpdateRestoreJobStatus(id string, status string) error {
+	_, err := r.db.Exec(`
+		UPDATE restore_jobs SET status = $1, updated_at = $2 WHERE id = $3`,
+		status, time.Now().Format(time.RFC3339), id)
+	return err
+}
+
+func (r *BackupRepository) GetRestoreJob(id string) (*RestoreJob, error) {
+	row := r.db.QueryRow(`SELECT `+restoreJobColumns+` FROM restore_jobs j WHERE j.id = $1`, id)
+	return scanRestoreJob(row)
+}
+
+func (r *BackupRepository) ListRestoreJobs(opts RestoreJobListOptions) ([]*RestoreJob, int, error) {
+	whereClause := "WHERE c.user_id = $1"
+	args := []interface{}{opts.UserID}
+	if opts.ConnectionID != "" {
+		whereClause += " AND j.connection_id = $2"
+		args = append(args, opts.ConnectionID)
+	}
+
+	var total int
+	err := r.db.QueryRow(`
+		SELECT COUNT(*)
+		FROM restore_jobs j
+		INNER JOIN connections c ON j.connection_id = c.id
+		`+whereClause, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to count restore jobs: %v", err)
+	}
+
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM restore_jobs j
+		INNER JOIN connections c ON j.connection_id = c.id
+		%s
+		ORDER BY j.created_at DESC
+		LIMIT $%d OFFSET $%d`, restoreJobColumns, whereClause, len(args)+1, len(args)+2)
+	args = append(args, opts.Limit, opts.Offset)
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var jobs []*RestoreJob
+	for rows.Next() {
+		job, err := scanRestoreJob(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		// The list view does not carry the full log
+		job.Log = ""
+		jobs = append(jobs, job)
+	}
+
+	return jobs, total, rows.Err()
+}
+
+// FailInterruptedRestoreJobs marks restores that were still running when the
+// server stopped as failed
+func (r *BackupRepository) FailInterruptedRestoreJobs() error {
+	now := time.Now().Format(time.RFC3339)
+	_, err := r.db.Exec(`
+		UPDATE restore_jobs
+		SET status = $1,
+		    error = 'restore was interrupted by a server restart',
+		    completed_time = $2,
+		    updated_at = $2
+		WHERE status IN ($3, $4)`,
+		RestoreStatusFailed, now, RestoreStatusPending, RestoreStatusRunning)
+	return err
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanRestoreJob(row rowScanner) (*RestoreJob, error) {
+	var (
+		logStr           sql.NullString
+		startedTimeStr   sql.NullString
+		completedTimeStr sql.NullString
+		createdAtStr     string
+		updatedAtStr     string
+	)
+	job := &RestoreJob{}
+	err := row.Scan(
+		&job.ID, &job.BackupID, &job.ConnectionID, &job.TargetDatabase, &job.Mode, &job.Status,
+		&job.BytesTotal, &job.BytesDone, &job.SnapshotBackupID, &job.RollbackOf, &job.Error,
+		&logStr, &startedTimeStr, &completedTimeStr, &createdAtStr, &updatedAtStr)
+	if err != nil {
+		return nil, err
+	}
+	job.Log = logStr.String
+
+	if job.BytesTotal > 0 {
+		progress := float64(job.BytesDone) / float64(job.BytesTotal) * 100
+		job.Progress = &progress
+	} else if job.Status == RestoreStatusCompleted {
+		progress := 100.0
+		job.Progress = &progress
+	}
+
+	if startedTimeStr.Valid {
+		startedTime, err := common.ParseTime(startedTimeStr.String)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing started_time: %v", err)
+		}
+		job.StartedTime = &startedTime
+	}
+
+	if completedTimeStr.Valid {
+		completedTime, err := common.ParseTime(completedTimeStr.String)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing completed_time: %v", err)
+		}
+		job.CompletedTime = &completedTime
+	}
+
+	createdAt, err := common.ParseTime(createdAtStr)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing created_at: %v", err)
+	}
+	job.CreatedAt = createdAt
+
+	updatedAt, err := common.ParseTime(updatedAtStr)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing updated_at: %v", err)
+	}
+	job.UpdatedAt = updatedAt
+
+	return job, nil
+}
+
+func formatOptionalTime(t *time.Time) *string {
+	if t == nil {
+		return nil
+	}
+	str := t.Format(time.RFC3339)
+	return &str
+}
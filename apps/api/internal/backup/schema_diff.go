@@ -0,0 +1,292 @@
+package backup
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Change kinds of a schema comparison
+const (
+	ChangeAdded     = "added"
+	ChangeRemoved   = "removed"
+	ChangeModified  = "modified"
+	ChangeUnchanged = "unchanged"
+)
+
+// SchemaDiff is the structural difference between two SQL backups. Source
+// is the older state and target the newer one.
+type SchemaDiff struct {
+	SourceBackupID string            `json:"source_backup_id"`
+	TargetBackupID string            `json:"target_backup_id"`
+	Summary        SchemaDiffSummary `json:"summary"`
+	Tables         []TableDiff       `json:"tables"`
+	Indexes        []ObjectDiff      `json:"indexes"`
+	Constraints    []ObjectDiff      `json:"constraints"`
+	Functions      []ObjectDiff      `json:"functions"`
+	Views          []ObjectDiff      `json:"views"`
+}
+
+// SchemaDiffSummary counts the changes per object type
+type SchemaDiffSummary struct {
+	Tables      ChangeCounts `json:"tables"`
+	Columns     ChangeCounts `json:"columns"`
+	Indexes     ChangeCounts `json:"indexes"`
+	Constraints ChangeCounts `json:"constraints"`
+	Functions   ChangeCounts `json:"functions"`
+	Views       ChangeCounts `json:"views"`
+	SourceRows  int64        `json:"source_rows"`
+	TargetRows  int64        `json:"target_rows"`
+}
+
+type ChangeCounts struct {
+	Added     int `json:"added"`
+	Removed   int `json:"removed"`
+	Modified  int `json:"modified"`
+	Unchanged int `json:"unchanged"`
+}
+
+func (c *ChangeCounts) count(change string) {
+	switch change {
+	case ChangeAdded:
+		c.Added++
+	case ChangeRemoved:
+		c.Removed++
+	case ChangeModified:
+		c.Modified++
+	default:
+		c.Unchanged++
+	}
+}
+
+// TableDiff describes a table that was added, removed, changed or whose
+// number of rows differs. Row counts are nil when a dump has no data for it.
+type TableDiff struct {
+	Name       string       `json:"name"`
+	Change     string       `json:"change"`
+	Columns    []ColumnDiff `json:"columns,omitempty"`
+	PrimaryKey []string     `json:"primary_key,omitempty"`
+	SourceRows *int64       `json:"source_rows,omitempty"`
+	TargetRows *int64       `json:"target_rows,omitempty"`
+	RowDelta   int64        `json:"row_delta"`
+}
+
+type ColumnDiff struct {
+	Name   string        `json:"name"`
+	Change string        `json:"change"`
+	Source *SchemaColumn `json:"source,omitempty"`
+	Target *SchemaColumn `json:"target,omitempty"`
+}
+
+// ObjectDiff is an index, constraint, function or view that differs
+type ObjectDiff struct {
+	Name   string `json:"name"`
+	Table  string `json:"table,omitempty"`
+	Change string `json:"change"`
+	Source string `json:"source,omitempty"`
+	Target string `json:"target,omitempty"`
+}
+
+// CompareSchemas parses both backups and compares their schema objects and
+// the number of rows per table.
+func (s *BackupService) CompareSchemas(sourceID, targetID string) (*SchemaDiff, error) {
+	source, err := s.loadDumpSchema(sourceID)
+	if err != nil {
+		return nil, fmt.Errorf("source backup: %w", err)
+	}
+	target, err := s.loadDumpSchema(targetID)
+	if err != nil {
+		return nil, fmt.Errorf("target backup: %w", err)
+	}
+	if source.dialect != target.dialect {
+		return nil, fmt.Errorf("backups of different database engines cannot be compared")
+	}
+
+	diff := compareSchemas(source, target)
+	diff.SourceBackupID = sourceID
+	diff.TargetBackupID = targetID
+	return diff, nil
+}
+
+func (s *BackupService) loadDumpSchema(backupID string) (*dumpSchema, error) {
+	backup, err := s.backupRepo.GetBackup(backupID)
+	if err != nil {
+		return nil, fmt.Errorf("backup not found: %v", err)
+	}
+
+	reader, dialect, err := s.openDumpSQL(backup)
+	if err != nil {
+		return nil, err
+	}
+
+	schema, err := parseDumpSchema(reader, dialect)
+	if closeErr := reader.Close(); err == nil && closeErr != nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return schema, nil
+}
+
+func compareSchemas(source, target *dumpSchema) *SchemaDiff {
+	diff := &SchemaDiff{Tables: []TableDiff{}}
+	diff.Indexes = compareObjects(source.indexes, target.indexes, &diff.Summary.Indexes)
+	diff.Constraints = compareObjects(source.constraints, target.constraints, &diff.Summary.Constraints)
+	diff.Functions = compareObjects(source.functions, target.functions, &diff.Summary.Functions)
+	diff.Views = compareObjects(source.views, target.views, &diff.Summary.Views)
+
+	for _, name := range mergedNames(source.tableNames(), target.tableNames()) {
+		before, after := source.tables[name], target.tables[name]
+		table := TableDiff{Name: name, Change: ChangeUnchanged}
+
+		switch {
+		case before == nil:
+			table.Change = ChangeAdded
+			table.PrimaryKey = after.PrimaryKey
+			for i := range after.Columns {
+				table.Columns = append(table.Columns, ColumnDiff{Name: after.Columns[i].Name, Change: ChangeAdded, Target: &after.Columns[i]})
+				diff.Summary.Columns.count(ChangeAdded)
+			}
+		case after == nil:
+			table.Change = ChangeRemoved
+			table.PrimaryKey = before.PrimaryKey
+			for i := range before.Columns {
+				table.Columns = append(table.Columns, ColumnDiff{Name: before.Columns[i].Name, Change: ChangeRemoved, Source: &before.Columns[i]})
+				diff.Summary.Columns.count(ChangeRemoved)
+			}
+		default:
+			table.PrimaryKey = after.PrimaryKey
+			table.Columns = compareColumns(before, after, &diff.Summary.Columns)
+			if len(table.Columns) > 0 || !equalStrings(before.PrimaryKey, after.PrimaryKey) {
+				table.Change = ChangeModified
+			}
+		}
+		diff.Summary.Tables.count(table.Change)
+
+		if before != nil && before.HasData {
+			rows := before.Rows
+			table.SourceRows = &rows
+			diff.Summary.SourceRows += rows
+		}
+		if after != nil && after.HasData {
+			rows := after.Rows
+			table.TargetRows = &rows
+			diff.Summary.TargetRows += rows
+		}
+		if after != nil {
+			table.RowDelta += after.Rows
+		}
+		if before != nil {
+			table.RowDelta -= before.Rows
+		}
+
+		if table.Change != ChangeUnchanged || table.RowDelta != 0 {
+			diff.Tables = append(diff.Tables, table)
+		}
+	}
+
+	return diff
+}
+
+// compareColumns returns the columns that were added, removed or changed
+func compareColumns(before, after *schemaTable, counts *ChangeCounts) []ColumnDiff {
+	var columns []ColumnDiff
+	for i := range before.Columns {
+		old := &before.Columns[i]
+		current := after.column(old.Name)
+		switch {
+		case current == nil:
+			columns = append(columns, ColumnDiff{Name: old.Name, Change: ChangeRemoved, Source: old})
+			counts.count(ChangeRemoved)
+		case !old.equal(*current):
+			columns = append(columns, ColumnDiff{Name: old.Name, Change: ChangeModified, Source: old, Target: current})
+			counts.count(ChangeModified)
+		default:
+			counts.count(ChangeUnchanged)
+		}
+	}
+	for i := range after.Columns {
+		if before.column(after.Columns[i].Name) == nil {
+			columns = append(columns, ColumnDiff{Name: after.Columns[i].Name, Change: ChangeAdded, Target: &after.Columns[i]})
+			counts.count(ChangeAdded)
+		}
+	}
+	return columns
+}
+
+// compareObjects returns the objects that were added, removed or whose
+// definition changed, sorted by key
+func compareObjects(source, target map[string]schemaObject, counts *ChangeCounts) []ObjectDiff {
+	keys := func(objects map[string]schemaObject) []string {
+		names := make([]string, 0, len(objects))
+		for key := range objects {
+			names = append(names, key)
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	diffs := []ObjectDiff{}
+	for _, key := range mergedNames(keys(source), keys(target)) {
+		before, inSource := source[key]
+		after, inTarget := target[key]
+
+		var change string
+		switch {
+		case !inSource:
+			change = ChangeAdded
+		case !inTarget:
+			change = ChangeRemoved
+		case before.Definition != after.Definition:
+			change = ChangeModified
+		default:
+			change = ChangeUnchanged
+		}
+		counts.count(change)
+		if change == ChangeUnchanged {
+			continue
+		}
+
+		object := ObjectDiff{Change: change, Source: before.Definition, Target: after.Definition}
+		if inTarget {
+			object.Name, object.Table = after.Name, after.Table
+		} else {
+			object.Name, object.Table = before.Name, before.Table
+		}
+		diffs = append(diffs, object)
+	}
+	return diffs
+}
+
+// mergedNames merges two sorted lists of names without duplicates
+func mergedNames(a, b []string) []string {
+	merged := make([]string, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case j >= len(b) || (i < len(a) && a[i] < b[j]):
+			merged = append(merged, a[i])
+			i++
+		case i >= len(a) || b[j] < a[i]:
+			merged = append(merged, b[j])
+			j++
+		default:
+			merged = append(merged, a[i])
+			i++
+			j++
+		}
+	}
+	return merged
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
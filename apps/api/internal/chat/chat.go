@@ -0,0 +1,86 @@
+// Package chat formats notifications for chat platforms and posts them
+// through their incoming webhooks or bot APIs.
+package chat
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Severity decides the color a platform shows a message with
+type Severity string
+
+const (
+	SeverityInfo    Severity = "info"
+	SeveritySuccess Severity = "success"
+	SeverityWarning Severity = "warning"
+	SeverityError   Severity = "error"
+)
+
+// maxErrorLength and maxTextLength bound the error excerpt and the text of a
+// message, chat platforms reject long messages: Slack section text over 3000
+// characters and Telegram messages over 4096
+const (
+	maxErrorLength = 500
+	maxTextLength  = 1000
+)
+
+// Field is a labelled value shown below the text of a message
+type Field struct {
+	Name  string
+	Value string
+}
+
+// Message is a notification rendered by each platform in its own format
+type Message struct {
+	Title    string
+	Text     string
+	Severity Severity
+	Fields   []Field
+	// Error is shown as a preformatted excerpt
+	Error string
+	// URL links back to the backup, empty when no public URL is configured
+	URL       string
+	Timestamp time.Time
+}
+
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+// text shortens the text of msg to maxTextLength characters
+func (m *Message) text() string {
+	return truncate(m.Text, maxTextLength)
+}
+
+// errorExcerpt shortens the error of msg to maxErrorLength characters
+func (m *Message) errorExcerpt() string {
+	runes := []rune(m.Error)
+	if len(runes) <= maxErrorLength {
+		return m.Error
+	}
+	return string(runes[:maxErrorLength]) + "…"
+}
+
+// postJSON posts payload to url and turns a non-2xx response into an error
+// carrying the start of the response body
+func postJSON(url string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode message: %w", err)
+	}
+
+	resp, err := httpClient.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("message rejected with status %d: %s", resp.StatusCode, bytes.TrimSpace(respBody))
+	}
+	return nil
+}
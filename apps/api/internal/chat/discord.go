@@ -0,0 +1,67 @@
+package chat
+
+import "time"
+
+// Discord limits, longer values are rejected
+const (
+	discordMaxFields     = 25
+	discordMaxFieldValue = 1024
+)
+
+var discordColors = map[Severity]int{
+	SeverityInfo:    0x3498db,
+	SeveritySuccess: 0x2ecc71,
+	SeverityWarning: 0xf1c40f,
+	SeverityError:   0xe74c3c,
+}
+
+// SendDiscord posts msg to a Discord webhook as an embed
+func SendDiscord(webhookURL string, msg *Message) error {
+	return postJSON(webhookURL, discordPayload(msg))
+}
+
+func discordPayload(msg *Message) map[string]interface{} {
+	var fields []map[string]interface{}
+	for i, field := range msg.Fields {
+		if i == discordMaxFields-1 {
+			break
+		}
+		fields = append(fields, map[string]interface{}{
+			"name":   field.Name,
+			"value":  truncate(field.Value, discordMaxFieldValue),
+			"inline": true,
+		})
+	}
+	if msg.Error != "" {
+		fields = append(fields, map[string]interface{}{
+			"name":  "Error",
+			"value": "```" + msg.errorExcerpt() + "```",
+		})
+	}
+
+	embed := map[string]interface{}{
+		"title":       msg.Title,
+		"description": msg.text(),
+		"color":       discordColors[msg.Severity],
+		"fields":      fields,
+	}
+	if msg.URL != "" {
+		embed["url"] = msg.URL
+	}
+	if !msg.Timestamp.IsZero() {
+		embed["timestamp"] = msg.Timestamp.UTC().Format(time.RFC3339)
+	}
+
+	return map[string]interface{}{
+		"username": "Velld",
+		"embeds":   []map[string]interface{}{embed},
+	}
+}
+
+func truncate(s string, max int) string {
+	runes := []rune(s)
+	if len(runes) <= max {
+		return s
+	}
+	return string(runes[:max-1]) + "…"
+}
@@ -0,0 +1,87 @@
+package chat
+
+import (
+	"fmt"
+	"strings"
+)
+
+// slackMaxFields is the number of fields Slack accepts in a section block
+const slackMaxFields = 10
+
+var slackEmoji = map[Severity]string{
+	SeverityInfo:    ":information_source:",
+	SeveritySuccess: ":white_check_mark:",
+	SeverityWarning: ":warning:",
+	SeverityError:   ":rotating_light:",
+}
+
+// SendSlack posts msg to a Slack incoming webhook using Block Kit
+func SendSlack(webhookURL string, msg *Message) error {
+	return postJSON(webhookURL, slackPayload(msg))
+}
+
+func slackPayload(msg *Message) map[string]interface{} {
+	blocks := []map[string]interface{}{
+		{
+			"type": "header",
+			"text": map[string]interface{}{"type": "plain_text", "text": msg.Title, "emoji": true},
+		},
+		{
+			"type": "section",
+			"text": map[string]interface{}{"type": "mrkdwn", "text": fmt.Sprintf("%s %s", slackEmoji[msg.Severity], slackEscape(msg.text()))},
+		},
+	}
+
+	if len(msg.Fields) > 0 {
+		var fields []map[string]interface{}
+		for i, field := range msg.Fields {
+			if i == slackMaxFields {
+				break
+			}
+			fields = append(fields, map[string]interface{}{
+				"type": "mrkdwn",
+				"text": fmt.Sprintf("*%s*\n%s", slackEscape(field.Name), slackEscape(field.Value)),
+			})
+		}
+		blocks = append(blocks, map[string]interface{}{"type": "section", "fields": fields})
+	}
+
+	if msg.Error != "" {
+		blocks = append(blocks, map[string]interface{}{
+			"type": "section",
+			"text": map[string]interface{}{"type": "mrkdwn", "text": "```" + slackEscape(msg.errorExcerpt()) + "```"},
+		})
+	}
+
+	if msg.URL != "" {
+		blocks = append(blocks, map[string]interface{}{
+			"type": "actions",
+			"elements": []map[string]interface{}{{
+				"type": "button",
+				"text": map[string]interface{}{"type": "plain_text", "text": "View backup"},
+				"url":  msg.URL,
+			}},
+		})
+	}
+
+	if !msg.Timestamp.IsZero() {
+		blocks = append(blocks, map[string]interface{}{
+			"type": "context",
+			"elements": []map[string]interface{}{{
+				"type": "mrkdwn",
+				"text": fmt.Sprintf("<!date^%d^{date_short_pretty} {time}|%s>", msg.Timestamp.Unix(), msg.Timestamp.UTC().Format("2006-01-02 15:04 MST")),
+			}},
+		})
+	}
+
+	return map[string]interface{}{
+		// text is shown in push notifications and clients without blocks
+		"text":   msg.Title + ": " + msg.text(),
+		"blocks": blocks,
+	}
+}
+
+// slackEscape escapes the characters Slack treats as control sequences
+func slackEscape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
@@ -0,0 +1,84 @@
+package chat
+
+import "time"
+
+var teamsColors = map[Severity]string{
+	SeverityInfo:    "accent",
+	SeveritySuccess: "good",
+	SeverityWarning: "warning",
+	SeverityError:   "attention",
+}
+
+// SendTeams posts msg to a Microsoft Teams incoming webhook or workflow as
+// an adaptive card
+func SendTeams(webhookURL string, msg *Message) error {
+	return postJSON(webhookURL, teamsPayload(msg))
+}
+
+func teamsPayload(msg *Message) map[string]interface{} {
+	body := []map[string]interface{}{
+		{
+			"type":   "TextBlock",
+			"text":   msg.Title,
+			"size":   "Large",
+			"weight": "Bolder",
+			"color":  teamsColors[msg.Severity],
+			"wrap":   true,
+		},
+		{
+			"type": "TextBlock",
+			"text": msg.text(),
+			"wrap": true,
+		},
+	}
+
+	if len(msg.Fields) > 0 {
+		var facts []map[string]interface{}
+		for _, field := range msg.Fields {
+			facts = append(facts, map[string]interface{}{"title": field.Name, "value": field.Value})
+		}
+		body = append(body, map[string]interface{}{"type": "FactSet", "facts": facts})
+	}
+
+	if msg.Error != "" {
+		body = append(body, map[string]interface{}{
+			"type":     "TextBlock",
+			"text":     msg.errorExcerpt(),
+			"fontType": "Monospace",
+			"color":    "attention",
+			"wrap":     true,
+		})
+	}
+
+	if !msg.Timestamp.IsZero() {
+		body = append(body, map[string]interface{}{
+			"type":     "TextBlock",
+			"text":     "{{DATE(" + msg.Timestamp.UTC().Format(time.RFC3339) + ", SHORT)}} {{TIME(" + msg.Timestamp.UTC().Format(time.RFC3339) + ")}}",
+			"isSubtle": true,
+			"size":     "Small",
+		})
+	}
+
+	card := map[string]interface{}{
+		"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+		"type":    "AdaptiveCard",
+		"version": "1.4",
+		"body":    body,
+	}
+	if msg.URL != "" {
+		card["actions"] = []map[string]interface{}{{
+			"type":  "Action.OpenUrl",
+			"title": "View backup",
+			"url":   msg.URL,
+		}}
+	}
+
+	return map[string]interface{}{
+		"type": "message",
+		"attachments": []map[string]interface{}{{
+			"contentType": "application/vnd.microsoft.card.adaptive",
+			"contentUrl":  nil,
+			"content":     card,
+		}},
+	}
+}
@@ -0,0 +1,49 @@
+package chat
+
+import (
+	"fmt"
+	"html"
+	"strings"
+)
+
+// telegramAPIURL is the Bot API endpoint, the token is appended to it
+var telegramAPIURL = "https://api.telegram.org/bot"
+
+var telegramEmoji = map[Severity]string{
+	SeverityInfo:    "ℹ️",
+	SeveritySuccess: "✅",
+	SeverityWarning: "⚠️",
+	SeverityError:   "🚨",
+}
+
+// SendTelegram sends msg to a chat through the Telegram Bot API
+func SendTelegram(botToken, chatID string, msg *Message) error {
+	if botToken == "" || chatID == "" {
+		return fmt.Errorf("telegram bot token and chat ID are required")
+	}
+	return postJSON(telegramAPIURL+botToken+"/sendMessage", telegramPayload(chatID, msg))
+}
+
+func telegramPayload(chatID string, msg *Message) map[string]interface{} {
+	var text strings.Builder
+	fmt.Fprintf(&text, "%s <b>%s</b>\n%s\n", telegramEmoji[msg.Severity], html.EscapeString(msg.Title), html.EscapeString(msg.text()))
+	if len(msg.Fields) > 0 {
+		text.WriteString("\n")
+		for _, field := range msg.Fields {
+			fmt.Fprintf(&text, "<b>%s:</b> %s\n", html.EscapeString(field.Name), html.EscapeString(field.Value))
+		}
+	}
+	if msg.Error != "" {
+		fmt.Fprintf(&text, "\n<pre>%s</pre>\n", html.EscapeString(msg.errorExcerpt()))
+	}
+	if msg.URL != "" {
+		fmt.Fprintf(&text, "\n<a href=\"%s\">View backup</a>", html.EscapeString(msg.URL))
+	}
+
+	return map[string]interface{}{
+		"chat_id":                  chatID,
+		"text":                     text.String(),
+		"parse_mode":               "HTML",
+		"disable_web_page_preview": true,
+	}
+}
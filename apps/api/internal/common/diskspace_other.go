@@ -0,0 +1,13 @@
+//go:build !linux && !darwin && !freebsd && !dragonfly && !windows
+
+package common
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// FreeDiskSpace is not available on this platform.
+func FreeDiskSpace(path string) (int64, error) {
+	return 0, fmt.Errorf("free disk space is not supported on %s", runtime.GOOS)
+}
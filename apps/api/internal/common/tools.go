@@ -0,0 +1,298 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DumpToolNames maps a database type to the client tool used to back it up.
+var DumpToolNames = map[string]string{
+	"postgresql": "pg_dump",
+	"mysql":      "mysqldump",
+	"mariadb":    "mysqldump",
+	"mongodb":    "mongodump",
+	"redis":      "redis-cli",
+}
+
+// Version is a parsed database server or client tool version.
+type Version struct {
+	Raw    string `json:"raw"`
+	Major  int    `json:"major"`
+	Minor  int    `json:"minor"`
+	Flavor string `json:"flavor,omitempty"` // "mariadb" for MariaDB servers and tools
+}
+
+// ToolInstall is a client tool binary found on this host.
+type ToolInstall struct {
+	Tool    string  `json:"tool"`
+	Dir     string  `json:"dir"`
+	Path    string  `json:"path"`
+	Version Version `json:"version"`
+}
+
+type Compatibility string
+
+const (
+	CompatibilityOK           Compatibility = "ok"
+	CompatibilityWarning      Compatibility = "warning"
+	CompatibilityIncompatible Compatibility = "incompatible"
+	CompatibilityUnknown      Compatibility = "unknown"
+)
+
+// ToolCompatibility describes how well a tool install fits a server.
+type ToolCompatibility struct {
+	Tool    string        `json:"tool"`
+	Status  Compatibility `json:"status"`
+	Message string        `json:"message,omitempty"`
+	Install *ToolInstall  `json:"install,omitempty"`
+}
+
+var (
+	versionPattern = regexp.MustCompile(`(\d+)\.(\d+)(?:\.(\d+))?`)
+	distribPattern = regexp.MustCompile(`(?:Distrib|from)\s+(\d+\.\d+(?:\.\d+)?)`)
+)
+
+// ParseVersion extracts the version from server strings such as
+// "16.2 (Debian 16.2-1)" or "10.11.6-MariaDB" and from the --version output
+// of the client tools.
+func ParseVersion(text string) (Version, bool) {
+	v := Version{Raw: strings.TrimSpace(text)}
+	if strings.Contains(strings.ToLower(text), "mariadb") {
+		v.Flavor = "mariadb"
+	}
+
+	// mysqldump reports its own client version first ("Ver 10.13 Distrib
+	// 5.7.44" or "Ver 10.19 Distrib 10.11.6-MariaDB"), the release it ships
+	// with is what matters
+	match := versionPattern.FindString(text)
+	if m := distribPattern.FindStringSubmatch(text); m != nil {
+		match = m[1]
+	}
+	if match == "" {
+		return v, false
+	}
+
+	parts := versionPattern.FindStringSubmatch(match)
+	v.Major, _ = strconv.Atoi(parts[1])
+	v.Minor, _ = strconv.Atoi(parts[2])
+	return v, true
+}
+
+func (v Version) String() string {
+	if v.Flavor != "" {
+		return fmt.Sprintf("%d.%d (%s)", v.Major, v.Minor, v.Flavor)
+	}
+	return fmt.Sprintf("%d.%d", v.Major, v.Minor)
+}
+
+// FindBinaryPaths returns every directory holding toolName, in search order.
+func FindBinaryPaths(toolName string) []string {
+	execName := GetPlatformExecutableName(toolName)
+	seen := make(map[string]bool)
+	var dirs []string
+
+	add := func(dir string) {
+		resolved, err := filepath.EvalSymlinks(filepath.Join(dir, execName))
+		if err != nil || seen[resolved] {
+			return
+		}
+		seen[resolved] = true
+		dirs = append(dirs, dir)
+	}
+
+	if paths, ok := CommonBinaryPaths[runtime.GOOS]; ok {
+		for _, pathPattern := range paths {
+			matches, _ := filepath.Glob(pathPattern)
+			for _, path := range matches {
+				if _, err := os.Stat(filepath.Join(path, execName)); err == nil {
+					add(path)
+				}
+			}
+		}
+	}
+
+	if path, err := exec.LookPath(execName); err == nil {
+		add(filepath.Dir(path))
+	}
+
+	return dirs
+}
+
+type toolVersionCacheEntry struct {
+	modTime time.Time
+	version Version
+	ok      bool
+}
+
+var toolVersionCache sync.Map // map[path]toolVersionCacheEntry
+
+// DetectToolInstalls runs "<tool> --version" for every install of toolName
+// and returns those whose version could be determined, newest first.
+func DetectToolInstalls(toolName string) []ToolInstall {
+	execName := GetPlatformExecutableName(toolName)
+	var installs []ToolInstall
+
+	for _, dir := range FindBinaryPaths(toolName) {
+		path := filepath.Join(dir, execName)
+		version, ok := detectToolVersion(path)
+		if !ok {
+			continue
+		}
+		installs = append(installs, ToolInstall{
+			Tool:    toolName,
+			Dir:     dir,
+			Path:    path,
+			Version: version,
+		})
+	}
+
+	sort.SliceStable(installs, func(i, j int) bool {
+		a, b := installs[i].Version, installs[j].Version
+		if a.Major != b.Major {
+			return a.Major > b.Major
+		}
+		return a.Minor > b.Minor
+	})
+
+	return installs
+}
+
+func detectToolVersion(path string) (Version, bool) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return Version{}, false
+	}
+
+	if cached, ok := toolVersionCache.Load(path); ok {
+		entry := cached.(toolVersionCacheEntry)
+		if entry.modTime.Equal(info.ModTime()) {
+			return entry.version, entry.ok
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	output, err := exec.CommandContext(ctx, path, "--version").CombinedOutput()
+	var version Version
+	ok := false
+	if err == nil {
+		version, ok = ParseVersion(string(output))
+	}
+
+	toolVersionCache.Store(path, toolVersionCacheEntry{modTime: info.ModTime(), version: version, ok: ok})
+	return version, ok
+}
+
+// CheckToolCompatibility decides whether a client tool can work against a
+// server of the given type and version.
+func CheckToolCompatibility(dbType string, tool Version, server Version) (Compatibility, string) {
+	switch dbType {
+	case "postgresql":
+		// pg_dump refuses to dump servers newer than itself, older ones are fine
+		if tool.Major < server.Major {
+			return CompatibilityIncompatible, fmt.Sprintf(
+				"client version %d is older than PostgreSQL server %d; install PostgreSQL %d client tools or newer",
+				tool.Major, server.Major, server.Major)
+		}
+	case "mysql", "mariadb":
+		if tool.Flavor != server.Flavor {
+			serverFlavor, toolFlavor := "MySQL", "MySQL"
+			if server.Flavor == "mariadb" {
+				serverFlavor = "MariaDB"
+			}
+			if tool.Flavor == "mariadb" {
+				toolFlavor = "MariaDB"
+			}
+			return CompatibilityWarning, fmt.Sprintf(
+				"%s client tools are used against a %s server; some options may not be supported", toolFlavor, serverFlavor)
+		}
+		if tool.Major < server.Major {
+			return CompatibilityWarning, fmt.Sprintf(
+				"client version %s is older than server %s", tool.String(), server.String())
+		}
+	case "mongodb":
+		// The Database Tools are versioned separately (100.x) since MongoDB 4.4
+		if tool.Major < 100 && server.Major >= 4 && (server.Major > 4 || server.Minor >= 4) {
+			return CompatibilityWarning, fmt.Sprintf(
+				"legacy mongodump %s may not support MongoDB %s; install MongoDB Database Tools 100.x", tool.String(), server.String())
+		}
+	case "redis":
+		if tool.Major < server.Major {
+			return CompatibilityWarning, fmt.Sprintf(
+				"redis-cli %s is older than server %s", tool.String(), server.String())
+		}
+	}
+
+	return CompatibilityOK, ""
+}
+
+// SelectToolInstall picks the install of toolName that best fits the server.
+// PostgreSQL prefers the oldest client that is still new enough, so that a
+// dump matches the server release as closely as possible; other engines
+// prefer a compatible install of the same flavor, newest first.
+func SelectToolInstall(dbType, toolName, serverVersion string) (*ToolInstall, ToolCompatibility) {
+	result := ToolCompatibility{Tool: toolName, Status: CompatibilityUnknown}
+
+	installs := DetectToolInstalls(toolName)
+	if len(installs) == 0 {
+		result.Status = CompatibilityIncompatible
+		result.Message = fmt.Sprintf("%s was not found on this host", toolName)
+		return nil, result
+	}
+
+	server, ok := ParseVersion(serverVersion)
+	if serverVersion == "" || !ok {
+		install := installs[0]
+		result.Install = &install
+		result.Message = "server version unknown"
+		return &install, result
+	}
+
+	var best *ToolInstall
+	bestStatus := CompatibilityIncompatible
+	bestMessage := ""
+
+	for i := len(installs) - 1; i >= 0; i-- {
+		install := installs[i]
+		status, message := CheckToolCompatibility(dbType, install.Version, server)
+		better := best == nil || rankCompatibility(status) > rankCompatibility(bestStatus)
+		if !better && status == bestStatus && dbType != "postgresql" {
+			// Iterating oldest to newest, so a later equal match is newer
+			better = true
+		}
+		if better {
+			best = &installs[i]
+			bestStatus = status
+			bestMessage = message
+		}
+	}
+
+	result.Install = best
+	result.Status = bestStatus
+	result.Message = bestMessage
+	return best, result
+}
+
+func rankCompatibility(c Compatibility) int {
+	switch c {
+	case CompatibilityOK:
+		return 3
+	case CompatibilityWarning:
+		return 2
+	case CompatibilityUnknown:
+		return 1
+	default:
+		return 0
+	}
+}
@@ -61,12 +61,17 @@ var CommonBinaryPaths = map[string][]string{
 		"/usr/local/bin",
 		"/opt/postgresql*/bin",
 		"/opt/mysql*/bin",
+		"/usr/lib/postgresql/*/bin",
+		"/usr/pgsql-*/bin",
+		"/usr/libexec/postgresql*",
 	},
 	"darwin": {
 		"/opt/homebrew/bin",
 		"/usr/local/bin",
 		"/opt/homebrew/opt/postgresql@*/bin",
 		"/opt/homebrew/opt/mysql@*/bin",
+		"/opt/homebrew/opt/libpq/bin",
+		"/Applications/Postgres.app/Contents/Versions/*/bin",
 	},
 }
 
@@ -128,3 +133,15 @@ func SanitizeConnectionName(name string) string {
 
 	return sanitized
 }
+
+// ScrubSecrets masks every occurrence of the given secrets in text so tool
+// output can be logged or stored without leaking credentials.
+func ScrubSecrets(text string, secrets ...string) string {
+	for _, secret := range secrets {
+		if secret == "" {
+			continue
+		}
+		text = strings.ReplaceAll(text, secret, "******")
+	}
+	return text
+}
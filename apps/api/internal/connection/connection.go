@@ -6,6 +6,7 @@ import (
 
 	"github.com/dendianugerah/velld/internal/common"
 	"github.com/dendianugerah/velld/internal/common/response"
+	"github.com/dendianugerah/velld/internal/incident"
 	"github.com/gorilla/mux"
 )
 
@@ -26,7 +27,7 @@ func (h *ConnectionHandler) TestConnection(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	isConnected, err := h.service.TestConnection(config)
+	result, err := h.service.TestConnection(config)
 	if err != nil {
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"isConnected": false,
@@ -36,11 +37,35 @@ func (h *ConnectionHandler) TestConnection(w http.ResponseWriter, r *http.Reques
 	}
 
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"isConnected": isConnected,
-		"lastSync":    "",
+		"isConnected":       true,
+		"lastSync":          "",
+		"serverVersion":     result.ServerVersion,
+		"compatible":        result.Compatible,
+		"toolCompatibility": result.ToolCompatibility,
 	})
 }
 
+// TestIncident sends a test alert through an incident integration before
+// it is saved, and resolves it again
+func (h *ConnectionHandler) TestIncident(w http.ResponseWriter, r *http.Request) {
+	var integration incident.Integration
+	if err := json.NewDecoder(r.Body).Decode(&integration); err != nil {
+		response.SendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := incident.SendTest(integration); err != nil {
+		response.SendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	response.SendSuccess(w, "Test alert sent successfully", nil)
+}
+
+func (h *ConnectionHandler) ListTools(w http.ResponseWriter, r *http.Request) {
+	response.SendSuccess(w, "Client tools retrieved successfully", h.service.ListToolInstalls())
+}
+
 func (h *ConnectionHandler) SaveConnection(w http.ResponseWriter, r *http.Request) {
 	var config ConnectionConfig
 	if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
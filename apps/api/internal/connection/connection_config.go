@@ -5,6 +5,7 @@ import (
 	"crypto/tls"
 	"database/sql"
 	"fmt"
+	"strings"
 
 	"github.com/go-sql-driver/mysql"
 	"github.com/lib/pq"
@@ -92,14 +93,7 @@ func (cm *ConnectionManager) connectWithSSH(config ConnectionConfig) error {
 }
 
 func (cm *ConnectionManager) connectMySQL(config ConnectionConfig) error {
-	sslMode := "false"
-	if config.SSL {
-		sslMode = "true"
-	}
-	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?tls=%s",
-		config.Username, config.Password, config.Host, config.Port, config.Database, sslMode)
-
-	db, err := sql.Open("mysql", dsn)
+	db, err := sql.Open("mysql", mysqlDSN(config))
 	if err != nil {
 		return err
 	}
@@ -113,14 +107,7 @@ func (cm *ConnectionManager) connectMySQL(config ConnectionConfig) error {
 }
 
 func (cm *ConnectionManager) connectPostgres(config ConnectionConfig) error {
-	sslMode := "disable"
-	if config.SSL {
-		sslMode = "require"
-	}
-	dsn := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
-		config.Host, config.Port, config.Username, config.Password, config.Database, sslMode)
-
-	db, err := sql.Open("postgres", dsn)
+	db, err := sql.Open("postgres", postgresDSN(config))
 	if err != nil {
 		return err
 	}
@@ -133,12 +120,32 @@ func (cm *ConnectionManager) connectPostgres(config ConnectionConfig) error {
 	return nil
 }
 
-func (cm *ConnectionManager) connectMongoDB(config ConnectionConfig) error {
-	ctx := context.Background()
-	uri := fmt.Sprintf("mongodb://%s:%s@%s:%d/%s",
+func mysqlDSN(config ConnectionConfig) string {
+	sslMode := "false"
+	if config.SSL {
+		sslMode = "true"
+	}
+	return fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?tls=%s",
+		config.Username, config.Password, config.Host, config.Port, config.Database, sslMode)
+}
+
+func postgresDSN(config ConnectionConfig) string {
+	sslMode := "disable"
+	if config.SSL {
+		sslMode = "require"
+	}
+	return fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
+		config.Host, config.Port, config.Username, config.Password, config.Database, sslMode)
+}
+
+func mongoURI(config ConnectionConfig) string {
+	return fmt.Sprintf("mongodb://%s:%s@%s:%d/%s",
 		config.Username, config.Password, config.Host, config.Port, config.Database)
+}
 
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+func (cm *ConnectionManager) connectMongoDB(config ConnectionConfig) error {
+	ctx := context.Background()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(mongoURI(config)))
 	if err != nil {
 		return err
 	}
@@ -202,6 +209,76 @@ func (cm *ConnectionManager) Disconnect(id string) error {
 	}
 }
 
+// GetServerVersion returns the version string reported by the server.
+func (cm *ConnectionManager) GetServerVersion(id string) (string, error) {
+	conn, exists := cm.connections[id]
+	if !exists {
+		return "", fmt.Errorf("connection not found: %s", id)
+	}
+
+	switch c := conn.(type) {
+	case *sql.DB:
+		return cm.getSQLServerVersion(c)
+	case *mongo.Client:
+		return cm.getMongoDBServerVersion(c)
+	case *redis.Client:
+		return cm.getRedisServerVersion(c)
+	default:
+		return "", fmt.Errorf("unknown connection type for id: %s", id)
+	}
+}
+
+func (cm *ConnectionManager) getSQLServerVersion(db *sql.DB) (string, error) {
+	var query string
+
+	switch db.Driver().(type) {
+	case *pq.Driver:
+		query = "SHOW server_version"
+	case *mysql.MySQLDriver:
+		query = "SELECT VERSION()"
+	case *sqlite3.SQLiteDriver:
+		query = "SELECT sqlite_version()"
+	default:
+		return "", fmt.Errorf("unsupported database type for version detection")
+	}
+
+	var version string
+	err := db.QueryRow(query).Scan(&version)
+	return version, err
+}
+
+func (cm *ConnectionManager) getMongoDBServerVersion(client *mongo.Client) (string, error) {
+	ctx := context.Background()
+	result := client.Database("admin").RunCommand(ctx, bson.D{
+		{Key: "buildInfo", Value: 1},
+	})
+
+	var info bson.M
+	if err := result.Decode(&info); err != nil {
+		return "", err
+	}
+
+	version, _ := info["version"].(string)
+	return version, nil
+}
+
+func (cm *ConnectionManager) getRedisServerVersion(client *redis.Client) (string, error) {
+	ctx := context.Background()
+
+	info, err := client.Info(ctx, "server").Result()
+	if err != nil {
+		return "", fmt.Errorf("failed to get Redis server info: %w", err)
+	}
+
+	for _, line := range strings.Split(info, "\n") {
+		if strings.HasPrefix(line, "redis_version:") {
+			return strings.TrimSpace(strings.TrimPrefix(line, "redis_version:")), nil
+		}
+	}
+
+	return "", nil
+}
+
 func (cm *ConnectionManager) GetDatabaseSize(id string) (int64, error) {
 	conn, exists := cm.connections[id]
 	if !exists {
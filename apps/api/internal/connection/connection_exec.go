@@ -0,0 +1,63 @@
+package connection
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// ExecStatement runs a single SQL statement against a stored connection and
+// returns the number of affected rows. Host and port are used as given, so
+// callers that tunnel over SSH pass the rewritten connection.
+func ExecStatement(ctx context.Context, conn *StoredConnection, statement string) (int64, error) {
+	db, err := openSQL(conn.Type, conn.ToConfig())
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	result, err := db.ExecContext(ctx, statement)
+	if err != nil {
+		return 0, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return 0, nil
+	}
+	return affected, nil
+}
+
+func openSQL(dbType string, config ConnectionConfig) (*sql.DB, error) {
+	switch dbType {
+	case "postgresql":
+		return sql.Open("postgres", postgresDSN(config))
+	case "mysql", "mariadb":
+		return sql.Open("mysql", mysqlDSN(config))
+	default:
+		return nil, fmt.Errorf("sql statements are not supported for %s connections", dbType)
+	}
+}
+
+// ToConfig converts a stored connection back into the config used to connect.
+func (c *StoredConnection) ToConfig() ConnectionConfig {
+	return ConnectionConfig{
+		ID:            c.ID,
+		Name:          c.Name,
+		Type:          c.Type,
+		Host:          c.Host,
+		Port:          c.Port,
+		Username:      c.Username,
+		Password:      c.Password,
+		Database:      c.DatabaseName,
+		SSL:           c.SSL,
+		SSHEnabled:    c.SSHEnabled,
+		SSHHost:       c.SSHHost,
+		SSHPort:       c.SSHPort,
+		SSHUsername:   c.SSHUsername,
+		SSHPassword:   c.SSHPassword,
+		SSHPrivateKey: c.SSHPrivateKey,
+		DumpOptions:   c.DumpOptions,
+		Hooks:         c.Hooks,
+	}
+}
@@ -2,8 +2,13 @@ package connection
 
 import (
 	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
 
 	"github.com/dendianugerah/velld/internal/common"
+	"github.com/dendianugerah/velld/internal/incident"
+	"github.com/dendianugerah/velld/internal/notification"
 	"github.com/google/uuid"
 )
 
@@ -56,14 +61,42 @@ func (r *ConnectionRepository) Save(conn StoredConnection) error {
 		sshEnabledInt = 1
 	}
 
+	dumpOptions, err := MarshalDumpOptions(conn.DumpOptions)
+	if err != nil {
+		return err
+	}
+
+	hooks, err := MarshalHooks(conn.Hooks)
+	if err != nil {
+		return err
+	}
+
+	notificationEvents, err := notification.MarshalEventSubscriptions(conn.NotificationEvents)
+	if err != nil {
+		return err
+	}
+
+	incidents, err := r.marshalIncidents(conn.Incidents)
+	if err != nil {
+		return err
+	}
+
+	tags, err := MarshalTags(conn.Tags)
+	if err != nil {
+		return err
+	}
+
 	query := `
 		INSERT INTO connections (
 			id, name, type, host, port, username, password, 
 			database_name, ssl, database_size, created_at, updated_at, 
 			last_connected_at, user_id, status, ssh_enabled, ssh_host, 
-			ssh_port, ssh_username, ssh_password, ssh_private_key
+			ssh_port, ssh_username, ssh_password, ssh_private_key, dump_options,
+			server_version, hooks, rpo_minutes, notification_events, incident_integrations,
+			tags, environment
 		) VALUES (
-			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21
+			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24, $25, $26, $27,
+			$28, $29
 		)`
 
 	_, err = r.db.Exec(
@@ -89,6 +122,14 @@ func (r *ConnectionRepository) Save(conn StoredConnection) error {
 		conn.SSHUsername,
 		sshPassword,
 		sshPrivateKey,
+		dumpOptions,
+		conn.ServerVersion,
+		hooks,
+		conn.RPOMinutes,
+		notificationEvents,
+		incidents,
+		tags,
+		conn.Environment,
 	)
 
 	return err
@@ -98,12 +139,17 @@ func (r *ConnectionRepository) GetConnection(id string) (*StoredConnection, erro
 	var conn StoredConnection
 	var encryptedUsername, encryptedPassword string
 	var encryptedSSHPassword, encryptedSSHPrivateKey sql.NullString
+	var dumpOptions, serverVersion, hooks, notificationEvents, incidents, incidentTriggeredAt sql.NullString
+	var tags, environment sql.NullString
+	var rpoMinutes sql.NullInt64
 	var sslInt, sshEnabledInt int
 
 	query := `SELECT 
 		id, name, type, host, port, username, password, database_name, ssl, 
 		database_size, created_at, updated_at, last_connected_at, user_id, status,
-		ssh_enabled, ssh_host, ssh_port, ssh_username, ssh_password, ssh_private_key
+		ssh_enabled, ssh_host, ssh_port, ssh_username, ssh_password, ssh_private_key,
+		dump_options, server_version, hooks, rpo_minutes, notification_events,
+		incident_integrations, incident_triggered_at, tags, environment
 	FROM connections WHERE id = $1`
 
 	err := r.db.QueryRow(query, id).Scan(
@@ -128,6 +174,15 @@ func (r *ConnectionRepository) GetConnection(id string) (*StoredConnection, erro
 		&conn.SSHUsername,
 		&encryptedSSHPassword,
 		&encryptedSSHPrivateKey,
+		&dumpOptions,
+		&serverVersion,
+		&hooks,
+		&rpoMinutes,
+		&notificationEvents,
+		&incidents,
+		&incidentTriggeredAt,
+		&tags,
+		&environment,
 	)
 	if err != nil {
 		return nil, err
@@ -135,6 +190,42 @@ func (r *ConnectionRepository) GetConnection(id string) (*StoredConnection, erro
 
 	conn.SSL = sslInt != 0
 	conn.SSHEnabled = sshEnabledInt != 0
+	conn.ServerVersion = serverVersion.String
+	conn.RPOMinutes = int(rpoMinutes.Int64)
+
+	conn.DumpOptions, err = UnmarshalDumpOptions(dumpOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	conn.Hooks, err = UnmarshalHooks(hooks)
+	if err != nil {
+		return nil, err
+	}
+
+	conn.NotificationEvents, err = notification.UnmarshalEventSubscriptions(notificationEvents)
+	if err != nil {
+		return nil, err
+	}
+
+	conn.Incidents, err = r.unmarshalIncidents(incidents)
+	if err != nil {
+		return nil, err
+	}
+
+	conn.Tags, err = UnmarshalTags(tags)
+	if err != nil {
+		return nil, err
+	}
+	conn.Environment = environment.String
+
+	if incidentTriggeredAt.Valid {
+		triggeredAt, err := common.ParseTime(incidentTriggeredAt.String)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing incident_triggered_at: %v", err)
+		}
+		conn.IncidentTriggeredAt = &triggeredAt
+	}
 
 	conn.Username, err = r.crypto.Decrypt(encryptedUsername)
 	if err != nil {
@@ -163,6 +254,8 @@ func (r *ConnectionRepository) GetConnection(id string) (*StoredConnection, erro
 	return &conn, nil
 }
 
+// Update stores conn. Changing its recovery point objective clears the
+// recorded breach, so that a breach of the new objective is reported.
 func (r *ConnectionRepository) Update(conn StoredConnection) error {
 	username, err := r.crypto.Encrypt(conn.Username)
 	if err != nil {
@@ -200,14 +293,43 @@ func (r *ConnectionRepository) Update(conn StoredConnection) error {
 		sshEnabledInt = 1
 	}
 
+	dumpOptions, err := MarshalDumpOptions(conn.DumpOptions)
+	if err != nil {
+		return err
+	}
+
+	hooks, err := MarshalHooks(conn.Hooks)
+	if err != nil {
+		return err
+	}
+
+	notificationEvents, err := notification.MarshalEventSubscriptions(conn.NotificationEvents)
+	if err != nil {
+		return err
+	}
+
+	incidents, err := r.marshalIncidents(conn.Incidents)
+	if err != nil {
+		return err
+	}
+
+	tags, err := MarshalTags(conn.Tags)
+	if err != nil {
+		return err
+	}
+
 	query := `
 		UPDATE connections SET 
 			name = $1, type = $2, host = $3, port = $4, 
 			username = $5, password = $6, database_name = $7, 
 			ssl = $8, ssh_enabled = $9, ssh_host = $10, ssh_port = $11,
 			ssh_username = $12, ssh_password = $13, ssh_private_key = $14,
-			database_size = $15, updated_at = CURRENT_TIMESTAMP
-		WHERE id = $16`
+			database_size = $15, dump_options = $16, server_version = $17,
+			hooks = $18, rpo_minutes = $19, notification_events = $20,
+			rpo_breached_at = CASE WHEN rpo_minutes = $19 THEN rpo_breached_at ELSE NULL END,
+			incident_integrations = $21, tags = $22, environment = $23,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $24`
 
 	_, err = r.db.Exec(
 		query,
@@ -226,6 +348,14 @@ func (r *ConnectionRepository) Update(conn StoredConnection) error {
 		sshPassword,
 		sshPrivateKey,
 		conn.DatabaseSize,
+		dumpOptions,
+		conn.ServerVersion,
+		hooks,
+		conn.RPOMinutes,
+		notificationEvents,
+		incidents,
+		tags,
+		conn.Environment,
 		conn.ID,
 	)
 
@@ -244,9 +374,18 @@ func (r *ConnectionRepository) ListByUserID(userID uuid.UUID) ([]ConnectionListI
 			b.completed_time as last_backup_time,
 			COALESCE(bs.enabled, false) as backup_enabled,
 			bs.cron_schedule,
-			bs.retention_days
+			bs.retention_days,
+			(SELECT COUNT(*) FROM backup_schedules WHERE connection_id = c.id AND enabled = true) as schedule_count,
+			c.tags,
+			c.environment
 		FROM connections c
-		LEFT JOIN backup_schedules bs ON c.id = bs.connection_id AND bs.enabled = true
+		LEFT JOIN backup_schedules bs ON bs.id = (
+			SELECT id
+			FROM backup_schedules
+			WHERE connection_id = c.id AND enabled = true
+			ORDER BY created_at DESC
+			LIMIT 1
+		)
 		LEFT JOIN backups b ON c.id = b.connection_id
 			AND b.completed_time = (
 				SELECT MAX(completed_time)
@@ -254,7 +393,7 @@ func (r *ConnectionRepository) ListByUserID(userID uuid.UUID) ([]ConnectionListI
 				WHERE connection_id = c.id
 			)
 		WHERE c.user_id = $1
-		GROUP BY c.id, c.name, c.type, c.host, c.status, c.database_size, b.completed_time, bs.enabled, bs.cron_schedule, bs.retention_days
+		GROUP BY c.id, c.name, c.type, c.host, c.status, c.database_size, b.completed_time, bs.enabled, bs.cron_schedule, bs.retention_days, c.tags, c.environment
 	`
 
 	rows, err := r.db.Query(query, userID)
@@ -269,6 +408,7 @@ func (r *ConnectionRepository) ListByUserID(userID uuid.UUID) ([]ConnectionListI
 		var lastBackupTime sql.NullString
 		var cronSchedule sql.NullString
 		var retentionDays sql.NullInt64
+		var tags, environment sql.NullString
 
 		err := rows.Scan(
 			&conn.ID,
@@ -281,11 +421,20 @@ func (r *ConnectionRepository) ListByUserID(userID uuid.UUID) ([]ConnectionListI
 			&conn.BackupEnabled,
 			&cronSchedule,
 			&retentionDays,
+			&conn.ScheduleCount,
+			&tags,
+			&environment,
 		)
 		if err != nil {
 			return nil, err
 		}
 
+		conn.Tags, err = UnmarshalTags(tags)
+		if err != nil {
+			return nil, err
+		}
+		conn.Environment = environment.String
+
 		if lastBackupTime.Valid {
 			conn.LastBackupTime = &lastBackupTime.String
 		}
@@ -319,3 +468,129 @@ func (r *ConnectionRepository) Delete(id string) error {
 	_, err := r.db.Exec(query, id)
 	return err
 }
+
+// ListRPOTargets returns the connections with a recovery point objective,
+// of all users when userID is uuid.Nil
+func (r *ConnectionRepository) ListRPOTargets(userID uuid.UUID) ([]RPOTarget, error) {
+	query := `
+		SELECT id, name, database_name, type, user_id, rpo_minutes, created_at, rpo_breached_at
+		FROM connections
+		WHERE rpo_minutes > 0`
+	args := []interface{}{}
+	if userID != uuid.Nil {
+		query += " AND user_id = $1"
+		args = append(args, userID)
+	}
+	query += " ORDER BY name"
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var targets []RPOTarget
+	for rows.Next() {
+		var target RPOTarget
+		var createdAtStr string
+		var breachedAtStr sql.NullString
+		if err := rows.Scan(&target.ConnectionID, &target.ConnectionName, &target.DatabaseName,
+			&target.DatabaseType, &target.UserID, &target.RPOMinutes, &createdAtStr, &breachedAtStr); err != nil {
+			return nil, err
+		}
+
+		// Older connections were saved without a creation time
+		if createdAt, err := common.ParseTime(createdAtStr); err == nil {
+			target.CreatedAt = createdAt
+		}
+		if breachedAtStr.Valid {
+			breachedAt, err := common.ParseTime(breachedAtStr.String)
+			if err != nil {
+				return nil, fmt.Errorf("error parsing rpo_breached_at: %v", err)
+			}
+			target.BreachedAt = &breachedAt
+		}
+		targets = append(targets, target)
+	}
+	return targets, rows.Err()
+}
+
+// SetRPOBreachedAt records when a connection missed its recovery point
+// objective, nil once it is met again
+func (r *ConnectionRepository) SetRPOBreachedAt(id string, breachedAt *time.Time) error {
+	var value *string
+	if breachedAt != nil {
+		str := breachedAt.Format(time.RFC3339)
+		value = &str
+	}
+	_, err := r.db.Exec(`UPDATE connections SET rpo_breached_at = $1 WHERE id = $2`, value, id)
+	return err
+}
+
+// SetIncidentTriggeredAt records when an alert was opened for a connection,
+// nil once it is resolved
+func (r *ConnectionRepository) SetIncidentTriggeredAt(id string, triggeredAt *time.Time) error {
+	var value *string
+	if triggeredAt != nil {
+		str := triggeredAt.Format(time.RFC3339)
+		value = &str
+	}
+	_, err := r.db.Exec(`UPDATE connections SET incident_triggered_at = $1 WHERE id = $2`, value, id)
+	return err
+}
+
+// marshalIncidents encodes incident integrations with their routing keys
+// encrypted
+func (r *ConnectionRepository) marshalIncidents(integrations []incident.Integration) (sql.NullString, error) {
+	encrypted := make([]incident.Integration, len(integrations))
+	for i, integration := range integrations {
+		key, err := r.crypto.Encrypt(integration.RoutingKey)
+		if err != nil {
+			return sql.NullString{}, err
+		}
+		integration.RoutingKey = key
+		encrypted[i] = integration
+	}
+	return incident.MarshalIntegrations(encrypted)
+}
+
+// unmarshalIncidents decodes incident integrations stored by
+// marshalIncidents
+func (r *ConnectionRepository) unmarshalIncidents(value sql.NullString) ([]incident.Integration, error) {
+	integrations, err := incident.UnmarshalIntegrations(value)
+	if err != nil {
+		return nil, err
+	}
+	for i := range integrations {
+		integrations[i].RoutingKey, err = r.crypto.Decrypt(integrations[i].RoutingKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt incident routing key: %v", err)
+		}
+	}
+	return integrations, nil
+}
+
+// MarshalDumpOptions encodes dump options for a TEXT column, storing NULL when
+// there are none.
+func MarshalDumpOptions(opts *DumpOptions) (sql.NullString, error) {
+	if opts == nil {
+		return sql.NullString{}, nil
+	}
+	data, err := json.Marshal(opts)
+	if err != nil {
+		return sql.NullString{}, fmt.Errorf("failed to encode dump options: %w", err)
+	}
+	return sql.NullString{String: string(data), Valid: true}, nil
+}
+
+// UnmarshalDumpOptions decodes a column written by MarshalDumpOptions.
+func UnmarshalDumpOptions(value sql.NullString) (*DumpOptions, error) {
+	if !value.Valid || value.String == "" {
+		return nil, nil
+	}
+	opts := &DumpOptions{}
+	if err := json.Unmarshal([]byte(value.String), opts); err != nil {
+		return nil, fmt.Errorf("failed to decode dump options: %w", err)
+	}
+	return opts, nil
+}
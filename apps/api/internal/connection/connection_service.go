@@ -1,6 +1,11 @@
 package connection
 
 import (
+	"fmt"
+	"time"
+
+	"github.com/dendianugerah/velld/internal/common"
+	"github.com/dendianugerah/velld/internal/incident"
 	"github.com/google/uuid"
 )
 
@@ -16,13 +21,44 @@ func NewConnectionService(repo *ConnectionRepository, manager *ConnectionManager
 	}
 }
 
-func (s *ConnectionService) TestConnection(config ConnectionConfig) (bool, error) {
+func (s *ConnectionService) TestConnection(config ConnectionConfig) (*ConnectionTestResult, error) {
 	err := s.manager.Connect(config)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 	defer s.manager.Disconnect(config.ID)
-	return true, nil
+
+	result := &ConnectionTestResult{Compatible: true}
+	result.ServerVersion, err = s.manager.GetServerVersion(config.ID)
+	if err != nil {
+		result.ServerVersion = ""
+	}
+
+	if toolName, ok := common.DumpToolNames[config.Type]; ok {
+		_, compatibility := common.SelectToolInstall(config.Type, toolName, result.ServerVersion)
+		if compatibility.Status == common.CompatibilityIncompatible {
+			result.Compatible = false
+		}
+		result.ToolCompatibility = append(result.ToolCompatibility, compatibility)
+	}
+
+	return result, nil
+}
+
+// ListToolInstalls returns the dump tools found on this host with their versions.
+func (s *ConnectionService) ListToolInstalls() map[string][]common.ToolInstall {
+	installs := make(map[string][]common.ToolInstall)
+	for _, toolName := range common.DumpToolNames {
+		if _, done := installs[toolName]; done {
+			continue
+		}
+		found := common.DetectToolInstalls(toolName)
+		if found == nil {
+			found = []common.ToolInstall{}
+		}
+		installs[toolName] = found
+	}
+	return installs
 }
 
 func (s *ConnectionService) SaveConnection(config ConnectionConfig, userID uuid.UUID) (*StoredConnection, error) {
@@ -30,6 +66,36 @@ func (s *ConnectionService) SaveConnection(config ConnectionConfig, userID uuid.
 		config.ID = uuid.New().String()
 	}
 
+	if err := config.DumpOptions.Validate(config.Type); err != nil {
+		return nil, err
+	}
+
+	if err := ValidateHooks(config.Hooks, config.Type); err != nil {
+		return nil, err
+	}
+
+	if config.RPOMinutes < 0 {
+		return nil, fmt.Errorf("rpo_minutes cannot be negative")
+	}
+
+	if err := config.NotificationEvents.Validate(); err != nil {
+		return nil, err
+	}
+
+	if err := incident.ValidateIntegrations(config.Incidents); err != nil {
+		return nil, err
+	}
+
+	tags, err := NormalizeTags(config.Tags)
+	if err != nil {
+		return nil, err
+	}
+
+	environment, err := NormalizeEnvironment(config.Environment)
+	if err != nil {
+		return nil, err
+	}
+
 	if err := s.manager.Connect(config); err != nil {
 		return nil, err
 	}
@@ -40,25 +106,41 @@ func (s *ConnectionService) SaveConnection(config ConnectionConfig, userID uuid.
 		dbSize = 0 // Set to 0 if we can't get the size
 	}
 
+	serverVersion, err := s.manager.GetServerVersion(config.ID)
+	if err != nil {
+		serverVersion = "" // Tool selection falls back to the first install found
+	}
+
+	now := time.Now().Format(time.RFC3339)
 	storedConn := StoredConnection{
-		ID:            config.ID,
-		Name:          config.Name,
-		Type:          config.Type,
-		Host:          config.Host,
-		Port:          config.Port,
-		Username:      config.Username,
-		Password:      config.Password,
-		DatabaseName:  config.Database,
-		SSL:           config.SSL,
-		SSHEnabled:    config.SSHEnabled,
-		SSHHost:       config.SSHHost,
-		SSHPort:       config.SSHPort,
-		SSHUsername:   config.SSHUsername,
-		SSHPassword:   config.SSHPassword,
-		SSHPrivateKey: config.SSHPrivateKey,
-		UserID:        userID,
-		Status:        "connected",
-		DatabaseSize:  dbSize,
+		ID:                 config.ID,
+		Name:               config.Name,
+		Type:               config.Type,
+		Host:               config.Host,
+		Port:               config.Port,
+		Username:           config.Username,
+		Password:           config.Password,
+		DatabaseName:       config.Database,
+		SSL:                config.SSL,
+		SSHEnabled:         config.SSHEnabled,
+		SSHHost:            config.SSHHost,
+		SSHPort:            config.SSHPort,
+		SSHUsername:        config.SSHUsername,
+		SSHPassword:        config.SSHPassword,
+		SSHPrivateKey:      config.SSHPrivateKey,
+		DumpOptions:        config.DumpOptions,
+		Hooks:              config.Hooks,
+		RPOMinutes:         config.RPOMinutes,
+		ServerVersion:      serverVersion,
+		UserID:             userID,
+		Status:             "connected",
+		DatabaseSize:       dbSize,
+		NotificationEvents: config.NotificationEvents,
+		Incidents:          config.Incidents,
+		Tags:               tags,
+		Environment:        environment,
+		CreatedAt:          now,
+		UpdatedAt:          now,
 	}
 
 	if err := s.repo.Save(storedConn); err != nil {
@@ -77,6 +159,36 @@ func (s *ConnectionService) GetConnection(id string) (*StoredConnection, error)
 }
 
 func (s *ConnectionService) UpdateConnection(config ConnectionConfig, userID uuid.UUID) (*StoredConnection, error) {
+	if err := config.DumpOptions.Validate(config.Type); err != nil {
+		return nil, err
+	}
+
+	if err := ValidateHooks(config.Hooks, config.Type); err != nil {
+		return nil, err
+	}
+
+	if config.RPOMinutes < 0 {
+		return nil, fmt.Errorf("rpo_minutes cannot be negative")
+	}
+
+	if err := config.NotificationEvents.Validate(); err != nil {
+		return nil, err
+	}
+
+	if err := incident.ValidateIntegrations(config.Incidents); err != nil {
+		return nil, err
+	}
+
+	tags, err := NormalizeTags(config.Tags)
+	if err != nil {
+		return nil, err
+	}
+
+	environment, err := NormalizeEnvironment(config.Environment)
+	if err != nil {
+		return nil, err
+	}
+
 	if err := s.manager.Connect(config); err != nil {
 		return nil, err
 	}
@@ -87,25 +199,38 @@ func (s *ConnectionService) UpdateConnection(config ConnectionConfig, userID uui
 		dbSize = 0 // Set to 0 if we can't get the size
 	}
 
+	serverVersion, err := s.manager.GetServerVersion(config.ID)
+	if err != nil {
+		serverVersion = "" // Tool selection falls back to the first install found
+	}
+
 	storedConn := StoredConnection{
-		ID:            config.ID,
-		Name:          config.Name,
-		Type:          config.Type,
-		Host:          config.Host,
-		Port:          config.Port,
-		Username:      config.Username,
-		Password:      config.Password,
-		DatabaseName:  config.Database,
-		SSL:           config.SSL,
-		SSHEnabled:    config.SSHEnabled,
-		SSHHost:       config.SSHHost,
-		SSHPort:       config.SSHPort,
-		SSHUsername:   config.SSHUsername,
-		SSHPassword:   config.SSHPassword,
-		SSHPrivateKey: config.SSHPrivateKey,
-		UserID:        userID,
-		Status:        "connected",
-		DatabaseSize:  dbSize,
+		ID:                 config.ID,
+		Name:               config.Name,
+		Type:               config.Type,
+		Host:               config.Host,
+		Port:               config.Port,
+		Username:           config.Username,
+		Password:           config.Password,
+		DatabaseName:       config.Database,
+		SSL:                config.SSL,
+		SSHEnabled:         config.SSHEnabled,
+		SSHHost:            config.SSHHost,
+		SSHPort:            config.SSHPort,
+		SSHUsername:        config.SSHUsername,
+		SSHPassword:        config.SSHPassword,
+		SSHPrivateKey:      config.SSHPrivateKey,
+		DumpOptions:        config.DumpOptions,
+		Hooks:              config.Hooks,
+		RPOMinutes:         config.RPOMinutes,
+		ServerVersion:      serverVersion,
+		UserID:             userID,
+		Status:             "connected",
+		DatabaseSize:       dbSize,
+		NotificationEvents: config.NotificationEvents,
+		Incidents:          config.Incidents,
+		Tags:               tags,
+		Environment:        environment,
 	}
 
 	if err := s.repo.Update(storedConn); err != nil {
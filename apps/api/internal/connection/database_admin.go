@@ -0,0 +1,333 @@
+package connection
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/lib/pq"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CreateDatabaseOptions are applied when velld creates a database for a restore.
+type CreateDatabaseOptions struct {
+	Owner    string // PostgreSQL
+	Encoding string // PostgreSQL
+	Charset  string // MySQL and MariaDB
+}
+
+var (
+	databaseNamePattern = regexp.MustCompile(`^[A-Za-z0-9_$-]{1,63}$`)
+	encodingPattern     = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+)
+
+// ValidateDatabaseName rejects names that cannot safely be used as a database
+// or role name on every supported engine.
+func ValidateDatabaseName(name string) error {
+	if !databaseNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid database name %q: use up to 63 letters, digits, '_', '$' or '-'", name)
+	}
+	return nil
+}
+
+// Validate checks the options against the connection type.
+func (o CreateDatabaseOptions) Validate(dbType string) error {
+	if (o.Owner != "" || o.Encoding != "") && dbType != "postgresql" {
+		return fmt.Errorf("owner and encoding are only supported for postgresql")
+	}
+	if o.Charset != "" && dbType != "mysql" && dbType != "mariadb" {
+		return fmt.Errorf("charset is only supported for mysql and mariadb")
+	}
+	if o.Owner != "" && !databaseNamePattern.MatchString(o.Owner) {
+		return fmt.Errorf("invalid owner %q", o.Owner)
+	}
+	if o.Encoding != "" && !encodingPattern.MatchString(o.Encoding) {
+		return fmt.Errorf("invalid encoding %q", o.Encoding)
+	}
+	if o.Charset != "" && !charsetPattern.MatchString(o.Charset) {
+		return fmt.Errorf("invalid charset %q", o.Charset)
+	}
+	return nil
+}
+
+// DatabaseExists reports whether the server of conn has a database called name.
+func DatabaseExists(ctx context.Context, conn *StoredConnection, name string) (bool, error) {
+	if conn.Type == "mongodb" {
+		client, err := openMongo(ctx, conn)
+		if err != nil {
+			return false, err
+		}
+		defer client.Disconnect(ctx)
+
+		names, err := client.ListDatabaseNames(ctx, bson.M{"name": name})
+		if err != nil {
+			return false, err
+		}
+		return len(names) > 0, nil
+	}
+
+	db, err := openAdminSQL(conn)
+	if err != nil {
+		return false, err
+	}
+	defer db.Close()
+
+	query := "SELECT 1 FROM pg_database WHERE datname = $1"
+	if conn.Type != "postgresql" {
+		query = "SELECT 1 FROM information_schema.SCHEMATA WHERE SCHEMA_NAME = ?"
+	}
+
+	var exists int
+	err = db.QueryRowContext(ctx, query, name).Scan(&exists)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// CreateDatabase creates an empty database. MongoDB creates databases on the
+// first write, so nothing is done for it.
+func CreateDatabase(ctx context.Context, conn *StoredConnection, name string, opts CreateDatabaseOptions) error {
+	if conn.Type == "mongodb" {
+		return nil
+	}
+
+	db, err := openAdminSQL(conn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var statement string
+	switch conn.Type {
+	case "postgresql":
+		statement = "CREATE DATABASE " + pq.QuoteIdentifier(name)
+		if opts.Owner != "" {
+			statement += " OWNER " + pq.QuoteIdentifier(opts.Owner)
+		}
+		if opts.Encoding != "" {
+			// template1 may use another encoding, template0 accepts any
+			statement += " ENCODING " + pq.QuoteLiteral(opts.Encoding) + " TEMPLATE template0"
+		}
+	default:
+		statement = "CREATE DATABASE " + quoteMySQLIdentifier(name)
+		if opts.Charset != "" {
+			statement += " CHARACTER SET " + opts.Charset
+		}
+	}
+
+	if _, err := db.ExecContext(ctx, statement); err != nil {
+		return fmt.Errorf("failed to create database %s: %w", name, err)
+	}
+	return nil
+}
+
+// DropDatabase drops the database if it exists. PostgreSQL refuses to drop a
+// database that is in use, so its other sessions are terminated first.
+func DropDatabase(ctx context.Context, conn *StoredConnection, name string) error {
+	if conn.Type == "mongodb" {
+		client, err := openMongo(ctx, conn)
+		if err != nil {
+			return err
+		}
+		defer client.Disconnect(ctx)
+
+		if err := client.Database(name).Drop(ctx); err != nil {
+			return fmt.Errorf("failed to drop database %s: %w", name, err)
+		}
+		return nil
+	}
+
+	db, err := openAdminSQL(conn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	statement := "DROP DATABASE IF EXISTS " + quoteMySQLIdentifier(name)
+	if conn.Type == "postgresql" {
+		if _, err := db.ExecContext(ctx,
+			"SELECT pg_terminate_backend(pid) FROM pg_stat_activity WHERE datname = $1 AND pid <> pg_backend_pid()",
+			name); err != nil {
+			return fmt.Errorf("failed to disconnect sessions from %s: %w", name, err)
+		}
+		statement = "DROP DATABASE IF EXISTS " + pq.QuoteIdentifier(name)
+	}
+
+	if _, err := db.ExecContext(ctx, statement); err != nil {
+		return fmt.Errorf("failed to drop database %s: %w", name, err)
+	}
+	return nil
+}
+
+// QueryServerVersion asks the server of conn for its version string.
+func QueryServerVersion(ctx context.Context, conn *StoredConnection) (string, error) {
+	if conn.Type == "mongodb" {
+		client, err := openMongo(ctx, conn)
+		if err != nil {
+			return "", err
+		}
+		defer client.Disconnect(ctx)
+
+		var info bson.M
+		if err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "buildInfo", Value: 1}}).Decode(&info); err != nil {
+			return "", err
+		}
+		version, _ := info["version"].(string)
+		return version, nil
+	}
+
+	db, err := openAdminSQL(conn)
+	if err != nil {
+		return "", err
+	}
+	defer db.Close()
+
+	query := "SELECT VERSION()"
+	if conn.Type == "postgresql" {
+		query = "SHOW server_version"
+	}
+
+	var version string
+	if err := db.QueryRowContext(ctx, query).Scan(&version); err != nil {
+		return "", err
+	}
+	return version, nil
+}
+
+// CountObjects returns the number of user tables, or collections for
+// MongoDB, in the database called name.
+func CountObjects(ctx context.Context, conn *StoredConnection, name string) (int, error) {
+	if conn.Type == "mongodb" {
+		client, err := openMongo(ctx, conn)
+		if err != nil {
+			return 0, err
+		}
+		defer client.Disconnect(ctx)
+
+		names, err := client.Database(name).ListCollectionNames(ctx, bson.M{"name": bson.M{"$not": bson.M{"$regex": "^system\\."}}})
+		if err != nil {
+			return 0, err
+		}
+		return len(names), nil
+	}
+
+	var (
+		db    *sql.DB
+		err   error
+		count int
+	)
+	if conn.Type == "postgresql" {
+		// Tables are only visible from within their own database
+		config := conn.ToConfig()
+		config.Database = name
+		db, err = openSQL(conn.Type, config)
+		if err != nil {
+			return 0, err
+		}
+		defer db.Close()
+		err = db.QueryRowContext(ctx, `
+			SELECT COUNT(*) FROM information_schema.tables
+			WHERE table_schema NOT IN ('pg_catalog', 'information_schema')`).Scan(&count)
+	} else {
+		db, err = openAdminSQL(conn)
+		if err != nil {
+			return 0, err
+		}
+		defer db.Close()
+		err = db.QueryRowContext(ctx,
+			"SELECT COUNT(*) FROM information_schema.TABLES WHERE TABLE_SCHEMA = ?", name).Scan(&count)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// StorageInfo describes where a server keeps its data. MongoDB reports the
+// free space of its filesystem itself; for the SQL engines only the data
+// directory is known, which is only useful when the server runs on this host.
+type StorageInfo struct {
+	DataDirectory string
+	FreeBytes     int64 // -1 when unknown
+}
+
+// QueryStorageInfo looks up the data directory or free space of the server of conn.
+func QueryStorageInfo(ctx context.Context, conn *StoredConnection) (StorageInfo, error) {
+	info := StorageInfo{FreeBytes: -1}
+
+	if conn.Type == "mongodb" {
+		client, err := openMongo(ctx, conn)
+		if err != nil {
+			return info, err
+		}
+		defer client.Disconnect(ctx)
+
+		var stats bson.M
+		if err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "dbStats", Value: 1}}).Decode(&stats); err != nil {
+			return info, err
+		}
+		total, totalOK := bsonNumber(stats["fsTotalSize"])
+		used, usedOK := bsonNumber(stats["fsUsedSize"])
+		if totalOK && usedOK {
+			info.FreeBytes = total - used
+		}
+		return info, nil
+	}
+
+	db, err := openAdminSQL(conn)
+	if err != nil {
+		return info, err
+	}
+	defer db.Close()
+
+	// PostgreSQL only shows data_directory to superusers and members of
+	// pg_read_all_settings
+	query := "SELECT @@datadir"
+	if conn.Type == "postgresql" {
+		query = "SHOW data_directory"
+	}
+	if err := db.QueryRowContext(ctx, query).Scan(&info.DataDirectory); err != nil {
+		return info, err
+	}
+	return info, nil
+}
+
+func bsonNumber(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case float64:
+		return int64(v), true
+	default:
+		return 0, false
+	}
+}
+
+// openAdminSQL connects to the server without selecting the connection's own
+// database, which may be the one being created or dropped.
+func openAdminSQL(conn *StoredConnection) (*sql.DB, error) {
+	config := conn.ToConfig()
+	config.Database = ""
+	if conn.Type == "postgresql" {
+		config.Database = "postgres"
+	}
+	return openSQL(conn.Type, config)
+}
+
+func openMongo(ctx context.Context, conn *StoredConnection) (*mongo.Client, error) {
+	return mongo.Connect(ctx, options.Client().ApplyURI(mongoURI(conn.ToConfig())))
+}
+
+func quoteMySQLIdentifier(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
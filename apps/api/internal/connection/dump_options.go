@@ -0,0 +1,165 @@
+package connection
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+)
+
+// DumpOptions holds the engine-specific flags applied by the dump and restore
+// tools. Only the section matching the connection type is used; when it is
+// omitted the defaults for that engine apply.
+type DumpOptions struct {
+	PostgreSQL *PostgresDumpOptions `json:"postgresql,omitempty"`
+	MySQL      *MySQLDumpOptions    `json:"mysql,omitempty"`
+	MongoDB    *MongoDumpOptions    `json:"mongodb,omitempty"`
+}
+
+type PostgresDumpOptions struct {
+	Format          string `json:"format"` // "plain" or "custom"
+	NoOwner         bool   `json:"no_owner"`
+	NoPrivileges    bool   `json:"no_privileges"`
+	LockWaitTimeout int    `json:"lock_wait_timeout"` // milliseconds, 0 waits forever
+	SchemaOnly      bool   `json:"schema_only"`
+}
+
+type MySQLDumpOptions struct {
+	SingleTransaction   bool   `json:"single_transaction"`
+	Routines            bool   `json:"routines"`
+	Triggers            bool   `json:"triggers"`
+	Events              bool   `json:"events"`
+	SetGTIDPurged       string `json:"set_gtid_purged"` // "", "OFF", "ON", "AUTO" or "COMMENTED"
+	NoData              bool   `json:"no_data"`
+	DefaultCharacterSet string `json:"default_character_set"`
+}
+
+type MongoDumpOptions struct {
+	Gzip           bool   `json:"gzip"`
+	ReadPreference string `json:"read_preference"`
+}
+
+const (
+	PostgresFormatPlain  = "plain"
+	PostgresFormatCustom = "custom"
+)
+
+var (
+	validGTIDPurgedModes = map[string]bool{"": true, "OFF": true, "ON": true, "AUTO": true, "COMMENTED": true}
+	validReadPreferences = map[string]bool{
+		"":                   true,
+		"primary":            true,
+		"primaryPreferred":   true,
+		"secondary":          true,
+		"secondaryPreferred": true,
+		"nearest":            true,
+	}
+	charsetPattern = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+)
+
+// DefaultPostgresDumpOptions returns the options used when none are configured.
+func DefaultPostgresDumpOptions() PostgresDumpOptions {
+	return PostgresDumpOptions{
+		Format: PostgresFormatPlain,
+	}
+}
+
+// DefaultMySQLDumpOptions returns the options used when none are configured.
+// --single-transaction gives a consistent snapshot of InnoDB tables without
+// locking them for the duration of the dump.
+func DefaultMySQLDumpOptions() MySQLDumpOptions {
+	return MySQLDumpOptions{
+		SingleTransaction: true,
+		Routines:          true,
+		Triggers:          true,
+	}
+}
+
+// UnmarshalJSON decodes a mysql section over DefaultMySQLDumpOptions, so
+// that fields it leaves out keep their defaults instead of turning off the
+// consistent snapshot, routines and triggers
+func (o *MySQLDumpOptions) UnmarshalJSON(data []byte) error {
+	type plain MySQLDumpOptions
+	opts := plain(DefaultMySQLDumpOptions())
+	if err := json.Unmarshal(data, &opts); err != nil {
+		return err
+	}
+	*o = MySQLDumpOptions(opts)
+	return nil
+}
+
+// DefaultMongoDumpOptions returns the options used when none are configured.
+func DefaultMongoDumpOptions() MongoDumpOptions {
+	return MongoDumpOptions{}
+}
+
+func (o *DumpOptions) PostgresOptions() PostgresDumpOptions {
+	if o == nil || o.PostgreSQL == nil {
+		return DefaultPostgresDumpOptions()
+	}
+	opts := *o.PostgreSQL
+	if opts.Format == "" {
+		opts.Format = PostgresFormatPlain
+	}
+	return opts
+}
+
+func (o *DumpOptions) MySQLOptions() MySQLDumpOptions {
+	if o == nil || o.MySQL == nil {
+		return DefaultMySQLDumpOptions()
+	}
+	return *o.MySQL
+}
+
+func (o *DumpOptions) MongoOptions() MongoDumpOptions {
+	if o == nil || o.MongoDB == nil {
+		return DefaultMongoDumpOptions()
+	}
+	return *o.MongoDB
+}
+
+// Validate checks that only the section for dbType is set and that its values
+// are accepted by the corresponding tool.
+func (o *DumpOptions) Validate(dbType string) error {
+	if o == nil {
+		return nil
+	}
+
+	if o.PostgreSQL != nil && dbType != "postgresql" {
+		return fmt.Errorf("postgresql dump options are not valid for %s connections", dbType)
+	}
+	if o.MySQL != nil && dbType != "mysql" && dbType != "mariadb" {
+		return fmt.Errorf("mysql dump options are not valid for %s connections", dbType)
+	}
+	if o.MongoDB != nil && dbType != "mongodb" {
+		return fmt.Errorf("mongodb dump options are not valid for %s connections", dbType)
+	}
+
+	if pg := o.PostgreSQL; pg != nil {
+		if pg.Format != "" && pg.Format != PostgresFormatPlain && pg.Format != PostgresFormatCustom {
+			return fmt.Errorf("invalid postgresql format %q: must be %q or %q", pg.Format, PostgresFormatPlain, PostgresFormatCustom)
+		}
+		if pg.LockWaitTimeout < 0 {
+			return fmt.Errorf("lock_wait_timeout must not be negative")
+		}
+	}
+
+	if my := o.MySQL; my != nil {
+		if !validGTIDPurgedModes[my.SetGTIDPurged] {
+			return fmt.Errorf("invalid set_gtid_purged value %q: must be OFF, ON, AUTO or COMMENTED", my.SetGTIDPurged)
+		}
+		if my.SetGTIDPurged != "" && dbType == "mariadb" {
+			return fmt.Errorf("set_gtid_purged is not supported by MariaDB")
+		}
+		if my.DefaultCharacterSet != "" && !charsetPattern.MatchString(my.DefaultCharacterSet) {
+			return fmt.Errorf("invalid default_character_set %q", my.DefaultCharacterSet)
+		}
+	}
+
+	if mongo := o.MongoDB; mongo != nil {
+		if !validReadPreferences[mongo.ReadPreference] {
+			return fmt.Errorf("invalid read_preference %q", mongo.ReadPreference)
+		}
+	}
+
+	return nil
+}
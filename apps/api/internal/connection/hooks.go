@@ -0,0 +1,121 @@
+package connection
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+type HookStage string
+
+const (
+	HookPreBackup   HookStage = "pre_backup"
+	HookPostBackup  HookStage = "post_backup"
+	HookPostRestore HookStage = "post_restore"
+)
+
+type HookType string
+
+const (
+	HookShell HookType = "shell"
+	HookHTTP  HookType = "http"
+	HookSQL   HookType = "sql"
+)
+
+const (
+	DefaultHookTimeoutSeconds = 60
+	MaxHookTimeoutSeconds     = 3600
+)
+
+// Hook is a command, HTTP call or SQL statement run around backups and
+// restores of a connection.
+type Hook struct {
+	Name           string            `json:"name"`
+	Stage          HookStage         `json:"stage"`
+	Type           HookType          `json:"type"`
+	Command        string            `json:"command,omitempty"`
+	URL            string            `json:"url,omitempty"`
+	Method         string            `json:"method,omitempty"`
+	Headers        map[string]string `json:"headers,omitempty"`
+	Body           string            `json:"body,omitempty"`
+	SQL            string            `json:"sql,omitempty"`
+	TimeoutSeconds int               `json:"timeout_seconds"`
+	AbortOnFailure bool              `json:"abort_on_failure"`
+}
+
+// Timeout returns the configured timeout in seconds, or the default.
+func (h Hook) Timeout() int {
+	if h.TimeoutSeconds <= 0 {
+		return DefaultHookTimeoutSeconds
+	}
+	return h.TimeoutSeconds
+}
+
+// ValidateHooks checks every hook against the connection type.
+func ValidateHooks(hooks []Hook, dbType string) error {
+	for i, hook := range hooks {
+		name := hook.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", i+1)
+		}
+
+		switch hook.Stage {
+		case HookPreBackup, HookPostBackup, HookPostRestore:
+		default:
+			return fmt.Errorf("hook %s: invalid stage %q", name, hook.Stage)
+		}
+
+		if hook.TimeoutSeconds < 0 || hook.TimeoutSeconds > MaxHookTimeoutSeconds {
+			return fmt.Errorf("hook %s: timeout_seconds must be between 0 and %d", name, MaxHookTimeoutSeconds)
+		}
+
+		switch hook.Type {
+		case HookShell:
+			if strings.TrimSpace(hook.Command) == "" {
+				return fmt.Errorf("hook %s: command is required", name)
+			}
+		case HookHTTP:
+			parsed, err := url.Parse(hook.URL)
+			if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+				return fmt.Errorf("hook %s: url must be an absolute http(s) URL", name)
+			}
+		case HookSQL:
+			if dbType != "postgresql" && dbType != "mysql" && dbType != "mariadb" {
+				return fmt.Errorf("hook %s: sql hooks are not supported for %s connections", name, dbType)
+			}
+			if strings.TrimSpace(hook.SQL) == "" {
+				return fmt.Errorf("hook %s: sql is required", name)
+			}
+		default:
+			return fmt.Errorf("hook %s: invalid type %q", name, hook.Type)
+		}
+	}
+
+	return nil
+}
+
+// MarshalHooks encodes hooks for a TEXT column, storing NULL when there are none.
+func MarshalHooks(hooks []Hook) (sql.NullString, error) {
+	if len(hooks) == 0 {
+		return sql.NullString{}, nil
+	}
+	data, err := json.Marshal(hooks)
+	if err != nil {
+		return sql.NullString{}, fmt.Errorf("failed to encode hooks: %w", err)
+	}
+	return sql.NullString{String: string(data), Valid: true}, nil
+}
+
+// UnmarshalHooks decodes hooks stored by MarshalHooks.
+func UnmarshalHooks(value sql.NullString) ([]Hook, error) {
+	if !value.Valid || value.String == "" {
+		return nil, nil
+	}
+	var hooks []Hook
+	if err := json.Unmarshal([]byte(value.String), &hooks); err != nil {
+		return nil, fmt.Errorf("failed to decode hooks: %w", err)
+	}
+	return hooks, nil
+}
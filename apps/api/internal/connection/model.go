@@ -3,49 +3,100 @@ package connection
 import (
 	"time"
 
+	"github.com/dendianugerah/velld/internal/common"
+	"github.com/dendianugerah/velld/internal/incident"
+	"github.com/dendianugerah/velld/internal/notification"
 	"github.com/google/uuid"
 )
 
 type StoredConnection struct {
-	ID              string     `json:"id"`
-	Name            string     `json:"name"`
-	Type            string     `json:"type"`
-	Host            string     `json:"host"`
-	Port            int        `json:"port"`
-	Username        string     `json:"username"`
-	Password        string     `json:"password"`
-	DatabaseName    string     `json:"database_name"`
-	SSL             bool       `json:"ssl"`
-	SSHEnabled      bool       `json:"ssh_enabled"`
-	SSHHost         string     `json:"ssh_host"`
-	SSHPort         int        `json:"ssh_port"`
-	SSHUsername     string     `json:"ssh_username"`
-	SSHPassword     string     `json:"ssh_password"`
-	SSHPrivateKey   string     `json:"ssh_private_key"`
-	CreatedAt       string     `json:"created_at"`
-	UpdatedAt       string     `json:"updated_at"`
-	LastConnectedAt *time.Time `json:"last_connected_at"`
-	UserID          uuid.UUID  `json:"user_id"`
-	Status          string     `json:"status"`
-	DatabaseSize    int64      `json:"database_size"`
+	ID              string       `json:"id"`
+	Name            string       `json:"name"`
+	Type            string       `json:"type"`
+	Host            string       `json:"host"`
+	Port            int          `json:"port"`
+	Username        string       `json:"username"`
+	Password        string       `json:"password"`
+	DatabaseName    string       `json:"database_name"`
+	SSL             bool         `json:"ssl"`
+	SSHEnabled      bool         `json:"ssh_enabled"`
+	SSHHost         string       `json:"ssh_host"`
+	SSHPort         int          `json:"ssh_port"`
+	SSHUsername     string       `json:"ssh_username"`
+	SSHPassword     string       `json:"ssh_password"`
+	SSHPrivateKey   string       `json:"ssh_private_key"`
+	DumpOptions     *DumpOptions `json:"dump_options,omitempty"`
+	Hooks           []Hook       `json:"hooks,omitempty"`
+	RPOMinutes      int          `json:"rpo_minutes,omitempty"` // 0 when not monitored
+	ServerVersion   string       `json:"server_version"`
+	CreatedAt       string       `json:"created_at"`
+	UpdatedAt       string       `json:"updated_at"`
+	LastConnectedAt *time.Time   `json:"last_connected_at"`
+	UserID          uuid.UUID    `json:"user_id"`
+	Status          string       `json:"status"`
+	DatabaseSize    int64        `json:"database_size"`
+	// NotificationEvents replaces the subscriptions of the owner per channel
+	NotificationEvents notification.EventSubscriptions `json:"notification_events,omitempty"`
+	// Incidents open alerts in incident management services
+	Incidents []incident.Integration `json:"incidents,omitempty"`
+	// Tags and Environment label the connection for notification rules
+	Tags        []string `json:"tags,omitempty"`
+	Environment string   `json:"environment,omitempty"`
+	// IncidentTriggeredAt is when an alert was last opened for the
+	// connection, nil once it is resolved
+	IncidentTriggeredAt *time.Time `json:"incident_triggered_at,omitempty"`
 }
 
 type ConnectionConfig struct {
-	ID            string `json:"id"`
-	Name          string `json:"name"`
-	Type          string `json:"type"`
-	Host          string `json:"host"`
-	Port          int    `json:"port"`
-	Username      string `json:"username"`
-	Password      string `json:"password"`
-	Database      string `json:"database"`
-	SSL           bool   `json:"ssl"`
-	SSHEnabled    bool   `json:"ssh_enabled"`
-	SSHHost       string `json:"ssh_host"`
-	SSHPort       int    `json:"ssh_port"`
-	SSHUsername   string `json:"ssh_username"`
-	SSHPassword   string `json:"ssh_password"`
-	SSHPrivateKey string `json:"ssh_private_key"`
+	ID            string       `json:"id"`
+	Name          string       `json:"name"`
+	Type          string       `json:"type"`
+	Host          string       `json:"host"`
+	Port          int          `json:"port"`
+	Username      string       `json:"username"`
+	Password      string       `json:"password"`
+	Database      string       `json:"database"`
+	SSL           bool         `json:"ssl"`
+	SSHEnabled    bool         `json:"ssh_enabled"`
+	SSHHost       string       `json:"ssh_host"`
+	SSHPort       int          `json:"ssh_port"`
+	SSHUsername   string       `json:"ssh_username"`
+	SSHPassword   string       `json:"ssh_password"`
+	SSHPrivateKey string       `json:"ssh_private_key"`
+	DumpOptions   *DumpOptions `json:"dump_options,omitempty"`
+	Hooks         []Hook       `json:"hooks,omitempty"`
+	// RPOMinutes is the longest accepted time without a completed backup
+	RPOMinutes int `json:"rpo_minutes,omitempty"`
+	// NotificationEvents replaces the subscriptions of the owner for the
+	// channels it lists
+	NotificationEvents notification.EventSubscriptions `json:"notification_events,omitempty"`
+	// Incidents open alerts in incident management services
+	Incidents []incident.Integration `json:"incidents,omitempty"`
+	// Tags and Environment label the connection for notification rules
+	Tags        []string `json:"tags,omitempty"`
+	Environment string   `json:"environment,omitempty"`
+}
+
+// RPOTarget is a connection with a recovery point objective
+type RPOTarget struct {
+	ConnectionID   string
+	ConnectionName string
+	DatabaseName   string
+	DatabaseType   string
+	UserID         uuid.UUID
+	RPOMinutes     int
+	CreatedAt      time.Time
+	// BreachedAt is when the watchdog last found the objective missed, nil
+	// while it is met
+	BreachedAt *time.Time
+}
+
+// ConnectionTestResult reports the server version and whether the installed
+// client tools can back it up.
+type ConnectionTestResult struct {
+	ServerVersion     string                     `json:"serverVersion"`
+	Compatible        bool                       `json:"compatible"`
+	ToolCompatibility []common.ToolCompatibility `json:"toolCompatibility"`
 }
 
 type ConnectionStats struct {
@@ -67,6 +118,11 @@ type ConnectionListItem struct {
 	DatabaseSize   int64   `json:"database_size"`
 	LastBackupTime *string `json:"last_backup_time"`
 	BackupEnabled  bool    `json:"backup_enabled"`
-	CronSchedule   *string `json:"cron_schedule"`
-	RetentionDays  *int    `json:"retention_days"`
+	// CronSchedule and RetentionDays are those of the most recent enabled
+	// schedule, ScheduleCount counts all enabled schedules
+	CronSchedule  *string  `json:"cron_schedule"`
+	RetentionDays *int     `json:"retention_days"`
+	ScheduleCount int      `json:"schedule_count"`
+	Tags          []string `json:"tags,omitempty"`
+	Environment   string   `json:"environment,omitempty"`
 }
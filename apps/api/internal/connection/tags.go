@@ -0,0 +1,70 @@
+package connection
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// maxTagLength bounds tags and environments, they are labels and not notes
+const maxTagLength = 64
+
+// normalizeLabel lowercases and trims a tag or environment, so that rules
+// match regardless of how it was typed
+func normalizeLabel(label string) string {
+	return strings.ToLower(strings.TrimSpace(label))
+}
+
+// NormalizeTags trims, lowercases and deduplicates tags
+func NormalizeTags(tags []string) ([]string, error) {
+	var normalized []string
+	seen := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		tag = normalizeLabel(tag)
+		if tag == "" {
+			continue
+		}
+		if len(tag) > maxTagLength || strings.ContainsAny(tag, ",\r\n") {
+			return nil, fmt.Errorf("invalid tag %q", tag)
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			normalized = append(normalized, tag)
+		}
+	}
+	return normalized, nil
+}
+
+// NormalizeEnvironment trims and lowercases an environment name
+func NormalizeEnvironment(environment string) (string, error) {
+	environment = normalizeLabel(environment)
+	if len(environment) > maxTagLength || strings.ContainsAny(environment, ",\r\n") {
+		return "", fmt.Errorf("invalid environment %q", environment)
+	}
+	return environment, nil
+}
+
+// MarshalTags encodes tags for a TEXT column, storing NULL when there are none.
+func MarshalTags(tags []string) (sql.NullString, error) {
+	if len(tags) == 0 {
+		return sql.NullString{}, nil
+	}
+	data, err := json.Marshal(tags)
+	if err != nil {
+		return sql.NullString{}, fmt.Errorf("failed to encode tags: %w", err)
+	}
+	return sql.NullString{String: string(data), Valid: true}, nil
+}
+
+// UnmarshalTags decodes tags stored by MarshalTags.
+func UnmarshalTags(value sql.NullString) ([]string, error) {
+	if !value.Valid || value.String == "" {
+		return nil, nil
+	}
+	var tags []string
+	if err := json.Unmarshal([]byte(value.String), &tags); err != nil {
+		return nil, fmt.Errorf("failed to decode tags: %w", err)
+	}
+	return tags, nil
+}
@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'Adding dump tool options to connections';
+
+ALTER TABLE connections ADD COLUMN dump_options TEXT;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'Removing dump tool options from connections';
+
+ALTER TABLE connections DROP COLUMN dump_options;
+
+-- +goose StatementEnd
@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'Adding server version to connections';
+
+ALTER TABLE connections ADD COLUMN server_version TEXT;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'Removing server version from connections';
+
+ALTER TABLE connections DROP COLUMN server_version;
+
+-- +goose StatementEnd
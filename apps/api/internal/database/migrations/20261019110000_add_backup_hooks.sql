@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'Adding backup hooks and backup log';
+
+ALTER TABLE connections ADD COLUMN hooks TEXT;
+ALTER TABLE backup_schedules ADD COLUMN hooks TEXT;
+ALTER TABLE backups ADD COLUMN log TEXT;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'Removing backup hooks and backup log';
+
+ALTER TABLE backups DROP COLUMN log;
+ALTER TABLE backup_schedules DROP COLUMN hooks;
+ALTER TABLE connections DROP COLUMN hooks;
+
+-- +goose StatementEnd
@@ -0,0 +1,36 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'Creating restore jobs table';
+
+CREATE TABLE restore_jobs (
+    id TEXT PRIMARY KEY,
+    backup_id TEXT NOT NULL,
+    connection_id TEXT NOT NULL,
+    target_database TEXT NOT NULL,
+    mode TEXT NOT NULL,
+    status TEXT NOT NULL,
+    bytes_total INTEGER NOT NULL DEFAULT 0,
+    bytes_done INTEGER NOT NULL DEFAULT 0,
+    snapshot_backup_id TEXT,
+    rollback_of TEXT,
+    error TEXT,
+    log TEXT,
+    started_time TEXT,
+    completed_time TEXT,
+    created_at TEXT NOT NULL,
+    updated_at TEXT NOT NULL,
+    FOREIGN KEY (connection_id) REFERENCES connections(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_restore_jobs_connection_id ON restore_jobs(connection_id);
+CREATE INDEX idx_restore_jobs_status ON restore_jobs(status);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'Dropping restore jobs table';
+
+DROP TABLE IF EXISTS restore_jobs;
+
+-- +goose StatementEnd
@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'Adding backup checksum and server version';
+
+ALTER TABLE backups ADD COLUMN checksum TEXT;
+ALTER TABLE backups ADD COLUMN server_version TEXT;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'Removing backup checksum and server version';
+
+ALTER TABLE backups DROP COLUMN server_version;
+ALTER TABLE backups DROP COLUMN checksum;
+
+-- +goose StatementEnd
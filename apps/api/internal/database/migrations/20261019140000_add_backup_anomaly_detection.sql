@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'Adding backup anomaly detection';
+
+ALTER TABLE backups ADD COLUMN anomaly TEXT;
+ALTER TABLE backup_schedules ADD COLUMN anomaly_detection TEXT;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'Removing backup anomaly detection';
+
+ALTER TABLE backup_schedules DROP COLUMN anomaly_detection;
+ALTER TABLE backups DROP COLUMN anomaly;
+
+-- +goose StatementEnd
@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'Adding recovery point objectives to connections';
+
+ALTER TABLE connections ADD COLUMN rpo_minutes INTEGER;
+ALTER TABLE connections ADD COLUMN rpo_breached_at TEXT;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'Removing recovery point objectives from connections';
+
+ALTER TABLE connections DROP COLUMN rpo_breached_at;
+ALTER TABLE connections DROP COLUMN rpo_minutes;
+
+-- +goose StatementEnd
@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'Adding per-schedule options';
+
+ALTER TABLE backup_schedules ADD COLUMN name TEXT;
+ALTER TABLE backup_schedules ADD COLUMN dump_options TEXT;
+ALTER TABLE backup_schedules ADD COLUMN destination TEXT;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'Removing per-schedule options';
+
+ALTER TABLE backup_schedules DROP COLUMN destination;
+ALTER TABLE backup_schedules DROP COLUMN dump_options;
+ALTER TABLE backup_schedules DROP COLUMN name;
+
+-- +goose StatementEnd
@@ -0,0 +1,21 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'Adding schedule timezones, blackout windows, jitter and missed run policy';
+
+ALTER TABLE backup_schedules ADD COLUMN timezone TEXT;
+ALTER TABLE backup_schedules ADD COLUMN blackout_windows TEXT;
+ALTER TABLE backup_schedules ADD COLUMN jitter_seconds INTEGER DEFAULT 0;
+ALTER TABLE backup_schedules ADD COLUMN missed_run_policy TEXT;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'Removing schedule timezones, blackout windows, jitter and missed run policy';
+
+ALTER TABLE backup_schedules DROP COLUMN missed_run_policy;
+ALTER TABLE backup_schedules DROP COLUMN jitter_seconds;
+ALTER TABLE backup_schedules DROP COLUMN blackout_windows;
+ALTER TABLE backup_schedules DROP COLUMN timezone;
+
+-- +goose StatementEnd
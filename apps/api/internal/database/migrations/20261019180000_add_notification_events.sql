@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'Adding notification event subscriptions';
+
+ALTER TABLE user_settings ADD COLUMN notification_events TEXT;
+ALTER TABLE connections ADD COLUMN notification_events TEXT;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'Removing notification event subscriptions';
+
+ALTER TABLE connections DROP COLUMN notification_events;
+ALTER TABLE user_settings DROP COLUMN notification_events;
+
+-- +goose StatementEnd
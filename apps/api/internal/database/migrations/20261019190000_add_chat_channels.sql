@@ -0,0 +1,31 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'Adding chat notification channels';
+
+ALTER TABLE user_settings ADD COLUMN notify_slack BOOLEAN DEFAULT FALSE;
+ALTER TABLE user_settings ADD COLUMN slack_webhook_url TEXT;
+ALTER TABLE user_settings ADD COLUMN notify_discord BOOLEAN DEFAULT FALSE;
+ALTER TABLE user_settings ADD COLUMN discord_webhook_url TEXT;
+ALTER TABLE user_settings ADD COLUMN notify_teams BOOLEAN DEFAULT FALSE;
+ALTER TABLE user_settings ADD COLUMN teams_webhook_url TEXT;
+ALTER TABLE user_settings ADD COLUMN notify_telegram BOOLEAN DEFAULT FALSE;
+ALTER TABLE user_settings ADD COLUMN telegram_bot_token TEXT;
+ALTER TABLE user_settings ADD COLUMN telegram_chat_id TEXT;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'Removing chat notification channels';
+
+ALTER TABLE user_settings DROP COLUMN telegram_chat_id;
+ALTER TABLE user_settings DROP COLUMN telegram_bot_token;
+ALTER TABLE user_settings DROP COLUMN notify_telegram;
+ALTER TABLE user_settings DROP COLUMN teams_webhook_url;
+ALTER TABLE user_settings DROP COLUMN notify_teams;
+ALTER TABLE user_settings DROP COLUMN discord_webhook_url;
+ALTER TABLE user_settings DROP COLUMN notify_discord;
+ALTER TABLE user_settings DROP COLUMN slack_webhook_url;
+ALTER TABLE user_settings DROP COLUMN notify_slack;
+
+-- +goose StatementEnd
@@ -0,0 +1,41 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'Creating webhook deliveries table';
+
+ALTER TABLE user_settings ADD COLUMN webhook_secret TEXT;
+ALTER TABLE user_settings ADD COLUMN webhook_headers TEXT;
+
+CREATE TABLE webhook_deliveries (
+    id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL,
+    url TEXT NOT NULL,
+    event_type TEXT NOT NULL,
+    payload TEXT NOT NULL,
+    status TEXT NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    status_code INTEGER,
+    response_body TEXT,
+    error TEXT,
+    redelivery_of TEXT,
+    next_attempt_at TEXT,
+    last_attempt_at TEXT,
+    created_at TEXT NOT NULL,
+    updated_at TEXT NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_webhook_deliveries_user_id ON webhook_deliveries(user_id);
+CREATE INDEX idx_webhook_deliveries_status ON webhook_deliveries(status);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'Dropping webhook deliveries table';
+
+DROP TABLE IF EXISTS webhook_deliveries;
+
+ALTER TABLE user_settings DROP COLUMN webhook_headers;
+ALTER TABLE user_settings DROP COLUMN webhook_secret;
+
+-- +goose StatementEnd
@@ -0,0 +1,21 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'Adding email recipients and templates';
+
+ALTER TABLE user_settings ADD COLUMN email_cc TEXT;
+ALTER TABLE user_settings ADD COLUMN email_subject_template TEXT;
+ALTER TABLE user_settings ADD COLUMN email_text_template TEXT;
+ALTER TABLE user_settings ADD COLUMN email_html_template TEXT;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'Removing email recipients and templates';
+
+ALTER TABLE user_settings DROP COLUMN email_html_template;
+ALTER TABLE user_settings DROP COLUMN email_text_template;
+ALTER TABLE user_settings DROP COLUMN email_subject_template;
+ALTER TABLE user_settings DROP COLUMN email_cc;
+
+-- +goose StatementEnd
@@ -0,0 +1,23 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'Adding SMTP transport options';
+
+ALTER TABLE user_settings ADD COLUMN smtp_tls_mode TEXT;
+ALTER TABLE user_settings ADD COLUMN smtp_auth_method TEXT;
+ALTER TABLE user_settings ADD COLUMN smtp_helo_name TEXT;
+ALTER TABLE user_settings ADD COLUMN smtp_from_name TEXT;
+ALTER TABLE user_settings ADD COLUMN smtp_from_address TEXT;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'Removing SMTP transport options';
+
+ALTER TABLE user_settings DROP COLUMN smtp_from_address;
+ALTER TABLE user_settings DROP COLUMN smtp_from_name;
+ALTER TABLE user_settings DROP COLUMN smtp_helo_name;
+ALTER TABLE user_settings DROP COLUMN smtp_auth_method;
+ALTER TABLE user_settings DROP COLUMN smtp_tls_mode;
+
+-- +goose StatementEnd
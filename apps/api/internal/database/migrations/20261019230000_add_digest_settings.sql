@@ -0,0 +1,27 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'Adding digest settings';
+
+ALTER TABLE user_settings ADD COLUMN digest_frequency TEXT;
+ALTER TABLE user_settings ADD COLUMN digest_time TEXT;
+ALTER TABLE user_settings ADD COLUMN digest_weekday INTEGER;
+ALTER TABLE user_settings ADD COLUMN digest_timezone TEXT;
+ALTER TABLE user_settings ADD COLUMN digest_email BOOLEAN DEFAULT FALSE;
+ALTER TABLE user_settings ADD COLUMN digest_webhook BOOLEAN DEFAULT FALSE;
+ALTER TABLE user_settings ADD COLUMN digest_last_sent_at TEXT;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'Removing digest settings';
+
+ALTER TABLE user_settings DROP COLUMN digest_last_sent_at;
+ALTER TABLE user_settings DROP COLUMN digest_webhook;
+ALTER TABLE user_settings DROP COLUMN digest_email;
+ALTER TABLE user_settings DROP COLUMN digest_timezone;
+ALTER TABLE user_settings DROP COLUMN digest_weekday;
+ALTER TABLE user_settings DROP COLUMN digest_time;
+ALTER TABLE user_settings DROP COLUMN digest_frequency;
+
+-- +goose StatementEnd
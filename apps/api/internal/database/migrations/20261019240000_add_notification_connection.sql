@@ -0,0 +1,25 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'Adding connection to notifications';
+
+ALTER TABLE notifications ADD COLUMN connection_id TEXT;
+
+UPDATE notifications
+SET connection_id = json_extract(CAST(metadata AS TEXT), '$.connection_id')
+WHERE metadata IS NOT NULL AND json_valid(CAST(metadata AS TEXT));
+
+CREATE INDEX idx_notifications_user_status ON notifications(user_id, status, created_at);
+CREATE INDEX idx_notifications_connection_id ON notifications(connection_id);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'Removing connection from notifications';
+
+DROP INDEX IF EXISTS idx_notifications_connection_id;
+DROP INDEX IF EXISTS idx_notifications_user_status;
+
+ALTER TABLE notifications DROP COLUMN connection_id;
+
+-- +goose StatementEnd
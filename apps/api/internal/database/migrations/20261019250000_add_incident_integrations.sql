@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'Adding incident integrations to connections';
+
+ALTER TABLE connections ADD COLUMN incident_integrations TEXT;
+ALTER TABLE connections ADD COLUMN incident_triggered_at TEXT;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'Removing incident integrations from connections';
+
+ALTER TABLE connections DROP COLUMN incident_triggered_at;
+ALTER TABLE connections DROP COLUMN incident_integrations;
+
+-- +goose StatementEnd
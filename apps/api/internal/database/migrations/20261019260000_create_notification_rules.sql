@@ -0,0 +1,48 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'Creating notification rules';
+
+ALTER TABLE connections ADD COLUMN tags TEXT;
+ALTER TABLE connections ADD COLUMN environment TEXT;
+
+CREATE TABLE notification_rules (
+    id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL,
+    name TEXT NOT NULL,
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    position INTEGER NOT NULL DEFAULT 0,
+    match TEXT NOT NULL,
+    channels TEXT NOT NULL,
+    quiet_hours TEXT,
+    rate_limit TEXT,
+    stop BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TEXT NOT NULL,
+    updated_at TEXT NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_notification_rules_user_id ON notification_rules(user_id);
+
+-- Deliveries of each rule, counted against its rate limit
+CREATE TABLE notification_rule_sends (
+    rule_id TEXT NOT NULL,
+    connection_id TEXT NOT NULL,
+    sent_at TEXT NOT NULL,
+    FOREIGN KEY (rule_id) REFERENCES notification_rules(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_notification_rule_sends_rule ON notification_rule_sends(rule_id, connection_id, sent_at);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'Dropping notification rules';
+
+DROP TABLE IF EXISTS notification_rule_sends;
+DROP TABLE IF EXISTS notification_rules;
+
+ALTER TABLE connections DROP COLUMN environment;
+ALTER TABLE connections DROP COLUMN tags;
+
+-- +goose StatementEnd
@@ -0,0 +1,132 @@
+package events
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Event types
+const (
+	BackupUpdated        = "backup.updated"
+	BackupProgress       = "backup.progress"
+	BackupDeleted        = "backup.deleted"
+	RestoreUpdated       = "restore.updated"
+	RestoreProgress      = "restore.progress"
+	ScheduleUpdated      = "schedule.updated"
+	ScheduleDeleted      = "schedule.deleted"
+	NotificationCreated  = "notification.created"
+	NotificationsRead    = "notification.read"
+	NotificationsDeleted = "notification.deleted"
+)
+
+// subscriberBuffer is how many events a subscriber can fall behind before
+// further events are dropped for it
+const subscriberBuffer = 64
+
+// Event is something that happened to the resources of a user
+type Event struct {
+	ID        uint64      `json:"id"`
+	Type      string      `json:"type"`
+	UserID    uuid.UUID   `json:"-"`
+	Data      interface{} `json:"data"`
+	Timestamp time.Time   `json:"timestamp"`
+}
+
+// Bus fans out the events published by the services to their subscribers.
+// Publishing never blocks, a subscriber that does not keep up misses events.
+type Bus struct {
+	mu          sync.RWMutex
+	subscribers map[*Subscription]struct{}
+	lastID      atomic.Uint64
+}
+
+func NewBus() *Bus {
+	return &Bus{subscribers: make(map[*Subscription]struct{})}
+}
+
+// Subscription receives the events of a user, or of every user when it was
+// made for uuid.Nil
+type Subscription struct {
+	bus     *Bus
+	userID  uuid.UUID
+	types   map[string]bool
+	events  chan Event
+	dropped atomic.Uint64
+	once    sync.Once
+}
+
+// Subscribe returns a subscription to the events of userID. When types are
+// given only events of those types are received.
+func (b *Bus) Subscribe(userID uuid.UUID, types ...string) *Subscription {
+	sub := &Subscription{
+		bus:    b,
+		userID: userID,
+		events: make(chan Event, subscriberBuffer),
+	}
+	if len(types) > 0 {
+		sub.types = make(map[string]bool, len(types))
+		for _, eventType := range types {
+			sub.types[eventType] = true
+		}
+	}
+
+	b.mu.Lock()
+	b.subscribers[sub] = struct{}{}
+	b.mu.Unlock()
+	return sub
+}
+
+// Publish sends an event to the subscribers of userID
+func (b *Bus) Publish(userID uuid.UUID, eventType string, data interface{}) {
+	event := Event{
+		ID:        b.lastID.Add(1),
+		Type:      eventType,
+		UserID:    userID,
+		Data:      data,
+		Timestamp: time.Now(),
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for sub := range b.subscribers {
+		if !sub.wants(event) {
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+			sub.dropped.Add(1)
+		}
+	}
+}
+
+func (s *Subscription) wants(event Event) bool {
+	if s.userID != uuid.Nil && s.userID != event.UserID {
+		return false
+	}
+	return s.types == nil || s.types[event.Type]
+}
+
+// Events returns the channel events are received on. It is closed by Close.
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Dropped returns how many events were dropped because the subscriber fell
+// behind
+func (s *Subscription) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Close stops the subscription
+func (s *Subscription) Close() {
+	s.once.Do(func() {
+		s.bus.mu.Lock()
+		delete(s.bus.subscribers, s)
+		s.bus.mu.Unlock()
+		close(s.events)
+	})
+}
@@ -0,0 +1,91 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dendianugerah/velld/internal/common"
+	"github.com/dendianugerah/velld/internal/common/response"
+)
+
+// keepAliveInterval is how often an idle stream sends a comment, so that
+// proxies do not close it
+const keepAliveInterval = 20 * time.Second
+
+type EventsHandler struct {
+	bus *Bus
+}
+
+func NewEventsHandler(bus *Bus) *EventsHandler {
+	return &EventsHandler{bus: bus}
+}
+
+// Stream sends the events of the user as server-sent events until the
+// client disconnects. The types query parameter takes a comma separated list
+// of event types to receive. Events are not replayed, clients reload the
+// resources they show after reconnecting.
+func (h *EventsHandler) Stream(w http.ResponseWriter, r *http.Request) {
+	userID, err := common.GetUserIDFromContext(r.Context())
+	if err != nil {
+		response.SendError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		response.SendError(w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+
+	var types []string
+	if typesParam := r.URL.Query().Get("types"); typesParam != "" {
+		for _, eventType := range strings.Split(typesParam, ",") {
+			if eventType = strings.TrimSpace(eventType); eventType != "" {
+				types = append(types, eventType)
+			}
+		}
+	}
+
+	sub := h.bus.Subscribe(userID, types...)
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	// Keep nginx from buffering the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "retry: 5000\n\n")
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case event, ok := <-sub.Events():
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				fmt.Printf("Error encoding %s event: %v\n", event.Type, err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,260 @@
+// Package incident opens and resolves alerts in incident management
+// services such as PagerDuty and Opsgenie, so that failed backups page
+// whoever is on call.
+package incident
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/dendianugerah/velld/internal/notification"
+)
+
+// Severity is how urgent an alert is, in PagerDuty's terms. Providers with
+// other scales map it to their own.
+type Severity string
+
+const (
+	SeverityCritical Severity = "critical"
+	SeverityError    Severity = "error"
+	SeverityWarning  Severity = "warning"
+	SeverityInfo     Severity = "info"
+)
+
+// DefaultSeverities are the events that open an alert when an integration
+// maps none
+var DefaultSeverities = map[notification.NotificationType]Severity{
+	notification.BackupFailed: SeverityCritical,
+	notification.RPOBreached:  SeverityError,
+}
+
+// ResolvingEvents close the alert of a connection
+var ResolvingEvents = []notification.NotificationType{
+	notification.BackupCompleted,
+	notification.RPORecovered,
+}
+
+// Integration sends the alerts of a connection to a provider
+type Integration struct {
+	Provider string `json:"provider"`
+	// RoutingKey is the PagerDuty integration key or the Opsgenie API key
+	RoutingKey string `json:"routing_key"`
+	// APIURL replaces the endpoint of the provider, for Opsgenie's EU
+	// region or a local stand-in
+	APIURL string `json:"api_url,omitempty"`
+	// Severities maps the events that open an alert to its severity,
+	// DefaultSeverities when empty
+	Severities map[notification.NotificationType]Severity `json:"severities,omitempty"`
+}
+
+// Alert is an incident about a connection. Alerts with the same DedupKey
+// are grouped into one incident by the provider.
+type Alert struct {
+	DedupKey string
+	Summary  string
+	Source   string
+	Severity Severity
+	Details  map[string]interface{}
+	// URL links back to the backup, empty when no public URL is configured
+	URL       string
+	Timestamp time.Time
+}
+
+// Provider is an incident management service. Trigger opens or updates the
+// alert of alert.DedupKey and Resolve closes it.
+type Provider interface {
+	Trigger(integration Integration, alert Alert) error
+	Resolve(integration Integration, dedupKey string) error
+}
+
+var providers = map[string]Provider{
+	ProviderPagerDuty: pagerDuty{},
+	ProviderOpsgenie:  opsgenie{},
+}
+
+// Register adds a provider integrations can name
+func Register(name string, provider Provider) {
+	providers[name] = provider
+}
+
+// Providers returns the names of the registered providers
+func Providers() []string {
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DedupKey is the key of the alert of a connection
+func DedupKey(connectionID string) string {
+	return "velld-" + connectionID
+}
+
+// SeverityFor returns the severity of the alert an event opens, and false
+// when the event does not open one
+func (i Integration) SeverityFor(eventType notification.NotificationType) (Severity, bool) {
+	severities := i.Severities
+	if len(severities) == 0 {
+		severities = DefaultSeverities
+	}
+	severity, ok := severities[eventType]
+	return severity, ok
+}
+
+// Resolves reports whether an event closes the alert of its connection
+func Resolves(eventType notification.NotificationType) bool {
+	for _, resolving := range ResolvingEvents {
+		if eventType == resolving {
+			return true
+		}
+	}
+	return false
+}
+
+func isEventType(eventType notification.NotificationType) bool {
+	for _, known := range notification.EventTypes {
+		if eventType == known {
+			return true
+		}
+	}
+	return false
+}
+
+func (i Integration) provider() (Provider, error) {
+	provider, ok := providers[i.Provider]
+	if !ok {
+		return nil, fmt.Errorf("unknown incident provider %q", i.Provider)
+	}
+	return provider, nil
+}
+
+// Trigger opens or updates an alert through the provider of the integration
+func (i Integration) Trigger(alert Alert) error {
+	provider, err := i.provider()
+	if err != nil {
+		return err
+	}
+	return provider.Trigger(i, alert)
+}
+
+// Resolve closes an alert through the provider of the integration
+func (i Integration) Resolve(dedupKey string) error {
+	provider, err := i.provider()
+	if err != nil {
+		return err
+	}
+	return provider.Resolve(i, dedupKey)
+}
+
+// ValidateIntegrations checks the integrations of a connection
+func ValidateIntegrations(integrations []Integration) error {
+	for n, integration := range integrations {
+		if _, ok := providers[integration.Provider]; !ok {
+			return fmt.Errorf("incident integration %d: unknown provider %q", n+1, integration.Provider)
+		}
+		if integration.RoutingKey == "" {
+			return fmt.Errorf("incident integration %d: routing_key is required", n+1)
+		}
+		for eventType, severity := range integration.Severities {
+			if !isEventType(eventType) {
+				return fmt.Errorf("incident integration %d: unknown event %q", n+1, eventType)
+			}
+			if Resolves(eventType) {
+				return fmt.Errorf("incident integration %d: %s resolves alerts and cannot open one", n+1, eventType)
+			}
+			switch severity {
+			case SeverityCritical, SeverityError, SeverityWarning, SeverityInfo:
+			default:
+				return fmt.Errorf("incident integration %d: unknown severity %q", n+1, severity)
+			}
+		}
+	}
+	return nil
+}
+
+// MarshalIntegrations encodes integrations for a TEXT column, storing NULL
+// when there are none.
+func MarshalIntegrations(integrations []Integration) (sql.NullString, error) {
+	if len(integrations) == 0 {
+		return sql.NullString{}, nil
+	}
+	data, err := json.Marshal(integrations)
+	if err != nil {
+		return sql.NullString{}, fmt.Errorf("failed to encode incident integrations: %w", err)
+	}
+	return sql.NullString{String: string(data), Valid: true}, nil
+}
+
+// UnmarshalIntegrations decodes integrations stored by MarshalIntegrations.
+func UnmarshalIntegrations(value sql.NullString) ([]Integration, error) {
+	if !value.Valid || value.String == "" {
+		return nil, nil
+	}
+	var integrations []Integration
+	if err := json.Unmarshal([]byte(value.String), &integrations); err != nil {
+		return nil, fmt.Errorf("failed to decode incident integrations: %w", err)
+	}
+	return integrations, nil
+}
+
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+// post sends payload to url and turns a non-2xx response into an error
+// carrying the start of the response body
+func post(url string, headers map[string]string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode alert: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send alert: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("alert rejected with status %d: %s", resp.StatusCode, bytes.TrimSpace(respBody))
+	}
+	return nil
+}
+
+// SendTest opens an informational alert through an integration and
+// resolves it again, to check its routing key and endpoint
+func SendTest(integration Integration) error {
+	if err := ValidateIntegrations([]Integration{integration}); err != nil {
+		return err
+	}
+
+	dedupKey := "velld-test-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	err := integration.Trigger(Alert{
+		DedupKey:  dedupKey,
+		Summary:   "Velld test alert: incident integration is configured correctly",
+		Source:    "velld",
+		Severity:  SeverityInfo,
+		Timestamp: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	return integration.Resolve(dedupKey)
+}
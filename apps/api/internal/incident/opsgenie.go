@@ -0,0 +1,87 @@
+package incident
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+const ProviderOpsgenie = "opsgenie"
+
+// opsgenieAPIURL is the base URL of the Opsgenie API, EU accounts use
+// https://api.eu.opsgenie.com
+const opsgenieAPIURL = "https://api.opsgenie.com"
+
+type opsgenie struct{}
+
+type opsgenieAlert struct {
+	Message     string            `json:"message"`
+	Alias       string            `json:"alias"`
+	Description string            `json:"description,omitempty"`
+	Priority    string            `json:"priority"`
+	Source      string            `json:"source"`
+	Entity      string            `json:"entity,omitempty"`
+	Tags        []string          `json:"tags,omitempty"`
+	Details     map[string]string `json:"details,omitempty"`
+}
+
+type opsgenieClose struct {
+	Source string `json:"source"`
+	Note   string `json:"note,omitempty"`
+}
+
+// opsgeniePriorities maps severities to Opsgenie priorities
+var opsgeniePriorities = map[Severity]string{
+	SeverityCritical: "P1",
+	SeverityError:    "P2",
+	SeverityWarning:  "P3",
+	SeverityInfo:     "P5",
+}
+
+func (opsgenie) baseURL(integration Integration) string {
+	if integration.APIURL != "" {
+		return strings.TrimRight(integration.APIURL, "/")
+	}
+	return opsgenieAPIURL
+}
+
+func (opsgenie) headers(integration Integration) map[string]string {
+	return map[string]string{"Authorization": "GenieKey " + integration.RoutingKey}
+}
+
+func (o opsgenie) Trigger(integration Integration, alert Alert) error {
+	priority, ok := opsgeniePriorities[alert.Severity]
+	if !ok {
+		priority = "P3"
+	}
+
+	details := make(map[string]string, len(alert.Details)+1)
+	for key, value := range alert.Details {
+		details[key] = fmt.Sprint(value)
+	}
+	description := alert.Summary
+	if alert.URL != "" {
+		details["url"] = alert.URL
+		description += "\n\n" + alert.URL
+	}
+
+	return post(o.baseURL(integration)+"/v2/alerts", o.headers(integration), opsgenieAlert{
+		// Opsgenie rejects messages longer than 130 characters
+		Message:     truncate(alert.Summary, 130),
+		Alias:       alert.DedupKey,
+		Description: description,
+		Priority:    priority,
+		Source:      "velld",
+		Entity:      alert.Source,
+		Tags:        []string{"velld", string(alert.Severity)},
+		Details:     details,
+	})
+}
+
+func (o opsgenie) Resolve(integration Integration, dedupKey string) error {
+	endpoint := fmt.Sprintf("%s/v2/alerts/%s/close?identifierType=alias", o.baseURL(integration), url.PathEscape(dedupKey))
+	return post(endpoint, o.headers(integration), opsgenieClose{
+		Source: "velld",
+		Note:   "Resolved by velld",
+	})
+}
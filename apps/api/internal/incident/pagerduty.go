@@ -0,0 +1,77 @@
+package incident
+
+import "time"
+
+const ProviderPagerDuty = "pagerduty"
+
+// pagerDutyEventsURL is the endpoint of the PagerDuty Events API v2
+const pagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+
+type pagerDuty struct{}
+
+type pagerDutyEvent struct {
+	RoutingKey  string            `json:"routing_key"`
+	EventAction string            `json:"event_action"`
+	DedupKey    string            `json:"dedup_key"`
+	Payload     *pagerDutyPayload `json:"payload,omitempty"`
+	Links       []pagerDutyLink   `json:"links,omitempty"`
+}
+
+type pagerDutyPayload struct {
+	Summary       string                 `json:"summary"`
+	Source        string                 `json:"source"`
+	Severity      Severity               `json:"severity"`
+	Timestamp     string                 `json:"timestamp"`
+	Component     string                 `json:"component"`
+	CustomDetails map[string]interface{} `json:"custom_details,omitempty"`
+}
+
+type pagerDutyLink struct {
+	Href string `json:"href"`
+	Text string `json:"text"`
+}
+
+func (pagerDuty) url(integration Integration) string {
+	if integration.APIURL != "" {
+		return integration.APIURL
+	}
+	return pagerDutyEventsURL
+}
+
+func (p pagerDuty) Trigger(integration Integration, alert Alert) error {
+	event := pagerDutyEvent{
+		RoutingKey:  integration.RoutingKey,
+		EventAction: "trigger",
+		DedupKey:    alert.DedupKey,
+		Payload: &pagerDutyPayload{
+			// PagerDuty truncates longer summaries
+			Summary:       truncate(alert.Summary, 1024),
+			Source:        alert.Source,
+			Severity:      alert.Severity,
+			Timestamp:     alert.Timestamp.Format(time.RFC3339),
+			Component:     "velld",
+			CustomDetails: alert.Details,
+		},
+	}
+	if alert.URL != "" {
+		event.Links = []pagerDutyLink{{Href: alert.URL, Text: "View backup"}}
+	}
+	return post(p.url(integration), nil, event)
+}
+
+func (p pagerDuty) Resolve(integration Integration, dedupKey string) error {
+	return post(p.url(integration), nil, pagerDutyEvent{
+		RoutingKey:  integration.RoutingKey,
+		EventAction: "resolve",
+		DedupKey:    dedupKey,
+	})
+}
+
+// truncate shortens s to max characters
+func truncate(s string, max int) string {
+	runes := []rune(s)
+	if len(runes) <= max {
+		return s
+	}
+	return string(runes[:max-1]) + "…"
+}
@@ -1,81 +1,145 @@
 package mail
 
 import (
+	"bytes"
 	"crypto/tls"
 	"fmt"
+	"io"
 	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
 	"net"
+	netmail "net/mail"
 	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// TLS modes of an SMTP connection
+const (
+	TLSNone     = "none"
+	TLSStartTLS = "starttls"
+	// TLSImplicit connects over TLS, as servers on port 465 expect
+	TLSImplicit = "implicit"
+)
+
+// Authentication mechanisms, AuthNone sends without authenticating as
+// internal relays expect
+const (
+	AuthPlain   = "plain"
+	AuthLogin   = "login"
+	AuthCRAMMD5 = "cram-md5"
+	AuthNone    = "none"
+)
+
+const dialTimeout = 30 * time.Second
+
 type SMTPConfig struct {
 	Host     string
 	Port     int
 	Username string
 	Password string
+	// TLSMode defaults to implicit TLS on port 465 and STARTTLS elsewhere
+	TLSMode string
+	// AuthMethod defaults to PLAIN with a username and none without
+	AuthMethod string
+	// HeloName is sent in EHLO, "localhost" when empty
+	HeloName string
 }
 
-type Message struct {
-	From    string
-	To      string
-	Subject string
-	Body    string
+// ValidateTLSMode checks a TLS mode, empty picks the default
+func ValidateTLSMode(mode string) error {
+	switch mode {
+	case "", TLSNone, TLSStartTLS, TLSImplicit:
+		return nil
+	}
+	return fmt.Errorf("unknown SMTP TLS mode %q, expected none, starttls or implicit", mode)
 }
 
-func SendEmail(config *SMTPConfig, msg *Message) error {
-	addr := fmt.Sprintf("%s:%d", config.Host, config.Port)
-	auth := smtp.PlainAuth("", config.Username, config.Password, config.Host)
-
-	emailMsg := fmt.Sprintf("From: %s\r\n"+
-		"To: %s\r\n"+
-		"Subject: %s\r\n"+
-		"MIME-Version: 1.0\r\n"+
-		"Content-Type: text/plain; charset=utf-8\r\n"+
-		"\r\n"+
-		"%s\r\n", msg.From, msg.To, msg.Subject, msg.Body)
-
-	conn, err := net.Dial("tcp", addr)
-	if err != nil {
-		log.Printf("Failed to connect to SMTP server: %v", err)
-		return err
+// ValidateAuthMethod checks an authentication mechanism, empty picks the
+// default
+func ValidateAuthMethod(method string) error {
+	switch method {
+	case "", AuthPlain, AuthLogin, AuthCRAMMD5, AuthNone:
+		return nil
 	}
-	defer conn.Close()
+	return fmt.Errorf("unknown SMTP auth method %q, expected plain, login, cram-md5 or none", method)
+}
 
-	client, err := smtp.NewClient(conn, config.Host)
-	if err != nil {
-		log.Printf("Failed to create SMTP client: %v", err)
-		return err
+func (c *SMTPConfig) tlsMode() string {
+	if c.TLSMode != "" {
+		return c.TLSMode
 	}
-	defer client.Close()
+	if c.Port == 465 {
+		return TLSImplicit
+	}
+	return TLSStartTLS
+}
 
-	if err = client.Hello("localhost"); err != nil {
-		log.Printf("Failed to send EHLO: %v", err)
-		return err
+func (c *SMTPConfig) authMethod() string {
+	if c.AuthMethod != "" {
+		return c.AuthMethod
+	}
+	if c.Username == "" {
+		return AuthNone
 	}
+	return AuthPlain
+}
 
-	tlsConfig := &tls.Config{
-		ServerName:         config.Host,
-		InsecureSkipVerify: false,
+// auth returns the authentication of the config, nil for AuthNone
+func (c *SMTPConfig) auth() smtp.Auth {
+	switch c.authMethod() {
+	case AuthLogin:
+		return &loginAuth{username: c.Username, password: c.Password}
+	case AuthCRAMMD5:
+		return smtp.CRAMMD5Auth(c.Username, c.Password)
+	case AuthPlain:
+		return &plainAuth{username: c.Username, password: c.Password}
+	}
+	return nil
+}
+
+type Message struct {
+	// From is the sender address, FromName its optional display name
+	From     string
+	FromName string
+	To       []string
+	CC       []string
+	Subject  string
+	Body     string
+	// HTMLBody is sent along with Body as multipart/alternative when set
+	HTMLBody string
+}
+
+func SendEmail(config *SMTPConfig, msg *Message) error {
+	if len(msg.To) == 0 {
+		return fmt.Errorf("no recipients")
 	}
 
-	if err = client.StartTLS(tlsConfig); err != nil {
-		log.Printf("Failed to start TLS: %v", err)
+	emailMsg, err := buildMessage(msg)
+	if err != nil {
 		return err
 	}
 
-	if err = client.Auth(auth); err != nil {
-		log.Printf("Failed to authenticate: %v", err)
+	client, err := dial(config)
+	if err != nil {
 		return err
 	}
+	defer client.Close()
 
 	if err = client.Mail(msg.From); err != nil {
 		log.Printf("Failed to set sender: %v", err)
 		return err
 	}
 
-	if err = client.Rcpt(msg.To); err != nil {
-		log.Printf("Failed to set recipient: %v", err)
-		return err
+	for _, recipient := range append(append([]string{}, msg.To...), msg.CC...) {
+		if err = client.Rcpt(recipient); err != nil {
+			log.Printf("Failed to set recipient %s: %v", recipient, err)
+			return err
+		}
 	}
 
 	w, err := client.Data()
@@ -84,7 +148,7 @@ func SendEmail(config *SMTPConfig, msg *Message) error {
 		return err
 	}
 
-	_, err = w.Write([]byte(emailMsg))
+	_, err = w.Write(emailMsg)
 	if err != nil {
 		log.Printf("Failed to write email body: %v", err)
 		return err
@@ -96,6 +160,178 @@ func SendEmail(config *SMTPConfig, msg *Message) error {
 		return err
 	}
 
-	log.Printf("Email sent successfully to %s", msg.To)
+	log.Printf("Email sent successfully to %s", strings.Join(msg.To, ", "))
 	return client.Quit()
 }
+
+// dial connects to the SMTP server, greets it, sets up TLS according to the
+// TLS mode and authenticates
+func dial(config *SMTPConfig) (*smtp.Client, error) {
+	addr := net.JoinHostPort(config.Host, strconv.Itoa(config.Port))
+	tlsConfig := &tls.Config{
+		ServerName:         config.Host,
+		InsecureSkipVerify: false,
+	}
+	mode := config.tlsMode()
+
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	var conn net.Conn
+	var err error
+	if mode == TLSImplicit {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		log.Printf("Failed to connect to SMTP server: %v", err)
+		return nil, err
+	}
+
+	client, err := smtp.NewClient(conn, config.Host)
+	if err != nil {
+		conn.Close()
+		log.Printf("Failed to create SMTP client: %v", err)
+		return nil, err
+	}
+
+	heloName := config.HeloName
+	if heloName == "" {
+		heloName = "localhost"
+	}
+	if err = client.Hello(heloName); err != nil {
+		client.Close()
+		log.Printf("Failed to send EHLO: %v", err)
+		return nil, err
+	}
+
+	if mode == TLSStartTLS {
+		if err = client.StartTLS(tlsConfig); err != nil {
+			client.Close()
+			log.Printf("Failed to start TLS: %v", err)
+			return nil, err
+		}
+	}
+
+	if auth := config.auth(); auth != nil {
+		if err = client.Auth(auth); err != nil {
+			client.Close()
+			log.Printf("Failed to authenticate: %v", err)
+			return nil, err
+		}
+	}
+
+	return client, nil
+}
+
+// plainAuth is PLAIN authentication. Unlike smtp.PlainAuth it also works
+// without TLS, which the none TLS mode asks for explicitly.
+type plainAuth struct {
+	username, password string
+}
+
+func (a *plainAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	return "PLAIN", []byte("\x00" + a.username + "\x00" + a.password), nil
+}
+
+func (a *plainAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if more {
+		return nil, fmt.Errorf("unexpected server challenge")
+	}
+	return nil, nil
+}
+
+// loginAuth is the LOGIN mechanism, which net/smtp does not provide and
+// Microsoft servers still require
+type loginAuth struct {
+	username, password string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	switch strings.ToLower(strings.TrimSpace(string(fromServer))) {
+	case "username:":
+		return []byte(a.username), nil
+	case "password:":
+		return []byte(a.password), nil
+	}
+	return nil, fmt.Errorf("unexpected server challenge %q", fromServer)
+}
+
+// ParseAddresses splits a comma separated list of email addresses, an empty
+// list has no addresses
+func ParseAddresses(list string) ([]string, error) {
+	if strings.TrimSpace(list) == "" {
+		return nil, nil
+	}
+	parsed, err := netmail.ParseAddressList(list)
+	if err != nil {
+		return nil, fmt.Errorf("invalid email address list %q: %w", list, err)
+	}
+	addresses := make([]string, 0, len(parsed))
+	for _, address := range parsed {
+		addresses = append(addresses, address.Address)
+	}
+	return addresses, nil
+}
+
+// buildMessage renders the headers and body of msg, as multipart/alternative
+// when it has an HTML body
+func buildMessage(msg *Message) ([]byte, error) {
+	var buf bytes.Buffer
+	from := (&netmail.Address{Name: msg.FromName, Address: msg.From}).String()
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(msg.To, ", "))
+	if len(msg.CC) > 0 {
+		fmt.Fprintf(&buf, "Cc: %s\r\n", strings.Join(msg.CC, ", "))
+	}
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+
+	if msg.HTMLBody == "" {
+		buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+		buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+		if err := writeQuotedPrintable(&buf, msg.Body); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	writer := multipart.NewWriter(&buf)
+	fmt.Fprintf(&buf, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", writer.Boundary())
+
+	for _, part := range []struct{ contentType, body string }{
+		{"text/plain; charset=utf-8", msg.Body},
+		{"text/html; charset=utf-8", msg.HTMLBody},
+	} {
+		partWriter, err := writer.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := writeQuotedPrintable(partWriter, part.body); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeQuotedPrintable(w io.Writer, body string) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write([]byte(body)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
@@ -0,0 +1,207 @@
+package mail
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+// Default templates, used for the templates a user did not customize
+const (
+	DefaultSubjectTemplate = `Velld - {{.Event.Title}}`
+
+	DefaultTextTemplate = `{{.Event.Message}}
+
+Connection: {{.Connection.Name}} ({{.Connection.Type}})
+Database: {{.Connection.DatabaseName}}
+{{- with .Backup}}
+{{- if .Size}}
+Size: {{.SizeHuman}}
+{{- end}}
+{{- if .Duration}}
+Duration: {{.Duration}}
+{{- end}}
+{{- if .URL}}
+Backup: {{.URL}}
+{{- end}}
+{{- end}}
+{{- if .Error}}
+
+Error:
+{{.Error}}
+{{- end}}
+`
+
+	DefaultHTMLTemplate = `<!DOCTYPE html>
+<html>
+<body style="font-family: -apple-system, Segoe UI, Helvetica, Arial, sans-serif; color: #1f2937;">
+  <h2 style="margin-bottom: 4px;">{{.Event.Title}}</h2>
+  <p>{{.Event.Message}}</p>
+  <table cellpadding="4" style="border-collapse: collapse;">
+    <tr><td><strong>Connection</strong></td><td>{{.Connection.Name}} ({{.Connection.Type}})</td></tr>
+    <tr><td><strong>Database</strong></td><td>{{.Connection.DatabaseName}}</td></tr>
+    {{- with .Backup}}
+    {{- if .Size}}
+    <tr><td><strong>Size</strong></td><td>{{.SizeHuman}}</td></tr>
+    {{- end}}
+    {{- if .Duration}}
+    <tr><td><strong>Duration</strong></td><td>{{.Duration}}</td></tr>
+    {{- end}}
+    {{- end}}
+  </table>
+  {{- if .Error}}
+  <pre style="background: #fef2f2; color: #991b1b; padding: 8px; white-space: pre-wrap;">{{.Error}}</pre>
+  {{- end}}
+  {{- with .Backup}}{{if .URL}}
+  <p><a href="{{.URL}}">View backup</a></p>
+  {{- end}}{{end}}
+  <p style="color: #6b7280; font-size: 12px;">Sent by Velld at {{.Event.Timestamp.Format "2006-01-02 15:04:05 MST"}}</p>
+</body>
+</html>
+`
+)
+
+// Templates are the subject and body templates of notification emails. An
+// empty template falls back to its default.
+type Templates struct {
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// TemplateData is what templates are executed with
+type TemplateData struct {
+	Event      TemplateEvent
+	Connection TemplateConnection
+	// Backup is nil for events that are not about a single backup
+	Backup *TemplateBackup
+	Error  string
+	// Metadata holds every detail of the event, including those without a
+	// field above
+	Metadata map[string]interface{}
+}
+
+type TemplateEvent struct {
+	Type      string
+	Title     string
+	Message   string
+	Timestamp time.Time
+}
+
+type TemplateConnection struct {
+	ID           string
+	Name         string
+	Type         string
+	Host         string
+	DatabaseName string
+}
+
+type TemplateBackup struct {
+	ID        string
+	Size      int64
+	SizeHuman string
+	Duration  string
+	URL       string
+}
+
+// Rendered is an email rendered from Templates
+type Rendered struct {
+	Subject string `json:"subject"`
+	Text    string `json:"text"`
+	HTML    string `json:"html"`
+}
+
+type parsedTemplates struct {
+	subject *texttemplate.Template
+	text    *texttemplate.Template
+	html    *htmltemplate.Template
+}
+
+// ValidateTemplates checks that templates parse and render the sample data
+func ValidateTemplates(templates Templates) error {
+	_, err := Render(templates, SampleData())
+	return err
+}
+
+// Render executes templates with data
+func Render(templates Templates, data *TemplateData) (*Rendered, error) {
+	parsed, err := parseTemplates(templates)
+	if err != nil {
+		return nil, err
+	}
+
+	var subject, text, html bytes.Buffer
+	if err := parsed.subject.Execute(&subject, data); err != nil {
+		return nil, fmt.Errorf("failed to render subject template: %w", err)
+	}
+	if err := parsed.text.Execute(&text, data); err != nil {
+		return nil, fmt.Errorf("failed to render text template: %w", err)
+	}
+	if err := parsed.html.Execute(&html, data); err != nil {
+		return nil, fmt.Errorf("failed to render HTML template: %w", err)
+	}
+
+	return &Rendered{
+		// A header cannot span lines
+		Subject: strings.Join(strings.Fields(subject.String()), " "),
+		Text:    text.String(),
+		HTML:    html.String(),
+	}, nil
+}
+
+func parseTemplates(templates Templates) (*parsedTemplates, error) {
+	subject, err := texttemplate.New("subject").Parse(orDefault(templates.Subject, DefaultSubjectTemplate))
+	if err != nil {
+		return nil, fmt.Errorf("invalid subject template: %w", err)
+	}
+	text, err := texttemplate.New("text").Parse(orDefault(templates.Text, DefaultTextTemplate))
+	if err != nil {
+		return nil, fmt.Errorf("invalid text template: %w", err)
+	}
+	html, err := htmltemplate.New("html").Parse(orDefault(templates.HTML, DefaultHTMLTemplate))
+	if err != nil {
+		return nil, fmt.Errorf("invalid HTML template: %w", err)
+	}
+	return &parsedTemplates{subject: subject, text: text, html: html}, nil
+}
+
+// SampleData is a failed backup used to preview templates
+func SampleData() *TemplateData {
+	return &TemplateData{
+		Event: TemplateEvent{
+			Type:      "backup_failed",
+			Title:     "Backup Failed",
+			Message:   "Backup failed for database 'shop': pg_dump exited with status 1",
+			Timestamp: time.Now(),
+		},
+		Connection: TemplateConnection{
+			ID:           "00000000-0000-0000-0000-000000000000",
+			Name:         "Production",
+			Type:         "postgresql",
+			Host:         "db.example.com",
+			DatabaseName: "shop",
+		},
+		Backup: &TemplateBackup{
+			ID:        "00000000-0000-0000-0000-000000000001",
+			Size:      0,
+			SizeHuman: "0 B",
+			Duration:  "12s",
+			URL:       "http://localhost:3000/history?backup=00000000-0000-0000-0000-000000000001",
+		},
+		Error: "pg_dump: error: connection to server at \"db.example.com\" failed: FATAL: password authentication failed",
+		Metadata: map[string]interface{}{
+			"type":  "backup_failed",
+			"error": "pg_dump: error: connection to server failed",
+		},
+	}
+}
+
+func orDefault(value, fallback string) string {
+	if strings.TrimSpace(value) == "" {
+		return fallback
+	}
+	return value
+}
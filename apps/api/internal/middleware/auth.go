@@ -23,6 +23,11 @@ func NewAuthMiddleware(jwtSecret string) *AuthMiddleware {
 func (m *AuthMiddleware) RequireAuth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		authHeader := r.Header.Get("Authorization")
+		// Browsers cannot set headers on EventSource requests, so the event
+		// stream takes the token as a query parameter
+		if authHeader == "" && r.URL.Path == "/api/events" {
+			authHeader = r.URL.Query().Get("access_token")
+		}
 		if authHeader == "" {
 			http.Error(w, "unauthorized", http.StatusUnauthorized)
 			return
@@ -0,0 +1,161 @@
+package notification
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+)
+
+// Channels notifications are delivered through
+const (
+	ChannelDashboard = "dashboard"
+	ChannelEmail     = "email"
+	ChannelWebhook   = "webhook"
+	ChannelSlack     = "slack"
+	ChannelDiscord   = "discord"
+	ChannelTeams     = "teams"
+	ChannelTelegram  = "telegram"
+)
+
+// Channels lists every delivery channel
+var Channels = []string{
+	ChannelDashboard, ChannelEmail, ChannelWebhook,
+	ChannelSlack, ChannelDiscord, ChannelTeams, ChannelTelegram,
+}
+
+// ChatChannels lists the channels that post formatted messages to a chat
+// platform
+var ChatChannels = []string{ChannelSlack, ChannelDiscord, ChannelTeams, ChannelTelegram}
+
+// IsChatChannel reports whether channel posts to a chat platform
+func IsChatChannel(channel string) bool {
+	return containsString(ChatChannels, channel)
+}
+
+// EventTypes lists every notification type a channel can subscribe to
+var EventTypes = []NotificationType{
+	BackupStarted,
+	BackupCompleted,
+	BackupFailed,
+	BackupSuspicious,
+	UploadFailed,
+	RetentionDeleted,
+	RestoreStarted,
+	RestoreCompleted,
+	RestoreFailed,
+	RPOBreached,
+	RPORecovered,
+}
+
+// DefaultEvents are delivered through channels without subscriptions, so
+// that only problems are reported until a user asks for more
+var DefaultEvents = []NotificationType{
+	BackupFailed,
+	BackupSuspicious,
+	UploadFailed,
+	RestoreFailed,
+	RPOBreached,
+	RPORecovered,
+}
+
+// EventSubscriptions lists per channel the notification types delivered
+// through it. A channel that is not listed receives DefaultEvents, an empty
+// list turns the channel off.
+type EventSubscriptions map[string][]NotificationType
+
+// EventCatalog describes the channels and events for clients
+type EventCatalog struct {
+	Channels      []string           `json:"channels"`
+	Events        []NotificationType `json:"events"`
+	DefaultEvents []NotificationType `json:"default_events"`
+}
+
+// Catalog returns the channels and events that can be subscribed to
+func Catalog() EventCatalog {
+	return EventCatalog{
+		Channels:      Channels,
+		Events:        EventTypes,
+		DefaultEvents: DefaultEvents,
+	}
+}
+
+// Validate checks that the subscriptions only name known channels and events
+func (s EventSubscriptions) Validate() error {
+	for channel, events := range s {
+		if !containsString(Channels, channel) {
+			return fmt.Errorf("unknown notification channel %q", channel)
+		}
+		for _, event := range events {
+			if !containsType(EventTypes, event) {
+				return fmt.Errorf("unknown notification event %q for channel %s", event, channel)
+			}
+		}
+	}
+	return nil
+}
+
+// Merge returns s with the channels listed in override replaced by those of
+// override
+func (s EventSubscriptions) Merge(override EventSubscriptions) EventSubscriptions {
+	merged := EventSubscriptions{}
+	for channel, events := range s {
+		merged[channel] = events
+	}
+	for channel, events := range override {
+		merged[channel] = events
+	}
+	return merged
+}
+
+// Wants reports whether a channel receives events of the given type
+func (s EventSubscriptions) Wants(channel string, eventType NotificationType) bool {
+	events, ok := s[channel]
+	if !ok {
+		events = DefaultEvents
+	}
+	return containsType(events, eventType)
+}
+
+// MarshalEventSubscriptions encodes subscriptions for a TEXT column, storing
+// NULL when there are none.
+func MarshalEventSubscriptions(s EventSubscriptions) (sql.NullString, error) {
+	if len(s) == 0 {
+		return sql.NullString{}, nil
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		return sql.NullString{}, fmt.Errorf("failed to encode notification events: %w", err)
+	}
+	return sql.NullString{String: string(data), Valid: true}, nil
+}
+
+// UnmarshalEventSubscriptions decodes a column written by
+// MarshalEventSubscriptions.
+func UnmarshalEventSubscriptions(value sql.NullString) (EventSubscriptions, error) {
+	if !value.Valid || value.String == "" {
+		return nil, nil
+	}
+	var s EventSubscriptions
+	if err := json.Unmarshal([]byte(value.String), &s); err != nil {
+		return nil, fmt.Errorf("failed to decode notification events: %w", err)
+	}
+	return s, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func containsType(values []NotificationType, value NotificationType) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
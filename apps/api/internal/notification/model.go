@@ -12,6 +12,22 @@ type NotificationType string
 const (
 	BackupFailed    NotificationType = "backup_failed"
 	BackupCompleted NotificationType = "backup_completed"
+	// BackupSuspicious is a backup that completed but whose size or duration
+	// is far from the usual for its schedule
+	BackupSuspicious NotificationType = "backup_suspicious"
+	// RPOBreached and RPORecovered follow whether a connection had a
+	// completed backup within its recovery point objective
+	RPOBreached  NotificationType = "rpo_breached"
+	RPORecovered NotificationType = "rpo_recovered"
+
+	BackupStarted    NotificationType = "backup_started"
+	RestoreStarted   NotificationType = "restore_started"
+	RestoreCompleted NotificationType = "restore_completed"
+	RestoreFailed    NotificationType = "restore_failed"
+	// RetentionDeleted reports backups removed by the retention of a schedule
+	RetentionDeleted NotificationType = "retention_deleted"
+	// UploadFailed is a backup that completed but could not be uploaded
+	UploadFailed NotificationType = "upload_failed"
 )
 
 type NotificationStatus string
@@ -22,30 +38,33 @@ const (
 )
 
 type Notification struct {
-	ID        uuid.UUID          `json:"id"`
-	UserID    uuid.UUID          `json:"user_id"`
-	Title     string             `json:"title"`
-	Message   string             `json:"message"`
-	Type      NotificationType   `json:"type"`
-	Status    NotificationStatus `json:"status"`
-	Metadata  json.RawMessage    `json:"metadata,omitempty"`
-	CreatedAt time.Time          `json:"created_at"`
-	UpdatedAt time.Time          `json:"updated_at"`
+	ID           uuid.UUID          `json:"id"`
+	UserID       uuid.UUID          `json:"user_id"`
+	Title        string             `json:"title"`
+	Message      string             `json:"message"`
+	Type         NotificationType   `json:"type"`
+	Status       NotificationStatus `json:"status"`
+	Metadata     json.RawMessage    `json:"metadata,omitempty"`
+	ConnectionID *string            `json:"connection_id,omitempty"`
+	CreatedAt    time.Time          `json:"created_at"`
+	UpdatedAt    time.Time          `json:"updated_at"`
 }
 
 type NotificationList struct {
-	ID        uuid.UUID          `json:"id"`
-	Title     string             `json:"title"`
-	Message   string             `json:"message"`
-	Type      NotificationType   `json:"type"`
-	Status    NotificationStatus `json:"status"`
-	CreatedAt string             `json:"created_at"`
+	ID           uuid.UUID          `json:"id"`
+	Title        string             `json:"title"`
+	Message      string             `json:"message"`
+	Type         NotificationType   `json:"type"`
+	Status       NotificationStatus `json:"status"`
+	ConnectionID *string            `json:"connection_id,omitempty"`
+	CreatedAt    string             `json:"created_at"`
 }
 
 type NotificationListOptions struct {
-	UserID  uuid.UUID
-	Status  *NotificationStatus
-	Type    *NotificationType
-	Limit   int
-	Offset  int
+	UserID       uuid.UUID
+	Status       *NotificationStatus
+	Type         *NotificationType
+	ConnectionID string
+	Limit        int
+	Offset       int
 }
@@ -1,12 +1,16 @@
 package notification
 
 import (
+	"database/sql"
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"strconv"
 
 	"github.com/dendianugerah/velld/internal/common"
 	"github.com/dendianugerah/velld/internal/common/response"
 	"github.com/google/uuid"
+	"github.com/gorilla/mux"
 )
 
 type NotificationHandler struct {
@@ -24,15 +28,65 @@ func (h *NotificationHandler) GetNotifications(w http.ResponseWriter, r *http.Re
 		return
 	}
 
-	notifications, err := h.service.GetNotifications(userID)
+	page := 1
+	limit := 50
+	if pageStr := r.URL.Query().Get("page"); pageStr != "" {
+		if p, err := strconv.Atoi(pageStr); err == nil && p > 0 {
+			page = p
+		}
+	}
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 {
+			limit = l
+		}
+	}
+
+	opts := NotificationListOptions{
+		UserID:       userID,
+		ConnectionID: r.URL.Query().Get("connection_id"),
+		Limit:        limit,
+		Offset:       (page - 1) * limit,
+	}
+	if statusStr := r.URL.Query().Get("status"); statusStr != "" {
+		status := NotificationStatus(statusStr)
+		if status != StatusUnread && status != StatusRead {
+			response.SendError(w, http.StatusBadRequest, "status must be unread or read")
+			return
+		}
+		opts.Status = &status
+	}
+	if typeStr := r.URL.Query().Get("type"); typeStr != "" {
+		notificationType := NotificationType(typeStr)
+		opts.Type = &notificationType
+	}
+
+	notifications, total, err := h.service.ListNotifications(opts)
 	if err != nil {
 		response.SendError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	response.SendSuccess(w, "Notifications retrieved successfully", notifications)
+	response.SendPaginatedSuccess(w, "Notifications retrieved successfully", notifications, page, limit, total)
 }
 
+func (h *NotificationHandler) GetUnreadCount(w http.ResponseWriter, r *http.Request) {
+	userID, err := common.GetUserIDFromContext(r.Context())
+	if err != nil {
+		response.SendError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	count, err := h.service.CountUnread(userID)
+	if err != nil {
+		response.SendError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response.SendSuccess(w, "Unread count retrieved successfully", map[string]int{"unread": count})
+}
+
+// MarkAsRead marks one notification, or several with notification_ids, as
+// read
 func (h *NotificationHandler) MarkAsRead(w http.ResponseWriter, r *http.Request) {
 	userID, err := common.GetUserIDFromContext(r.Context())
 	if err != nil {
@@ -41,7 +95,8 @@ func (h *NotificationHandler) MarkAsRead(w http.ResponseWriter, r *http.Request)
 	}
 
 	var req struct {
-		NotificationID string `json:"notification_id"`
+		NotificationID  string   `json:"notification_id"`
+		NotificationIDs []string `json:"notification_ids"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -49,6 +104,21 @@ func (h *NotificationHandler) MarkAsRead(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	if req.NotificationIDs != nil {
+		notificationIDs, err := parseNotificationIDs(req.NotificationIDs)
+		if err != nil {
+			response.SendError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		updated, err := h.service.MarkManyAsRead(userID, notificationIDs)
+		if err != nil {
+			response.SendError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		response.SendSuccess(w, "Notifications marked as read", map[string]int64{"updated": updated})
+		return
+	}
+
 	notificationID, err := uuid.Parse(req.NotificationID)
 	if err != nil {
 		response.SendError(w, http.StatusBadRequest, "invalid notification ID")
@@ -62,3 +132,243 @@ func (h *NotificationHandler) MarkAsRead(w http.ResponseWriter, r *http.Request)
 
 	response.SendSuccess(w, "Notification marked as read", nil)
 }
+
+func (h *NotificationHandler) MarkAllAsRead(w http.ResponseWriter, r *http.Request) {
+	userID, err := common.GetUserIDFromContext(r.Context())
+	if err != nil {
+		response.SendError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	updated, err := h.service.MarkAllAsRead(userID)
+	if err != nil {
+		response.SendError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response.SendSuccess(w, "Notifications marked as read", map[string]int64{"updated": updated})
+}
+
+func (h *NotificationHandler) DeleteNotification(w http.ResponseWriter, r *http.Request) {
+	userID, err := common.GetUserIDFromContext(r.Context())
+	if err != nil {
+		response.SendError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	notificationID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		response.SendError(w, http.StatusBadRequest, "invalid notification ID")
+		return
+	}
+
+	deleted, err := h.service.DeleteNotifications(userID, []uuid.UUID{notificationID})
+	if err != nil {
+		response.SendError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if deleted == 0 {
+		response.SendError(w, http.StatusNotFound, "Notification not found")
+		return
+	}
+
+	response.SendSuccess(w, "Notification deleted successfully", nil)
+}
+
+func (h *NotificationHandler) DeleteNotifications(w http.ResponseWriter, r *http.Request) {
+	userID, err := common.GetUserIDFromContext(r.Context())
+	if err != nil {
+		response.SendError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	var req struct {
+		NotificationIDs []string `json:"notification_ids"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.SendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	notificationIDs, err := parseNotificationIDs(req.NotificationIDs)
+	if err != nil {
+		response.SendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	deleted, err := h.service.DeleteNotifications(userID, notificationIDs)
+	if err != nil {
+		response.SendError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response.SendSuccess(w, "Notifications deleted successfully", map[string]int64{"deleted": deleted})
+}
+
+func parseNotificationIDs(ids []string) ([]uuid.UUID, error) {
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("notification_ids is required")
+	}
+	notificationIDs := make([]uuid.UUID, 0, len(ids))
+	for _, id := range ids {
+		notificationID, err := uuid.Parse(id)
+		if err != nil {
+			return nil, fmt.Errorf("invalid notification ID %q", id)
+		}
+		notificationIDs = append(notificationIDs, notificationID)
+	}
+	return notificationIDs, nil
+}
+
+// GetEventCatalog lists the channels and events notification subscriptions
+// can name
+func (h *NotificationHandler) GetEventCatalog(w http.ResponseWriter, r *http.Request) {
+	response.SendSuccess(w, "Notification events retrieved successfully", Catalog())
+}
+
+func (h *NotificationHandler) ListRules(w http.ResponseWriter, r *http.Request) {
+	userID, err := common.GetUserIDFromContext(r.Context())
+	if err != nil {
+		response.SendError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	rules, err := h.service.ListRules(userID)
+	if err != nil {
+		response.SendError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response.SendSuccess(w, "Notification rules retrieved successfully", rules)
+}
+
+func (h *NotificationHandler) GetRule(w http.ResponseWriter, r *http.Request) {
+	userID, err := common.GetUserIDFromContext(r.Context())
+	if err != nil {
+		response.SendError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	ruleID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		response.SendError(w, http.StatusBadRequest, "invalid rule ID")
+		return
+	}
+
+	rule, err := h.service.GetRule(userID, ruleID)
+	if err == sql.ErrNoRows {
+		response.SendError(w, http.StatusNotFound, "Notification rule not found")
+		return
+	}
+	if err != nil {
+		response.SendError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response.SendSuccess(w, "Notification rule retrieved successfully", rule)
+}
+
+func (h *NotificationHandler) CreateRule(w http.ResponseWriter, r *http.Request) {
+	userID, err := common.GetUserIDFromContext(r.Context())
+	if err != nil {
+		response.SendError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	var rule Rule
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		response.SendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	created, err := h.service.CreateRule(userID, &rule)
+	if err != nil {
+		response.SendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	response.SendSuccess(w, "Notification rule created successfully", created)
+}
+
+func (h *NotificationHandler) UpdateRule(w http.ResponseWriter, r *http.Request) {
+	userID, err := common.GetUserIDFromContext(r.Context())
+	if err != nil {
+		response.SendError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	ruleID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		response.SendError(w, http.StatusBadRequest, "invalid rule ID")
+		return
+	}
+
+	var rule Rule
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		response.SendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	updated, err := h.service.UpdateRule(userID, ruleID, &rule)
+	if err == sql.ErrNoRows {
+		response.SendError(w, http.StatusNotFound, "Notification rule not found")
+		return
+	}
+	if err != nil {
+		response.SendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	response.SendSuccess(w, "Notification rule updated successfully", updated)
+}
+
+func (h *NotificationHandler) DeleteRule(w http.ResponseWriter, r *http.Request) {
+	userID, err := common.GetUserIDFromContext(r.Context())
+	if err != nil {
+		response.SendError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	ruleID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		response.SendError(w, http.StatusBadRequest, "invalid rule ID")
+		return
+	}
+
+	err = h.service.DeleteRule(userID, ruleID)
+	if err == sql.ErrNoRows {
+		response.SendError(w, http.StatusNotFound, "Notification rule not found")
+		return
+	}
+	if err != nil {
+		response.SendError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response.SendSuccess(w, "Notification rule deleted successfully", nil)
+}
+
+// EvaluateRules shows which channels an event would be delivered through,
+// without sending anything
+func (h *NotificationHandler) EvaluateRules(w http.ResponseWriter, r *http.Request) {
+	userID, err := common.GetUserIDFromContext(r.Context())
+	if err != nil {
+		response.SendError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	var target RouteTarget
+	if err := json.NewDecoder(r.Body).Decode(&target); err != nil {
+		response.SendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	route, err := h.service.EvaluateRules(userID, target)
+	if err != nil {
+		response.SendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	response.SendSuccess(w, "Notification rules evaluated successfully", route)
+}
@@ -2,6 +2,8 @@ package notification
 
 import (
 	"database/sql"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -19,40 +21,78 @@ func (r *NotificationRepository) CreateNotification(n *Notification) error {
 	now := time.Now().Format(time.RFC3339)
 	_, err := r.db.Exec(`
 		INSERT INTO notifications (
-			id, user_id, title, message, type, status, metadata, created_at, updated_at
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
-		n.ID, n.UserID, n.Title, n.Message, n.Type, n.Status, n.Metadata, now, now)
+			id, user_id, title, message, type, status, metadata, connection_id, created_at, updated_at
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		n.ID, n.UserID, n.Title, n.Message, n.Type, n.Status, n.Metadata, n.ConnectionID, now, now)
 	return err
 }
 
-func (r *NotificationRepository) GetUserNotifications(userID uuid.UUID) ([]*NotificationList, error) {
-	query := `
-        SELECT id, title, message, type, status, created_at
+// ListNotifications returns a page of the notifications of a user, unread
+// first, together with how many match the options
+func (r *NotificationRepository) ListNotifications(opts NotificationListOptions) ([]*NotificationList, int, error) {
+	whereClause := "WHERE user_id = $1"
+	args := []interface{}{opts.UserID}
+	argCount := 2
+
+	if opts.Status != nil {
+		whereClause += fmt.Sprintf(" AND status = $%d", argCount)
+		args = append(args, *opts.Status)
+		argCount++
+	}
+
+	if opts.Type != nil {
+		whereClause += fmt.Sprintf(" AND type = $%d", argCount)
+		args = append(args, *opts.Type)
+		argCount++
+	}
+
+	if opts.ConnectionID != "" {
+		whereClause += fmt.Sprintf(" AND connection_id = $%d", argCount)
+		args = append(args, opts.ConnectionID)
+		argCount++
+	}
+
+	var total int
+	countQuery := fmt.Sprintf("SELECT COUNT(*) FROM notifications %s", whereClause)
+	if err := r.db.QueryRow(countQuery, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query := fmt.Sprintf(`
+        SELECT id, title, message, type, status, connection_id, created_at
         FROM notifications
-        WHERE user_id = $1
-        AND (status = 'unread' OR created_at > datetime('now', '-7 days'))
+        %s
         ORDER BY 
             CASE WHEN status = 'unread' THEN 0 ELSE 1 END,
             created_at DESC
-        LIMIT 50`
+        LIMIT $%d OFFSET $%d`, whereClause, argCount, argCount+1)
 
-	rows, err := r.db.Query(query, userID)
+	args = append(args, opts.Limit, opts.Offset)
+	rows, err := r.db.Query(query, args...)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	defer rows.Close()
 
-	var notifications []*NotificationList
+	notifications := make([]*NotificationList, 0)
 	for rows.Next() {
 		n := &NotificationList{}
-		err := rows.Scan(&n.ID, &n.Title, &n.Message, &n.Type, &n.Status, &n.CreatedAt)
+		err := rows.Scan(&n.ID, &n.Title, &n.Message, &n.Type, &n.Status, &n.ConnectionID, &n.CreatedAt)
 		if err != nil {
-			return nil, err
+			return nil, 0, err
 		}
 		notifications = append(notifications, n)
 	}
 
-	return notifications, nil
+	return notifications, total, rows.Err()
+}
+
+func (r *NotificationRepository) CountUnread(userID uuid.UUID) (int, error) {
+	var count int
+	err := r.db.QueryRow(
+		"SELECT COUNT(*) FROM notifications WHERE user_id = $1 AND status = $2",
+		userID, StatusUnread).Scan(&count)
+	return count, err
 }
 
 func (r *NotificationRepository) MarkAsRead(userID uuid.UUID, notificationID uuid.UUID) error {
@@ -65,9 +105,70 @@ func (r *NotificationRepository) MarkAsRead(userID uuid.UUID, notificationID uui
 	return err
 }
 
-func (r *NotificationRepository) DeleteNotifications(userID uuid.UUID, notificationIDs []uuid.UUID) error {
-	_, err := r.db.Exec(
-		"DELETE FROM notifications WHERE user_id = $1 AND id = ANY($2)",
-		userID, notificationIDs)
-	return err
+// MarkManyAsRead marks the given notifications of a user as read
+func (r *NotificationRepository) MarkManyAsRead(userID uuid.UUID, notificationIDs []uuid.UUID) (int64, error) {
+	if len(notificationIDs) == 0 {
+		return 0, nil
+	}
+
+	placeholders, args := idPlaceholders(notificationIDs, 4)
+	result, err := r.db.Exec(
+		fmt.Sprintf("UPDATE notifications SET status = $1, updated_at = $2 WHERE user_id = $3 AND id IN (%s)", placeholders),
+		append([]interface{}{StatusRead, time.Now().Format(time.RFC3339), userID}, args...)...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// MarkAllAsRead marks every unread notification of a user as read
+func (r *NotificationRepository) MarkAllAsRead(userID uuid.UUID) (int64, error) {
+	result, err := r.db.Exec(
+		"UPDATE notifications SET status = $1, updated_at = $2 WHERE user_id = $3 AND status = $4",
+		StatusRead, time.Now().Format(time.RFC3339), userID, StatusUnread)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// DeleteNotifications removes the given notifications of a user and returns
+// how many were removed
+func (r *NotificationRepository) DeleteNotifications(userID uuid.UUID, notificationIDs []uuid.UUID) (int64, error) {
+	if len(notificationIDs) == 0 {
+		return 0, nil
+	}
+
+	// SQLite has no arrays to compare against, so each ID gets a placeholder
+	placeholders, args := idPlaceholders(notificationIDs, 2)
+	result, err := r.db.Exec(
+		fmt.Sprintf("DELETE FROM notifications WHERE user_id = $1 AND id IN (%s)", placeholders),
+		append([]interface{}{userID}, args...)...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// PurgeReadNotifications removes read notifications created before cutoff
+func (r *NotificationRepository) PurgeReadNotifications(cutoff time.Time) (int64, error) {
+	result, err := r.db.Exec(
+		"DELETE FROM notifications WHERE status = $1 AND created_at < $2",
+		StatusRead, cutoff.Format(time.RFC3339))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// idPlaceholders returns "$first, $first+1, ..." for ids and the ids as
+// query arguments
+func idPlaceholders(ids []uuid.UUID, first int) (string, []interface{}) {
+	placeholders := make([]string, len(ids))
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		placeholders[i] = fmt.Sprintf("$%d", first+i)
+		args[i] = id
+	}
+	return strings.Join(placeholders, ", "), args
 }
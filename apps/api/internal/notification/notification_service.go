@@ -1,25 +1,193 @@
 package notification
 
 import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/dendianugerah/velld/internal/events"
 	"github.com/google/uuid"
 )
 
+const (
+	// defaultRetentionDays is how long read notifications are kept when
+	// NOTIFICATION_RETENTION_DAYS is not set
+	defaultRetentionDays = 30
+	// purgeInterval is how often old read notifications are purged
+	purgeInterval = time.Hour
+)
+
 type NotificationService struct {
-	repo *NotificationRepository
+	repo     *NotificationRepository
+	router   *Router
+	eventBus *events.Bus
+}
+
+// NotificationsReadEvent names notifications that were read, so that other
+// sessions of the user can update their unread count. All is set when every
+// notification of the user was marked read.
+type NotificationsReadEvent struct {
+	IDs []uuid.UUID `json:"ids,omitempty"`
+	All bool        `json:"all,omitempty"`
+}
+
+// NotificationsDeletedEvent names notifications that were deleted
+type NotificationsDeletedEvent struct {
+	IDs []uuid.UUID `json:"ids"`
+}
+
+func NewNotificationService(repo *NotificationRepository, eventBus *events.Bus) *NotificationService {
+	service := &NotificationService{repo: repo, router: NewRouter(repo), eventBus: eventBus}
+	go service.purgeLoop()
+	return service
 }
 
-func NewNotificationService(repo *NotificationRepository) *NotificationService {
-	return &NotificationService{repo: repo}
+func (s *NotificationService) ListNotifications(opts NotificationListOptions) ([]*NotificationList, int, error) {
+	return s.repo.ListNotifications(opts)
 }
 
-func (s *NotificationService) GetNotifications(userID uuid.UUID) ([]*NotificationList, error) {
-	return s.repo.GetUserNotifications(userID)
+func (s *NotificationService) CountUnread(userID uuid.UUID) (int, error) {
+	return s.repo.CountUnread(userID)
 }
 
 func (s *NotificationService) MarkAsRead(userID uuid.UUID, notificationID uuid.UUID) error {
-	return s.repo.MarkAsRead(userID, notificationID)
+	if err := s.repo.MarkAsRead(userID, notificationID); err != nil {
+		return err
+	}
+	s.eventBus.Publish(userID, events.NotificationsRead, NotificationsReadEvent{IDs: []uuid.UUID{notificationID}})
+	return nil
+}
+
+func (s *NotificationService) MarkManyAsRead(userID uuid.UUID, notificationIDs []uuid.UUID) (int64, error) {
+	updated, err := s.repo.MarkManyAsRead(userID, notificationIDs)
+	if err != nil {
+		return 0, err
+	}
+	if updated > 0 {
+		s.eventBus.Publish(userID, events.NotificationsRead, NotificationsReadEvent{IDs: notificationIDs})
+	}
+	return updated, nil
+}
+
+func (s *NotificationService) MarkAllAsRead(userID uuid.UUID) (int64, error) {
+	updated, err := s.repo.MarkAllAsRead(userID)
+	if err != nil {
+		return 0, err
+	}
+	if updated > 0 {
+		s.eventBus.Publish(userID, events.NotificationsRead, NotificationsReadEvent{All: true})
+	}
+	return updated, nil
+}
+
+func (s *NotificationService) DeleteNotifications(userID uuid.UUID, notificationIDs []uuid.UUID) (int64, error) {
+	deleted, err := s.repo.DeleteNotifications(userID, notificationIDs)
+	if err != nil {
+		return 0, err
+	}
+	if deleted > 0 {
+		s.eventBus.Publish(userID, events.NotificationsDeleted, NotificationsDeletedEvent{IDs: notificationIDs})
+	}
+	return deleted, nil
+}
+
+// retentionDays returns how many days read notifications are kept, 0 when
+// they are kept forever
+func retentionDays() int {
+	value := os.Getenv("NOTIFICATION_RETENTION_DAYS")
+	if value == "" {
+		return defaultRetentionDays
+	}
+	days, err := strconv.Atoi(value)
+	if err != nil || days < 0 {
+		fmt.Printf("Warning: invalid NOTIFICATION_RETENTION_DAYS %q, using %d\n", value, defaultRetentionDays)
+		return defaultRetentionDays
+	}
+	return days
+}
+
+// purgeLoop removes read notifications older than the retention and rule
+// sends no rate limit counts anymore, so that neither table grows without
+// bound
+func (s *NotificationService) purgeLoop() {
+	days := retentionDays()
+
+	ticker := time.NewTicker(purgeInterval)
+	defer ticker.Stop()
+	for {
+		if days > 0 {
+			deleted, err := s.repo.PurgeReadNotifications(time.Now().AddDate(0, 0, -days))
+			if err != nil {
+				fmt.Printf("ERROR: failed to purge read notifications: %v\n", err)
+			} else if deleted > 0 {
+				fmt.Printf("Purged %d read notifications older than %d days\n", deleted, days)
+			}
+		}
+		if _, err := s.repo.PurgeRuleSends(time.Now().Add(-maxRateLimitWindow)); err != nil {
+			fmt.Printf("ERROR: failed to purge notification rule sends: %v\n", err)
+		}
+		<-ticker.C
+	}
+}
+
+func (s *NotificationService) ListRules(userID uuid.UUID) ([]*Rule, error) {
+	return s.repo.ListRules(userID)
+}
+
+func (s *NotificationService) GetRule(userID, ruleID uuid.UUID) (*Rule, error) {
+	return s.repo.GetRule(userID, ruleID)
+}
+
+func (s *NotificationService) CreateRule(userID uuid.UUID, rule *Rule) (*Rule, error) {
+	if err := rule.Validate(); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	rule.ID = uuid.New()
+	rule.UserID = userID
+	rule.CreatedAt = now
+	rule.UpdatedAt = now
+	if err := s.repo.CreateRule(rule); err != nil {
+		return nil, fmt.Errorf("failed to create notification rule: %v", err)
+	}
+	return rule, nil
+}
+
+// UpdateRule replaces a rule of the user, returning sql.ErrNoRows when there
+// is no such rule
+func (s *NotificationService) UpdateRule(userID, ruleID uuid.UUID, rule *Rule) (*Rule, error) {
+	if err := rule.Validate(); err != nil {
+		return nil, err
+	}
+
+	existing, err := s.repo.GetRule(userID, ruleID)
+	if err != nil {
+		return nil, err
+	}
+	rule.ID = existing.ID
+	rule.UserID = existing.UserID
+	rule.CreatedAt = existing.CreatedAt
+	rule.UpdatedAt = time.Now()
+	if err := s.repo.UpdateRule(rule); err != nil {
+		return nil, err
+	}
+	return rule, nil
+}
+
+func (s *NotificationService) DeleteRule(userID, ruleID uuid.UUID) error {
+	return s.repo.DeleteRule(userID, ruleID)
 }
 
-func (s *NotificationService) DeleteNotifications(userID uuid.UUID, notificationIDs []uuid.UUID) error {
-	return s.repo.DeleteNotifications(userID, notificationIDs)
+// EvaluateRules shows how the rules of a user would route an event, without
+// counting it against rate limits
+func (s *NotificationService) EvaluateRules(userID uuid.UUID, target RouteTarget) (*Route, error) {
+	if !containsType(EventTypes, target.EventType) {
+		return nil, fmt.Errorf("unknown notification event %q", target.EventType)
+	}
+	if target.Severity != "" && !containsSeverity(Severities, target.Severity) {
+		return nil, fmt.Errorf("unknown severity %q", target.Severity)
+	}
+	return s.router.Evaluate(userID, target, time.Now())
 }
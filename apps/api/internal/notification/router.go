@@ -0,0 +1,136 @@
+package notification
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Router evaluates the notification rules of a user for an event
+type Router struct {
+	repo *NotificationRepository
+}
+
+// Route is the outcome of evaluating rules for an event
+type Route struct {
+	// HasRules is false when the user has no enabled rules, in which case
+	// the channel toggles of their settings apply instead
+	HasRules bool `json:"has_rules"`
+	// Channels the event is delivered through
+	Channels []string `json:"channels"`
+	// Rules that matched the event, with what each one did
+	Rules []RuleOutcome `json:"rules"`
+}
+
+// RuleOutcome describes how one matching rule handled an event
+type RuleOutcome struct {
+	RuleID   uuid.UUID `json:"rule_id"`
+	Name     string    `json:"name"`
+	Channels []string  `json:"channels"`
+	// Held is set when quiet hours or the rate limit kept the rule to the
+	// dashboard, and says which
+	Held string `json:"held,omitempty"`
+}
+
+const (
+	heldQuietHours = "quiet_hours"
+	heldRateLimit  = "rate_limit"
+)
+
+func NewRouter(repo *NotificationRepository) *Router {
+	return &Router{repo: repo}
+}
+
+// Route returns the channels an event is delivered through and counts the
+// delivery against the rate limits of the rules that sent it
+func (r *Router) Route(userID uuid.UUID, target RouteTarget, now time.Time) (*Route, error) {
+	return r.evaluate(userID, target, now, true)
+}
+
+// Evaluate is Route without recording anything, so that rules can be tried
+// out
+func (r *Router) Evaluate(userID uuid.UUID, target RouteTarget, now time.Time) (*Route, error) {
+	return r.evaluate(userID, target, now, false)
+}
+
+func (r *Router) evaluate(userID uuid.UUID, target RouteTarget, now time.Time, record bool) (*Route, error) {
+	if target.Severity == "" {
+		target.Severity = EventSeverity(target.EventType)
+	}
+
+	rules, err := r.repo.ListEnabledRules(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load notification rules: %v", err)
+	}
+
+	route := &Route{HasRules: len(rules) > 0, Channels: []string{}, Rules: []RuleOutcome{}}
+	for _, rule := range rules {
+		if !rule.Match.Matches(target) {
+			continue
+		}
+
+		outcome, err := r.apply(rule, target, now, record)
+		if err != nil {
+			return nil, err
+		}
+
+		route.Rules = append(route.Rules, outcome)
+		for _, channel := range outcome.Channels {
+			if !containsString(route.Channels, channel) {
+				route.Channels = append(route.Channels, channel)
+			}
+		}
+		if rule.Stop {
+			break
+		}
+	}
+	return route, nil
+}
+
+// apply returns the channels a matching rule sends to, keeping only the
+// dashboard during quiet hours and once the rate limit is reached. With
+// record set, a send through other channels counts against the rate limit.
+func (r *Router) apply(rule *Rule, target RouteTarget, now time.Time, record bool) (RuleOutcome, error) {
+	outcome := RuleOutcome{RuleID: rule.ID, Name: rule.Name, Channels: rule.Channels}
+
+	switch limit := rule.RateLimit; {
+	case rule.QuietHours != nil && rule.QuietHours.Holds(now, target.Severity):
+		outcome.Held = heldQuietHours
+	case limit == nil || !sendsPush(rule.Channels):
+	case record:
+		claimed, err := r.repo.ClaimRuleSend(rule.ID, target.ConnectionID, now, now.Add(-limit.Window()), limit.Max)
+		if err != nil {
+			return outcome, fmt.Errorf("failed to record notification rule send: %v", err)
+		}
+		if !claimed {
+			outcome.Held = heldRateLimit
+		}
+	default:
+		sent, err := r.repo.CountRuleSends(rule.ID, target.ConnectionID, now.Add(-limit.Window()))
+		if err != nil {
+			return outcome, fmt.Errorf("failed to count notification rule sends: %v", err)
+		}
+		if sent >= limit.Max {
+			outcome.Held = heldRateLimit
+		}
+	}
+
+	if outcome.Held != "" {
+		outcome.Channels = []string{}
+		if containsString(rule.Channels, ChannelDashboard) {
+			outcome.Channels = []string{ChannelDashboard}
+		}
+	}
+	return outcome, nil
+}
+
+// sendsPush reports whether channels reach beyond the dashboard
+func sendsPush(channels []string) bool {
+	for _, channel := range channels {
+		if channel != ChannelDashboard {
+			return true
+		}
+	}
+	return false
+}
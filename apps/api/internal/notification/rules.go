@@ -0,0 +1,225 @@
+package notification
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Severity is how serious a notification event is
+type Severity string
+
+const (
+	SeverityInfo    Severity = "info"
+	SeveritySuccess Severity = "success"
+	SeverityWarning Severity = "warning"
+	SeverityError   Severity = "error"
+)
+
+// Severities lists every severity a rule can match
+var Severities = []Severity{SeverityInfo, SeveritySuccess, SeverityWarning, SeverityError}
+
+// maxRateLimitWindow bounds the window of rate limits, older deliveries are
+// purged
+const maxRateLimitWindow = 7 * 24 * time.Hour
+
+// EventSeverity returns the severity of an event type
+func EventSeverity(eventType NotificationType) Severity {
+	switch eventType {
+	case BackupFailed, RestoreFailed, UploadFailed, RPOBreached:
+		return SeverityError
+	case BackupSuspicious:
+		return SeverityWarning
+	case BackupCompleted, RestoreCompleted, RPORecovered:
+		return SeveritySuccess
+	}
+	return SeverityInfo
+}
+
+// Rule sends the events it matches through its channels. Rules are
+// evaluated in order of Position and, once a user has any, replace the
+// channel toggles and subscriptions of their settings.
+type Rule struct {
+	ID       uuid.UUID `json:"id"`
+	UserID   uuid.UUID `json:"user_id"`
+	Name     string    `json:"name"`
+	Enabled  bool      `json:"enabled"`
+	Position int       `json:"position"`
+	Match    RuleMatch `json:"match"`
+	Channels []string  `json:"channels"`
+	// QuietHours hold back everything but the dashboard during a daily
+	// period
+	QuietHours *QuietHours `json:"quiet_hours,omitempty"`
+	// RateLimit bounds how often the rule sends per connection, the
+	// dashboard is not limited
+	RateLimit *RateLimit `json:"rate_limit,omitempty"`
+	// Stop skips the rules after this one when it matches
+	Stop      bool      `json:"stop"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// RuleMatch selects events. Each list that is set must contain the value of
+// the event, empty lists match everything.
+type RuleMatch struct {
+	ConnectionIDs []string `json:"connection_ids,omitempty"`
+	// Tags matches connections with any of the tags
+	Tags         []string           `json:"tags,omitempty"`
+	Environments []string           `json:"environments,omitempty"`
+	EventTypes   []NotificationType `json:"event_types,omitempty"`
+	Severities   []Severity         `json:"severities,omitempty"`
+}
+
+// QuietHours is a daily period from Start to End, which may wrap around
+// midnight
+type QuietHours struct {
+	Start    string `json:"start"` // HH:MM
+	End      string `json:"end"`   // HH:MM
+	Timezone string `json:"timezone,omitempty"`
+	// Except lists the severities still sent during quiet hours
+	Except []Severity `json:"except,omitempty"`
+}
+
+// RateLimit allows at most Max sends per connection within WindowMinutes
+type RateLimit struct {
+	Max           int `json:"max"`
+	WindowMinutes int `json:"window_minutes"`
+}
+
+// RouteTarget is an event as rules see it
+type RouteTarget struct {
+	ConnectionID string           `json:"connection_id"`
+	Tags         []string         `json:"tags"`
+	Environment  string           `json:"environment"`
+	EventType    NotificationType `json:"event_type"`
+	Severity     Severity         `json:"severity"`
+}
+
+// Matches reports whether an event is selected by m
+func (m RuleMatch) Matches(target RouteTarget) bool {
+	if len(m.ConnectionIDs) > 0 && !containsString(m.ConnectionIDs, target.ConnectionID) {
+		return false
+	}
+	if len(m.Tags) > 0 && !containsAny(m.Tags, target.Tags) {
+		return false
+	}
+	if len(m.Environments) > 0 && !containsString(m.Environments, target.Environment) {
+		return false
+	}
+	if len(m.EventTypes) > 0 && !containsType(m.EventTypes, target.EventType) {
+		return false
+	}
+	if len(m.Severities) > 0 && !containsSeverity(m.Severities, target.Severity) {
+		return false
+	}
+	return true
+}
+
+// Holds reports whether quiet hours hold back an event of severity at now
+func (q *QuietHours) Holds(now time.Time, severity Severity) bool {
+	if containsSeverity(q.Except, severity) {
+		return false
+	}
+
+	loc := time.Local
+	if q.Timezone != "" {
+		if l, err := time.LoadLocation(q.Timezone); err == nil {
+			loc = l
+		}
+	}
+	start, errStart := time.Parse("15:04", q.Start)
+	end, errEnd := time.Parse("15:04", q.End)
+	if errStart != nil || errEnd != nil {
+		return false
+	}
+
+	local := now.In(loc)
+	minute := local.Hour()*60 + local.Minute()
+	startMinute := start.Hour()*60 + start.Minute()
+	endMinute := end.Hour()*60 + end.Minute()
+	if startMinute <= endMinute {
+		return minute >= startMinute && minute < endMinute
+	}
+	return minute >= startMinute || minute < endMinute
+}
+
+// Window returns the period sends are counted over
+func (l *RateLimit) Window() time.Duration {
+	return time.Duration(l.WindowMinutes) * time.Minute
+}
+
+// Validate checks a rule before it is stored
+func (r *Rule) Validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("rule name is required")
+	}
+	if len(r.Channels) == 0 {
+		return fmt.Errorf("rule must send to at least one channel")
+	}
+	for _, channel := range r.Channels {
+		if !containsString(Channels, channel) {
+			return fmt.Errorf("unknown notification channel %q", channel)
+		}
+	}
+	for _, eventType := range r.Match.EventTypes {
+		if !containsType(EventTypes, eventType) {
+			return fmt.Errorf("unknown notification event %q", eventType)
+		}
+	}
+	for _, severity := range r.Match.Severities {
+		if !containsSeverity(Severities, severity) {
+			return fmt.Errorf("unknown severity %q", severity)
+		}
+	}
+
+	if q := r.QuietHours; q != nil {
+		if _, err := time.Parse("15:04", q.Start); err != nil {
+			return fmt.Errorf("invalid quiet hours start %q, expected HH:MM", q.Start)
+		}
+		if _, err := time.Parse("15:04", q.End); err != nil {
+			return fmt.Errorf("invalid quiet hours end %q, expected HH:MM", q.End)
+		}
+		if q.Start == q.End {
+			return fmt.Errorf("quiet hours must not start and end at the same time")
+		}
+		if q.Timezone != "" {
+			if _, err := time.LoadLocation(q.Timezone); err != nil {
+				return fmt.Errorf("invalid quiet hours timezone %q: %v", q.Timezone, err)
+			}
+		}
+		for _, severity := range q.Except {
+			if !containsSeverity(Severities, severity) {
+				return fmt.Errorf("unknown severity %q", severity)
+			}
+		}
+	}
+
+	if l := r.RateLimit; l != nil {
+		if l.Max < 1 {
+			return fmt.Errorf("rate limit max must be at least 1")
+		}
+		if l.WindowMinutes < 1 || l.Window() > maxRateLimitWindow {
+			return fmt.Errorf("rate limit window must be between 1 minute and %d days", int(maxRateLimitWindow.Hours()/24))
+		}
+	}
+	return nil
+}
+
+func containsSeverity(values []Severity, value Severity) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAny(values []string, candidates []string) bool {
+	for _, candidate := range candidates {
+		if containsString(values, candidate) {
+			return true
+		}
+	}
+	return false
+}
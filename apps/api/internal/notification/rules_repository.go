@@ -0,0 +1,216 @@
+package notification
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/dendianugerah/velld/internal/common"
+	"github.com/google/uuid"
+)
+
+const ruleColumns = `id, user_id, name, enabled, position, match, channels,
+	quiet_hours, rate_limit, stop, created_at, updated_at`
+
+func (r *NotificationRepository) CreateRule(rule *Rule) error {
+	match, channels, quietHours, rateLimit, err := marshalRule(rule)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.Exec(`
+		INSERT INTO notification_rules (`+ruleColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`,
+		rule.ID, rule.UserID, rule.Name, rule.Enabled, rule.Position, match, channels,
+		quietHours, rateLimit, rule.Stop,
+		rule.CreatedAt.Format(time.RFC3339), rule.UpdatedAt.Format(time.RFC3339))
+	return err
+}
+
+// UpdateRule stores rule, returning sql.ErrNoRows when the user has no such
+// rule
+func (r *NotificationRepository) UpdateRule(rule *Rule) error {
+	match, channels, quietHours, rateLimit, err := marshalRule(rule)
+	if err != nil {
+		return err
+	}
+
+	result, err := r.db.Exec(`
+		UPDATE notification_rules
+		SET name = $1, enabled = $2, position = $3, match = $4, channels = $5,
+			quiet_hours = $6, rate_limit = $7, stop = $8, updated_at = $9
+		WHERE id = $10 AND user_id = $11`,
+		rule.Name, rule.Enabled, rule.Position, match, channels,
+		quietHours, rateLimit, rule.Stop, rule.UpdatedAt.Format(time.RFC3339),
+		rule.ID, rule.UserID)
+	if err != nil {
+		return err
+	}
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (r *NotificationRepository) GetRule(userID, ruleID uuid.UUID) (*Rule, error) {
+	row := r.db.QueryRow(
+		"SELECT "+ruleColumns+" FROM notification_rules WHERE id = $1 AND user_id = $2",
+		ruleID, userID)
+	return scanRule(row)
+}
+
+// ListRules returns the rules of a user in the order they are evaluated
+func (r *NotificationRepository) ListRules(userID uuid.UUID) ([]*Rule, error) {
+	return r.queryRules(
+		"SELECT "+ruleColumns+" FROM notification_rules WHERE user_id = $1 ORDER BY position, created_at",
+		userID)
+}
+
+func (r *NotificationRepository) ListEnabledRules(userID uuid.UUID) ([]*Rule, error) {
+	return r.queryRules(
+		"SELECT "+ruleColumns+" FROM notification_rules WHERE user_id = $1 AND enabled = $2 ORDER BY position, created_at",
+		userID, true)
+}
+
+// DeleteRule removes a rule and its sends, returning sql.ErrNoRows when the
+// user has no such rule
+func (r *NotificationRepository) DeleteRule(userID, ruleID uuid.UUID) error {
+	result, err := r.db.Exec("DELETE FROM notification_rules WHERE id = $1 AND user_id = $2", ruleID, userID)
+	if err != nil {
+		return err
+	}
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return sql.ErrNoRows
+	}
+	_, err = r.db.Exec("DELETE FROM notification_rule_sends WHERE rule_id = $1", ruleID)
+	return err
+}
+
+// CountRuleSends returns how often a rule sent for a connection since
+func (r *NotificationRepository) CountRuleSends(ruleID uuid.UUID, connectionID string, since time.Time) (int, error) {
+	var count int
+	err := r.db.QueryRow(
+		"SELECT COUNT(*) FROM notification_rule_sends WHERE rule_id = $1 AND connection_id = $2 AND sent_at >= $3",
+		ruleID, connectionID, since.Format(time.RFC3339)).Scan(&count)
+	return count, err
+}
+
+// ClaimRuleSend records a send of a rule for a connection unless it already
+// sent max times since, and reports whether it did. Checking and recording
+// is one statement, so that concurrent events cannot both pass the limit.
+func (r *NotificationRepository) ClaimRuleSend(ruleID uuid.UUID, connectionID string, sentAt, since time.Time, max int) (bool, error) {
+	result, err := r.db.Exec(`
+		INSERT INTO notification_rule_sends (rule_id, connection_id, sent_at)
+		SELECT $1, $2, $3
+		WHERE (
+			SELECT COUNT(*) FROM notification_rule_sends
+			WHERE rule_id = $1 AND connection_id = $2 AND sent_at >= $4
+		) < $5`,
+		ruleID, connectionID, sentAt.Format(time.RFC3339), since.Format(time.RFC3339), max)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows == 1, err
+}
+
+// PurgeRuleSends removes sends older than cutoff, which no rate limit
+// window reaches back to
+func (r *NotificationRepository) PurgeRuleSends(cutoff time.Time) (int64, error) {
+	result, err := r.db.Exec("DELETE FROM notification_rule_sends WHERE sent_at < $1", cutoff.Format(time.RFC3339))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (r *NotificationRepository) queryRules(query string, args ...interface{}) ([]*Rule, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := make([]*Rule, 0)
+	for rows.Next() {
+		rule, err := scanRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+type ruleScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanRule(row ruleScanner) (*Rule, error) {
+	var (
+		rule                  Rule
+		match, channels       string
+		quietHours, rateLimit sql.NullString
+		createdAt, updatedAt  string
+	)
+	err := row.Scan(&rule.ID, &rule.UserID, &rule.Name, &rule.Enabled, &rule.Position,
+		&match, &channels, &quietHours, &rateLimit, &rule.Stop, &createdAt, &updatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal([]byte(match), &rule.Match); err != nil {
+		return nil, fmt.Errorf("failed to decode rule match: %w", err)
+	}
+	if err := json.Unmarshal([]byte(channels), &rule.Channels); err != nil {
+		return nil, fmt.Errorf("failed to decode rule channels: %w", err)
+	}
+	if quietHours.Valid && quietHours.String != "" {
+		if err := json.Unmarshal([]byte(quietHours.String), &rule.QuietHours); err != nil {
+			return nil, fmt.Errorf("failed to decode rule quiet hours: %w", err)
+		}
+	}
+	if rateLimit.Valid && rateLimit.String != "" {
+		if err := json.Unmarshal([]byte(rateLimit.String), &rule.RateLimit); err != nil {
+			return nil, fmt.Errorf("failed to decode rule rate limit: %w", err)
+		}
+	}
+
+	if rule.CreatedAt, err = common.ParseTime(createdAt); err != nil {
+		return nil, err
+	}
+	if rule.UpdatedAt, err = common.ParseTime(updatedAt); err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+// marshalRule encodes the JSON columns of a rule, leaving quiet hours and
+// rate limit NULL when they are not set
+func marshalRule(rule *Rule) (match, channels string, quietHours, rateLimit sql.NullString, err error) {
+	data, err := json.Marshal(rule.Match)
+	if err != nil {
+		return "", "", quietHours, rateLimit, fmt.Errorf("failed to encode rule match: %w", err)
+	}
+	match = string(data)
+
+	if data, err = json.Marshal(rule.Channels); err != nil {
+		return "", "", quietHours, rateLimit, fmt.Errorf("failed to encode rule channels: %w", err)
+	}
+	channels = string(data)
+
+	if rule.QuietHours != nil {
+		if data, err = json.Marshal(rule.QuietHours); err != nil {
+			return "", "", quietHours, rateLimit, fmt.Errorf("failed to encode rule quiet hours: %w", err)
+		}
+		quietHours = sql.NullString{String: string(data), Valid: true}
+	}
+	if rule.RateLimit != nil {
+		if data, err = json.Marshal(rule.RateLimit); err != nil {
+			return "", "", quietHours, rateLimit, fmt.Errorf("failed to encode rule rate limit: %w", err)
+		}
+		rateLimit = sql.NullString{String: string(data), Valid: true}
+	}
+	return match, channels, quietHours, rateLimit, nil
+}
@@ -3,6 +3,7 @@ package settings
 import (
 	"time"
 
+	"github.com/dendianugerah/velld/internal/notification"
 	"github.com/google/uuid"
 )
 
@@ -18,6 +19,11 @@ type UserSettings struct {
 	SMTPPort        *int      `json:"smtp_port,omitempty"`
 	SMTPUsername    *string   `json:"smtp_username,omitempty"`
 	SMTPPassword    *string   `json:"smtp_password,omitempty"`
+	SMTPTLSMode     *string   `json:"smtp_tls_mode,omitempty"`
+	SMTPAuthMethod  *string   `json:"smtp_auth_method,omitempty"`
+	SMTPHeloName    *string   `json:"smtp_helo_name,omitempty"`
+	SMTPFromName    *string   `json:"smtp_from_name,omitempty"`
+	SMTPFromAddress *string   `json:"smtp_from_address,omitempty"`
 	// S3-compatible storage settings
 	S3Enabled    bool      `json:"s3_enabled"`
 	S3Endpoint   *string   `json:"s3_endpoint,omitempty"`
@@ -27,6 +33,36 @@ type UserSettings struct {
 	S3SecretKey  *string   `json:"s3_secret_key,omitempty"`
 	S3UseSSL     bool      `json:"s3_use_ssl"`
 	S3PathPrefix *string   `json:"s3_path_prefix,omitempty"`
+	// Chat notification channels
+	NotifySlack       bool    `json:"notify_slack"`
+	SlackWebhookURL   *string `json:"slack_webhook_url,omitempty"`
+	NotifyDiscord     bool    `json:"notify_discord"`
+	DiscordWebhookURL *string `json:"discord_webhook_url,omitempty"`
+	NotifyTeams       bool    `json:"notify_teams"`
+	TeamsWebhookURL   *string `json:"teams_webhook_url,omitempty"`
+	NotifyTelegram    bool    `json:"notify_telegram"`
+	TelegramBotToken  *string `json:"telegram_bot_token,omitempty"`
+	TelegramChatID    *string `json:"telegram_chat_id,omitempty"`
+	// WebhookSecret signs webhook calls, WebhookHeaders are added to them
+	WebhookSecret  *string           `json:"webhook_secret,omitempty"`
+	WebhookHeaders map[string]string `json:"webhook_headers,omitempty"`
+	// Email holds the comma separated To addresses of notification emails,
+	// EmailCC their CC addresses. Templates left empty use the defaults.
+	EmailCC              *string `json:"email_cc,omitempty"`
+	EmailSubjectTemplate *string `json:"email_subject_template,omitempty"`
+	EmailTextTemplate    *string `json:"email_text_template,omitempty"`
+	EmailHTMLTemplate    *string `json:"email_html_template,omitempty"`
+	// Digest reports on backup health at DigestTime in DigestTimezone, on
+	// DigestWeekday for weekly digests. An empty DigestFrequency turns it off.
+	DigestFrequency  *string    `json:"digest_frequency,omitempty"`
+	DigestTime       *string    `json:"digest_time,omitempty"`
+	DigestWeekday    *int       `json:"digest_weekday,omitempty"`
+	DigestTimezone   *string    `json:"digest_timezone,omitempty"`
+	DigestEmail      bool       `json:"digest_email"`
+	DigestWebhook    bool       `json:"digest_webhook"`
+	DigestLastSentAt *time.Time `json:"digest_last_sent_at,omitempty"`
+	// NotificationEvents chooses the events of each channel
+	NotificationEvents notification.EventSubscriptions `json:"notification_events"`
 	CreatedAt    time.Time `json:"created_at"`
 	UpdatedAt    time.Time `json:"updated_at"`
 	EnvConfigured map[string]bool `json:"env_configured,omitempty"`
@@ -42,6 +78,11 @@ type UpdateSettingsRequest struct {
 	SMTPPort        *int    `json:"smtp_port,omitempty"`
 	SMTPUsername    *string `json:"smtp_username,omitempty"`
 	SMTPPassword    *string `json:"smtp_password,omitempty"`
+	SMTPTLSMode     *string `json:"smtp_tls_mode,omitempty"`
+	SMTPAuthMethod  *string `json:"smtp_auth_method,omitempty"`
+	SMTPHeloName    *string `json:"smtp_helo_name,omitempty"`
+	SMTPFromName    *string `json:"smtp_from_name,omitempty"`
+	SMTPFromAddress *string `json:"smtp_from_address,omitempty"`
 	// S3-compatible storage settings
 	S3Enabled    *bool   `json:"s3_enabled,omitempty"`
 	S3Endpoint   *string `json:"s3_endpoint,omitempty"`
@@ -51,4 +92,56 @@ type UpdateSettingsRequest struct {
 	S3SecretKey  *string `json:"s3_secret_key,omitempty"`
 	S3UseSSL     *bool   `json:"s3_use_ssl,omitempty"`
 	S3PathPrefix *string `json:"s3_path_prefix,omitempty"`
+	// Chat notification channels
+	NotifySlack       *bool   `json:"notify_slack,omitempty"`
+	SlackWebhookURL   *string `json:"slack_webhook_url,omitempty"`
+	NotifyDiscord     *bool   `json:"notify_discord,omitempty"`
+	DiscordWebhookURL *string `json:"discord_webhook_url,omitempty"`
+	NotifyTeams       *bool   `json:"notify_teams,omitempty"`
+	TeamsWebhookURL   *string `json:"teams_webhook_url,omitempty"`
+	NotifyTelegram    *bool   `json:"notify_telegram,omitempty"`
+	TelegramBotToken  *string `json:"telegram_bot_token,omitempty"`
+	TelegramChatID    *string `json:"telegram_chat_id,omitempty"`
+	// WebhookSecret is cleared by an empty string, WebhookHeaders replaces
+	// the headers and nil keeps them
+	WebhookSecret  *string           `json:"webhook_secret,omitempty"`
+	WebhookHeaders map[string]string `json:"webhook_headers,omitempty"`
+	// Email recipients and templates, an empty template restores the default
+	EmailCC              *string `json:"email_cc,omitempty"`
+	EmailSubjectTemplate *string `json:"email_subject_template,omitempty"`
+	EmailTextTemplate    *string `json:"email_text_template,omitempty"`
+	EmailHTMLTemplate    *string `json:"email_html_template,omitempty"`
+	// Digest schedule and channels, an empty frequency turns it off
+	DigestFrequency *string `json:"digest_frequency,omitempty"`
+	DigestTime      *string `json:"digest_time,omitempty"`
+	DigestWeekday   *int    `json:"digest_weekday,omitempty"`
+	DigestTimezone  *string `json:"digest_timezone,omitempty"`
+	DigestEmail     *bool   `json:"digest_email,omitempty"`
+	DigestWebhook   *bool   `json:"digest_webhook,omitempty"`
+	// NotificationEvents replaces the subscriptions, nil keeps them
+	NotificationEvents notification.EventSubscriptions `json:"notification_events,omitempty"`
+}
+
+// PreviewEmailRequest renders templates against a sample event. Templates
+// left out are those saved in the settings.
+type PreviewEmailRequest struct {
+	SubjectTemplate *string `json:"subject_template,omitempty"`
+	TextTemplate    *string `json:"text_template,omitempty"`
+	HTMLTemplate    *string `json:"html_template,omitempty"`
+}
+
+// TestSMTPRequest sends a test email. The SMTP fields replace the saved
+// settings for this email only, so that a configuration can be checked
+// before saving it. To defaults to the notification recipients.
+type TestSMTPRequest struct {
+	To              *string `json:"to,omitempty"`
+	SMTPHost        *string `json:"smtp_host,omitempty"`
+	SMTPPort        *int    `json:"smtp_port,omitempty"`
+	SMTPUsername    *string `json:"smtp_username,omitempty"`
+	SMTPPassword    *string `json:"smtp_password,omitempty"`
+	SMTPTLSMode     *string `json:"smtp_tls_mode,omitempty"`
+	SMTPAuthMethod  *string `json:"smtp_auth_method,omitempty"`
+	SMTPHeloName    *string `json:"smtp_helo_name,omitempty"`
+	SMTPFromName    *string `json:"smtp_from_name,omitempty"`
+	SMTPFromAddress *string `json:"smtp_from_address,omitempty"`
 }
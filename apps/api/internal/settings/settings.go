@@ -6,6 +6,8 @@ import (
 
 	"github.com/dendianugerah/velld/internal/common"
 	"github.com/dendianugerah/velld/internal/common/response"
+	"github.com/dendianugerah/velld/internal/notification"
+	"github.com/gorilla/mux"
 )
 
 type SettingsHandler struct {
@@ -53,3 +55,74 @@ func (h *SettingsHandler) UpdateSettings(w http.ResponseWriter, r *http.Request)
 
 	response.SendSuccess(w, "Settings updated successfully", settings)
 }
+
+// TestChannel sends a test message through the chat channel named in the
+// path
+func (h *SettingsHandler) TestChannel(w http.ResponseWriter, r *http.Request) {
+	userID, err := common.GetUserIDFromContext(r.Context())
+	if err != nil {
+		response.SendError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	channel := mux.Vars(r)["channel"]
+	if !notification.IsChatChannel(channel) {
+		response.SendError(w, http.StatusBadRequest, "unknown notification channel")
+		return
+	}
+
+	if err := h.service.SendTestMessage(userID, channel); err != nil {
+		response.SendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	response.SendSuccess(w, "Test message sent successfully", nil)
+}
+
+// PreviewEmail renders the email templates in the request, or the saved
+// ones, against a sample event
+func (h *SettingsHandler) PreviewEmail(w http.ResponseWriter, r *http.Request) {
+	userID, err := common.GetUserIDFromContext(r.Context())
+	if err != nil {
+		response.SendError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	var req PreviewEmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.SendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	rendered, err := h.service.PreviewEmail(userID, &req)
+	if err != nil {
+		response.SendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	response.SendSuccess(w, "Email preview rendered successfully", rendered)
+}
+
+// TestSMTP sends a test email to validate the SMTP settings
+func (h *SettingsHandler) TestSMTP(w http.ResponseWriter, r *http.Request) {
+	userID, err := common.GetUserIDFromContext(r.Context())
+	if err != nil {
+		response.SendError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	var req TestSMTPRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			response.SendError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	if err := h.service.TestSMTP(userID, &req); err != nil {
+		response.SendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	response.SendSuccess(w, "Test email sent successfully", nil)
+}
@@ -0,0 +1,101 @@
+package settings
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dendianugerah/velld/internal/chat"
+	"github.com/dendianugerah/velld/internal/notification"
+	"github.com/google/uuid"
+)
+
+// ChatEnabled reports whether a chat channel is turned on and configured
+func (s *UserSettings) ChatEnabled(channel string) bool {
+	switch channel {
+	case notification.ChannelSlack:
+		return s.NotifySlack && s.ChatConfigured(channel)
+	case notification.ChannelDiscord:
+		return s.NotifyDiscord && s.ChatConfigured(channel)
+	case notification.ChannelTeams:
+		return s.NotifyTeams && s.ChatConfigured(channel)
+	case notification.ChannelTelegram:
+		return s.NotifyTelegram && s.ChatConfigured(channel)
+	}
+	return false
+}
+
+// ChatConfigured reports whether a chat channel has somewhere to post to,
+// whether or not it is turned on
+func (s *UserSettings) ChatConfigured(channel string) bool {
+	switch channel {
+	case notification.ChannelSlack:
+		return isSet(s.SlackWebhookURL)
+	case notification.ChannelDiscord:
+		return isSet(s.DiscordWebhookURL)
+	case notification.ChannelTeams:
+		return isSet(s.TeamsWebhookURL)
+	case notification.ChannelTelegram:
+		return isSet(s.TelegramBotToken) && isSet(s.TelegramChatID)
+	}
+	return false
+}
+
+// SendChatMessage posts msg through a chat channel of settings, which must
+// come from GetUserSettingsInternal so that the Telegram token can be
+// decrypted
+func (s *SettingsService) SendChatMessage(settings *UserSettings, channel string, msg *chat.Message) error {
+	switch channel {
+	case notification.ChannelSlack:
+		if !isSet(settings.SlackWebhookURL) {
+			return fmt.Errorf("slack webhook URL is not configured")
+		}
+		return chat.SendSlack(*settings.SlackWebhookURL, msg)
+	case notification.ChannelDiscord:
+		if !isSet(settings.DiscordWebhookURL) {
+			return fmt.Errorf("discord webhook URL is not configured")
+		}
+		return chat.SendDiscord(*settings.DiscordWebhookURL, msg)
+	case notification.ChannelTeams:
+		if !isSet(settings.TeamsWebhookURL) {
+			return fmt.Errorf("teams webhook URL is not configured")
+		}
+		return chat.SendTeams(*settings.TeamsWebhookURL, msg)
+	case notification.ChannelTelegram:
+		if !isSet(settings.TelegramBotToken) || !isSet(settings.TelegramChatID) {
+			return fmt.Errorf("telegram bot token and chat ID are not configured")
+		}
+		token, err := s.cryptoService.Decrypt(*settings.TelegramBotToken)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt telegram bot token: %v", err)
+		}
+		return chat.SendTelegram(token, *settings.TelegramChatID, msg)
+	}
+	return fmt.Errorf("unknown chat channel %q", channel)
+}
+
+// SendTestMessage posts a sample message through a chat channel, whether or
+// not it is turned on, so that its settings can be checked
+func (s *SettingsService) SendTestMessage(userID uuid.UUID, channel string) error {
+	if !notification.IsChatChannel(channel) {
+		return fmt.Errorf("unknown chat channel %q", channel)
+	}
+
+	settings, err := s.GetUserSettingsInternal(userID)
+	if err != nil {
+		return err
+	}
+
+	return s.SendChatMessage(settings, channel, &chat.Message{
+		Title:    "Velld Test Message",
+		Text:     "Notifications from Velld will be posted here.",
+		Severity: chat.SeverityInfo,
+		Fields: []chat.Field{
+			{Name: "Channel", Value: channel},
+		},
+		Timestamp: time.Now(),
+	})
+}
+
+func isSet(value *string) bool {
+	return value != nil && *value != ""
+}
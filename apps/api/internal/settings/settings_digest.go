@@ -0,0 +1,123 @@
+package settings
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Digest frequencies
+const (
+	DigestDaily  = "daily"
+	DigestWeekly = "weekly"
+)
+
+const (
+	defaultDigestTime    = "08:00"
+	defaultDigestWeekday = int(time.Monday)
+)
+
+// DigestLocation returns the timezone of the digest, the server's when none
+// is set
+func (s *UserSettings) DigestLocation() *time.Location {
+	if s.DigestTimezone != nil && *s.DigestTimezone != "" {
+		if loc, err := time.LoadLocation(*s.DigestTimezone); err == nil {
+			return loc
+		}
+	}
+	return time.Local
+}
+
+// LastDigestTime returns the latest time at or before now the digest was
+// scheduled for, and the start of the period it reports on
+func (s *UserSettings) LastDigestTime(now time.Time) (periodStart, scheduled time.Time) {
+	loc := s.DigestLocation()
+	clock := defaultDigestTime
+	if s.DigestTime != nil && *s.DigestTime != "" {
+		clock = *s.DigestTime
+	}
+	parsed, err := time.Parse("15:04", clock)
+	if err != nil {
+		parsed, _ = time.Parse("15:04", defaultDigestTime)
+	}
+
+	local := now.In(loc)
+	scheduled = time.Date(local.Year(), local.Month(), local.Day(), parsed.Hour(), parsed.Minute(), 0, 0, loc)
+	if scheduled.After(local) {
+		scheduled = scheduled.AddDate(0, 0, -1)
+	}
+
+	if valueOf(s.DigestFrequency) == DigestWeekly {
+		weekday := defaultDigestWeekday
+		if s.DigestWeekday != nil {
+			weekday = *s.DigestWeekday
+		}
+		for int(scheduled.Weekday()) != weekday {
+			scheduled = scheduled.AddDate(0, 0, -1)
+		}
+		return scheduled.AddDate(0, 0, -7), scheduled
+	}
+	return scheduled.AddDate(0, 0, -1), scheduled
+}
+
+// DigestDue reports whether the digest scheduled at or before now has not
+// been sent yet
+func (s *UserSettings) DigestDue(now time.Time) bool {
+	if valueOf(s.DigestFrequency) == "" {
+		return false
+	}
+	_, scheduled := s.LastDigestTime(now)
+	return s.DigestLastSentAt == nil || s.DigestLastSentAt.Before(scheduled)
+}
+
+func (s *SettingsService) ListDigestUserIDs() ([]uuid.UUID, error) {
+	return s.repo.ListDigestUserIDs()
+}
+
+func (s *SettingsService) SetDigestSentAt(userID uuid.UUID, sentAt time.Time) error {
+	return s.repo.SetDigestSentAt(userID, sentAt)
+}
+
+// applyDigestSettings updates the digest of settings from req and reports
+// whether its schedule changed
+func applyDigestSettings(settings *UserSettings, req *UpdateSettingsRequest) (bool, error) {
+	changed := false
+	if req.DigestFrequency != nil {
+		switch *req.DigestFrequency {
+		case "", DigestDaily, DigestWeekly:
+		default:
+			return false, fmt.Errorf("unknown digest frequency %q, expected daily or weekly", *req.DigestFrequency)
+		}
+		changed = changed || valueOf(settings.DigestFrequency) != *req.DigestFrequency
+		settings.DigestFrequency = req.DigestFrequency
+	}
+	if req.DigestTime != nil {
+		if _, err := time.Parse("15:04", *req.DigestTime); err != nil {
+			return false, fmt.Errorf("invalid digest time %q, expected HH:MM", *req.DigestTime)
+		}
+		changed = changed || valueOf(settings.DigestTime) != *req.DigestTime
+		settings.DigestTime = req.DigestTime
+	}
+	if req.DigestWeekday != nil {
+		if *req.DigestWeekday < 0 || *req.DigestWeekday > 6 {
+			return false, fmt.Errorf("invalid digest weekday %d, expected 0 (Sunday) to 6", *req.DigestWeekday)
+		}
+		changed = changed || settings.DigestWeekday == nil || *settings.DigestWeekday != *req.DigestWeekday
+		settings.DigestWeekday = req.DigestWeekday
+	}
+	if req.DigestTimezone != nil {
+		if _, err := time.LoadLocation(*req.DigestTimezone); err != nil {
+			return false, fmt.Errorf("invalid digest timezone %q: %v", *req.DigestTimezone, err)
+		}
+		changed = changed || valueOf(settings.DigestTimezone) != *req.DigestTimezone
+		settings.DigestTimezone = req.DigestTimezone
+	}
+	if req.DigestEmail != nil {
+		settings.DigestEmail = *req.DigestEmail
+	}
+	if req.DigestWebhook != nil {
+		settings.DigestWebhook = *req.DigestWebhook
+	}
+	return changed, nil
+}
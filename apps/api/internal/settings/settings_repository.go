@@ -2,9 +2,12 @@ package settings
 
 import (
 	"database/sql"
+	"encoding/json"
+	"fmt"
 	"time"
 
 	"github.com/dendianugerah/velld/internal/common"
+	"github.com/dendianugerah/velld/internal/notification"
 	"github.com/google/uuid"
 )
 
@@ -19,22 +22,37 @@ func NewSettingsRepository(db *sql.DB) *SettingsRepository {
 func (r *SettingsRepository) GetUserSettings(userID uuid.UUID) (*UserSettings, error) {
 	settings := &UserSettings{}
 	var createdAtStr, updatedAtStr string
+	var webhookHeaders, notificationEvents, digestLastSentAt sql.NullString
 
 	err := r.db.QueryRow(`
         SELECT id, user_id, notify_dashboard, notify_email, notify_webhook,
                webhook_url, email, smtp_host, smtp_port, smtp_username, 
-               smtp_password, s3_enabled, s3_endpoint, s3_region, s3_bucket,
+               smtp_password, smtp_tls_mode, smtp_auth_method, smtp_helo_name,
+               smtp_from_name, smtp_from_address, s3_enabled, s3_endpoint, s3_region, s3_bucket,
                s3_access_key, s3_secret_key, s3_use_ssl, s3_path_prefix,
-               created_at, updated_at
+               notify_slack, slack_webhook_url, notify_discord, discord_webhook_url,
+               notify_teams, teams_webhook_url, notify_telegram, telegram_bot_token,
+               telegram_chat_id, webhook_secret, webhook_headers, email_cc,
+               email_subject_template, email_text_template, email_html_template,
+               digest_frequency, digest_time, digest_weekday, digest_timezone,
+               digest_email, digest_webhook, digest_last_sent_at,
+               notification_events, created_at, updated_at
         FROM user_settings
         WHERE user_id = $1`, userID).Scan(
 		&settings.ID, &settings.UserID, &settings.NotifyDashboard,
 		&settings.NotifyEmail, &settings.NotifyWebhook, &settings.WebhookURL,
 		&settings.Email, &settings.SMTPHost, &settings.SMTPPort,
-		&settings.SMTPUsername, &settings.SMTPPassword,
+		&settings.SMTPUsername, &settings.SMTPPassword, &settings.SMTPTLSMode, &settings.SMTPAuthMethod,
+		&settings.SMTPHeloName, &settings.SMTPFromName, &settings.SMTPFromAddress,
 		&settings.S3Enabled, &settings.S3Endpoint, &settings.S3Region, &settings.S3Bucket,
 		&settings.S3AccessKey, &settings.S3SecretKey, &settings.S3UseSSL, &settings.S3PathPrefix,
-		&createdAtStr, &updatedAtStr)
+		&settings.NotifySlack, &settings.SlackWebhookURL, &settings.NotifyDiscord, &settings.DiscordWebhookURL,
+		&settings.NotifyTeams, &settings.TeamsWebhookURL, &settings.NotifyTelegram, &settings.TelegramBotToken,
+		&settings.TelegramChatID, &settings.WebhookSecret, &webhookHeaders, &settings.EmailCC,
+		&settings.EmailSubjectTemplate, &settings.EmailTextTemplate, &settings.EmailHTMLTemplate,
+		&settings.DigestFrequency, &settings.DigestTime, &settings.DigestWeekday, &settings.DigestTimezone,
+		&settings.DigestEmail, &settings.DigestWebhook, &digestLastSentAt,
+		&notificationEvents, &createdAtStr, &updatedAtStr)
 
 	if err == sql.ErrNoRows {
 		// Create default settings if none exist
@@ -54,6 +72,24 @@ func (r *SettingsRepository) GetUserSettings(userID uuid.UUID) (*UserSettings, e
 		return nil, err
 	}
 
+	if digestLastSentAt.Valid {
+		sentAt, err := common.ParseTime(digestLastSentAt.String)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing digest_last_sent_at: %v", err)
+		}
+		settings.DigestLastSentAt = &sentAt
+	}
+
+	settings.WebhookHeaders, err = unmarshalHeaders(webhookHeaders)
+	if err != nil {
+		return nil, err
+	}
+
+	settings.NotificationEvents, err = notification.UnmarshalEventSubscriptions(notificationEvents)
+	if err != nil {
+		return nil, err
+	}
+
 	// Parse timestamps
 	settings.CreatedAt, err = common.ParseTime(createdAtStr)
 	if err != nil {
@@ -69,44 +105,143 @@ func (r *SettingsRepository) GetUserSettings(userID uuid.UUID) (*UserSettings, e
 }
 
 func (r *SettingsRepository) CreateUserSettings(settings *UserSettings) error {
-	_, err := r.db.Exec(`
+	webhookHeaders, err := marshalHeaders(settings.WebhookHeaders)
+	if err != nil {
+		return err
+	}
+	notificationEvents, err := notification.MarshalEventSubscriptions(settings.NotificationEvents)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.Exec(`
         INSERT INTO user_settings (
             id, user_id, notify_dashboard, notify_email, notify_webhook,
             webhook_url, email, smtp_host, smtp_port, smtp_username, 
-            smtp_password, s3_enabled, s3_endpoint, s3_region, s3_bucket,
+            smtp_password, smtp_tls_mode, smtp_auth_method, smtp_helo_name,
+            smtp_from_name, smtp_from_address, s3_enabled, s3_endpoint, s3_region, s3_bucket,
             s3_access_key, s3_secret_key, s3_use_ssl, s3_path_prefix,
-            created_at, updated_at
-        ) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21)`,
+            notify_slack, slack_webhook_url, notify_discord, discord_webhook_url,
+            notify_teams, teams_webhook_url, notify_telegram, telegram_bot_token,
+            telegram_chat_id, webhook_secret, webhook_headers, email_cc,
+            email_subject_template, email_text_template, email_html_template,
+            digest_frequency, digest_time, digest_weekday, digest_timezone,
+            digest_email, digest_webhook, notification_events, created_at, updated_at
+        ) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22,
+            $23, $24, $25, $26, $27, $28, $29, $30, $31, $32, $33, $34, $35, $36, $37, $38, $39, $40, $41, $42,
+            $43, $44, $45, $46, $47, $48)`,
 		settings.ID, settings.UserID, settings.NotifyDashboard,
 		settings.NotifyEmail, settings.NotifyWebhook, settings.WebhookURL,
 		settings.Email, settings.SMTPHost, settings.SMTPPort,
-		settings.SMTPUsername, settings.SMTPPassword,
+		settings.SMTPUsername, settings.SMTPPassword, settings.SMTPTLSMode, settings.SMTPAuthMethod,
+		settings.SMTPHeloName, settings.SMTPFromName, settings.SMTPFromAddress,
 		settings.S3Enabled, settings.S3Endpoint, settings.S3Region, settings.S3Bucket,
 		settings.S3AccessKey, settings.S3SecretKey, settings.S3UseSSL, settings.S3PathPrefix,
-		settings.CreatedAt, settings.UpdatedAt)
+		settings.NotifySlack, settings.SlackWebhookURL, settings.NotifyDiscord, settings.DiscordWebhookURL,
+		settings.NotifyTeams, settings.TeamsWebhookURL, settings.NotifyTelegram, settings.TelegramBotToken,
+		settings.TelegramChatID, settings.WebhookSecret, webhookHeaders, settings.EmailCC,
+		settings.EmailSubjectTemplate, settings.EmailTextTemplate, settings.EmailHTMLTemplate,
+		settings.DigestFrequency, settings.DigestTime, settings.DigestWeekday, settings.DigestTimezone,
+		settings.DigestEmail, settings.DigestWebhook, notificationEvents, settings.CreatedAt, settings.UpdatedAt)
 	return err
 }
 
 func (r *SettingsRepository) UpdateUserSettings(settings *UserSettings) error {
+	webhookHeaders, err := marshalHeaders(settings.WebhookHeaders)
+	if err != nil {
+		return err
+	}
+	notificationEvents, err := notification.MarshalEventSubscriptions(settings.NotificationEvents)
+	if err != nil {
+		return err
+	}
+
 	settings.UpdatedAt = time.Now()
-	_, err := r.db.Exec(`
+	_, err = r.db.Exec(`
         UPDATE user_settings SET
             notify_dashboard = $1, notify_email = $2, notify_webhook = $3,
             webhook_url = $4, email = $5, smtp_host = $6, smtp_port = $7,
-            smtp_username = $8, smtp_password = $9, s3_enabled = $10,
-            s3_endpoint = $11, s3_region = $12, s3_bucket = $13,
-            s3_access_key = $14, s3_secret_key = $15, s3_use_ssl = $16,
-            s3_path_prefix = $17, updated_at = $18
-        WHERE user_id = $19`,
+            smtp_username = $8, smtp_password = $9, smtp_tls_mode = $10,
+            smtp_auth_method = $11, smtp_helo_name = $12, smtp_from_name = $13,
+            smtp_from_address = $14, s3_enabled = $15,
+            s3_endpoint = $16, s3_region = $17, s3_bucket = $18,
+            s3_access_key = $19, s3_secret_key = $20, s3_use_ssl = $21,
+            s3_path_prefix = $22, notify_slack = $23, slack_webhook_url = $24,
+            notify_discord = $25, discord_webhook_url = $26, notify_teams = $27,
+            teams_webhook_url = $28, notify_telegram = $29, telegram_bot_token = $30,
+            telegram_chat_id = $31, webhook_secret = $32, webhook_headers = $33,
+            email_cc = $34, email_subject_template = $35, email_text_template = $36,
+            email_html_template = $37, digest_frequency = $38, digest_time = $39,
+            digest_weekday = $40, digest_timezone = $41, digest_email = $42,
+            digest_webhook = $43, notification_events = $44, updated_at = $45
+        WHERE user_id = $46`,
 		settings.NotifyDashboard, settings.NotifyEmail, settings.NotifyWebhook,
 		settings.WebhookURL, settings.Email, settings.SMTPHost, settings.SMTPPort,
-		settings.SMTPUsername, settings.SMTPPassword,
+		settings.SMTPUsername, settings.SMTPPassword, settings.SMTPTLSMode, settings.SMTPAuthMethod,
+		settings.SMTPHeloName, settings.SMTPFromName, settings.SMTPFromAddress,
 		settings.S3Enabled, settings.S3Endpoint, settings.S3Region, settings.S3Bucket,
 		settings.S3AccessKey, settings.S3SecretKey, settings.S3UseSSL, settings.S3PathPrefix,
-		settings.UpdatedAt, settings.UserID)
+		settings.NotifySlack, settings.SlackWebhookURL, settings.NotifyDiscord, settings.DiscordWebhookURL,
+		settings.NotifyTeams, settings.TeamsWebhookURL, settings.NotifyTelegram, settings.TelegramBotToken,
+		settings.TelegramChatID, settings.WebhookSecret, webhookHeaders, settings.EmailCC,
+		settings.EmailSubjectTemplate, settings.EmailTextTemplate, settings.EmailHTMLTemplate,
+		settings.DigestFrequency, settings.DigestTime, settings.DigestWeekday, settings.DigestTimezone,
+		settings.DigestEmail, settings.DigestWebhook, notificationEvents, settings.UpdatedAt, settings.UserID)
 	return err
 }
 
+// ListDigestUserIDs returns the users with a digest turned on
+func (r *SettingsRepository) ListDigestUserIDs() ([]uuid.UUID, error) {
+	rows, err := r.db.Query(`
+        SELECT user_id FROM user_settings
+        WHERE digest_frequency IS NOT NULL AND digest_frequency != ''`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var userIDs []uuid.UUID
+	for rows.Next() {
+		var userID uuid.UUID
+		if err := rows.Scan(&userID); err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, userID)
+	}
+	return userIDs, rows.Err()
+}
+
+// SetDigestSentAt records when the digest of a user last went out
+func (r *SettingsRepository) SetDigestSentAt(userID uuid.UUID, sentAt time.Time) error {
+	_, err := r.db.Exec(`UPDATE user_settings SET digest_last_sent_at = $1 WHERE user_id = $2`,
+		sentAt.Format(time.RFC3339), userID)
+	return err
+}
+
+// marshalHeaders encodes webhook headers for a TEXT column, storing NULL
+// when there are none
+func marshalHeaders(headers map[string]string) (sql.NullString, error) {
+	if len(headers) == 0 {
+		return sql.NullString{}, nil
+	}
+	data, err := json.Marshal(headers)
+	if err != nil {
+		return sql.NullString{}, fmt.Errorf("failed to encode webhook headers: %w", err)
+	}
+	return sql.NullString{String: string(data), Valid: true}, nil
+}
+
+func unmarshalHeaders(value sql.NullString) (map[string]string, error) {
+	if !value.Valid || value.String == "" {
+		return nil, nil
+	}
+	var headers map[string]string
+	if err := json.Unmarshal([]byte(value.String), &headers); err != nil {
+		return nil, fmt.Errorf("failed to decode webhook headers: %w", err)
+	}
+	return headers, nil
+}
+
 // func (r *SettingsRepository) GetDatabaseBinaryPath(dbType string, userID uuid.UUID) (string, error) {
 // 	var binPath sql.NullString
 // 	err := r.db.QueryRow(`
@@ -1,10 +1,15 @@
 package settings
 
 import (
+	"fmt"
+	"net/http"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/dendianugerah/velld/internal/common"
+	"github.com/dendianugerah/velld/internal/mail"
 	"github.com/google/uuid"
 )
 
@@ -32,6 +37,8 @@ func (s *SettingsService) GetUserSettings(userID uuid.UUID) (*UserSettings, erro
 	// Remove sensitive data before returning
 	settings.SMTPPassword = nil
 	settings.S3SecretKey = nil
+	settings.TelegramBotToken = nil
+	settings.WebhookSecret = nil
 	return settings, nil
 }
 
@@ -102,8 +109,17 @@ func (s *SettingsService) UpdateUserSettings(userID uuid.UUID, req *UpdateSettin
 		settings.WebhookURL = req.WebhookURL
 	}
 	if req.Email != nil && !envSMTPFrom {
+		if _, err := mail.ParseAddresses(*req.Email); err != nil {
+			return nil, err
+		}
 		settings.Email = req.Email
 	}
+	if req.EmailCC != nil {
+		if _, err := mail.ParseAddresses(*req.EmailCC); err != nil {
+			return nil, err
+		}
+		settings.EmailCC = req.EmailCC
+	}
 	if req.SMTPHost != nil && !envSMTPHost {
 		settings.SMTPHost = req.SMTPHost
 	}
@@ -121,6 +137,30 @@ func (s *SettingsService) UpdateUserSettings(userID uuid.UUID, req *UpdateSettin
 		}
 		settings.SMTPPassword = &encryptedPass
 	}
+	if req.SMTPTLSMode != nil {
+		if err := mail.ValidateTLSMode(*req.SMTPTLSMode); err != nil {
+			return nil, err
+		}
+		settings.SMTPTLSMode = req.SMTPTLSMode
+	}
+	if req.SMTPAuthMethod != nil {
+		if err := mail.ValidateAuthMethod(*req.SMTPAuthMethod); err != nil {
+			return nil, err
+		}
+		settings.SMTPAuthMethod = req.SMTPAuthMethod
+	}
+	if req.SMTPHeloName != nil {
+		settings.SMTPHeloName = req.SMTPHeloName
+	}
+	if req.SMTPFromName != nil {
+		settings.SMTPFromName = req.SMTPFromName
+	}
+	if req.SMTPFromAddress != nil {
+		if _, err := mail.ParseAddresses(*req.SMTPFromAddress); err != nil {
+			return nil, err
+		}
+		settings.SMTPFromAddress = req.SMTPFromAddress
+	}
 
 	// Update S3 settings
 	if req.S3Enabled != nil {
@@ -153,12 +193,171 @@ func (s *SettingsService) UpdateUserSettings(userID uuid.UUID, req *UpdateSettin
 		settings.S3PathPrefix = req.S3PathPrefix
 	}
 
+	// Update chat channels
+	if req.NotifySlack != nil {
+		settings.NotifySlack = *req.NotifySlack
+	}
+	if req.SlackWebhookURL != nil {
+		settings.SlackWebhookURL = req.SlackWebhookURL
+	}
+	if req.NotifyDiscord != nil {
+		settings.NotifyDiscord = *req.NotifyDiscord
+	}
+	if req.DiscordWebhookURL != nil {
+		settings.DiscordWebhookURL = req.DiscordWebhookURL
+	}
+	if req.NotifyTeams != nil {
+		settings.NotifyTeams = *req.NotifyTeams
+	}
+	if req.TeamsWebhookURL != nil {
+		settings.TeamsWebhookURL = req.TeamsWebhookURL
+	}
+	if req.NotifyTelegram != nil {
+		settings.NotifyTelegram = *req.NotifyTelegram
+	}
+	if req.TelegramBotToken != nil {
+		// Encrypt Telegram bot token before storing
+		encryptedToken, err := s.cryptoService.Encrypt(*req.TelegramBotToken)
+		if err != nil {
+			return nil, err
+		}
+		settings.TelegramBotToken = &encryptedToken
+	}
+	if req.TelegramChatID != nil {
+		settings.TelegramChatID = req.TelegramChatID
+	}
+
+	if req.WebhookSecret != nil {
+		if *req.WebhookSecret == "" {
+			settings.WebhookSecret = nil
+		} else {
+			// Encrypt webhook secret before storing
+			encryptedSecret, err := s.cryptoService.Encrypt(*req.WebhookSecret)
+			if err != nil {
+				return nil, err
+			}
+			settings.WebhookSecret = &encryptedSecret
+		}
+	}
+	if req.WebhookHeaders != nil {
+		if err := validateWebhookHeaders(req.WebhookHeaders); err != nil {
+			return nil, err
+		}
+		settings.WebhookHeaders = req.WebhookHeaders
+	}
+
+	if req.EmailSubjectTemplate != nil {
+		settings.EmailSubjectTemplate = req.EmailSubjectTemplate
+	}
+	if req.EmailTextTemplate != nil {
+		settings.EmailTextTemplate = req.EmailTextTemplate
+	}
+	if req.EmailHTMLTemplate != nil {
+		settings.EmailHTMLTemplate = req.EmailHTMLTemplate
+	}
+	if err := mail.ValidateTemplates(settings.EmailTemplates()); err != nil {
+		return nil, err
+	}
+
+	digestChanged, err := applyDigestSettings(settings, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.NotificationEvents != nil {
+		if err := req.NotificationEvents.Validate(); err != nil {
+			return nil, err
+		}
+		settings.NotificationEvents = req.NotificationEvents
+	}
+
 	if err := s.repo.UpdateUserSettings(settings); err != nil {
 		return nil, err
 	}
 
+	// A new digest schedule starts from now instead of sending the digest
+	// of the period that just ended
+	if digestChanged {
+		now := time.Now()
+		if err := s.repo.SetDigestSentAt(userID, now); err != nil {
+			return nil, err
+		}
+		settings.DigestLastSentAt = &now
+	}
+
 	// Remove sensitive data before returning
 	settings.SMTPPassword = nil
 	settings.S3SecretKey = nil
+	settings.TelegramBotToken = nil
+	settings.WebhookSecret = nil
 	return settings, nil
 }
+
+// WebhookSecret returns the decrypted signing secret of webhook calls, or an
+// empty string when none is set. settings must come from
+// GetUserSettingsInternal.
+func (s *SettingsService) WebhookSecret(settings *UserSettings) (string, error) {
+	if settings.WebhookSecret == nil || *settings.WebhookSecret == "" {
+		return "", nil
+	}
+	secret, err := s.cryptoService.Decrypt(*settings.WebhookSecret)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt webhook secret: %v", err)
+	}
+	return secret, nil
+}
+
+// validateWebhookHeaders rejects headers that are malformed or that would
+// replace those velld sets on each call
+func validateWebhookHeaders(headers map[string]string) error {
+	for name, value := range headers {
+		if name == "" || strings.ContainsAny(name, " :\r\n") {
+			return fmt.Errorf("invalid webhook header name %q", name)
+		}
+		if strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("invalid value for webhook header %s", name)
+		}
+		canonical := http.CanonicalHeaderKey(name)
+		if canonical == "Content-Type" || canonical == "Content-Length" || canonical == "Host" ||
+			strings.HasPrefix(canonical, "X-Velld-") {
+			return fmt.Errorf("webhook header %s is set by velld", name)
+		}
+	}
+	return nil
+}
+
+// EmailTemplates returns the templates of notification emails
+func (s *UserSettings) EmailTemplates() mail.Templates {
+	templates := mail.Templates{}
+	if s.EmailSubjectTemplate != nil {
+		templates.Subject = *s.EmailSubjectTemplate
+	}
+	if s.EmailTextTemplate != nil {
+		templates.Text = *s.EmailTextTemplate
+	}
+	if s.EmailHTMLTemplate != nil {
+		templates.HTML = *s.EmailHTMLTemplate
+	}
+	return templates
+}
+
+// PreviewEmail renders email templates against a sample event
+func (s *SettingsService) PreviewEmail(userID uuid.UUID, req *PreviewEmailRequest) (*mail.Rendered, error) {
+	settings, err := s.repo.GetUserSettings(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	templates := settings.EmailTemplates()
+	if req.SubjectTemplate != nil {
+		templates.Subject = *req.SubjectTemplate
+	}
+	if req.TextTemplate != nil {
+		templates.Text = *req.TextTemplate
+	}
+	if req.HTMLTemplate != nil {
+		templates.HTML = *req.HTMLTemplate
+	}
+
+	return mail.Render(templates, mail.SampleData())
+}
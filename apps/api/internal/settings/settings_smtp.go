@@ -0,0 +1,139 @@
+package settings
+
+import (
+	"fmt"
+
+	"github.com/dendianugerah/velld/internal/mail"
+	"github.com/google/uuid"
+)
+
+// SMTPConfig returns the SMTP transport of settings, which must come from
+// GetUserSettingsInternal so that the password can be decrypted
+func (s *SettingsService) SMTPConfig(settings *UserSettings) (*mail.SMTPConfig, error) {
+	if !isSet(settings.SMTPHost) || settings.SMTPPort == nil {
+		return nil, fmt.Errorf("incomplete SMTP configuration: host and port are required")
+	}
+
+	config := &mail.SMTPConfig{
+		Host:       *settings.SMTPHost,
+		Port:       *settings.SMTPPort,
+		TLSMode:    valueOf(settings.SMTPTLSMode),
+		AuthMethod: valueOf(settings.SMTPAuthMethod),
+		HeloName:   valueOf(settings.SMTPHeloName),
+		Username:   valueOf(settings.SMTPUsername),
+	}
+
+	if isSet(settings.SMTPPassword) {
+		// If password is configured via env var, it's already plain text
+		// Otherwise, it's encrypted in the database and needs to be decrypted
+		password := *settings.SMTPPassword
+		if settings.EnvConfigured == nil || !settings.EnvConfigured["smtp_password"] {
+			decryptedPassword, err := s.cryptoService.Decrypt(password)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decrypt SMTP password: %v", err)
+			}
+			password = decryptedPassword
+		}
+		config.Password = password
+	}
+
+	if config.AuthMethod != mail.AuthNone && config.AuthMethod != "" && config.Username == "" {
+		return nil, fmt.Errorf("incomplete SMTP configuration: %s authentication needs a username", config.AuthMethod)
+	}
+
+	return config, nil
+}
+
+// SMTPFrom returns the sender of notification emails, the SMTP username
+// unless a From address is configured
+func (s *UserSettings) SMTPFrom() (address, name string) {
+	address = valueOf(s.SMTPFromAddress)
+	if address == "" {
+		address = valueOf(s.SMTPUsername)
+	}
+	return address, valueOf(s.SMTPFromName)
+}
+
+// TestSMTP sends a test email with the saved SMTP settings, replaced by
+// those of the request
+func (s *SettingsService) TestSMTP(userID uuid.UUID, req *TestSMTPRequest) error {
+	settings, err := s.GetUserSettingsInternal(userID)
+	if err != nil {
+		return err
+	}
+
+	if req.SMTPTLSMode != nil {
+		if err := mail.ValidateTLSMode(*req.SMTPTLSMode); err != nil {
+			return err
+		}
+		settings.SMTPTLSMode = req.SMTPTLSMode
+	}
+	if req.SMTPAuthMethod != nil {
+		if err := mail.ValidateAuthMethod(*req.SMTPAuthMethod); err != nil {
+			return err
+		}
+		settings.SMTPAuthMethod = req.SMTPAuthMethod
+	}
+	if req.SMTPHost != nil {
+		settings.SMTPHost = req.SMTPHost
+	}
+	if req.SMTPPort != nil {
+		settings.SMTPPort = req.SMTPPort
+	}
+	if req.SMTPUsername != nil {
+		settings.SMTPUsername = req.SMTPUsername
+	}
+	if req.SMTPHeloName != nil {
+		settings.SMTPHeloName = req.SMTPHeloName
+	}
+	if req.SMTPFromName != nil {
+		settings.SMTPFromName = req.SMTPFromName
+	}
+	if req.SMTPFromAddress != nil {
+		settings.SMTPFromAddress = req.SMTPFromAddress
+	}
+	// The password of the request is plain text, unlike the saved one
+	if req.SMTPPassword != nil {
+		settings.SMTPPassword = nil
+	}
+
+	config, err := s.SMTPConfig(settings)
+	if err != nil {
+		return err
+	}
+	if req.SMTPPassword != nil {
+		config.Password = *req.SMTPPassword
+	}
+
+	recipients := valueOf(req.To)
+	if recipients == "" {
+		recipients = valueOf(settings.Email)
+	}
+	to, err := mail.ParseAddresses(recipients)
+	if err != nil {
+		return err
+	}
+	if len(to) == 0 {
+		return fmt.Errorf("no recipient: set an email address or pass one in the request")
+	}
+
+	from, fromName := settings.SMTPFrom()
+	if from == "" {
+		return fmt.Errorf("no sender: set a From address or an SMTP username")
+	}
+
+	return mail.SendEmail(config, &mail.Message{
+		From:     from,
+		FromName: fromName,
+		To:       to,
+		Subject:  "Velld - Test Email",
+		Body:     "Your SMTP settings work. Velld will send notification emails through this server.",
+	})
+}
+
+func valueOf(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
@@ -0,0 +1,53 @@
+package webhook
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Delivery statuses
+const (
+	// DeliveryPending waits for its first attempt or for a retry
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	// DeliveryFailed gave up after maxAttempts
+	DeliveryFailed = "failed"
+)
+
+// Headers set on every call, custom headers cannot replace them
+const (
+	HeaderEvent     = "X-Velld-Event"
+	HeaderDelivery  = "X-Velld-Delivery"
+	HeaderTimestamp = "X-Velld-Timestamp"
+	HeaderSignature = "X-Velld-Signature"
+)
+
+// Delivery is a webhook call and the outcome of its latest attempt
+type Delivery struct {
+	ID            uuid.UUID       `json:"id"`
+	UserID        uuid.UUID       `json:"user_id"`
+	URL           string          `json:"url"`
+	EventType     string          `json:"event_type"`
+	Payload       json.RawMessage `json:"payload"`
+	Status        string          `json:"status"`
+	Attempts      int             `json:"attempts"`
+	StatusCode    *int            `json:"status_code"`
+	ResponseBody  *string         `json:"response_body"`
+	Error         *string         `json:"error"`
+	RedeliveryOf  *string         `json:"redelivery_of"`
+	NextAttemptAt *time.Time      `json:"next_attempt_at"`
+	LastAttemptAt *time.Time      `json:"last_attempt_at"`
+	CreatedAt     time.Time       `json:"created_at"`
+	UpdatedAt     time.Time       `json:"updated_at"`
+}
+
+// DeliveryListOptions represents options for listing deliveries
+type DeliveryListOptions struct {
+	UserID    uuid.UUID
+	Status    string
+	EventType string
+	Limit     int
+	Offset    int
+}
@@ -0,0 +1,173 @@
+package webhook
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/dendianugerah/velld/internal/common"
+	"github.com/google/uuid"
+)
+
+type WebhookRepository struct {
+	db *sql.DB
+}
+
+func NewWebhookRepository(db *sql.DB) *WebhookRepository {
+	return &WebhookRepository{db: db}
+}
+
+const deliveryColumns = `
+	id, user_id, url, event_type, payload, status, attempts, status_code,
+	response_body, error, redelivery_of, next_attempt_at, last_attempt_at,
+	created_at, updated_at`
+
+func (r *WebhookRepository) CreateDelivery(d *Delivery) error {
+	_, err := r.db.Exec(`
+		INSERT INTO webhook_deliveries (
+			id, user_id, url, event_type, payload, status, attempts,
+			redelivery_of, created_at, updated_at
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		d.ID, d.UserID, d.URL, d.EventType, string(d.Payload), d.Status, d.Attempts,
+		d.RedeliveryOf, d.CreatedAt.Format(time.RFC3339), d.UpdatedAt.Format(time.RFC3339))
+	return err
+}
+
+// UpdateDelivery stores the outcome of an attempt
+func (r *WebhookRepository) UpdateDelivery(d *Delivery) error {
+	d.UpdatedAt = time.Now()
+	_, err := r.db.Exec(`
+		UPDATE webhook_deliveries
+		SET status = $1, attempts = $2, status_code = $3, response_body = $4,
+		    error = $5, next_attempt_at = $6, last_attempt_at = $7, updated_at = $8
+		WHERE id = $9`,
+		d.Status, d.Attempts, d.StatusCode, d.ResponseBody,
+		d.Error, formatOptionalTime(d.NextAttemptAt), formatOptionalTime(d.LastAttemptAt),
+		d.UpdatedAt.Format(time.RFC3339), d.ID)
+	return err
+}
+
+func (r *WebhookRepository) GetDelivery(userID uuid.UUID, id string) (*Delivery, error) {
+	row := r.db.QueryRow(`SELECT `+deliveryColumns+` FROM webhook_deliveries WHERE id = $1 AND user_id = $2`, id, userID)
+	return scanDelivery(row)
+}
+
+func (r *WebhookRepository) ListDeliveries(opts DeliveryListOptions) ([]*Delivery, int, error) {
+	whereClause := "WHERE user_id = $1"
+	args := []interface{}{opts.UserID}
+	if opts.Status != "" {
+		args = append(args, opts.Status)
+		whereClause += fmt.Sprintf(" AND status = $%d", len(args))
+	}
+	if opts.EventType != "" {
+		args = append(args, opts.EventType)
+		whereClause += fmt.Sprintf(" AND event_type = $%d", len(args))
+	}
+
+	var total int
+	err := r.db.QueryRow(`SELECT COUNT(*) FROM webhook_deliveries `+whereClause, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to count webhook deliveries: %v", err)
+	}
+
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM webhook_deliveries
+		%s
+		ORDER BY created_at DESC
+		LIMIT $%d OFFSET $%d`, deliveryColumns, whereClause, len(args)+1, len(args)+2)
+	args = append(args, opts.Limit, opts.Offset)
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var deliveries []*Delivery
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		deliveries = append(deliveries, d)
+	}
+
+	return deliveries, total, rows.Err()
+}
+
+// GetPendingDeliveries returns the deliveries that still have attempts left
+func (r *WebhookRepository) GetPendingDeliveries() ([]*Delivery, error) {
+	rows, err := r.db.Query(`SELECT `+deliveryColumns+` FROM webhook_deliveries WHERE status = $1`, DeliveryPending)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []*Delivery
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanDelivery(row rowScanner) (*Delivery, error) {
+	var (
+		payload          string
+		nextAttemptAtStr sql.NullString
+		lastAttemptAtStr sql.NullString
+		createdAtStr     string
+		updatedAtStr     string
+	)
+	d := &Delivery{}
+	err := row.Scan(
+		&d.ID, &d.UserID, &d.URL, &d.EventType, &payload, &d.Status, &d.Attempts, &d.StatusCode,
+		&d.ResponseBody, &d.Error, &d.RedeliveryOf, &nextAttemptAtStr, &lastAttemptAtStr,
+		&createdAtStr, &updatedAtStr)
+	if err != nil {
+		return nil, err
+	}
+	d.Payload = []byte(payload)
+
+	if d.NextAttemptAt, err = parseOptionalTime(nextAttemptAtStr); err != nil {
+		return nil, fmt.Errorf("error parsing next_attempt_at: %v", err)
+	}
+	if d.LastAttemptAt, err = parseOptionalTime(lastAttemptAtStr); err != nil {
+		return nil, fmt.Errorf("error parsing last_attempt_at: %v", err)
+	}
+	if d.CreatedAt, err = common.ParseTime(createdAtStr); err != nil {
+		return nil, fmt.Errorf("error parsing created_at: %v", err)
+	}
+	if d.UpdatedAt, err = common.ParseTime(updatedAtStr); err != nil {
+		return nil, fmt.Errorf("error parsing updated_at: %v", err)
+	}
+
+	return d, nil
+}
+
+func parseOptionalTime(value sql.NullString) (*time.Time, error) {
+	if !value.Valid || value.String == "" {
+		return nil, nil
+	}
+	t, err := common.ParseTime(value.String)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func formatOptionalTime(t *time.Time) *string {
+	if t == nil {
+		return nil
+	}
+	str := t.Format(time.RFC3339)
+	return &str
+}
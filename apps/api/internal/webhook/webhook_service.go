@@ -0,0 +1,230 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dendianugerah/velld/internal/settings"
+	"github.com/google/uuid"
+)
+
+const (
+	// maxAttempts includes the first call, retries wait retryBaseDelay and
+	// double after each failure
+	maxAttempts    = 5
+	retryBaseDelay = 30 * time.Second
+	requestTimeout = 10 * time.Second
+	// maxResponseBody bounds the part of a response kept in the delivery log
+	maxResponseBody = 4096
+)
+
+type WebhookService struct {
+	repo            *WebhookRepository
+	settingsService *settings.SettingsService
+	client          *http.Client
+}
+
+func NewWebhookService(repo *WebhookRepository, settingsService *settings.SettingsService) *WebhookService {
+	service := &WebhookService{
+		repo:            repo,
+		settingsService: settingsService,
+		client:          &http.Client{Timeout: requestTimeout},
+	}
+
+	if err := service.recoverDeliveries(); err != nil {
+		fmt.Printf("Error recovering webhook deliveries: %v\n", err)
+	}
+
+	return service
+}
+
+// Deliver records a call of the webhook of a user and makes its first
+// attempt in the background
+func (s *WebhookService) Deliver(userID uuid.UUID, url, eventType string, payload interface{}) (*Delivery, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode webhook payload: %v", err)
+	}
+
+	return s.createDelivery(&Delivery{
+		UserID:    userID,
+		URL:       url,
+		EventType: eventType,
+		Payload:   body,
+	})
+}
+
+// Redeliver sends the payload of a delivery again as a new delivery
+func (s *WebhookService) Redeliver(userID uuid.UUID, id string) (*Delivery, error) {
+	original, err := s.repo.GetDelivery(userID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	originalID := original.ID.String()
+	return s.createDelivery(&Delivery{
+		UserID:       userID,
+		URL:          original.URL,
+		EventType:    original.EventType,
+		Payload:      original.Payload,
+		RedeliveryOf: &originalID,
+	})
+}
+
+func (s *WebhookService) GetDelivery(userID uuid.UUID, id string) (*Delivery, error) {
+	return s.repo.GetDelivery(userID, id)
+}
+
+func (s *WebhookService) ListDeliveries(opts DeliveryListOptions) ([]*Delivery, int, error) {
+	if opts.Limit <= 0 {
+		opts.Limit = 10
+	}
+	if opts.Limit > 100 {
+		opts.Limit = 100
+	}
+	if opts.Offset < 0 {
+		opts.Offset = 0
+	}
+
+	return s.repo.ListDeliveries(opts)
+}
+
+func (s *WebhookService) createDelivery(delivery *Delivery) (*Delivery, error) {
+	now := time.Now()
+	delivery.ID = uuid.New()
+	delivery.Status = DeliveryPending
+	delivery.CreatedAt = now
+	delivery.UpdatedAt = now
+
+	if err := s.repo.CreateDelivery(delivery); err != nil {
+		return nil, fmt.Errorf("failed to save webhook delivery: %v", err)
+	}
+
+	go s.attempt(delivery)
+	return delivery, nil
+}
+
+// recoverDeliveries resumes the retries that were waiting when the server
+// stopped
+func (s *WebhookService) recoverDeliveries() error {
+	deliveries, err := s.repo.GetPendingDeliveries()
+	if err != nil {
+		return err
+	}
+
+	for _, delivery := range deliveries {
+		delay := time.Duration(0)
+		if delivery.NextAttemptAt != nil {
+			delay = time.Until(*delivery.NextAttemptAt)
+		}
+		s.scheduleAttempt(delivery, delay)
+	}
+	return nil
+}
+
+func (s *WebhookService) scheduleAttempt(delivery *Delivery, delay time.Duration) {
+	if delay <= 0 {
+		go s.attempt(delivery)
+		return
+	}
+	time.AfterFunc(delay, func() { s.attempt(delivery) })
+}
+
+// attempt calls the webhook once, records the response and schedules a
+// retry with exponential backoff while attempts are left
+func (s *WebhookService) attempt(delivery *Delivery) {
+	now := time.Now()
+	delivery.Attempts++
+	delivery.LastAttemptAt = &now
+	delivery.NextAttemptAt = nil
+	delivery.StatusCode = nil
+	delivery.ResponseBody = nil
+	delivery.Error = nil
+
+	if err := s.send(delivery); err != nil {
+		message := err.Error()
+		delivery.Error = &message
+		if delivery.Attempts < maxAttempts {
+			delay := retryBaseDelay << (delivery.Attempts - 1)
+			next := now.Add(delay)
+			delivery.NextAttemptAt = &next
+			s.scheduleAttempt(delivery, delay)
+		} else {
+			delivery.Status = DeliveryFailed
+		}
+	} else {
+		delivery.Status = DeliverySucceeded
+	}
+
+	if err := s.repo.UpdateDelivery(delivery); err != nil {
+		fmt.Printf("ERROR: failed to update webhook delivery %s: %v\n", delivery.ID, err)
+	}
+}
+
+// send posts the payload with the current secret and headers of the user,
+// so that a rotated secret also applies to retries
+func (s *WebhookService) send(delivery *Delivery) error {
+	userSettings, err := s.settingsService.GetUserSettingsInternal(delivery.UserID)
+	if err != nil {
+		return fmt.Errorf("failed to get user settings: %v", err)
+	}
+	secret, err := s.settingsService.WebhookSecret(userSettings)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return fmt.Errorf("invalid webhook request: %v", err)
+	}
+
+	for name, value := range userSettings.WebhookHeaders {
+		req.Header.Set(name, value)
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Velld-Webhook")
+	req.Header.Set(HeaderEvent, delivery.EventType)
+	req.Header.Set(HeaderDelivery, delivery.ID.String())
+	req.Header.Set(HeaderTimestamp, timestamp)
+	if secret != "" {
+		req.Header.Set(HeaderSignature, Sign(secret, timestamp, delivery.Payload))
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook call failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	statusCode := resp.StatusCode
+	delivery.StatusCode = &statusCode
+	if body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseBody)); err == nil && len(body) > 0 {
+		responseBody := string(body)
+		delivery.ResponseBody = &responseBody
+	}
+
+	if statusCode < 200 || statusCode >= 300 {
+		return fmt.Errorf("webhook responded with status %d", statusCode)
+	}
+	return nil
+}
+
+// Sign returns the X-Velld-Signature of a payload: the hex encoded
+// HMAC-SHA256, keyed with the secret, of the timestamp, a dot and the body.
+// Receivers should also reject old timestamps to prevent replays.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}